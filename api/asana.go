@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Settings holding, per project GID, the token in its webhook's URL and the
+// secret Asana signs the webhook's deliveries with
+const (
+	asanaTokenSetting  = "asana.webhook_token."
+	asanaSecretSetting = "asana.webhook_secret."
+)
+
+func asanaCardID(gid string) string {
+	return models.SourceAsana + ":" + gid
+}
+
+// asanaTaskCard maps task onto a card on the first configured project it is
+// in, reporting false if it is in none.
+func (h *Handler) asanaTaskCard(task models.AsanaTask) (models.Card, bool) {
+	card := models.Card{
+		ID:          asanaCardID(task.GID),
+		Source:      models.SourceAsana,
+		Name:        task.Name,
+		Description: task.Notes,
+		URL:         task.PermalinkURL,
+		Archived:    task.Completed,
+	}
+	found := false
+	for _, membership := range task.Memberships {
+		if _, ok := h.Config().Asana.Project(membership.Project.GID); ok {
+			card.BoardID = membership.Project.GID
+			card.ListID = membership.Section.Name
+			found = true
+			break
+		}
+	}
+	if due, err := time.Parse(time.DateOnly, task.DueOn); err == nil {
+		card.DueDate = &due
+	} else if due, err := time.Parse(time.RFC3339, task.DueAt); err == nil {
+		card.DueDate = utcDay(&due)
+	}
+	return card, found
+}
+
+// syncAsanaTask syncs the task, or removes it if it left every configured
+// project.
+func (h *Handler) syncAsanaTask(ctx context.Context, task models.AsanaTask) error {
+	card, ok := h.asanaTaskCard(task)
+	if !ok {
+		return h.removeSourceCard(ctx, card.ID)
+	}
+	return h.syncSourceCard(ctx, card)
+}
+
+// refreshAsanaTask fetches the task with the GID gid and syncs it.
+func (h *Handler) refreshAsanaTask(ctx context.Context, gid string) error {
+	task, err := h.Asana.GetTask(ctx, gid)
+	if errors.Is(err, integrations.ErrNotFound) {
+		return h.removeSourceCard(ctx, asanaCardID(gid))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch Asana task %s: %w", gid, err)
+	}
+	return h.syncAsanaTask(ctx, task)
+}
+
+// AsanaWebhookHandler answers the handshake Asana makes when a project's
+// webhook is registered, and syncs the tasks later deliveries report
+// changed. The project and a token generated at registration are in the URL,
+// so only a webhook this service registered can set the signing secret.
+func (h *Handler) AsanaWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	projectGID := c.Query("project")
+	if _, ok := h.Config().Asana.Project(projectGID); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown project"})
+		return
+	}
+	token, err := database.GetSetting(h.DB, asanaTokenSetting+projectGID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+		logging.FromContext(ctx).Warn("Rejected Asana webhook with a wrong token", zap.String("project", projectGID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	if secret := c.GetHeader("X-Hook-Secret"); secret != "" {
+		if err := database.PutSetting(h.DB, asanaSecretSetting+projectGID, secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		logging.FromContext(ctx).Info("Completed Asana webhook handshake", zap.String("project", projectGID))
+		c.Header("X-Hook-Secret", secret)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+	secret, err := database.GetSetting(h.DB, asanaSecretSetting+projectGID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !validHubSignature(secret, "sha256="+c.GetHeader("X-Hook-Signature"), body) {
+		logging.FromContext(ctx).Warn("Rejected Asana webhook with a missing or wrong signature", zap.String("project", projectGID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	var payload struct {
+		Events []models.AsanaEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if paused, err := h.Paused(ctx); err != nil || paused {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPaused.Error()})
+		return
+	}
+
+	// A delivery may report several changes to the same task; its current
+	// state covers them all
+	deleted := make(map[string]bool)
+	var order []string
+	for _, event := range payload.Events {
+		if event.Resource.ResourceType != "task" || event.Resource.GID == "" {
+			continue
+		}
+		gid := event.Resource.GID
+		if _, seen := deleted[gid]; !seen {
+			order = append(order, gid)
+		}
+		deleted[gid] = deleted[gid] || event.Action == "deleted"
+	}
+
+	ctx = logging.With(ctx, zap.String("project", projectGID))
+	var errs []error
+	for _, gid := range order {
+		if deleted[gid] {
+			err = h.removeSourceCard(ctx, asanaCardID(gid))
+		} else {
+			err = h.refreshAsanaTask(ctx, gid)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		logging.FromContext(ctx).Error("Failed to sync Asana tasks", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "sync failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+}
+
+// RunAsanaWebhooks makes sure every configured Asana project has an active
+// webhook now and every interval until ctx is cancelled, importing a
+// project's tasks whenever its webhook is registered anew.
+func (h *Handler) RunAsanaWebhooks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, project := range h.Config().Asana.Projects {
+			if err := h.checkAsanaProject(ctx, project); err != nil && ctx.Err() == nil {
+				zap.L().Error("Failed to set up Asana webhook", zap.String("project", project.GID), zap.Error(err))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) checkAsanaProject(ctx context.Context, project config.AsanaProject) error {
+	callbackURL := h.Config().Asana.CallbackURL
+	token, err := database.GetSetting(h.DB, asanaTokenSetting+project.GID)
+	if err != nil {
+		return err
+	}
+	secret, err := database.GetSetting(h.DB, asanaSecretSetting+project.GID)
+	if err != nil {
+		return err
+	}
+	hooks, err := h.Asana.ListWebhooks(ctx, project.Workspace, project.GID)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	current := asanaWebhookTarget(callbackURL, project.GID, token)
+	healthy := false
+	for _, hook := range hooks {
+		if !strings.HasPrefix(hook.Target, callbackURL+"?") {
+			continue // Not ours
+		}
+		if hook.Active && hook.Target == current && token != "" && secret != "" && !healthy {
+			healthy = true
+			continue
+		}
+		// Deactivated after failed deliveries, or left by an earlier run
+		if err := h.Asana.DeleteWebhook(ctx, hook.GID); err != nil {
+			return fmt.Errorf("failed to delete stale webhook %s: %w", hook.GID, err)
+		}
+	}
+	if healthy {
+		return nil
+	}
+
+	token = rand.Text()
+	if err := database.PutSetting(h.DB, asanaTokenSetting+project.GID, token); err != nil {
+		return err
+	}
+	if err := database.PutSetting(h.DB, asanaSecretSetting+project.GID, ""); err != nil {
+		return err
+	}
+	hook, err := h.Asana.CreateWebhook(ctx, project.GID, asanaWebhookTarget(callbackURL, project.GID, token))
+	if err != nil {
+		return fmt.Errorf("failed to register webhook: %w", err)
+	}
+	zap.L().Info("Registered Asana webhook", zap.String("project", project.GID), zap.String("webhookID", hook.GID))
+
+	// Changes made while there was no webhook were missed
+	return h.importAsanaProject(ctx, project.GID)
+}
+
+func asanaWebhookTarget(callbackURL, projectGID, token string) string {
+	return callbackURL + "?" + url.Values{"project": {projectGID}, "token": {token}}.Encode()
+}
+
+// importAsanaProject syncs the project's open tasks, and refreshes the stored
+// ones it no longer lists, which were completed, deleted or moved away.
+func (h *Handler) importAsanaProject(ctx context.Context, projectGID string) error {
+	tasks, err := h.Asana.ListOpenTasks(ctx, projectGID)
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+	var stored []string
+	err = h.DB.WithContext(ctx).Model(&models.Card{}).
+		Where("source = ? AND board_id = ?", models.SourceAsana, projectGID).
+		Pluck("id", &stored).Error
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+
+	listed := make(map[string]bool, len(tasks))
+	var errs []error
+	for _, task := range tasks {
+		listed[asanaCardID(task.GID)] = true
+		if err := h.syncAsanaTask(ctx, task); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, id := range stored {
+		if !listed[id] {
+			if err := h.refreshAsanaTask(ctx, strings.TrimPrefix(id, models.SourceAsana+":")); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	zap.L().Info("Imported Asana project tasks", zap.String("project", projectGID), zap.Int("tasks", len(tasks)))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/apikeys"
+	"github.com/chxlky/trello-gcal-sync/internal/ipallow"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// scopesKey is where RequireAdminAuth records the scopes a request was granted
+const scopesKey = "apiScopes"
+
+// tenantKey is where RequireAdminAuth records the tenant a request's API key
+// is confined to
+const tenantKey = "apiTenant"
+
+// apiKeyTouchInterval is how stale an API key's last-used time may get before
+// a request refreshes it, to avoid a write on every request
+const apiKeyTouchInterval = time.Minute
+
+// RequireAdminAuth guards routes with the bearer token configured in
+// server.admin_token, which grants every scope, or an API key issued with
+// `api-keys create`. RequireScope then checks what the route needs. Without
+// an admin token or any API keys the routes are disabled.
+func (h *Handler) RequireAdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := h.Config().Server.AdminToken
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		if token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+			c.Set(scopesKey, []string{apikeys.Admin})
+			c.Next()
+			return
+		}
+
+		id, ok := apikeys.ID(provided)
+		if !ok {
+			if token == "" {
+				zap.L().Warn("Rejected request to protected route; server.admin_token is not configured", zap.String("path", c.Request.URL.Path))
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin API is disabled"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing token"})
+			return
+		}
+
+		key, err := h.checkAPIKey(id, provided)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unable to check API key"})
+			return
+		}
+		if key == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing token"})
+			return
+		}
+		setAPIKey(c, key)
+		c.Next()
+	}
+}
+
+// onboardingCookie holds a tenant's onboarding key once its invite link has
+// been opened
+const onboardingCookie = "tgs_onboarding"
+
+// RequireOnboardingKey guards a tenant's onboarding pages, under path, with
+// an API key confined to the tenant and granted the onboard scope, as
+// `tenant invite` issues. The invite link carries the key in its key
+// parameter; it's moved to a cookie only sent to the pages, and the browser
+// sent back to the address without it. The cookie is SameSite=Lax, so other
+// sites can't submit the pages' forms.
+func (h *Handler) RequireOnboardingKey(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if provided := c.Query("key"); provided != "" {
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(onboardingCookie, provided, 0, path, "", IsHTTPS(c), true)
+			location := *c.Request.URL
+			query := location.Query()
+			query.Del("key")
+			location.RawQuery = query.Encode()
+			c.Redirect(http.StatusSeeOther, location.String())
+			c.Abort()
+			return
+		}
+
+		provided, _ := c.Cookie(onboardingCookie)
+		id, ok := apikeys.ID(provided)
+		if !ok {
+			c.Abort()
+			c.String(http.StatusUnauthorized, "Open the invite link you were sent to set up syncing.")
+			return
+		}
+		key, err := h.checkAPIKey(id, provided)
+		if err != nil {
+			c.Abort()
+			c.String(http.StatusServiceUnavailable, "Your invite can't be checked right now; try again shortly.")
+			return
+		}
+		if key == nil || key.Tenant == "" || !apikeys.Grants(strings.Split(key.Scopes, ","), apikeys.Onboard) {
+			c.Abort()
+			c.String(http.StatusUnauthorized, "This invite link is invalid or has been revoked; ask for a new one.")
+			return
+		}
+		setAPIKey(c, key)
+		c.Next()
+	}
+}
+
+// IsHTTPS reports whether the request reached the service over HTTPS,
+// directly or through a proxy terminating TLS.
+func IsHTTPS(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// checkAPIKey returns the unrevoked API key with id that provided is, or nil
+// if it isn't one, recording that it was used.
+func (h *Handler) checkAPIKey(id, provided string) (*models.APIKey, error) {
+	key, err := database.GetAPIKey(h.DB, id)
+	if err != nil {
+		zap.L().Error("Failed to look up API key", zap.String("keyID", id), zap.Error(err))
+		return nil, err
+	}
+	if key == nil || !apikeys.Matches(*key, provided) {
+		return nil, nil
+	}
+
+	if now := time.Now(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := database.TouchAPIKey(h.DB, key.ID, now); err != nil {
+			zap.L().Warn("Failed to record API key use", zap.String("keyID", key.ID), zap.Error(err))
+		}
+	}
+	return key, nil
+}
+
+// setAPIKey records what the request's API key was granted
+func setAPIKey(c *gin.Context, key *models.APIKey) {
+	c.Set(scopesKey, strings.Split(key.Scopes, ","))
+	c.Set(tenantKey, key.Tenant)
+	c.Set("apiKey", key.Name)
+}
+
+// RequireScope turns away requests whose credentials, checked by
+// RequireAdminAuth, weren't granted scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Value(scopesKey).([]string)
+		if !apikeys.Grants(scopes, scope) {
+			zap.L().Warn("Rejected API key lacking the route's scope", zap.String("path", c.Request.URL.Path), zap.String("apiKey", c.GetString("apiKey")), zap.String("scope", scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireOperator turns away requests made with a tenant's API key, for
+// routes acting on the whole service rather than one tenant's data.
+func RequireOperator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tenant := RequestTenant(c); tenant != "" {
+			zap.L().Warn("Rejected tenant API key on an operator route", zap.String("path", c.Request.URL.Path), zap.String("apiKey", c.GetString("apiKey")), zap.String("tenant", tenant))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not available to tenant API keys"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSourceIP turns away requests that don't come from an address in
+// list, such as webhook deliveries that can't be from Trello.
+func RequireSourceIP(list *ipallow.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, addr := list.Allows(c.Request); !ok {
+			zap.L().Warn("Rejected request from an address outside the allowed ranges", zap.String("path", c.Request.URL.Path), zap.Stringer("address", addr))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "address not allowed"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireClientCert guards routes with the client certificates verified
+// against server.tls.client_ca_file. If names are given, the certificate's
+// common name or one of its DNS or email names must be among them.
+func RequireClientCert(names []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := c.Request.TLS
+		if state == nil || len(state.VerifiedChains) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client certificate required"})
+			return
+		}
+
+		cert := state.VerifiedChains[0][0]
+		if len(names) > 0 {
+			subjects := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+			subjects = append(subjects, cert.EmailAddresses...)
+			if !slices.ContainsFunc(subjects, func(subject string) bool { return slices.Contains(names, subject) }) {
+				zap.L().Warn("Rejected client certificate not allowed on protected route", zap.String("path", c.Request.URL.Path), zap.String("subject", cert.Subject.String()))
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "client certificate not allowed"})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,419 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// backfillRunSetting holds the boards of a backfill that hasn't finished, so
+// the next leader can resume it
+const backfillRunSetting = "backfill.run"
+
+// backfillCursorPrefix is followed by a board ID in the settings recording the
+// last card of the board a backfill got through. Trello card IDs begin with
+// their creation time, so cards are backfilled in ID order and every card up
+// to the cursor is done.
+const backfillCursorPrefix = "backfill.cursor:"
+
+// backfillRetries is how many times a card is retried after being rate limited
+const backfillRetries = 5
+
+var (
+	// ErrBackfillRunning is returned when a backfill is started while one is
+	// under way.
+	ErrBackfillRunning = errors.New("a backfill is already running")
+	// ErrNothingToBackfill is returned when there are no tracked boards to
+	// backfill.
+	ErrNothingToBackfill = errors.New("no tracked boards to backfill")
+)
+
+type backfillBoard struct {
+	Account string `json:"account"`
+	BoardID string `json:"board_id"`
+}
+
+type backfillRun struct {
+	StartedAt time.Time       `json:"started_at"`
+	Boards    []backfillBoard `json:"boards"`
+}
+
+// BackfillProgress reports on the running or last backfill. Processed counts
+// cards done before a resume as well.
+type BackfillProgress struct {
+	Running    bool       `json:"running"`
+	StartedAt  time.Time  `json:"started_at,omitzero"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Boards     int        `json:"boards"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	ETASeconds *int       `json:"eta_seconds,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// backfillState tracks this instance's backfill
+type backfillState struct {
+	mu       sync.Mutex
+	progress BackfillProgress
+	resumed  int       // Cards already done when this instance took over
+	began    time.Time // When this instance started working on it, for the ETA
+}
+
+// BackfillStatusHandler reports the progress of the running or last backfill.
+func (h *Handler) BackfillStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.BackfillProgress())
+}
+
+// BackfillProgress returns the progress of the running or last backfill.
+func (h *Handler) BackfillProgress() BackfillProgress {
+	h.backfill.mu.Lock()
+	defer h.backfill.mu.Unlock()
+
+	progress := h.backfill.progress
+	done := progress.Processed - h.backfill.resumed
+	if elapsed := time.Since(h.backfill.began); progress.Running && done > 0 && progress.Total > 0 {
+		eta := int((elapsed * time.Duration(progress.Total-progress.Processed) / time.Duration(done)).Seconds())
+		progress.ETASeconds = &eta
+	}
+	return progress
+}
+
+// StartBackfill syncs every open card on the tracked boards, or on boardID
+// alone, in the background until ctx is done. An interrupted backfill of
+// the same boards carries on where it stopped unless restart is set.
+func (h *Handler) StartBackfill(ctx context.Context, boardID string, restart bool) error {
+	closed, err := h.closedBoards()
+	if err != nil {
+		return fmt.Errorf("failed to load closed boards: %w", err)
+	}
+	run := backfillRun{StartedAt: time.Now().UTC()}
+	for _, account := range slices.Sorted(maps.Keys(h.TrelloClients())) {
+		tracked, err := h.trackedBoards(account)
+		if err != nil {
+			return fmt.Errorf("failed to load tracked boards: %w", err)
+		}
+		for _, id := range slices.Sorted(maps.Keys(tracked)) {
+			if _, ok := closed[id]; ok || (boardID != "" && id != boardID) || !h.Tenancy().Owns(account, id) {
+				continue
+			}
+			run.Boards = append(run.Boards, backfillBoard{Account: account, BoardID: id})
+		}
+	}
+	if len(run.Boards) == 0 {
+		return ErrNothingToBackfill
+	}
+
+	if !h.claimBackfill(run) {
+		return ErrBackfillRunning
+	}
+	if restart {
+		for _, board := range run.Boards {
+			if err := database.DeleteSetting(h.DB, backfillCursorPrefix+board.BoardID); err != nil {
+				h.finishBackfill(err)
+				return fmt.Errorf("failed to reset backfill progress: %w", err)
+			}
+		}
+	}
+	value, _ := json.Marshal(run)
+	if err := database.PutSetting(h.DB, backfillRunSetting, string(value)); err != nil {
+		h.finishBackfill(err)
+		return fmt.Errorf("failed to save backfill: %w", err)
+	}
+
+	zap.L().Info("Starting backfill", zap.Int("boards", len(run.Boards)))
+	go h.runBackfill(ctx, run)
+	return nil
+}
+
+// ResumeBackfill carries on with a backfill left unfinished by an earlier
+// leader, if there is one.
+func (h *Handler) ResumeBackfill(ctx context.Context) {
+	value, err := database.GetSetting(h.DB, backfillRunSetting)
+	if err != nil {
+		zap.L().Error("Failed to load unfinished backfill", zap.Error(err))
+		return
+	}
+	if value == "" {
+		return
+	}
+	var run backfillRun
+	if err := json.Unmarshal([]byte(value), &run); err != nil {
+		zap.L().Error("Discarding unreadable unfinished backfill", zap.Error(err))
+		if err := database.DeleteSetting(h.DB, backfillRunSetting); err != nil {
+			zap.L().Error("Failed to discard unfinished backfill", zap.Error(err))
+		}
+		return
+	}
+	if !h.claimBackfill(run) {
+		return
+	}
+
+	zap.L().Info("Resuming unfinished backfill", zap.Time("startedAt", run.StartedAt), zap.Int("boards", len(run.Boards)))
+	h.runBackfill(ctx, run)
+}
+
+// claimBackfill marks run as this instance's backfill, unless one is running
+func (h *Handler) claimBackfill(run backfillRun) bool {
+	h.backfill.mu.Lock()
+	defer h.backfill.mu.Unlock()
+	if h.backfill.progress.Running {
+		return false
+	}
+	h.backfill.progress = BackfillProgress{Running: true, StartedAt: run.StartedAt, Boards: len(run.Boards)}
+	h.backfill.resumed = 0
+	h.backfill.began = time.Now()
+	return true
+}
+
+func (h *Handler) finishBackfill(err error) {
+	h.backfill.mu.Lock()
+	defer h.backfill.mu.Unlock()
+	now := time.Now().UTC()
+	h.backfill.progress.Running = false
+	h.backfill.progress.FinishedAt = &now
+	if err != nil {
+		h.backfill.progress.Error = err.Error()
+	}
+}
+
+// backfillCards is a board's cards, as listed when the backfill got to it
+type backfillCards struct {
+	backfillBoard
+	client integrations.TrelloAPI
+	name   string
+	cards  []models.TrelloCard // After the cursor, in ID order
+}
+
+func (h *Handler) runBackfill(ctx context.Context, run backfillRun) {
+	ctx = withBatchedWrites(ctx)
+	settings := h.Config().Sync.Backfill
+	trelloLimit := newLimiter(settings.TrelloRate)
+	googleLimit := newLimiter(settings.GoogleRate)
+
+	// List every board's cards up front so progress has a total to go by
+	var boards []backfillCards
+	for _, board := range run.Boards {
+		cards, err := h.listBackfillCards(ctx, trelloLimit, board)
+		if err != nil {
+			if ctx.Err() != nil {
+				h.interruptBackfill(ctx)
+				return
+			}
+			zap.L().Warn("Skipping board the backfill couldn't list", zap.String("account", board.Account), zap.String("boardID", board.BoardID), zap.Error(err))
+			continue
+		}
+		boards = append(boards, cards)
+	}
+
+	stopLogging := h.logBackfillProgress(ctx, settings.ProgressInterval)
+	defer stopLogging()
+
+	for _, board := range boards {
+		h.backfillBoard(ctx, googleLimit, max(settings.Concurrency, 1), board)
+		if ctx.Err() != nil {
+			h.interruptBackfill(ctx)
+			return
+		}
+	}
+
+	var errs []error
+	for _, board := range run.Boards {
+		errs = append(errs, database.DeleteSetting(h.DB, backfillCursorPrefix+board.BoardID))
+	}
+	errs = append(errs, database.DeleteSetting(h.DB, backfillRunSetting))
+	if err := errors.Join(errs...); err != nil {
+		zap.L().Error("Failed to clear finished backfill", zap.Error(err))
+	}
+	h.finishBackfill(nil)
+
+	progress := h.BackfillProgress()
+	zap.L().Info("Backfill finished", zap.Int("processed", progress.Processed), zap.Int("failed", progress.Failed), zap.Duration("took", progress.FinishedAt.Sub(progress.StartedAt).Round(time.Second)))
+}
+
+// interruptBackfill notes that the backfill stopped before finishing; it is
+// left in the database for the next leader to resume
+func (h *Handler) interruptBackfill(ctx context.Context) {
+	progress := h.BackfillProgress()
+	zap.L().Warn("Backfill interrupted; it resumes when this or another instance next leads", zap.Int("processed", progress.Processed), zap.Int("total", progress.Total), zap.Error(context.Cause(ctx)))
+	h.finishBackfill(errors.New("interrupted"))
+}
+
+// listBackfillCards lists the open cards of board that come after its cursor,
+// counting the rest as already processed
+func (h *Handler) listBackfillCards(ctx context.Context, limit *rate.Limiter, board backfillBoard) (backfillCards, error) {
+	client := h.TrelloClient(board.Account)
+	if client == nil {
+		return backfillCards{}, fmt.Errorf("trello account %q is no longer configured", board.Account)
+	}
+	if err := limit.Wait(ctx); err != nil {
+		return backfillCards{}, err
+	}
+	trelloBoard, err := client.GetBoard(ctx, board.BoardID)
+	if err != nil {
+		return backfillCards{}, err
+	}
+	if err := limit.Wait(ctx); err != nil {
+		return backfillCards{}, err
+	}
+	cards, err := client.ListCards(ctx, board.BoardID, "open")
+	if err != nil {
+		return backfillCards{}, err
+	}
+	cursor, err := database.GetSetting(h.DB, backfillCursorPrefix+board.BoardID)
+	if err != nil {
+		return backfillCards{}, err
+	}
+
+	sort.Slice(cards, func(i, j int) bool { return cards[i].ID < cards[j].ID })
+	done := sort.Search(len(cards), func(i int) bool { return cards[i].ID > cursor })
+
+	h.backfill.mu.Lock()
+	h.backfill.progress.Total += len(cards)
+	h.backfill.progress.Processed += done
+	h.backfill.resumed += done
+	h.backfill.mu.Unlock()
+	return backfillCards{backfillBoard: board, client: client, name: trelloBoard.Name, cards: cards[done:]}, nil
+}
+
+// backfillBoard syncs the board's cards with concurrency workers, moving its
+// cursor past each run of cards that are all done and saved
+func (h *Handler) backfillBoard(ctx context.Context, limit *rate.Limiter, concurrency int, board backfillCards) {
+	names := map[string]string{board.BoardID: board.name}
+
+	var mu sync.Mutex
+	done := make([]bool, len(board.cards))
+	next := 0 // Index of the first card not yet done
+	lastSaved := time.Now()
+	saveCursor := func(force bool) {
+		if next == 0 || (!force && time.Since(lastSaved) < 5*time.Second) {
+			return
+		}
+		// The cursor mustn't pass cards whose saves are still queued
+		if err := h.Writes.Flush(); err != nil {
+			zap.L().Warn("Failed to save backfilled cards", zap.String("boardID", board.BoardID), zap.Error(err))
+			return
+		}
+		if err := database.PutSetting(h.DB, backfillCursorPrefix+board.BoardID, board.cards[next-1].ID); err != nil {
+			zap.L().Warn("Failed to save backfill progress", zap.String("boardID", board.BoardID), zap.Error(err))
+		}
+		lastSaved = time.Now()
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for i := range work {
+				err := h.backfillCard(ctx, limit, board.client, board.cards[i], names)
+				if ctx.Err() != nil {
+					continue // Left undone for the resume
+				}
+
+				h.backfill.mu.Lock()
+				h.backfill.progress.Processed++
+				if err != nil {
+					h.backfill.progress.Failed++
+				}
+				h.backfill.mu.Unlock()
+				if err != nil {
+					zap.L().Warn("Failed to backfill card", zap.String("cardID", board.cards[i].ID), zap.String("boardID", board.BoardID), zap.Error(err))
+				}
+
+				mu.Lock()
+				done[i] = true
+				for next < len(done) && done[next] {
+					next++
+				}
+				saveCursor(false)
+				mu.Unlock()
+			}
+		})
+	}
+
+feed:
+	for i := range board.cards {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	saveCursor(true)
+}
+
+// backfillCard syncs one card, waiting out rate limiting by Google or Trello
+func (h *Handler) backfillCard(ctx context.Context, limit *rate.Limiter, client integrations.TrelloAPI, card models.TrelloCard, names map[string]string) error {
+	// The action has no date, so it doesn't count as newer than any webhook
+	action := models.TrelloAction{ID: "backfill", Type: "updateCard"}
+	for attempt := 1; ; attempt++ {
+		if err := limit.Wait(ctx); err != nil {
+			return err
+		}
+		err := h.syncFetchedCard(ctx, action, client, &card, names)
+
+		var rateLimited *integrations.RateLimitedError
+		if !errors.As(err, &rateLimited) || attempt == backfillRetries {
+			return err
+		}
+		wait := max(rateLimited.RetryAfter, defaultRetryDelay)
+		zap.L().Info("Backfill rate limited; waiting before retrying", zap.String("cardID", card.ID), zap.Duration("wait", wait))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// logBackfillProgress logs the backfill's progress every interval until the
+// returned function is called
+func (h *Handler) logBackfillProgress(ctx context.Context, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				progress := h.BackfillProgress()
+				fields := []zap.Field{zap.Int("processed", progress.Processed), zap.Int("total", progress.Total), zap.Int("failed", progress.Failed)}
+				if progress.ETASeconds != nil {
+					fields = append(fields, zap.Duration("eta", time.Duration(*progress.ETASeconds)*time.Second))
+				}
+				zap.L().Info("Backfill progress", fields...)
+			}
+		}
+	}()
+	return cancel
+}
+
+// newLimiter allows perSecond events a second, or any number if it isn't
+// positive
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
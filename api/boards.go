@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// closedBoardPrefix prefixes the settings recording, per board ID, when a
+// synced board was closed in Trello
+const closedBoardPrefix = "trello.closed_board:"
+
+// trackedBoardPrefix is followed by the account name, a colon and a board ID
+// in the settings naming the boards the leader receives each account's
+// updates for. They are kept in the database so every instance taking
+// webhook deliveries agrees on them.
+func trackedBoardPrefix(account string) string {
+	return "trello.board:" + account + ":"
+}
+
+// TrackBoards records boardIDs as the boards the account's updates are
+// received for, replacing those recorded before. Webhook deliveries for any
+// other board are turned away.
+func (h *Handler) TrackBoards(account string, boardIDs []string) error {
+	prefix := trackedBoardPrefix(account)
+	tracked, err := h.trackedBoards(account)
+	if err != nil {
+		return fmt.Errorf("failed to load tracked boards: %w", err)
+	}
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		for _, boardID := range boardIDs {
+			if _, ok := tracked[boardID]; ok {
+				delete(tracked, boardID)
+				continue
+			}
+			if err := database.PutSetting(tx, prefix+boardID, time.Now().UTC().Format(time.RFC3339)); err != nil {
+				return fmt.Errorf("failed to track board %s: %w", boardID, err)
+			}
+		}
+		for boardID := range tracked {
+			if err := database.DeleteSetting(tx, prefix+boardID); err != nil {
+				return fmt.Errorf("failed to stop tracking board %s: %w", boardID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// trackedBoards returns when each board the account's updates are received
+// for started being tracked, keyed by board ID.
+func (h *Handler) trackedBoards(account string) (map[string]string, error) {
+	settings, err := database.ListSettings(h.DB, trackedBoardPrefix(account))
+	if err != nil {
+		return nil, err
+	}
+	// Board IDs have no colons, so anything with one belongs to an account
+	// whose name starts with this one's and a colon
+	maps.DeleteFunc(settings, func(boardID, _ string) bool { return strings.Contains(boardID, ":") })
+	return settings, nil
+}
+
+// boardClient returns the Trello client of the account whose updates to
+// boardID are synced, or nil if no account's are.
+func (h *Handler) boardClient(boardID string) (integrations.TrelloAPI, error) {
+	for _, account := range slices.Sorted(maps.Keys(h.TrelloClients())) {
+		known, err := h.knownBoard(account, boardID)
+		if err != nil {
+			return nil, err
+		}
+		if known {
+			return h.TrelloClient(account), nil
+		}
+	}
+	return nil, nil
+}
+
+// knownBoard reports whether the account's updates are received for boardID
+// and synced, as it belongs to the account's owner.
+func (h *Handler) knownBoard(account, boardID string) (bool, error) {
+	if boardID == "" || strings.Contains(boardID, ":") || !h.Tenancy().Owns(account, boardID) {
+		return false, nil
+	}
+	trackedAt, err := database.GetSetting(h.DB, trackedBoardPrefix(account)+boardID)
+	return trackedAt != "", err
+}
+
+// processBoardUpdate notes boards being closed or reopened in Trello. Cards
+// on a closed board are no longer synced, and their events are removed if
+// trello.remove_closed_board_events is set.
+func (h *Handler) processBoardUpdate(ctx context.Context, action models.TrelloAction) error {
+	board := action.Data.Board
+	if action.Data.Old.Closed == nil || board.ID == "" {
+		return nil // Some other change to the board
+	}
+
+	if !board.Closed {
+		logging.FromContext(ctx).Info("Trello board reopened; syncing its cards again", zap.String("boardID", board.ID), zap.String("boardName", board.Name))
+		return database.DeleteSetting(h.DB, closedBoardPrefix+board.ID)
+	}
+
+	logging.FromContext(ctx).Warn("Trello board closed; no longer syncing its cards", zap.String("boardID", board.ID), zap.String("boardName", board.Name))
+	if err := database.PutSetting(h.DB, closedBoardPrefix+board.ID, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to record closed board: %w", err)
+	}
+	if !h.Config().Trello.RemoveClosedBoardEvents {
+		return nil
+	}
+	return h.removeBoardEvents(ctx, board.ID)
+}
+
+// boardClosed reports whether boardID was closed in Trello.
+func (h *Handler) boardClosed(boardID string) (bool, error) {
+	closedAt, err := database.GetSetting(h.DB, closedBoardPrefix+boardID)
+	return closedAt != "", err
+}
+
+// closedBoards returns when each closed board was closed, keyed by board ID.
+func (h *Handler) closedBoards() (map[string]string, error) {
+	return database.ListSettings(h.DB, closedBoardPrefix)
+}
+
+// removeBoardEvents deletes the events of every Trello card on the board,
+// keeping the cards so they sync again if it's reopened.
+func (h *Handler) removeBoardEvents(ctx context.Context, boardID string) error {
+	var cardIDs []string
+	err := h.DB.WithContext(ctx).Model(&models.Card{}).
+		Where("source = '' AND board_id = ?", boardID).
+		Pluck("id", &cardIDs).Error
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+
+	var errs []error
+	for _, cardID := range cardIDs {
+		if err := h.removeCardEvents(ctx, cardID); err != nil {
+			errs = append(errs, fmt.Errorf("card %s: %w", cardID, err))
+		}
+	}
+	logging.FromContext(ctx).Info("Removed events of closed board's cards", zap.String("boardID", boardID), zap.Int("cards", len(cardIDs)))
+	return errors.Join(errs...)
+}
+
+func (h *Handler) removeCardEvents(ctx context.Context, cardID string) error {
+	unlock, err := h.lockCard(ctx, cardID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var card models.Card
+	db := h.DB.WithContext(ctx)
+	err = db.First(&card, "id = ?", cardID).Error
+	if err == nil {
+		err = database.LoadCardEvents(db, &card)
+	}
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	if err := h.removeCalendarEvent(ctx, &card); err != nil {
+		return err
+	}
+	h.removeTask(ctx, &card)
+	h.removeTargetEvents(ctx, &card)
+	return database.SaveCardEvents(db, &card)
+}
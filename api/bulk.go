@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// processListMove re-syncs the cards a moveAllCardsInList action moved out
+// of the list in its data. The action doesn't name the cards, so they are
+// looked up in Trello: the stored cards of the source list, and every open
+// card of the list they went to, which may be on another board.
+func (h *Handler) processListMove(ctx context.Context, action models.TrelloAction, client integrations.TrelloAPI) error {
+	sourceListID := action.Data.List.ID
+	if sourceListID == "" || client == nil {
+		logging.FromContext(ctx).Debug("Bulk card move names no source list, or its account has no client; skipping")
+		return nil
+	}
+
+	var cardIDs []string
+	err := h.DB.WithContext(ctx).Model(&models.Card{}).
+		Where("source = '' AND list_id = ?", sourceListID).
+		Pluck("id", &cardIDs).Error
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+
+	cards := make(map[string]*models.TrelloCard)
+	destBoardID, destListID := action.Data.BoardTarget.ID, action.Data.ListAfter.ID
+	for _, cardID := range cardIDs {
+		card, err := client.GetCard(ctx, cardID)
+		if integrations.IsTrelloNotFound(err) {
+			continue // Deleted since
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch moved card %s: %w", cardID, err)
+		}
+		cards[cardID] = card
+		// Older payloads don't say where the cards went, but the cards do
+		if destListID == "" && card.IDList != sourceListID {
+			destBoardID, destListID = card.IDBoard, card.IDList
+		}
+	}
+
+	// Cards that were never synced may need to be now they're in another list
+	if destListID != "" {
+		if destBoardID == "" {
+			destBoardID = action.Data.Board.ID
+		}
+		boardCards, err := client.ListCards(ctx, destBoardID, "open")
+		if err != nil {
+			return fmt.Errorf("failed to list cards of board %s: %w", destBoardID, err)
+		}
+		for i := range boardCards {
+			if boardCards[i].IDList == destListID {
+				cards[boardCards[i].ID] = &boardCards[i]
+			}
+		}
+	}
+
+	logging.FromContext(ctx).Info("Re-syncing cards of a bulk list move", zap.String("fromList", sourceListID), zap.String("toList", destListID), zap.String("toBoard", destBoardID), zap.Int("cards", len(cards)))
+
+	boardNames := map[string]string{
+		action.Data.Board.ID:       action.Data.Board.Name,
+		action.Data.BoardTarget.ID: action.Data.BoardTarget.Name,
+	}
+	var errs []error
+	for _, cardID := range slices.Sorted(maps.Keys(cards)) {
+		if err := h.syncFetchedCard(ctx, action, client, cards[cardID], boardNames); err != nil {
+			errs = append(errs, fmt.Errorf("card %s: %w", cardID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// processCardCreation syncs a card created by the action. Cards converted
+// from checklist items can have a due date from the start, which the action
+// doesn't carry, so the card is fetched.
+func (h *Handler) processCardCreation(ctx context.Context, action models.TrelloAction, client integrations.TrelloAPI) error {
+	cardID := action.Data.Card.ID
+	if cardID == "" || client == nil {
+		logging.FromContext(ctx).Debug("Created card has no ID, or its account has no client; skipping")
+		return nil
+	}
+	card, err := client.GetCard(ctx, cardID)
+	if integrations.IsTrelloNotFound(err) {
+		return nil // Deleted since
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch created card %s: %w", cardID, err)
+	}
+	logging.FromContext(ctx).Info("Syncing card created from a checklist item", zap.String("cardID", cardID))
+	boardNames := map[string]string{action.Data.Board.ID: action.Data.Board.Name}
+	return h.syncFetchedCard(ctx, action, client, card, boardNames)
+}
+
+// syncFetchedCard syncs the card, as just fetched from Trello, as an update
+// to it made by action, unless it is on a closed board. Names of boards
+// already looked up are given in boardNames.
+func (h *Handler) syncFetchedCard(ctx context.Context, action models.TrelloAction, client integrations.TrelloAPI, card *models.TrelloCard, boardNames map[string]string) error {
+	board, err := h.boardData(ctx, client, card.IDBoard, boardNames)
+	if err != nil {
+		return err
+	}
+	if closed, err := h.boardClosed(board.ID); err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	} else if closed {
+		return nil
+	}
+
+	update := models.TrelloAction{ID: action.ID, Date: action.Date, Type: "updateCard"}
+	update.Data.Card = cardDataFrom(*card)
+	update.Data.Board = board
+	update.Data.ListAfter = models.TrelloListData{ID: card.IDList}
+	return h.syncTrelloCard(ctx, models.TrelloWebhookPayload{Action: update}, client, card)
+}
+
+// boardData returns the ID and name of the board, looking the name up in
+// Trello unless names already has it, and adding it there.
+func (h *Handler) boardData(ctx context.Context, client integrations.TrelloAPI, boardID string, names map[string]string) (models.TrelloBoardData, error) {
+	if name, ok := names[boardID]; ok && name != "" {
+		return models.TrelloBoardData{ID: boardID, Name: name}, nil
+	}
+	board, err := client.GetBoard(ctx, boardID)
+	if err != nil {
+		return models.TrelloBoardData{}, fmt.Errorf("failed to fetch board %s: %w", boardID, err)
+	}
+	names[boardID] = board.Name
+	return models.TrelloBoardData{ID: boardID, Name: board.Name}, nil
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+)
+
+type orphanedEvent struct {
+	CalendarID string `json:"calendar_id"`
+	EventID    string `json:"event_id"`
+	CardID     string `json:"card_id"`
+	Summary    string `json:"summary"`
+	Reason     string `json:"reason"`
+}
+
+type cleanupSummary struct {
+	Scanned  int             `json:"scanned"`
+	Orphaned []orphanedEvent `json:"orphaned"`
+	Deleted  int             `json:"deleted"`
+	Failed   int             `json:"failed"`
+	DryRun   bool            `json:"dry_run"`
+}
+
+// CleanupOrphansHandler deletes synced events whose card no longer warrants
+// one. Pass ?dry_run=true to only list them. A tenant's API key only reaches
+// the tenant's calendar.
+func (h *Handler) CleanupOrphansHandler(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	summary, err := h.cleanupOrphanedEvents(c.Request.Context(), RequestTenant(c), dryRun)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Orphaned event cleanup failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "cleanup failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// RunOrphanSweeps runs the orphaned event cleanup every interval until ctx is done.
+func (h *Handler) RunOrphanSweeps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.cleanupOrphanedEvents(ctx, "", false); err != nil {
+				logging.FromContext(ctx).Error("Scheduled orphaned event cleanup failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// cleanupOrphanedEvents lists the events this service created on every
+// configured calendar, or only the tenant's unless tenant is empty, and
+// deletes those no card accounts for. Events accumulate this way whenever
+// webhooks are missed. Events of cards the database doesn't know are only
+// deleted once Trello confirms the card is gone, so a lost or rebuilt
+// database doesn't empty the calendars.
+func (h *Handler) cleanupOrphanedEvents(ctx context.Context, tenant string, dryRun bool) (cleanupSummary, error) {
+	summary := cleanupSummary{Orphaned: []orphanedEvent{}, DryRun: dryRun}
+	ttl := h.claimTTL()
+
+	calendarIDs := h.CalClient.ConfiguredCalendarIDs()
+	if tenant != "" {
+		calendarIDs = nil
+		if calendarID, ok := h.Tenancy().Calendars[tenant]; ok {
+			calendarIDs = []string{calendarID}
+		}
+	}
+
+	var ops []integrations.EventOp
+	for _, calendarID := range calendarIDs {
+		events, err := h.CalClient.ListManagedEvents(ctx, calendarID)
+		if err != nil {
+			return summary, err
+		}
+		summary.Scanned += len(events)
+
+		for _, event := range events {
+			cardID := integrations.EventCardID(event)
+			if !h.Claims.TryClaim(cardID, claims.OwnerReconciler, ttl) {
+				continue // Being synced right now; check again next sweep
+			}
+
+			reason, err := h.orphanReason(ctx, calendarID, cardID, event, dryRun)
+			if err != nil || reason == "" {
+				h.Claims.Release(cardID, claims.OwnerReconciler)
+				if err != nil {
+					return summary, err
+				}
+				continue
+			}
+
+			summary.Orphaned = append(summary.Orphaned, orphanedEvent{
+				CalendarID: calendarID,
+				EventID:    event.Id,
+				CardID:     cardID,
+				Summary:    event.Summary,
+				Reason:     reason,
+			})
+			ops = append(ops, integrations.EventOp{
+				Type:    integrations.EventOpDelete,
+				Card:    models.Card{ID: cardID, CalendarID: calendarID},
+				EventID: event.Id,
+			})
+		}
+	}
+
+	if dryRun {
+		for _, op := range ops {
+			h.Claims.Release(op.Card.ID, claims.OwnerReconciler)
+		}
+		return summary, nil
+	}
+
+	logging.FromContext(ctx).Info("Deleting orphaned calendar events", zap.Int("scanned", summary.Scanned), zap.Int("orphaned", len(ops)))
+	results, batchSummary := h.CalClient.ApplyBatch(ctx, ops)
+	for _, res := range results {
+		if res.Err == nil {
+			h.Outbound.Send(outbound.CardEvent(outbound.Deleted, res.Op.Card, outbound.TargetCalendar, res.Op.EventID))
+		}
+	}
+	for _, op := range ops {
+		h.Claims.Release(op.Card.ID, claims.OwnerReconciler)
+	}
+	summary.Deleted = batchSummary.Deleted
+	summary.Failed = batchSummary.Failed
+
+	return summary, nil
+}
+
+// orphanReason explains why event should no longer exist, or returns "" if
+// its card still accounts for it.
+func (h *Handler) orphanReason(ctx context.Context, calendarID, cardID string, event *calendar.Event, dryRun bool) (string, error) {
+	if cardID == "" {
+		return "event has no Trello card ID", nil
+	}
+
+	var card models.Card
+	err := h.DB.First(&card, "id = ?", cardID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return h.unknownCardReason(ctx, calendarID, cardID, event, dryRun)
+	}
+	if err == nil {
+		err = database.LoadCardEvents(h.DB, &card)
+	}
+	if err != nil {
+		return "", fmt.Errorf("database query failed: %w", err)
+	}
+	return h.cardOrphanReason(card, calendarID, event), nil
+}
+
+// unknownCardReason asks Trello, through the account syncing the board in
+// the event's extended properties, about a card the database has no record
+// of. The event is orphaned if the card no longer exists; otherwise the card
+// is stored again, linked to the event, unless dryRun is set. Events Trello
+// can't be asked about are kept.
+func (h *Handler) unknownCardReason(ctx context.Context, calendarID, cardID string, event *calendar.Event, dryRun bool) (string, error) {
+	log := logging.FromContext(ctx).With(zap.String("cardID", cardID), zap.String("eventID", event.Id))
+	boardID := event.ExtendedProperties.Private[integrations.PropBoardID]
+	client, err := h.boardClient(boardID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load tracked boards: %w", err)
+	}
+	if client == nil {
+		log.Warn("Keeping event of an unknown card on a board no account syncs", zap.String("boardID", boardID))
+		return "", nil
+	}
+	fetched, err := client.GetCard(ctx, cardID)
+	if integrations.IsTrelloNotFound(err) {
+		return "card no longer exists in Trello", nil
+	}
+	if err != nil {
+		log.Warn("Keeping event of an unknown card Trello couldn't be asked about", zap.Error(err))
+		return "", nil
+	}
+
+	card := models.Card{
+		ID:          fetched.ID,
+		Name:        event.Summary,
+		Description: fetched.Desc,
+		URL:         fmt.Sprintf("https://trello.com/c/%s", fetched.ShortLink),
+		BoardID:     cmp.Or(fetched.IDBoard, boardID),
+		ListID:      fetched.IDList,
+		Labels:      labelKeys(fetched.Labels),
+		Archived:    fetched.Closed,
+		EventID:     event.Id,
+		CalendarID:  calendarID,
+	}
+	card.Tenant = h.Tenancy().BoardTenant(card.BoardID)
+	if due, err := time.Parse(time.RFC3339, fetched.Due); err == nil {
+		card.DueDate = &due
+	}
+	if reason := h.cardOrphanReason(card, calendarID, event); reason != "" || dryRun {
+		return reason, nil
+	}
+	if err := database.SaveCard(h.DB, &card); err != nil {
+		return "", fmt.Errorf("failed to relink event: %w", err)
+	}
+	log.Info("Relinked calendar event to card from extended properties")
+	return "", nil
+}
+
+// cardOrphanReason explains why the stored card no longer accounts for
+// event, or returns "" if it does
+func (h *Handler) cardOrphanReason(card models.Card, calendarID string, event *calendar.Event) string {
+	switch {
+	case card.Archived:
+		return "card is archived"
+	case card.DueDate == nil:
+		return "card has no due date"
+	case !h.wantsEvent(card):
+		return "card is excluded by sync rules"
+	case card.EventID != event.Id || h.CalClient.CalendarFor(card) != calendarID:
+		return "card is linked to a different event"
+	}
+	return ""
+}
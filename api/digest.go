@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/digest"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// digestCheckInterval is how often RunDigests checks whether a digest is due
+const digestCheckInterval = time.Minute
+
+// RunDigests emails the digest whenever it is due until ctx is done. The
+// schedule is read afresh at every check, so config reloads apply at once.
+func (h *Handler) RunDigests(ctx context.Context) {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	lastCheck := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cfg := h.Config().Digest
+			if len(cfg.To) == 0 {
+				lastCheck = now
+				continue
+			}
+			due, err := digest.Next(cfg, lastCheck)
+			lastCheck = now
+			if err != nil {
+				logging.FromContext(ctx).Error("Invalid digest schedule", zap.Error(err))
+				continue
+			}
+			if due.After(now) {
+				continue
+			}
+			if count, err := h.sendDigest(ctx); err != nil {
+				logging.FromContext(ctx).Error("Failed to send due date digest", zap.Error(err))
+			} else {
+				logging.FromContext(ctx).Info("Sent due date digest", zap.Int("cards", count), zap.Strings("to", cfg.To))
+			}
+		}
+	}
+}
+
+// SendDigestHandler emails the digest now, whatever the schedule, for
+// checking the mail settings.
+func (h *Handler) SendDigestHandler(c *gin.Context) {
+	cfg := h.Config().Digest
+	if len(cfg.To) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "digest.to is not configured"})
+		return
+	}
+	count, err := h.sendDigest(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to send due date digest", zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": logging.Redact(err.Error())})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cards": count, "to": cfg.To})
+}
+
+// sendDigest emails the operator's cards due in the next digest.days days and
+// returns how many there were.
+func (h *Handler) sendDigest(ctx context.Context) (int, error) {
+	cfg := h.Config().Digest
+	days := cfg.Days
+	if days <= 0 {
+		days = 7
+	}
+	now := time.Now()
+	if cfg.Timezone != "" {
+		if location, err := time.LoadLocation(cfg.Timezone); err == nil {
+			now = now.In(location)
+		}
+	}
+
+	cards, err := database.ListCardsDueBetween(h.DB.WithContext(ctx).Scopes(database.TenantCards("")), now, now.AddDate(0, 0, days))
+	if err == nil {
+		err = database.LoadCardListEvents(h.DB.WithContext(ctx), cards)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database query failed: %w", err)
+	}
+
+	items := make([]digest.Item, 0, len(cards))
+	boardNames := make(map[string]string)
+	for _, card := range cards {
+		item := digest.Item{Card: card}
+		if card.EventID != "" {
+			item.EventLink = integrations.EventURL(card.EventID, h.CalClient.CalendarFor(card))
+		}
+		items = append(items, item)
+		if _, ok := boardNames[card.BoardID]; !ok {
+			boardNames[card.BoardID] = h.boardName(ctx, card.BoardID)
+		}
+	}
+
+	subject, body := digest.Build(items, boardNames, now, days)
+	if err := digest.Send(cfg, subject, body); err != nil {
+		return 0, err
+	}
+	return len(cards), nil
+}
+
+// boardName looks the board up with whichever Trello account can see it, or
+// returns "" if none can
+func (h *Handler) boardName(ctx context.Context, boardID string) string {
+	for _, client := range h.TrelloClients() {
+		if board, err := client.GetBoard(ctx, boardID); err == nil {
+			return board.Name
+		}
+	}
+	return ""
+}
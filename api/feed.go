@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/ical"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// FeedHandler serves every synced card with a due date as an iCalendar feed,
+// for calendar apps that can subscribe to a URL. Tenants' cards are left out,
+// as their events go to calendars of their own. Calendar apps can't send
+// headers, so the token from feed.token is passed as ?token=.
+func (h *Handler) FeedHandler(c *gin.Context) {
+	token := h.Config().Feed.Token
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed is disabled"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing token"})
+		return
+	}
+
+	var cards []models.Card
+	if err := h.DB.Scopes(database.TenantCards("")).Where("archived = ? AND due_date IS NOT NULL", false).Order("due_date").Find(&cards).Error; err != nil {
+		zap.L().Error("Failed to load cards for feed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load cards"})
+		return
+	}
+
+	cal := ical.Calendar{Name: h.Config().Feed.Name, Method: "PUBLISH"}
+	if cal.Name == "" {
+		cal.Name = "Trello due dates"
+	}
+	for _, card := range cards {
+		if !h.Rules().Evaluate(ruleCard(card)).Sync {
+			continue
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:         card.ID + "@trello-gcal-sync",
+			Summary:     card.Name,
+			Description: card.Description,
+			URL:         card.URL,
+			Date:        *card.DueDate,
+			Modified:    card.UpdatedAt,
+		})
+	}
+
+	c.Header("Content-Disposition", `inline; filename="feed.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(cal.Render()))
+}
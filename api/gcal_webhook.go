@@ -0,0 +1,386 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultWatchTTL = 7 * 24 * time.Hour
+	watchRenewLead  = time.Hour
+)
+
+// StartCalendarWatch opens a Google Calendar watch channel on every configured
+// calendar, pointing at google.calendar.watch.callback_url, and keeps them
+// renewed until ctx is done. It is a no-op when no callback URL is configured.
+func (h *Handler) StartCalendarWatch(ctx context.Context) error {
+	address := h.Config().Google.Calendar.Watch.CallbackURL
+	if address == "" {
+		logging.FromContext(ctx).Debug("google.calendar.watch.callback_url not set; calendar change notifications disabled")
+		return nil
+	}
+
+	for _, calendarID := range h.CalClient.ConfiguredCalendarIDs() {
+		channel, err := h.openWatchChannel(ctx, calendarID, address)
+		if err != nil {
+			return err
+		}
+		go h.renewWatchChannel(ctx, channel, address)
+	}
+
+	return nil
+}
+
+func (h *Handler) renewWatchChannel(ctx context.Context, channel *models.WatchChannel, address string) {
+	for {
+		wait := time.Until(channel.Expiration.Add(-watchRenewLead))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		renewed, err := h.openWatchChannel(ctx, channel.CalendarID, address)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to renew Google Calendar watch channel; retrying shortly", zap.String("calendarID", channel.CalendarID), zap.Error(err))
+			channel.Expiration = time.Now().Add(watchRenewLead + time.Minute)
+			continue
+		}
+		channel = renewed
+	}
+}
+
+// StopCalendarWatch closes the active watch channel. The sync token is kept so
+// the next run picks up changes made while the service was down.
+func (h *Handler) StopCalendarWatch(ctx context.Context) {
+	var channels []models.WatchChannel
+	if err := h.DB.Find(&channels).Error; err != nil {
+		logging.FromContext(ctx).Error("Failed to load watch channels", zap.Error(err))
+		return
+	}
+
+	for _, channel := range channels {
+		if err := h.CalClient.StopChannel(ctx, channel.ID, channel.ResourceID); err != nil {
+			logging.FromContext(ctx).Error("Error stopping Google Calendar watch channel", zap.String("channelID", channel.ID), zap.Error(err))
+		} else {
+			logging.FromContext(ctx).Info("Stopped Google Calendar watch channel", zap.String("channelID", channel.ID))
+		}
+	}
+}
+
+// openWatchChannel replaces any existing channel on calendarID with a new one,
+// carrying over the sync token so no changes are lost across the switch.
+func (h *Handler) openWatchChannel(ctx context.Context, calendarID, address string) (*models.WatchChannel, error) {
+	var previous models.WatchChannel
+	err := h.DB.Where("calendar_id = ?", calendarID).Order("created_at DESC").First(&previous).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load existing watch channel: %w", err)
+	}
+
+	syncToken := previous.SyncToken
+	if syncToken == "" {
+		if _, syncToken, err = h.CalClient.ListChangedEvents(ctx, calendarID, ""); err != nil {
+			return nil, fmt.Errorf("failed to establish initial sync token: %w", err)
+		}
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate channel token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	ttl := h.Config().Google.Calendar.Watch.TTL
+	if ttl <= 0 {
+		ttl = defaultWatchTTL
+	}
+
+	created, err := h.CalClient.WatchEvents(ctx, calendarID, uuid.NewString(), address, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := models.WatchChannel{
+		ID:         created.Id,
+		ResourceID: created.ResourceId,
+		CalendarID: calendarID,
+		Token:      token,
+		SyncToken:  syncToken,
+		Expiration: time.UnixMilli(created.Expiration),
+	}
+	if err := h.DB.Create(&channel).Error; err != nil {
+		return nil, fmt.Errorf("failed to save watch channel: %w", err)
+	}
+
+	if previous.ID != "" {
+		if err := h.CalClient.StopChannel(ctx, previous.ID, previous.ResourceID); err != nil {
+			logging.FromContext(ctx).Warn("Failed to stop previous watch channel", zap.String("channelID", previous.ID), zap.Error(err))
+		}
+		h.DB.Delete(&previous)
+	}
+
+	logging.FromContext(ctx).Info("Watching Google Calendar for event changes", zap.String("calendarID", calendarID), zap.String("channelID", channel.ID), zap.Time("expiration", channel.Expiration))
+	return &channel, nil
+}
+
+func (h *Handler) GoogleCalendarWebhookHandler(c *gin.Context) {
+	channelID := c.GetHeader("X-Goog-Channel-ID")
+	state := c.GetHeader("X-Goog-Resource-State")
+
+	var channel models.WatchChannel
+	if err := h.DB.First(&channel, "id = ?", channelID).Error; err != nil {
+		logging.FromContext(c.Request.Context()).Warn("Received notification for unknown watch channel", zap.String("channelID", channelID))
+		// Tell Google to stop sending notifications for channels we don't know about
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Goog-Channel-Token")), []byte(channel.Token)) != 1 {
+		logging.FromContext(c.Request.Context()).Warn("Rejected calendar notification with invalid channel token", zap.String("channelID", channelID))
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	logging.FromContext(c.Request.Context()).Debug("Received Google Calendar notification", zap.String("channelID", channelID), zap.String("state", state))
+
+	// The initial "sync" message only confirms the channel is live
+	if state == "sync" {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	// The sync outlives this request, so it keeps the request's values but not
+	// its cancellation
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		if err := h.syncCalendarChanges(ctx, channelID); err != nil {
+			logging.FromContext(c.Request.Context()).Error("Error processing Google Calendar changes", zap.Error(err))
+		}
+	}()
+	c.Status(http.StatusOK)
+}
+
+// syncCalendarChanges pulls every event changed since the stored sync token and
+// repairs any synced event that no longer matches its card.
+func (h *Handler) syncCalendarChanges(ctx context.Context, channelID string) error {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	var channel models.WatchChannel
+	if err := h.DB.First(&channel, "id = ?", channelID).Error; err != nil {
+		return fmt.Errorf("failed to load watch channel: %w", err)
+	}
+
+	events, nextToken, err := h.CalClient.ListChangedEvents(ctx, channel.CalendarID, channel.SyncToken)
+	if errors.Is(err, integrations.ErrSyncTokenExpired) {
+		logging.FromContext(ctx).Warn("Calendar sync token expired; performing full resync", zap.String("calendarID", channel.CalendarID))
+		events, nextToken, err = h.CalClient.ListChangedEvents(ctx, channel.CalendarID, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if err := h.reconcileCalendarEvent(ctx, channel.CalendarID, event); err != nil {
+			logging.FromContext(ctx).Error("Failed to reconcile calendar event", zap.String("eventID", event.Id), zap.Error(err))
+		}
+	}
+
+	if err := h.DB.Model(&channel).Update("sync_token", nextToken).Error; err != nil {
+		return fmt.Errorf("failed to save sync token: %w", err)
+	}
+	return nil
+}
+
+// reconcileCalendarEvent restores a synced event that was deleted in Google
+// Calendar from the card's stored state, and settles a date it was moved to
+// by the board's conflict policy.
+func (h *Handler) reconcileCalendarEvent(ctx context.Context, calendarID string, event *calendar.Event) error {
+	cardID, err := database.FindCardByEvent(h.DB, models.TargetCalendar, event.Id)
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	if cardID == "" {
+		return h.relinkCalendarEvent(calendarID, event)
+	}
+
+	claimCtx, cancel := context.WithTimeout(ctx, h.claimTTL())
+	defer cancel()
+	if !h.Claims.Claim(claimCtx, cardID, claims.OwnerCalendarWatch, h.claimTTL()) {
+		return fmt.Errorf("timed out waiting to claim card %s", cardID)
+	}
+	defer h.Claims.Release(cardID, claims.OwnerCalendarWatch)
+
+	// Load under the claim; a webhook may have replaced the event meanwhile
+	var card models.Card
+	err = h.DB.First(&card, "id = ?", cardID).Error
+	if err == nil {
+		err = database.LoadCardEvents(h.DB, &card)
+	}
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	// Events moved to another calendar by routing show up as cancelled on the old one
+	if card.EventID != event.Id || h.CalClient.CalendarFor(card) != calendarID {
+		return nil
+	}
+
+	wantsEvent := h.wantsEvent(card)
+
+	if event.Status == "cancelled" {
+		if !wantsEvent || h.staleOverdue(ctx, card) {
+			card.EventID, card.CalendarID = "", ""
+			return database.SaveCardEvents(h.DB, &card)
+		}
+
+		logging.FromContext(ctx).Info("Synced event was deleted in Google Calendar; recreating", zap.String("cardID", card.ID), zap.String("eventID", event.Id))
+		created, err := h.CalClient.CreateEvent(ctx, card)
+		if err != nil {
+			return err
+		}
+		h.Outbound.Send(outbound.CardEvent(outbound.Created, card, outbound.TargetCalendar, created.Id))
+		card.EventID = created.Id
+		return database.SaveCardEvents(h.DB, &card)
+	}
+
+	if !wantsEvent || event.Start == nil {
+		return nil
+	}
+
+	day, ok := eventDay(event.Start)
+	if !ok || day.Format(time.DateOnly) == card.DueDate.Format(time.DateOnly) {
+		return nil
+	}
+
+	policy := h.Config().Google.Calendar.PolicyFor(card.BoardID)
+	if policy == config.NewestWins {
+		policy = config.TrelloWins
+		if calendarNewer(card, event) {
+			policy = config.CalendarWins
+		}
+	}
+	if policy == config.CalendarWins && card.Source != "" {
+		// Only Trello due dates can be written back
+		logging.FromContext(ctx).Debug("Can't move the due date of a card from another source; restoring event", zap.String("cardID", card.ID), zap.String("source", card.Source))
+		policy = config.TrelloWins
+	}
+
+	if policy == config.CalendarWins {
+		logging.FromContext(ctx).Info("Synced event was moved in Google Calendar; moving Trello due date",
+			zap.String("cardID", card.ID),
+			zap.String("eventID", event.Id),
+			zap.String("calendarDate", day.Format(time.DateOnly)),
+			zap.Time("dueDate", *card.DueDate),
+		)
+		return h.moveDueToEvent(ctx, &card, day)
+	}
+
+	logging.FromContext(ctx).Info("Synced event was moved in Google Calendar; restoring Trello due date",
+		zap.String("cardID", card.ID),
+		zap.String("eventID", event.Id),
+		zap.String("calendarDate", day.Format(time.DateOnly)),
+		zap.Time("dueDate", *card.DueDate),
+	)
+	if _, err := h.CalClient.UpdateEvent(ctx, card, event.Id); err != nil {
+		return err
+	}
+	h.Outbound.Send(outbound.CardEvent(outbound.Updated, card, outbound.TargetCalendar, event.Id))
+	return nil
+}
+
+// calendarNewer reports whether the event was edited after the last change to
+// the card in Trello. Cards with no Trello action applied yet fall back to
+// when they were last saved.
+func calendarNewer(card models.Card, event *calendar.Event) bool {
+	updated, err := time.Parse(time.RFC3339, event.Updated)
+	if err != nil {
+		return false
+	}
+	changed := card.UpdatedAt
+	if card.LastActionAt != nil {
+		changed = *card.LastActionAt
+	}
+	return updated.After(changed)
+}
+
+// eventDay returns the day an event starts on, in its own time zone for
+// events that were given a time
+func eventDay(start *calendar.EventDateTime) (time.Time, bool) {
+	if start.Date != "" {
+		day, err := time.Parse(time.DateOnly, start.Date)
+		return day, err == nil
+	}
+	at, err := time.Parse(time.RFC3339, start.DateTime)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC), true
+}
+
+// moveDueToEvent moves the card's due date in Trello to day, keeping its time
+// of day, with the account that syncs the card's board.
+func (h *Handler) moveDueToEvent(ctx context.Context, card *models.Card, day time.Time) error {
+	hour, minute, second := card.DueDate.Clock()
+	due := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, card.DueDate.Location())
+
+	client, err := h.boardClient(card.BoardID)
+	if err != nil {
+		return fmt.Errorf("failed to load tracked boards: %w", err)
+	}
+	if client == nil {
+		return fmt.Errorf("no Trello account syncs board %s to move the due date with", card.BoardID)
+	}
+	if err := client.SetCardDue(ctx, card.ID, due); err != nil {
+		return fmt.Errorf("failed to move Trello due date: %w", err)
+	}
+
+	// Store the new date so Trello's webhook for the change finds the event
+	// already in place
+	card.DueDate = &due
+	if err := h.DB.Model(card).Update("due_date", due).Error; err != nil {
+		return fmt.Errorf("failed to save moved due date: %w", err)
+	}
+	return nil
+}
+
+// relinkCalendarEvent reattaches an event we created to its card when the
+// card has lost track of it, e.g. after the database was restored or rebuilt.
+func (h *Handler) relinkCalendarEvent(calendarID string, event *calendar.Event) error {
+	cardID := integrations.EventCardID(event)
+	if cardID == "" || event.Status == "cancelled" {
+		return nil // Not an event we manage, or nothing to relink
+	}
+
+	relinked, err := database.RelinkTargetEvent(h.DB, models.TargetEvent{
+		CardID:    cardID,
+		Target:    models.TargetCalendar,
+		EventID:   event.Id,
+		Container: calendarID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to relink event: %w", err)
+	}
+	if relinked {
+		zap.L().Info("Relinked calendar event to card from extended properties", zap.String("cardID", cardID), zap.String("eventID", event.Id))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestCalendarNewer(t *testing.T) {
+	base := time.Date(2030, 3, 4, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name    string
+		card    models.Card
+		updated string
+		want    bool
+	}{
+		{
+			name:    "calendar edited after the Trello change, before a local save",
+			card:    models.Card{LastActionAt: ptr(at(0)), UpdatedAt: at(10)},
+			updated: at(5).Format(time.RFC3339),
+			want:    true,
+		},
+		{
+			name:    "Trello changed after the calendar edit",
+			card:    models.Card{LastActionAt: ptr(at(10)), UpdatedAt: at(10)},
+			updated: at(5).Format(time.RFC3339),
+			want:    false,
+		},
+		{
+			name:    "no Trello action yet, calendar edited after the save",
+			card:    models.Card{UpdatedAt: at(0)},
+			updated: at(5).Format(time.RFC3339),
+			want:    true,
+		},
+		{
+			name:    "no Trello action yet, saved after the calendar edit",
+			card:    models.Card{UpdatedAt: at(10)},
+			updated: at(5).Format(time.RFC3339),
+			want:    false,
+		},
+		{
+			name:    "unreadable edit time",
+			card:    models.Card{LastActionAt: ptr(at(0))},
+			updated: "",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calendarNewer(tt.card, &calendar.Event{Updated: tt.updated}); got != tt.want {
+				t.Errorf("calendarNewer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
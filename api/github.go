@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Card ID prefixes of GitHub issues and milestones. Their numeric IDs are
+// used rather than numbers, which are only unique within a repository.
+const (
+	githubIssuePrefix     = models.SourceGitHub + ":issue:"
+	githubMilestonePrefix = models.SourceGitHub + ":milestone:"
+)
+
+// issueDueDate returns the date of the issue's first due label, or else its
+// milestone's due date.
+func issueDueDate(issue models.GitHubIssue, labelPrefix string) *time.Time {
+	if labelPrefix != "" {
+		for _, label := range issue.Labels {
+			if len(label.Name) < len(labelPrefix) || !strings.EqualFold(label.Name[:len(labelPrefix)], labelPrefix) {
+				continue
+			}
+			if due, err := time.Parse(time.DateOnly, strings.TrimSpace(label.Name[len(labelPrefix):])); err == nil {
+				return &due
+			}
+		}
+	}
+	if issue.Milestone != nil {
+		return utcDay(issue.Milestone.DueOn)
+	}
+	return nil
+}
+
+func (h *Handler) githubIssueCard(repo string, issue models.GitHubIssue) models.Card {
+	card := models.Card{
+		ID:          githubIssuePrefix + strconv.FormatInt(issue.ID, 10),
+		Source:      models.SourceGitHub,
+		Name:        fmt.Sprintf("[%s#%d] %s", repo, issue.Number, issue.Title),
+		Description: issue.Body,
+		URL:         issue.HTMLURL,
+		BoardID:     repo,
+		DueDate:     issueDueDate(issue, h.Config().GitHub.DueLabelPrefix),
+		Archived:    issue.State == "closed",
+	}
+	if issue.Milestone != nil {
+		card.ListID = issue.Milestone.Title
+	}
+	return card
+}
+
+func githubMilestoneCard(repo string, milestone models.GitHubMilestone) models.Card {
+	return models.Card{
+		ID:          githubMilestonePrefix + strconv.FormatInt(milestone.ID, 10),
+		Source:      models.SourceGitHub,
+		Name:        fmt.Sprintf("[%s] Milestone %s", repo, milestone.Title),
+		Description: milestone.Description,
+		URL:         milestone.HTMLURL,
+		BoardID:     repo,
+		ListID:      milestone.Title,
+		DueDate:     utcDay(milestone.DueOn),
+		Archived:    milestone.State == "closed",
+	}
+}
+
+// GitHubWebhookHandler syncs the issues and milestones of the configured
+// repositories as GitHub reports changes to them. Failed syncs are answered
+// with an error, so they show as failed deliveries that can be redelivered.
+func (h *Handler) GitHubWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+	if !validHubSignature(h.Config().GitHub.WebhookSecret, c.GetHeader("X-Hub-Signature-256"), body) {
+		logging.FromContext(ctx).Warn("Rejected GitHub webhook with a missing or wrong signature")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	event := c.GetHeader("X-GitHub-Event")
+	if event != "issues" && event != "milestone" {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+	var payload models.GitHubWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	repo := payload.Repository.FullName
+	if _, ok := h.Config().GitHub.Repo(repo); !ok {
+		logging.FromContext(ctx).Debug("Ignoring webhook from unconfigured GitHub repository", zap.String("repo", repo))
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+	if paused, err := h.Paused(ctx); err != nil || paused {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPaused.Error()})
+		return
+	}
+
+	ctx = logging.With(ctx, zap.String("repo", repo), zap.String("event", event), zap.String("action", payload.Action))
+	switch {
+	case event == "issues" && payload.Issue != nil:
+		err = h.syncGitHubIssue(ctx, repo, payload)
+	case event == "milestone" && payload.Milestone != nil:
+		err = h.syncGitHubMilestone(ctx, repo, payload)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to sync GitHub webhook", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "sync failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+}
+
+func (h *Handler) syncGitHubIssue(ctx context.Context, repo string, payload models.GitHubWebhookPayload) error {
+	card := h.githubIssueCard(repo, *payload.Issue)
+	switch payload.Action {
+	case "deleted", "transferred":
+		// A transferred issue arrives in its new repository as opened
+		return h.removeSourceCard(ctx, card.ID)
+	default:
+		return h.syncSourceCard(ctx, card)
+	}
+}
+
+// syncGitHubMilestone syncs the milestone's own event, and the issues in it
+// that take their date from it when its title or due date changes, since
+// GitHub doesn't report those as changes to the issues.
+func (h *Handler) syncGitHubMilestone(ctx context.Context, repo string, payload models.GitHubWebhookPayload) error {
+	milestone := *payload.Milestone
+	card := githubMilestoneCard(repo, milestone)
+	if payload.Action == "deleted" {
+		if err := h.removeSourceCard(ctx, card.ID); err != nil {
+			return err
+		}
+		return h.updateMilestoneIssues(ctx, repo, milestone.Title, milestone.DueOn, nil)
+	}
+	if err := h.syncSourceCard(ctx, card); err != nil {
+		return err
+	}
+
+	changes := payload.Changes
+	if payload.Action != "edited" || (changes.Title == nil && changes.DueOn == nil) {
+		return nil
+	}
+	title, due := milestone.Title, milestone.DueOn
+	if changes.Title != nil {
+		title = changes.Title.From
+	}
+	if changes.DueOn != nil {
+		due = changes.DueOn.From
+	}
+	return h.updateMilestoneIssues(ctx, repo, title, due, &milestone)
+}
+
+// updateMilestoneIssues moves the stored issues of the milestone that was
+// titled title and due on due to its new state, or out of it if milestone is
+// nil. Issues dated by a due label keep their date; those are told apart by
+// a date other than the milestone's.
+func (h *Handler) updateMilestoneIssues(ctx context.Context, repo, title string, due *time.Time, milestone *models.GitHubMilestone) error {
+	var cards []models.Card
+	err := h.DB.WithContext(ctx).
+		Where("source = ? AND board_id = ? AND list_id = ? AND id LIKE ?", models.SourceGitHub, repo, title, githubIssuePrefix+"%").
+		Find(&cards).Error
+	if err != nil {
+		return fmt.Errorf("failed to load milestone issues: %w", err)
+	}
+
+	oldDue := utcDay(due)
+	var errs []error
+	for _, card := range cards {
+		update := card
+		update.ListID = ""
+		var newDue *time.Time
+		if milestone != nil {
+			update.ListID = milestone.Title
+			newDue = utcDay(milestone.DueOn)
+		}
+		if (card.DueDate == nil && oldDue == nil) || (card.DueDate != nil && oldDue != nil && card.DueDate.Equal(*oldDue)) {
+			update.DueDate = newDue
+		}
+		if err := h.syncSourceCard(ctx, update); err != nil {
+			errs = append(errs, fmt.Errorf("issue card %s: %w", card.ID, err))
+		}
+	}
+	if len(cards) > 0 {
+		logging.FromContext(ctx).Info("Updated issues of changed GitHub milestone", zap.String("milestone", title), zap.Int("issues", len(cards)))
+	}
+	return errors.Join(errs...)
+}
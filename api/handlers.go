@@ -1,209 +1,940 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
 	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/cardlock"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/debounce"
+	"github.com/chxlky/trello-gcal-sync/internal/jobs"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
 	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/notify"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/chxlky/trello-gcal-sync/internal/titles"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	DB        *gorm.DB
-	CalClient *integrations.CalendarClient
-	Workers   chan struct{}
+	DB          *gorm.DB
+	CalClient   integrations.CalendarAPI
+	TasksClient integrations.TasksAPI
+	Jobs        *jobs.Queue
+	Claims      *claims.Registry
+	CardLocks   *cardlock.Locker
+	Debounce    *debounce.Debouncer
+	Notifier    *notify.Notifier
+	Outbound    *outbound.Dispatcher
+	Jira        *integrations.JiraClient  // Nil unless jira.url is set
+	Asana       *integrations.AsanaClient // Nil without asana.projects
+
+	// Writes queues the card saves of bulk syncs, such as backfills, which
+	// are written in chunks rather than a transaction per card
+	Writes *database.CardBatch
+
+	// Targets holds every sync target rules can route cards to, keyed by
+	// name. Google Calendar and Tasks are synced by their own code paths, which
+	// handle calendar routing and task lists; other targets go through the
+	// SyncTarget interface alone.
+	Targets map[string]integrations.SyncTarget
+
+	// The config and rules are replaced as a whole when config.toml is
+	// reloaded, so each sync sees one consistent version
+	cfg       atomic.Pointer[config.Config]
+	syncRules atomic.Pointer[rules.Set]
+	tenancy   atomic.Pointer[Tenancy]
+
+	// The Trello clients by account name, replaced as a whole when an account
+	// is added, as tenants are while the service runs
+	trelloMu sync.Mutex
+	trello   atomic.Pointer[map[string]integrations.TrelloAPI]
+
+	watchMu sync.Mutex // Serialises incremental syncs of calendar changes
+
+	unknownBoardDeliveries atomic.Int64 // Webhook deliveries turned away by board
+	skippedEventUpdates    atomic.Int64 // Calendar updates left out as nothing in the event changed
+	refusedCards           tenantCounts // Cards not synced as their tenant was at its card limit
+	backfill               backfillState
 }
 
-func (h *Handler) TrelloWebhookHandler(c *gin.Context) {
-	// Trello sends a HEAD request to validate the webhook endpoint upon creation
-	if c.Request.Method != http.MethodPost {
-		zap.L().Debug("Received non-POST request to webhook endpoint; responding with 200 OK")
-		c.Status(http.StatusOK)
-		return
+// Config returns the configuration currently in effect.
+func (h *Handler) Config() *config.Config {
+	return h.cfg.Load()
+}
+
+func (h *Handler) SetConfig(cfg *config.Config) {
+	h.cfg.Store(cfg)
+}
+
+// TrelloClients returns the client of each Trello account, by account name.
+// The map is never changed once returned, so it can be ranged over freely.
+func (h *Handler) TrelloClients() map[string]integrations.TrelloAPI {
+	if clients := h.trello.Load(); clients != nil {
+		return *clients
 	}
+	return nil
+}
 
-	var payload models.TrelloWebhookPayload
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		zap.L().Error("Could not bind JSON payload - likely an empty validation POST", zap.Error(err))
-		// Respond with 200 OK to satisfy Trello's validation, even if the payload is empty
-		c.Status(http.StatusOK)
-		return
+// TrelloClient returns the Trello account's client, or nil if there's none.
+func (h *Handler) TrelloClient(account string) integrations.TrelloAPI {
+	return h.TrelloClients()[account]
+}
+
+// SetTrelloClient adds the Trello account's client, or replaces it.
+func (h *Handler) SetTrelloClient(account string, client integrations.TrelloAPI) {
+	h.trelloMu.Lock()
+	defer h.trelloMu.Unlock()
+	clients := maps.Clone(h.TrelloClients())
+	if clients == nil {
+		clients = make(map[string]integrations.TrelloAPI)
+	}
+	clients[account] = client
+	h.trello.Store(&clients)
+}
+
+// Rules returns the sync rules currently in effect.
+func (h *Handler) Rules() *rules.Set {
+	return h.syncRules.Load()
+}
+
+func (h *Handler) SetRules(set *rules.Set) {
+	h.syncRules.Store(set)
+}
+
+const (
+	defaultClaimTTL   = 2 * time.Minute
+	defaultRetryDelay = 30 * time.Second
+)
+
+// claimTTL is how long a card claim is honoured before it is considered stale
+func (h *Handler) claimTTL() time.Duration {
+	if ttl := h.Config().Sync.ClaimTTL; ttl > 0 {
+		return ttl
 	}
+	return defaultClaimTTL
+}
 
-	action := payload.Action
-	card := action.Data.Card
+// TrelloWebhookHandler receives webhook deliveries for a Trello account. It
+// only validates and queues the action, since Trello gives up on callbacks
+// that respond slowly and eventually disables the webhook.
+func (h *Handler) TrelloWebhookHandler(account string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Trello sends a HEAD request to validate the webhook endpoint upon creation
+		if c.Request.Method != http.MethodPost {
+			logging.FromContext(c.Request.Context()).Debug("Received non-POST request to webhook endpoint; responding with 200 OK")
+			c.Status(http.StatusOK)
+			return
+		}
 
-	zap.L().Debug("Received Trello webhook", zap.String("actionType", action.Type), zap.String("cardID", card.ID))
+		var payload models.TrelloWebhookPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			logging.FromContext(c.Request.Context()).Error("Could not bind JSON payload - likely an empty validation POST", zap.Error(err))
+			// Respond with 200 OK to satisfy Trello's validation, even if the payload is empty
+			c.Status(http.StatusOK)
+			return
+		}
 
-	// Acquire a worker slot
-	h.Workers <- struct{}{}
-	go func() {
-		defer func() { <-h.Workers }() // Release the worker slot when done
+		action := payload.Action
+		logging.FromContext(c.Request.Context()).Debug("Received Trello webhook", zap.String("actionType", action.Type), zap.String("cardID", action.Data.Card.ID))
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(actionAttributes(account, action)...)
 
-		if err := h.processCardUpdate(payload); err != nil {
-			zap.L().Error("Error processing card update", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		// Only boards this service registered webhooks for are synced, which
+		// also turns away forged deliveries naming other boards
+		known, err := h.knownBoard(account, action.Data.Board.ID)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("Could not look up tracked Trello boards", zap.Error(err))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not check board"})
+			return
+		}
+		if !known {
+			h.unknownBoardDeliveries.Add(1)
+			logging.FromContext(c.Request.Context()).Warn("Rejecting webhook for a board that isn't synced", zap.String("boardID", action.Data.Board.ID), zap.String("actionID", action.ID))
+			c.JSON(http.StatusForbidden, gin.H{"error": "Unknown board"})
 			return
 		}
 
-		zap.L().Info("Successfully processed card", zap.String("cardID", card.ID))
-	}()
-	c.JSON(http.StatusOK, gin.H{"message": "Event received, processing asynchronously"})
+		if h.debounceWindow(action) > 0 {
+			h.Debounce.Note(action.Data.Card.ID, action.ID)
+		}
+		job := jobs.Job{
+			Account:   account,
+			Payload:   payload,
+			RequestID: requestid.FromContext(c.Request.Context()),
+			Trace:     span.SpanContext(),
+		}
+		if err := h.Jobs.Enqueue(job); err != nil {
+			// Trello retries failed deliveries, so ask it to come back later
+			logging.FromContext(c.Request.Context()).Warn("Could not queue webhook", zap.String("cardID", action.Data.Card.ID), zap.Error(err))
+			h.Debounce.Done(action.Data.Card.ID, action.ID)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too busy to accept event"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Event received, processing asynchronously"})
+	}
+}
+
+// ProcessJob syncs a queued webhook delivery. Rate-limited jobs are put back
+// on the queue, and jobs that hit an API outage or arrive while syncing is
+// paused are buffered and replayed in order once it's over; anything else is
+// dropped. Updates a later one to the same card supersedes within the
+// debounce window are skipped.
+func (h *Handler) ProcessJob(ctx context.Context, job jobs.Job) (err error) {
+	ctx = syncContext(ctx, job.RequestID, job.Account, job.Payload.Action)
+	action := job.Payload.Action
+	if window := h.debounceWindow(action); window > 0 {
+		latest, err := h.Debounce.Wait(ctx, action.Data.Card.ID, action.ID, window)
+		if err != nil {
+			return err
+		}
+		if !latest {
+			logging.FromContext(ctx).Debug("Skipping card update superseded by a later one", zap.String("cardID", action.Data.Card.ID))
+			return nil
+		}
+	}
+	defer h.Debounce.Done(action.Data.Card.ID, action.ID)
+
+	ctx, span := tracing.Tracer.Start(ctx, "sync card", trace.WithAttributes(actionAttributes(job.Account, job.Payload.Action)...))
+	defer func() { tracing.End(span, err) }()
+
+	if paused, pauseErr := h.Paused(ctx); pauseErr == nil && paused {
+		return jobs.Outage(errPaused)
+	}
+	err = h.processCardUpdate(ctx, job.Payload, h.TrelloClient(job.Account))
+	h.reportSyncResult(ctx, job.Payload.Action, err)
+
+	var rateLimited *integrations.RateLimitedError
+	switch {
+	case errors.As(err, &rateLimited):
+		return jobs.Retry(err, max(rateLimited.RetryAfter, defaultRetryDelay))
+	case errors.Is(err, integrations.ErrTransient):
+		return jobs.Outage(err)
+	}
+	return err
+}
+
+// staleOverdue reports whether the card is due before the day
+// sync.overdue_threshold ago, so no event is created for it when
+// sync.skip_overdue is set.
+func (h *Handler) staleOverdue(ctx context.Context, card models.Card) bool {
+	cfg := h.Config().Sync
+	if !cfg.SkipOverdue || card.DueDate == nil {
+		return false
+	}
+	cutoff := time.Now().Add(-cfg.OverdueThreshold).UTC().Truncate(24 * time.Hour)
+	if !card.DueDate.Before(cutoff) {
+		return false
+	}
+	logging.FromContext(ctx).Info("Not creating an event for a long overdue card", zap.String("cardID", card.ID), zap.Time("dueDate", *card.DueDate))
+	return true
+}
+
+// debounceWindow returns how long to wait for more updates to the action's
+// card before syncing it, or 0 if its sync shouldn't wait. Only syncs of the
+// whole fetched card can stand in for the updates they skip.
+func (h *Handler) debounceWindow(action models.TrelloAction) time.Duration {
+	cfg := h.Config().Sync
+	if !cfg.FetchFullCard || action.Type != "updateCard" || action.Data.Card.ID == "" {
+		return 0
+	}
+	return max(cfg.Debounce, 0)
+}
+
+// reportSyncResult counts the sync towards the card's failure streak, which
+// is alerted on. Rate limiting and outages are waited out rather than failing
+// the card, syncs cut off by shutdown didn't fail, and cards over their
+// tenant's limit are the tenant's to deal with, so none of them count.
+func (h *Handler) reportSyncResult(ctx context.Context, action models.TrelloAction, err error) {
+	var rateLimited *integrations.RateLimitedError
+	if errors.As(err, &rateLimited) || errors.Is(err, integrations.ErrTransient) || errors.Is(err, errCardLimit) || ctx.Err() != nil {
+		return
+	}
+	if action.Data.Card.ID == "" {
+		return // A board update
+	}
+	h.Notifier.SyncResult(action.Data.Card, action.Data.Board.ID, err)
+}
+
+// syncContext tags ctx with the ID of the request that delivered action, or a
+// new one, and with the action itself, so the sync's log lines can be told
+// apart from those of syncs running alongside it
+func syncContext(ctx context.Context, requestID, account string, action models.TrelloAction) context.Context {
+	if requestID == "" {
+		requestID = requestid.New()
+	}
+	ctx = requestid.NewContext(ctx, requestID)
+	return logging.With(ctx,
+		zap.String("requestID", requestID),
+		zap.String("account", account),
+		zap.String("actionID", action.ID),
+	)
+}
+
+// actionAttributes describe a Trello action on a span, so traces can be found
+// by action or card ID
+func actionAttributes(account string, action models.TrelloAction) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("trello.account", account),
+		attribute.String("trello.action_id", action.ID),
+		attribute.String("trello.action_type", action.Type),
+		attribute.String("trello.card_id", action.Data.Card.ID),
+		attribute.String("trello.board_id", action.Data.Board.ID),
+	}
 }
 
 // processCardUpdate orchestrates the main sync logic for a card update
-func (h *Handler) processCardUpdate(payload models.TrelloWebhookPayload) error {
-	if payload.Action.Type != "updateCard" {
-		zap.L().Debug("Action type is not 'updateCard', no action taken")
+func (h *Handler) processCardUpdate(ctx context.Context, payload models.TrelloWebhookPayload, client integrations.TrelloAPI) error {
+	switch payload.Action.Type {
+	case "updateBoard":
+		return h.processBoardUpdate(ctx, payload.Action)
+	case "moveAllCardsInList":
+		return h.processListMove(ctx, payload.Action, client)
+	case "convertToCardFromCheckItem":
+		return h.processCardCreation(ctx, payload.Action, client)
+	case "addLabelToCard", "removeLabelFromCard":
+		// Only the fetched card says which labels it has now, which rules
+		// matching labels need
+		if !h.Config().Sync.FetchFullCard {
+			logging.FromContext(ctx).Debug("Ignoring label change, as cards aren't fetched to see their labels")
+			return nil
+		}
+	case "updateCard":
+	default:
+		logging.FromContext(ctx).Debug("Action type is not 'updateCard', no action taken")
 		return nil // Not an error, just nothing to do
 	}
 
 	incomingCardData := payload.Action.Data.Card
 
 	if incomingCardData.ID == "" {
-		zap.L().Debug("Incoming card data does not contain an ID, skipping sync")
+		logging.FromContext(ctx).Debug("Incoming card data does not contain an ID, skipping sync")
 		return nil
 	}
+	if closed, err := h.boardClosed(payload.Action.Data.Board.ID); err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	} else if closed {
+		logging.FromContext(ctx).Debug("Ignoring update to a card on a closed board", zap.String("cardID", incomingCardData.ID), zap.String("boardID", payload.Action.Data.Board.ID))
+		return nil
+	}
+	return h.syncTrelloCard(ctx, payload, client, nil)
+}
+
+type batchedWritesKey struct{}
+
+// withBatchedWrites marks syncs run with ctx as part of a bulk operation,
+// whose card saves are queued in Handler.Writes
+func withBatchedWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batchedWritesKey{}, true)
+}
+
+func batchedWrites(ctx context.Context) bool {
+	batched, _ := ctx.Value(batchedWritesKey{}).(bool)
+	return batched
+}
+
+// syncTrelloCard brings the stored card and its events in line with the
+// update in payload. fetched is the card's current state if the caller has
+// already fetched it; otherwise it is fetched when sync.fetch_full_card is
+// set.
+func (h *Handler) syncTrelloCard(ctx context.Context, payload models.TrelloWebhookPayload, client integrations.TrelloAPI, fetched *models.TrelloCard) error {
+	incomingCardData := payload.Action.Data.Card
+
+	ttl := h.claimTTL()
+	claimCtx, cancel := context.WithTimeout(ctx, ttl)
+	defer cancel()
+
+	// Updates to the same card read and write the same row and event, so run
+	// them one at a time
+	unlock, err := h.CardLocks.Lock(claimCtx, incomingCardData.ID)
+	if err != nil {
+		return fmt.Errorf("timed out waiting for another update to card %s: %w", incomingCardData.ID, err)
+	}
+	defer unlock()
+
+	// Wait for any in-progress reconciliation of this card to finish so the two
+	// don't issue conflicting calendar writes
+	if !h.Claims.Claim(claimCtx, incomingCardData.ID, claims.OwnerWebhook, ttl) {
+		return fmt.Errorf("timed out waiting to claim card %s", incomingCardData.ID)
+	}
+	defer h.Claims.Release(incomingCardData.ID, claims.OwnerWebhook)
+
+	// Webhook payloads only carry the fields that changed, so sync from the
+	// card's current state when it can be fetched
+	authoritative, dueKnown := false, false
+	if fetched == nil {
+		fetched = h.fetchCard(ctx, client, incomingCardData.ID)
+	}
+	if fetched != nil {
+		incomingCardData = cardDataFrom(*fetched)
+		authoritative, dueKnown = true, true
+	} else if incomingCardData.Due == "" {
+		// An empty due date may mean it was removed or only that it didn't
+		// change, so ask Trello which rather than guess
+		if due, ok := h.fetchDue(ctx, client, incomingCardData.ID); ok {
+			incomingCardData.Due = due
+			dueKnown = true
+		}
+	}
 
 	boardName := payload.Action.Data.Board.Name
 	boardID := payload.Action.Data.Board.ID
 	var card models.Card
 
-	err := h.DB.First(&card, "id = ?", incomingCardData.ID).Error
+	// A bulk sync of the card may have left its save queued
+	if err := h.Writes.FlushCard(incomingCardData.ID); err != nil {
+		return fmt.Errorf("failed to save queued card writes: %w", err)
+	}
+
+	db := h.DB.WithContext(ctx)
+	err = db.First(&card, "id = ?", incomingCardData.ID).Error
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return fmt.Errorf("database query failed: %w", err)
 	}
 
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		zap.L().Info("Card not found in database; creating new record", zap.String("cardID", incomingCardData.ID))
+	created := errors.Is(err, gorm.ErrRecordNotFound)
+	if created {
+		logging.FromContext(ctx).Info("Card not found in database; creating new record", zap.String("cardID", incomingCardData.ID))
+		card.ID = incomingCardData.ID
+	} else if err := database.LoadCardEvents(db, &card); err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	// The card as last synced, which its events were built from
+	stored := card
+	// Trello retries failed deliveries and workers run in parallel, so an
+	// action can arrive after a later one to the same card; applying it would
+	// put back the state the later one replaced
+	actionAt, err := time.Parse(time.RFC3339, payload.Action.Date)
+	if err == nil && card.LastActionAt != nil && actionAt.Before(*card.LastActionAt) {
+		logging.FromContext(ctx).Info("Ignoring Trello action older than the last one applied to the card", zap.String("cardID", card.ID), zap.Time("actionDate", actionAt), zap.Time("lastActionAt", *card.LastActionAt))
+		return nil
+	}
+	if err == nil {
+		card.LastActionAt = &actionAt
+	}
+
+	// Cards moved to another board arrive with the board they're on now
+	if boardID != "" {
+		card.BoardID = boardID
+	}
+	card.Tenant = h.Tenancy().BoardTenant(card.BoardID)
+
+	// Trello only includes the description in the payload when it has changed
+	if incomingCardData.Desc != "" || authoritative {
+		card.Description = incomingCardData.Desc
+	}
+
+	if authoritative {
+		card.ListID = incomingCardData.IDList
+		card.Labels = labelKeys(incomingCardData.Labels)
+	} else if listID := incomingListID(payload); listID != "" {
+		card.ListID = listID
 	}
 
 	// Handle archiving
 	wasArchived := card.Archived
 	if incomingCardData.Closed {
 		if !wasArchived {
-			zap.L().Info("Card archived", zap.String("cardID", incomingCardData.ID), zap.String("cardName", incomingCardData.Name))
+			logging.FromContext(ctx).Info("Card archived", zap.String("cardID", incomingCardData.ID), zap.String("cardName", incomingCardData.Name))
 		}
 		card.Archived = true
 
-		if card.EventID != "" {
-			if err := h.CalClient.DeleteEvent(card.EventID); err != nil {
-				zap.L().Warn("Failed to delete event from Google Calendar for archived card", zap.String("eventID", card.EventID), zap.Error(err))
-			}
-			// Clear the event ID since it's deleted
-			card.EventID = ""
+		if err := h.removeCalendarEvent(ctx, &card); err != nil {
+			return err
 		}
+		h.removeTask(ctx, &card)
+		h.removeTargetEvents(ctx, &card)
 	} else {
 		if wasArchived {
-			zap.L().Info("Card unarchived", zap.String("cardID", incomingCardData.ID), zap.String("cardName", incomingCardData.Name))
+			logging.FromContext(ctx).Info("Card unarchived", zap.String("cardID", incomingCardData.ID), zap.String("cardName", incomingCardData.Name))
 		}
 		card.Archived = false
 	}
 
+	// Cards a tenant gains count towards its card limit
+	if !card.Archived && (created || wasArchived || card.Tenant != stored.Tenant) {
+		if err := h.checkCardLimit(ctx, card); err != nil {
+			return err
+		}
+	}
+
+	decision := h.Rules().Evaluate(ruleCard(card))
+
 	// Skip sync for archived cards
 	if card.Archived {
-		zap.L().Info("Skipping further sync for archived card", zap.String("cardID", incomingCardData.ID))
-	} else {
-		// Decide whether to sync an event or delete one based on the due date
+		logging.FromContext(ctx).Info("Skipping further sync for archived card", zap.String("cardID", incomingCardData.ID))
+	} else if !decision.Sync {
+		logging.FromContext(ctx).Info("Card excluded by sync rules", zap.String("cardID", incomingCardData.ID), zap.String("rule", decision.Rule))
+		// Keep tracking the due date so the card syncs if the rules change
 		if incomingCardData.Due != "" {
-			if err := h.syncCalendarEvent(&card, incomingCardData, boardName, boardID); err != nil {
+			if dueDate, err := time.Parse(time.RFC3339, incomingCardData.Due); err == nil {
+				card.DueDate = &dueDate
+			}
+		}
+		if err := h.removeCalendarEvent(ctx, &card); err != nil {
+			return err
+		}
+		h.removeTask(ctx, &card)
+		h.removeTargetEvents(ctx, &card)
+	} else {
+		targets := decision.Targets()
+		if !slices.Contains(targets, rules.TargetCalendar) {
+			if err := h.removeCalendarEvent(ctx, &card); err != nil {
 				return err
 			}
-		} else {
-			if card.DueDate != nil && card.EventID == "" {
-				// Recreate event using DB due date
-				zap.L().Info("Card has due date in DB but no event, recreating event", zap.String("cardID", card.ID))
-				// Create a copy of incoming with the DB due date
-				recreateIncoming := incomingCardData
-				recreateIncoming.Due = card.DueDate.Format(time.RFC3339)
-				if err := h.syncCalendarEvent(&card, recreateIncoming, boardName, boardID); err != nil {
-					return err
-				}
-			} else if card.DueDate != nil && card.EventID != "" {
-				zap.L().Info("Card has due date in DB, keeping existing event", zap.String("cardID", card.ID))
-			} else {
-				if err := h.deleteCalendarEvent(&card); err != nil {
-					return err
-				}
+		}
+		if !slices.Contains(targets, rules.TargetTasks) {
+			h.removeTask(ctx, &card)
+		}
+		h.removeTargetEvents(ctx, &card, targets...)
+
+		// The card fans out to every target the rule names, each following
+		// the same due date
+		for _, target := range targets {
+			var err error
+			switch target {
+			case rules.TargetCalendar:
+				err = h.syncCalendar(ctx, &card, stored, incomingCardData, dueKnown, boardName, boardID, decision.Calendar)
+			case rules.TargetTasks:
+				err = h.syncTask(ctx, &card, incomingCardData, dueKnown, boardName, boardID)
+			default:
+				err = h.syncTargetEvent(ctx, target, &card, incomingCardData, dueKnown, boardName, boardID)
+			}
+			if err != nil {
+				return err
 			}
 		}
 	}
 
-	if err := h.DB.Save(&card).Error; err != nil {
+	if batchedWrites(ctx) {
+		err = h.Writes.Add(card)
+	} else {
+		err = database.SaveCard(db, &card)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to save final card state: %w", err)
 	}
 
 	return nil
 }
 
-func (h *Handler) syncCalendarEvent(card *models.Card, incoming models.TrelloCardData, boardName string, boardID string) error {
+// syncCalendar decides whether to sync or delete the card's Google Calendar
+// event based on the due date, routing it to calendarRef. stored is the card
+// as it was last synced.
+func (h *Handler) syncCalendar(ctx context.Context, card *models.Card, stored models.Card, incoming models.TrelloCardData, authoritative bool, boardName string, boardID string, calendarRef string) error {
+	targetCalendarID := h.CalClient.ResolveCalendarID(calendarRef)
+
+	switch {
+	case incoming.Due != "":
+		return h.syncCalendarEvent(ctx, card, stored, incoming, boardName, boardID, targetCalendarID)
+	case authoritative:
+		// The fetched card has no due date, so it really was removed
+		return h.deleteCalendarEvent(ctx, card)
+	case card.DueDate != nil && card.EventID == "":
+		// Recreate event using DB due date
+		logging.FromContext(ctx).Info("Card has due date in DB but no event, recreating event", zap.String("cardID", card.ID))
+		// Create a copy of incoming with the DB due date
+		recreateIncoming := incoming
+		recreateIncoming.Due = card.DueDate.Format(time.RFC3339)
+		return h.syncCalendarEvent(ctx, card, stored, recreateIncoming, boardName, boardID, targetCalendarID)
+	case card.DueDate != nil && h.CalClient.CalendarFor(*card) != targetCalendarID:
+		logging.FromContext(ctx).Info("Card has due date in DB but is routed to a different calendar, moving event", zap.String("cardID", card.ID))
+		moveIncoming := incoming
+		moveIncoming.Due = card.DueDate.Format(time.RFC3339)
+		return h.syncCalendarEvent(ctx, card, stored, moveIncoming, boardName, boardID, targetCalendarID)
+	case card.DueDate != nil:
+		logging.FromContext(ctx).Info("Card has due date in DB, keeping existing event", zap.String("cardID", card.ID))
+		return nil
+	default:
+		return h.deleteCalendarEvent(ctx, card)
+	}
+}
+
+func (h *Handler) syncCalendarEvent(ctx context.Context, card *models.Card, stored models.Card, incoming models.TrelloCardData, boardName string, boardID string, targetCalendarID string) error {
 	if card.Archived {
-		zap.L().Info("Skipping event sync for archived card", zap.String("cardID", card.ID))
+		logging.FromContext(ctx).Info("Skipping event sync for archived card", zap.String("cardID", card.ID))
 		return nil
 	}
 
+	if err := h.updateCardDetails(card, incoming, boardName, boardID); err != nil {
+		return err
+	}
+
+	if card.EventID != "" {
+		currentCalendarID := h.CalClient.CalendarFor(*card)
+		// Trello sends updateCard for changes to fields events don't show,
+		// such as members or checklists. Changes to event settings are
+		// applied by reconciliation instead.
+		if currentCalendarID == targetCalendarID && sameEventFields(stored, *card) {
+			logging.FromContext(ctx).Debug("Nothing in the card's event changed; skipping update", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
+			h.skippedEventUpdates.Add(1)
+			return nil
+		}
+
+		// Move the event first if the card is now routed to another calendar
+		if currentCalendarID != targetCalendarID {
+			logging.FromContext(ctx).Info("Calendar routing changed for card; moving event", zap.String("cardID", card.ID), zap.String("from", currentCalendarID), zap.String("to", targetCalendarID))
+			_, err := h.CalClient.MoveEvent(ctx, card.EventID, currentCalendarID, targetCalendarID)
+			if errors.Is(err, integrations.ErrNotFound) {
+				// Deleted in Google Calendar; the update below recreates it
+				logging.FromContext(ctx).Info("Event to move no longer exists in Google Calendar", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
+			} else if err != nil {
+				return fmt.Errorf("failed to move event between calendars: %w", err)
+			}
+		}
+		card.CalendarID = targetCalendarID
+
+		// Update existing event. UpdateEvent creates a new one if it was
+		// deleted in Google Calendar.
+		logging.FromContext(ctx).Info("Due date updated for card; updating associated event", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
+		updatedEvent, err := h.CalClient.UpdateEvent(ctx, *card, card.EventID)
+		if err != nil {
+			return fmt.Errorf("failed to update event in Google Calendar: %w", err)
+		}
+		operation := outbound.Updated
+		if updatedEvent.Id != card.EventID {
+			logging.FromContext(ctx).Info("Recreated deleted event for card", zap.String("eventID", updatedEvent.Id), zap.String("oldEventID", card.EventID), zap.String("cardID", card.ID))
+			operation = outbound.Created
+		} else {
+			logging.FromContext(ctx).Info("Successfully updated event for card", zap.String("eventID", updatedEvent.Id), zap.String("cardID", card.ID))
+		}
+		card.EventID = updatedEvent.Id
+		h.Outbound.Send(outbound.CardEvent(operation, *card, outbound.TargetCalendar, card.EventID))
+	} else if !h.staleOverdue(ctx, *card) {
+		// Create new event
+		card.CalendarID = targetCalendarID
+		logging.FromContext(ctx).Info("Due date set for card; creating new event in Google Calendar", zap.String("cardID", card.ID), zap.String("calendarID", targetCalendarID))
+		createdEvent, err := h.CalClient.CreateEvent(ctx, *card)
+		if err != nil {
+			return fmt.Errorf("failed to create event in Google Calendar: %w", err)
+		}
+		logging.FromContext(ctx).Info("Successfully created event for card", zap.String("eventID", createdEvent.Id), zap.String("cardID", card.ID))
+		card.EventID = createdEvent.Id
+		h.Notifier.Notify(notify.EventCreatedNotification(*card, "Google Calendar"))
+		h.Outbound.Send(outbound.CardEvent(outbound.Created, *card, outbound.TargetCalendar, card.EventID))
+	}
+	return nil
+}
+
+// sameEventFields reports whether the two versions of a card give the same
+// calendar event. Labels can pick the event's colour.
+func sameEventFields(a, b models.Card) bool {
+	return a.Name == b.Name && a.Description == b.Description && a.URL == b.URL && a.BoardID == b.BoardID &&
+		slices.Equal(a.Labels, b.Labels) &&
+		a.DueDate != nil && b.DueDate != nil && a.DueDate.Equal(*b.DueDate)
+}
+
+// updateCardDetails copies the fields events and tasks are built from out of
+// the incoming payload
+func (h *Handler) updateCardDetails(card *models.Card, incoming models.TrelloCardData, boardName string, boardID string) error {
 	newDueDate, err := time.Parse(time.RFC3339, incoming.Due)
 	if err != nil {
 		return fmt.Errorf("invalid due date format: %w", err)
 	}
 
-	var boardPrefix string
-	if boardName != "" {
-		runes := []rune(boardName)
-		boardPrefix = string(runes[0])
-	} else {
-		boardPrefix = ""
+	name := incoming.Name
+	if prefix := h.Config().Trello.BoardPrefix(boardID, boardName); prefix != "" {
+		name = fmt.Sprintf("[%s] %s", prefix, incoming.Name)
 	}
-	prefixedName := fmt.Sprintf("[%s] %s", boardPrefix, incoming.Name)
 
 	// Update card details from the incoming payload
 	card.ID = incoming.ID
-	card.Name = prefixedName
+	card.Name = titles.Clean(name, h.Config().Sync.Titles)
 	card.DueDate = &newDueDate
 	card.URL = fmt.Sprintf("https://trello.com/c/%s", incoming.ShortLink)
 	card.BoardID = boardID
+	return nil
+}
 
-	if card.EventID != "" {
-		// Update existing event
-		zap.L().Info("Due date updated for card; updating associated event", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
-		updatedEvent, err := h.CalClient.UpdateEvent(*card, card.EventID)
+// syncTask mirrors the calendar sync for cards routed to Google Tasks
+func (h *Handler) syncTask(ctx context.Context, card *models.Card, incoming models.TrelloCardData, authoritative bool, boardName string, boardID string) error {
+	if incoming.Due == "" {
+		if authoritative || card.DueDate == nil {
+			// The due date was removed, so the task goes too
+			card.DueDate = nil
+			h.removeTask(ctx, card)
+			return nil
+		}
+		if card.TaskID != "" {
+			logging.FromContext(ctx).Info("Card has due date in DB, keeping existing task", zap.String("cardID", card.ID))
+			return nil
+		}
+		incoming.Due = card.DueDate.Format(time.RFC3339)
+	}
+
+	if err := h.updateCardDetails(card, incoming, boardName, boardID); err != nil {
+		return err
+	}
+
+	if card.TaskID != "" {
+		logging.FromContext(ctx).Info("Due date updated for card; updating associated task", zap.String("cardID", card.ID), zap.String("taskID", card.TaskID))
+		updatedTask, err := h.TasksClient.UpdateTask(ctx, *card, card.TaskID)
 		if err != nil {
-			return fmt.Errorf("failed to update event in Google Calendar: %w", err)
+			return fmt.Errorf("failed to update task in Google Tasks: %w", err)
 		}
-		zap.L().Info("Successfully updated event for card", zap.String("eventID", updatedEvent.Id), zap.String("cardID", card.ID))
-		card.EventID = updatedEvent.Id
+		card.TaskID = updatedTask.Id
+		return nil
+	}
+
+	if h.staleOverdue(ctx, *card) {
+		return nil
+	}
+	card.TaskListID = h.TasksClient.TaskListFor(*card)
+	logging.FromContext(ctx).Info("Due date set for card; creating new task in Google Tasks", zap.String("cardID", card.ID))
+	createdTask, err := h.TasksClient.CreateTask(ctx, *card)
+	if err != nil {
+		return fmt.Errorf("failed to create task in Google Tasks: %w", err)
+	}
+	logging.FromContext(ctx).Info("Successfully created task for card", zap.String("taskID", createdTask.Id), zap.String("cardID", card.ID))
+	card.TaskID = createdTask.Id
+	return nil
+}
+
+// removeTask deletes the card's task, if it has one
+func (h *Handler) removeTask(ctx context.Context, card *models.Card) {
+	if card.TaskID == "" {
+		return
+	}
+
+	if err := h.TasksClient.DeleteTask(ctx, card.TaskListID, card.TaskID); err != nil {
+		logging.FromContext(ctx).Warn("Failed to delete task from Google Tasks", zap.String("taskID", card.TaskID), zap.Error(err))
+	}
+	card.TaskID = ""
+	card.TaskListID = ""
+}
+
+// syncTargetEvent mirrors the calendar sync for cards routed to a pluggable
+// sync target
+func (h *Handler) syncTargetEvent(ctx context.Context, name string, card *models.Card, incoming models.TrelloCardData, authoritative bool, boardName string, boardID string) error {
+	target, ok := h.Targets[name]
+	if !ok {
+		return fmt.Errorf("no sync target named %q is configured", name)
+	}
+
+	eventID, err := database.GetTargetEvent(h.DB.WithContext(ctx), card.ID, name)
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+
+	if incoming.Due == "" {
+		if authoritative || card.DueDate == nil {
+			// The due date was removed, so the event goes too
+			card.DueDate = nil
+			h.removeTargetEvents(ctx, card)
+			return nil
+		}
+		if eventID != "" {
+			logging.FromContext(ctx).Info("Card has due date in DB, keeping existing event", zap.String("cardID", card.ID), zap.String("target", name))
+			return nil
+		}
+		incoming.Due = card.DueDate.Format(time.RFC3339)
+	}
+
+	if err := h.updateCardDetails(card, incoming, boardName, boardID); err != nil {
+		return err
+	}
+	if eventID == "" && h.staleOverdue(ctx, *card) {
+		return nil
+	}
+
+	if eventID != "" {
+		logging.FromContext(ctx).Info("Due date updated for card; updating associated event", zap.String("cardID", card.ID), zap.String("target", name), zap.String("eventID", eventID))
+		if eventID, err = target.UpdateEvent(ctx, *card, eventID); err != nil {
+			return fmt.Errorf("failed to update event on %s: %w", name, err)
+		}
+		h.Outbound.Send(outbound.CardEvent(outbound.Updated, *card, name, eventID))
 	} else {
-		// Create new event
-		zap.L().Info("Due date set for card; creating new event in Google Calendar", zap.String("cardID", card.ID))
-		createdEvent, err := h.CalClient.CreateEvent(*card)
-		if err != nil {
-			return fmt.Errorf("failed to create event in Google Calendar: %w", err)
+		logging.FromContext(ctx).Info("Due date set for card; creating new event", zap.String("cardID", card.ID), zap.String("target", name))
+		if eventID, err = target.CreateEvent(ctx, *card); err != nil {
+			return fmt.Errorf("failed to create event on %s: %w", name, err)
 		}
-		zap.L().Info("Successfully created event for card", zap.String("eventID", createdEvent.Id), zap.String("cardID", card.ID))
-		card.EventID = createdEvent.Id
+		h.Notifier.Notify(notify.EventCreatedNotification(*card, name))
+		h.Outbound.Send(outbound.CardEvent(outbound.Created, *card, name, eventID))
+	}
+
+	if err := database.PutTargetEvent(h.DB.WithContext(ctx), card.ID, name, eventID); err != nil {
+		return fmt.Errorf("failed to save event ID: %w", err)
 	}
 	return nil
 }
 
-func (h *Handler) deleteCalendarEvent(card *models.Card) error {
-	if card.EventID == "" {
-		zap.L().Info("Due date removed for card but no associated event found to delete", zap.String("cardID", card.ID))
-		return nil // Nothing to do
+// removeTargetEvents deletes the card's events on pluggable sync targets,
+// except those on the targets in keep
+func (h *Handler) removeTargetEvents(ctx context.Context, card *models.Card, keep ...string) {
+	events, err := database.ListTargetEvents(h.DB.WithContext(ctx), card.ID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to load card's sync target events", zap.String("cardID", card.ID), zap.Error(err))
+		return
 	}
 
-	zap.L().Info("Due date removed for card; deleting associated event", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
-	if err := h.CalClient.DeleteEvent(card.EventID); err != nil {
-		// Log the error but don't block saving the state, as the event might already be gone
-		zap.L().Warn("Failed to delete event from Google Calendar", zap.String("eventID", card.EventID), zap.Error(err))
+	for _, event := range events {
+		if slices.Contains(keep, event.Target) {
+			continue
+		}
+		if target, ok := h.Targets[event.Target]; ok {
+			if err := target.DeleteEvent(ctx, *card, event.EventID); err != nil {
+				logging.FromContext(ctx).Warn("Failed to delete event from sync target", zap.String("target", event.Target), zap.String("eventID", event.EventID), zap.Error(err))
+			} else {
+				h.Outbound.Send(outbound.CardEvent(outbound.Deleted, *card, event.Target, event.EventID))
+			}
+		}
+		if err := database.DeleteTargetEvent(h.DB.WithContext(ctx), card.ID, event.Target); err != nil {
+			logging.FromContext(ctx).Warn("Failed to forget sync target event", zap.String("cardID", card.ID), zap.String("target", event.Target), zap.Error(err))
+		}
 	}
+}
+
+// ruleCard is the stored card state sync rules match against
+func ruleCard(card models.Card) rules.Card {
+	return rules.Card{BoardID: card.BoardID, ListID: card.ListID, Labels: card.Labels}
+}
 
-	// Clear local record of the event
+// labelKeys lists the names and IDs of labels, which rules can match either
+// of
+func labelKeys(labels []models.TrelloLabel) []string {
+	keys := make([]string, 0, 2*len(labels))
+	for _, label := range labels {
+		if label.Name != "" {
+			keys = append(keys, label.Name)
+		}
+		keys = append(keys, label.ID)
+	}
+	return keys
+}
+
+// wantsEvent reports whether the stored card state calls for a calendar event
+func (h *Handler) wantsEvent(card models.Card) bool {
+	if card.Archived || card.DueDate == nil {
+		return false
+	}
+	decision := h.Rules().Evaluate(ruleCard(card))
+	return decision.Includes(rules.TargetCalendar)
+}
+
+// targetCalendarID returns the calendar the routing rules send the card to,
+// or for cards from other sources the rules don't route, the calendar their
+// project or repository maps to
+func (h *Handler) targetCalendarID(card models.Card) string {
+	decision := h.Rules().Evaluate(ruleCard(card))
+	ref := decision.Calendar
+	if ref == "" {
+		ref = h.sourceCalendar(card)
+	}
+	return h.CalClient.ResolveCalendarID(ref)
+}
+
+// removeCalendarEvent deletes the card's event without touching its due date.
+// If Google can't be reached the card keeps the event and the error is
+// returned, so the sync is buffered and the deletion replayed.
+func (h *Handler) removeCalendarEvent(ctx context.Context, card *models.Card) error {
+	if card.EventID == "" {
+		return nil
+	}
+
+	err := h.CalClient.DeleteEvent(ctx, card.CalendarID, card.EventID)
+	switch {
+	case errors.Is(err, integrations.ErrTransient):
+		return fmt.Errorf("failed to delete event %s: %w", card.EventID, err)
+	case err != nil:
+		logging.FromContext(ctx).Warn("Failed to delete event from Google Calendar", zap.String("eventID", card.EventID), zap.Error(err))
+	default:
+		h.Outbound.Send(outbound.CardEvent(outbound.Deleted, *card, outbound.TargetCalendar, card.EventID))
+	}
 	card.EventID = ""
+	card.CalendarID = ""
+	return nil
+}
+
+// fetchCard returns the card's current state from Trello, or nil if it can't
+// be fetched or sync.fetch_full_card is disabled
+func (h *Handler) fetchCard(ctx context.Context, client integrations.TrelloAPI, cardID string) *models.TrelloCard {
+	if client == nil || !h.Config().Sync.FetchFullCard {
+		return nil
+	}
+
+	card, err := client.GetCard(ctx, cardID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to fetch card from Trello; syncing from the webhook payload", zap.String("cardID", cardID), zap.Error(err))
+		return nil
+	}
+	return card
+}
+
+// fetchDue returns the card's current due date from Trello, reporting false
+// if it can't be fetched. It is skipped when sync.fetch_full_card is enabled,
+// since then the whole card was just fetched, or failed to be.
+func (h *Handler) fetchDue(ctx context.Context, client integrations.TrelloAPI, cardID string) (string, bool) {
+	if client == nil || h.Config().Sync.FetchFullCard {
+		return "", false
+	}
+
+	card, err := client.GetCard(ctx, cardID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to fetch card's due date from Trello; keeping the stored one", zap.String("cardID", cardID), zap.Error(err))
+		return "", false
+	}
+	return card.Due, true
+}
+
+// cardDataFrom converts a card fetched from the REST API into the shape
+// webhook payloads use
+func cardDataFrom(card models.TrelloCard) models.TrelloCardData {
+	return models.TrelloCardData{
+		ID:        card.ID,
+		Name:      card.Name,
+		Desc:      card.Desc,
+		Due:       card.Due,
+		ShortLink: card.ShortLink,
+		Closed:    card.Closed,
+		IDList:    card.IDList,
+		Start:     card.Start,
+		Labels:    card.Labels,
+		IDMembers: card.IDMembers,
+	}
+}
+
+// incomingListID returns the list the card is in after the action
+func incomingListID(payload models.TrelloWebhookPayload) string {
+	data := payload.Action.Data
+	switch {
+	case data.ListAfter.ID != "":
+		return data.ListAfter.ID
+	case data.List.ID != "":
+		return data.List.ID
+	default:
+		return data.Card.IDList
+	}
+}
+
+func (h *Handler) deleteCalendarEvent(ctx context.Context, card *models.Card) error {
+	if card.EventID == "" {
+		logging.FromContext(ctx).Info("Due date removed for card but no associated event found to delete", zap.String("cardID", card.ID))
+		return nil // Nothing to do
+	}
+
+	logging.FromContext(ctx).Info("Due date removed for card; deleting associated event", zap.String("cardID", card.ID), zap.String("eventID", card.EventID))
+	// Other errors don't block saving the state, as the event might already
+	// be gone
+	if err := h.removeCalendarEvent(ctx, card); err != nil {
+		return err
+	}
 	card.DueDate = nil
 	return nil
 }
@@ -211,17 +942,26 @@ func (h *Handler) deleteCalendarEvent(card *models.Card) error {
 func (h *Handler) HealthCheckHandler(c *gin.Context) {
 	// Check database connectivity
 	if err := h.DB.Exec("SELECT 1").Error; err != nil {
-		zap.L().Error("Health check failed: database not reachable", zap.Error(err))
+		logging.FromContext(c.Request.Context()).Error("Health check failed: database not reachable", zap.Error(err))
 		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": "database"})
+		return
 	}
 
 	// Check Google Calendar client
 	if h.CalClient == nil {
-		zap.L().Error("Health check failed: Google Calendar client not initialised")
+		logging.FromContext(c.Request.Context()).Error("Health check failed: Google Calendar client not initialised")
 		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": "google calendar client"})
 		return
 	}
 
-	zap.L().Debug("Health check passed")
-	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	// Boards closed in Trello don't make the service unhealthy, but their
+	// cards have stopped syncing
+	response := gin.H{"status": "healthy"}
+	if closed, err := h.closedBoards(); err == nil && len(closed) > 0 {
+		boardIDs := slices.Sorted(maps.Keys(closed))
+		response["closed_boards"] = boardIDs
+	}
+
+	logging.FromContext(c.Request.Context()).Debug("Health check passed")
+	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// jiraCursorSetting holds when the Jira poller last fetched updated issues
+const jiraCursorSetting = "jira.poll_cursor"
+
+// jiraCardID is the card ID of a Jira issue; issue IDs, unlike keys, survive
+// moving the issue to another project
+func jiraCardID(issue models.JiraIssue) string {
+	return models.SourceJira + ":" + issue.ID
+}
+
+// syncJiraIssue syncs the issue's due date, if its project is configured.
+func (h *Handler) syncJiraIssue(ctx context.Context, issue models.JiraIssue) error {
+	project := issue.Fields.Project.Key
+	if _, ok := h.Config().Jira.Projects[strings.ToLower(project)]; !ok {
+		logging.FromContext(ctx).Debug("Ignoring issue from unconfigured Jira project", zap.String("issue", issue.Key))
+		return nil
+	}
+
+	card := models.Card{
+		ID:      jiraCardID(issue),
+		Source:  models.SourceJira,
+		Name:    fmt.Sprintf("[%s] %s", issue.Key, issue.Fields.Summary),
+		URL:     h.Jira.IssueURL(issue.Key),
+		BoardID: project,
+		ListID:  issue.Fields.Status.Name,
+	}
+	if issue.Fields.DueDate != "" {
+		due, err := time.Parse(time.DateOnly, issue.Fields.DueDate)
+		if err != nil {
+			return fmt.Errorf("invalid due date on %s: %w", issue.Key, err)
+		}
+		card.DueDate = &due
+	}
+	return h.syncSourceCard(ctx, card)
+}
+
+// JiraWebhookHandler syncs issues as Jira reports changes to them. Failed
+// syncs are answered with an error so Jira delivers them again, as are
+// deliveries while syncing is paused.
+func (h *Handler) JiraWebhookHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+	if !h.validJiraWebhook(c.Request, body) {
+		logging.FromContext(ctx).Warn("Rejected Jira webhook with a missing or wrong secret")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	var payload models.JiraWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if paused, err := h.Paused(ctx); err != nil || paused {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPaused.Error()})
+		return
+	}
+
+	ctx = logging.With(ctx, zap.String("issue", payload.Issue.Key))
+	switch payload.WebhookEvent {
+	case "jira:issue_created", "jira:issue_updated":
+		err = h.syncJiraIssue(ctx, payload.Issue)
+	case "jira:issue_deleted":
+		err = h.removeSourceCard(ctx, jiraCardID(payload.Issue))
+	default:
+		logging.FromContext(ctx).Debug("Ignoring Jira webhook event", zap.String("event", payload.WebhookEvent))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to sync Jira issue", zap.String("event", payload.WebhookEvent), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "sync failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+}
+
+// validJiraWebhook checks the delivery's HMAC-SHA256 signature, which Jira
+// Cloud sends in X-Hub-Signature, or else its secret query parameter, for
+// Jira Data Center.
+func (h *Handler) validJiraWebhook(req *http.Request, body []byte) bool {
+	secret := h.Config().Jira.WebhookSecret
+	if secret == "" {
+		return false
+	}
+	if signature := req.Header.Get("X-Hub-Signature"); signature != "" {
+		return validHubSignature(secret, signature, body)
+	}
+	given := req.URL.Query().Get("secret")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// RunJiraPoller syncs the issues updated in the configured Jira projects
+// every interval until ctx is cancelled.
+func (h *Handler) RunJiraPoller(ctx context.Context, interval time.Duration) {
+	zap.L().Info("Polling Jira for issue updates", zap.String("url", h.Jira.BaseURL), zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.pollJira(ctx); err != nil && ctx.Err() == nil {
+			zap.L().Error("Failed to poll Jira", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) pollJira(ctx context.Context) error {
+	if paused, err := h.Paused(ctx); err != nil || paused {
+		return err
+	}
+	cursor, err := database.GetSetting(h.DB, jiraCursorSetting)
+	if err != nil {
+		return err
+	}
+
+	projects := make([]string, 0, len(h.Config().Jira.Projects))
+	for project := range h.Config().Jira.Projects {
+		projects = append(projects, strconv.Quote(strings.ToUpper(project)))
+	}
+	slices.Sort(projects)
+	jql := "project in (" + strings.Join(projects, ", ") + ")"
+	if since, err := time.Parse(time.RFC3339, cursor); err == nil {
+		// Relative times avoid depending on the Jira user's time zone; the
+		// extra minute covers the rounding
+		minutes := int(math.Ceil(time.Since(since).Minutes())) + 1
+		jql += fmt.Sprintf(" AND updated >= -%dm", minutes)
+	} else {
+		// The first poll picks up every due date already set
+		jql += " AND duedate is not EMPTY"
+	}
+	jql += " ORDER BY updated ASC"
+
+	started := time.Now().UTC()
+	issues, err := h.Jira.SearchIssues(ctx, jql)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		issueCtx := logging.With(ctx, zap.String("issue", issue.Key))
+		if err := h.syncJiraIssue(issueCtx, issue); err != nil {
+			// Leave the cursor so the issues are fetched again next poll
+			return fmt.Errorf("syncing %s: %w", issue.Key, err)
+		}
+	}
+	if len(issues) > 0 {
+		zap.L().Debug("Processed polled Jira issues", zap.Int("count", len(issues)))
+	}
+	return database.PutSetting(h.DB, jiraCursorSetting, started.Format(time.RFC3339))
+}
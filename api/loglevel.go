@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type logLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// GetLogLevelHandler reports the current log level.
+func (h *Handler) GetLogLevelHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": logging.Level().String()})
+}
+
+// SetLogLevelHandler changes the log level, for example to debug while
+// reproducing an issue, without a restart. It lasts until the next change or
+// until log.level is edited in the config.
+func (h *Handler) SetLogLevelHandler(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `body must be {"level": "debug|info|warn|error"}`})
+		return
+	}
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous := logging.Level()
+	logging.SetLevel(level)
+	logging.FromContext(c.Request.Context()).Log(max(zapcore.InfoLevel, level), "Log level changed",
+		zap.Stringer("from", previous),
+		zap.Stringer("to", level),
+	)
+	c.JSON(http.StatusOK, gin.H{"level": level.String()})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+)
+
+// pausedSetting holds when syncing was paused, or "" while it runs. It is
+// stored so every replica, and the next run, stays paused.
+const pausedSetting = "sync.paused"
+
+// errPaused holds syncs back while syncing is paused. Queued jobs wait their
+// turn as they do during an outage, and run in order once it is resumed.
+var errPaused = errors.New("syncing is paused")
+
+// Paused reports whether syncing is paused.
+func (h *Handler) Paused(ctx context.Context) (bool, error) {
+	since, err := database.GetSetting(h.DB.WithContext(ctx), pausedSetting)
+	return since != "", err
+}
+
+// SetPaused pauses or resumes syncing Trello changes.
+func (h *Handler) SetPaused(ctx context.Context, paused bool) error {
+	since := ""
+	if paused {
+		since = time.Now().UTC().Format(time.RFC3339)
+	}
+	return database.PutSetting(h.DB.WithContext(ctx), pausedSetting, since)
+}
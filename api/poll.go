@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// pollCursorKey stores the ID of the last board action the poller processed
+func pollCursorKey(boardID string) string {
+	return "trello.poll_cursor:" + boardID
+}
+
+// RunPoller is the alternative to webhooks for deployments without a public
+// callback URL. Every interval it fetches each board's card and board updates
+// since the last action it saw and feeds them through the same processing as
+// webhook deliveries, until ctx is cancelled.
+func (h *Handler) RunPoller(ctx context.Context, account string, client integrations.TrelloAPI, boardIDs []string, interval time.Duration) {
+	zap.L().Info("Polling Trello for card updates", zap.Strings("boardIDs", boardIDs), zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, boardID := range boardIDs {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := h.pollBoard(ctx, account, client, boardID); err != nil {
+				zap.L().Error("Failed to poll Trello board", zap.String("boardID", boardID), zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) pollBoard(ctx context.Context, account string, client integrations.TrelloAPI, boardID string) error {
+	// Boards found through the account's workspace may be a tenant's
+	if !h.Tenancy().Owns(account, boardID) {
+		return nil
+	}
+	// Leave the cursor where it is, so the actions are picked up on resume
+	if paused, err := h.Paused(ctx); err != nil || paused {
+		return err
+	}
+	cursor, err := database.GetSetting(h.DB, pollCursorKey(boardID))
+	if err != nil {
+		return err
+	}
+
+	actions, err := client.ListBoardActions(ctx, boardID, cursor, "updateCard,updateBoard,moveAllCardsInList,convertToCardFromCheckItem")
+	if err != nil {
+		return err
+	}
+
+	if cursor == "" {
+		// First poll of this board: start from now rather than replaying its
+		// history. Trello also accepts a date as the cursor, for boards with
+		// no card updates yet.
+		cursor = time.Now().UTC().Format(time.RFC3339)
+		if len(actions) > 0 {
+			cursor = actions[len(actions)-1].ID
+		}
+		return database.PutSetting(h.DB, pollCursorKey(boardID), cursor)
+	}
+
+	// A sync of the whole fetched card covers every earlier update to it, so
+	// only the last of a card's updates in the batch is synced
+	last := make(map[string]int)
+	if h.Config().Sync.FetchFullCard {
+		for i, action := range actions {
+			if action.Data.Card.ID != "" {
+				last[action.Data.Card.ID] = i
+			}
+		}
+	}
+
+	for i, action := range actions {
+		if j, ok := last[action.Data.Card.ID]; !ok || j == i {
+			actionCtx := syncContext(ctx, "", account, action)
+			err := h.processCardUpdate(actionCtx, models.TrelloWebhookPayload{Action: action}, client)
+			h.reportSyncResult(actionCtx, action, err)
+			if err != nil {
+				// Stop here so the action is retried on the next poll
+				return err
+			}
+		}
+		if err := database.PutSetting(h.DB, pollCursorKey(boardID), action.ID); err != nil {
+			return err
+		}
+	}
+
+	if len(actions) > 0 {
+		zap.L().Debug("Processed polled Trello actions", zap.String("boardID", boardID), zap.Int("count", len(actions)))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ReconcileHandler re-applies the stored state of every card (optionally
+// limited to one board) to Google Calendar in a single batched pass. A
+// tenant's API key only reaches the tenant's cards.
+func (h *Handler) ReconcileHandler(c *gin.Context) {
+	boardID := c.Query("board_id")
+
+	summary, err := h.reconcileCards(c.Request.Context(), RequestTenant(c), boardID)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Reconciliation failed", zap.String("boardID", boardID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "reconciliation failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+type reconcileSummary struct {
+	integrations.BatchSummary
+	Skipped int `json:"skipped"`
+}
+
+// reconcileCards builds the calendar operations needed to bring Google Calendar
+// in line with the database and applies them as one batch. Only the tenant's
+// cards are reconciled, unless tenant is empty.
+func (h *Handler) reconcileCards(ctx context.Context, tenant, boardID string) (reconcileSummary, error) {
+	var cards []models.Card
+	query := h.DB.Model(&models.Card{})
+	if tenant != "" {
+		query = query.Scopes(database.TenantCards(tenant))
+	}
+	if boardID != "" {
+		query = query.Where("board_id = ?", boardID)
+	}
+	if err := query.Find(&cards).Error; err != nil {
+		return reconcileSummary{}, fmt.Errorf("failed to load cards: %w", err)
+	}
+
+	closed, err := h.closedBoards()
+	if err != nil {
+		return reconcileSummary{}, fmt.Errorf("failed to load closed boards: %w", err)
+	}
+
+	ttl := h.claimTTL()
+	var summary reconcileSummary
+	var ops []integrations.EventOp
+	for _, card := range cards {
+		// Cards on closed boards are left as they were when it closed
+		if _, ok := closed[card.BoardID]; ok && card.Source == "" {
+			summary.Skipped++
+			continue
+		}
+		// Leave cards that a webhook worker is busy with; the live update wins
+		if !h.Claims.TryClaim(card.ID, claims.OwnerReconciler, ttl) {
+			holder, _ := h.Claims.Holder(card.ID)
+			logging.FromContext(ctx).Debug("Skipping card claimed by another worker", zap.String("cardID", card.ID), zap.String("holder", holder))
+			summary.Skipped++
+			continue
+		}
+
+		// Re-read under the claim in case a webhook updated the card since the list was loaded
+		err := h.Writes.FlushCard(card.ID)
+		if err == nil {
+			err = h.DB.First(&card, "id = ?", card.ID).Error
+		}
+		if err == nil {
+			err = database.LoadCardEvents(h.DB, &card)
+		}
+		if err != nil {
+			h.Claims.Release(card.ID, claims.OwnerReconciler)
+			logging.FromContext(ctx).Warn("Failed to reload card for reconciliation", zap.String("cardID", card.ID), zap.Error(err))
+			continue
+		}
+
+		wantsEvent := h.wantsEvent(card)
+		switch {
+		case wantsEvent && card.EventID == "" && !h.staleOverdue(ctx, card):
+			card.CalendarID = h.targetCalendarID(card)
+			ops = append(ops, integrations.EventOp{Type: integrations.EventOpCreate, Card: card})
+		case wantsEvent && card.EventID != "":
+			from := h.CalClient.CalendarFor(card)
+			card.CalendarID = h.targetCalendarID(card)
+			ops = append(ops, integrations.EventOp{Type: integrations.EventOpUpdate, Card: card, EventID: card.EventID, FromCalendarID: from})
+		case card.EventID != "":
+			ops = append(ops, integrations.EventOp{Type: integrations.EventOpDelete, Card: card, EventID: card.EventID})
+		default:
+			h.Claims.Release(card.ID, claims.OwnerReconciler)
+		}
+	}
+
+	logging.FromContext(ctx).Info("Starting reconciliation pass", zap.String("boardID", boardID), zap.Int("cards", len(cards)), zap.Int("operations", len(ops)))
+
+	results, batchSummary := h.CalClient.ApplyBatch(ctx, ops)
+	summary.BatchSummary = batchSummary
+
+	var changed []*models.Card
+	for _, res := range results {
+		if card, ok := h.reconcileResult(res.Op.Card, res); ok {
+			changed = append(changed, &card)
+		}
+	}
+	// The claims are held until the event IDs are saved, so a webhook can't
+	// read the card without them
+	if err := database.SaveCardsEvents(h.DB, changed); err != nil {
+		zap.L().Error("Failed to save event IDs after reconciliation", zap.Int("cards", len(changed)), zap.Error(err))
+	}
+	for _, res := range results {
+		h.Claims.Release(res.Op.Card.ID, claims.OwnerReconciler)
+	}
+
+	return summary, nil
+}
+
+// reconcileResult applies the outcome of the card's calendar operation to it,
+// reporting whether it has event IDs to save
+func (h *Handler) reconcileResult(card models.Card, res integrations.EventOpResult) (models.Card, bool) {
+	if res.Err != nil {
+		zap.L().Warn("Calendar operation failed during reconciliation", zap.String("cardID", card.ID), zap.String("op", string(res.Op.Type)), zap.Error(res.Err))
+		return card, false
+	}
+
+	switch res.Op.Type {
+	case integrations.EventOpCreate, integrations.EventOpUpdate:
+		card.EventID = res.Event.Id
+		operation := outbound.Updated
+		// Updates recreate events that were deleted in Google Calendar
+		if res.Op.Type == integrations.EventOpCreate || res.Event.Id != res.Op.EventID {
+			operation = outbound.Created
+		}
+		h.Outbound.Send(outbound.CardEvent(operation, card, outbound.TargetCalendar, card.EventID))
+	case integrations.EventOpDelete:
+		h.Outbound.Send(outbound.CardEvent(outbound.Deleted, card, outbound.TargetCalendar, res.Op.EventID))
+		card.EventID = ""
+		card.CalendarID = ""
+	}
+	return card, true
+}
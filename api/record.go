@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/recording"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxRecordedBody bounds the deliveries RecordWebhooks keeps; webhook bodies
+// are a few kilobytes
+const maxRecordedBody = 1 << 20
+
+// RecordWebhooks saves each delivery to recorder, headers and all, before
+// handing it on, so it can be replayed with the replay command.
+func RecordWebhooks(recorder *recording.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRecordedBody+1))
+		// The handler reads the body as it was, however much was read here
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		log := logging.FromContext(c.Request.Context())
+		switch {
+		case err != nil:
+			log.Warn("Failed to read webhook delivery for recording", zap.Error(err))
+		case len(body) > maxRecordedBody:
+			log.Warn("Not recording webhook delivery larger than the limit", zap.String("path", c.Request.URL.Path), zap.Int("limit", maxRecordedBody))
+		default:
+			rec := recording.New(c.Request, requestid.FromContext(c.Request.Context()), body)
+			if err := recorder.Save(rec); err != nil {
+				log.Warn("Failed to record webhook delivery", zap.Error(err))
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RequestID tags each request with the ID the caller sent in X-Request-ID, or
+// a new one, and echoes it in the response. The ID is added to every line
+// logged through the request's context and carried on to the jobs and API
+// calls the request leads to.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		c.Header(requestid.Header, id)
+
+		ctx := requestid.NewContext(c.Request.Context(), id)
+		ctx = logging.With(ctx, zap.String("requestID", id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"cmp"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type simulateRulesRequest struct {
+	BoardID string `json:"board_id" binding:"required"`
+	// Either a TOML snippet containing [[sync.rules]] tables, or the rules themselves
+	Config string       `json:"config"`
+	Rules  []rules.Rule `json:"rules"`
+}
+
+type simulatedCard struct {
+	CardID   string         `json:"card_id"`
+	Name     string         `json:"name"`
+	EventID  string         `json:"event_id,omitempty"`
+	Decision rules.Decision `json:"decision"`
+	// Set for moves: the calendar the event is in now and would move to
+	FromCalendarID string `json:"from_calendar_id,omitempty"`
+	ToCalendarID   string `json:"to_calendar_id,omitempty"`
+}
+
+type simulateRulesResponse struct {
+	BoardID   string          `json:"board_id"`
+	Evaluated int             `json:"evaluated"`
+	Gain      []simulatedCard `json:"gain"`
+	Lose      []simulatedCard `json:"lose"`
+	Move      []simulatedCard `json:"move"`
+	Unchanged int             `json:"unchanged"`
+}
+
+// SimulateRulesHandler evaluates a proposed rule set against a board's open
+// cards with due dates, as Trello has them now, and reports which would gain,
+// lose, or move calendar events. Cards are fetched read-only, so boards not
+// synced yet and cards no rule let through before are covered too. Nothing
+// is written to Trello, the database or the calendar.
+func (h *Handler) SimulateRulesHandler(c *gin.Context) {
+	var req simulateRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var proposed *rules.Set
+	var err error
+	if req.Config != "" {
+		proposed, err = rules.LoadTOML(req.Config)
+	} else {
+		proposed, err = rules.New(req.Rules)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	client, err := h.simulationClient(req.BoardID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to load tracked boards for rules simulation", zap.String("boardID", req.BoardID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tracked boards"})
+		return
+	}
+	if client == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no Trello account can read the board"})
+		return
+	}
+	fetched, err := client.ListCards(ctx, req.BoardID, "open")
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to fetch cards for rules simulation", zap.String("boardID", req.BoardID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": logging.Redact(err.Error())})
+		return
+	}
+	cards, err := h.simulatedCards(req.BoardID, fetched)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to load cards for rules simulation", zap.String("boardID", req.BoardID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load cards"})
+		return
+	}
+
+	resp := simulateRulesResponse{
+		BoardID:   req.BoardID,
+		Evaluated: len(cards),
+		Gain:      []simulatedCard{},
+		Lose:      []simulatedCard{},
+		Move:      []simulatedCard{},
+	}
+	for _, card := range cards {
+		decision := proposed.Evaluate(ruleCard(card))
+		result := simulatedCard{CardID: card.ID, Name: card.Name, EventID: card.EventID, Decision: decision}
+
+		hasEvent := card.EventID != ""
+		wantsEvent := decision.Includes(rules.TargetCalendar)
+		target := h.CalClient.ResolveCalendarID(decision.Calendar)
+		switch {
+		case wantsEvent && !hasEvent:
+			result.ToCalendarID = target
+			resp.Gain = append(resp.Gain, result)
+		case !wantsEvent && hasEvent:
+			resp.Lose = append(resp.Lose, result)
+		case wantsEvent && h.CalClient.CalendarFor(card) != target:
+			result.FromCalendarID = h.CalClient.CalendarFor(card)
+			result.ToCalendarID = target
+			resp.Move = append(resp.Move, result)
+		default:
+			resp.Unchanged++
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// simulationClient returns the client of the account syncing boardID or, for
+// a board no account syncs yet, of the first account that would own it.
+func (h *Handler) simulationClient(boardID string) (integrations.TrelloAPI, error) {
+	client, err := h.boardClient(boardID)
+	if client != nil || err != nil {
+		return client, err
+	}
+	for _, account := range slices.Sorted(maps.Keys(h.TrelloClients())) {
+		if h.Tenancy().Owns(account, boardID) {
+			return h.TrelloClient(account), nil
+		}
+	}
+	return nil, nil
+}
+
+// simulatedCards turns the board's fetched cards with due dates into the
+// state rules are evaluated against, with the events of those already stored.
+func (h *Handler) simulatedCards(boardID string, fetched []models.TrelloCard) ([]models.Card, error) {
+	var cards []models.Card
+	for _, trelloCard := range fetched {
+		if trelloCard.Due == "" {
+			continue
+		}
+		cards = append(cards, models.Card{
+			ID:      trelloCard.ID,
+			Name:    trelloCard.Name,
+			BoardID: cmp.Or(trelloCard.IDBoard, boardID),
+			ListID:  trelloCard.IDList,
+			Labels:  labelKeys(trelloCard.Labels),
+		})
+	}
+	if len(cards) == 0 {
+		return cards, nil
+	}
+
+	pointers := make([]*models.Card, len(cards))
+	for i := range cards {
+		pointers[i] = &cards[i]
+	}
+	if err := database.LoadCardEvents(h.DB, pointers...); err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
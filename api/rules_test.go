@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations/calendartest"
+	"github.com/chxlky/trello-gcal-sync/integrations/trellotest"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSimulateRulesFetchesBoardCards(t *testing.T) {
+	const (
+		boardID   = "5f00000000000000000b0a4d"
+		backlog   = "5f00000000000000000115a1"
+		doing     = "5f00000000000000000115a2"
+		due       = "2030-03-04T12:00:00.000Z"
+		someday   = "5f00000000000000001abe11"
+		calendarA = "primary"
+	)
+
+	db := database.Init(filepath.Join(t.TempDir(), "cards.db"))
+	cfg := &config.Config{}
+	cfg.Google.Calendar.CalendarID = calendarA
+	h := &Handler{DB: db, CalClient: calendartest.NewFake(&cfg.Google)}
+	h.SetConfig(cfg)
+
+	trello := trellotest.NewFake("")
+	trello.Cards = map[string]models.TrelloCard{
+		// Never stored: the rules the board was synced under excluded it
+		"new": {ID: "new", Name: "New", Due: due, IDBoard: boardID, IDList: doing},
+		// Stored with an event the proposed rules take away
+		"backlog": {ID: "backlog", Name: "Backlog", Due: due, IDBoard: boardID, IDList: backlog},
+		"someday": {ID: "someday", Name: "Someday", Due: due, IDBoard: boardID, IDList: doing, Labels: []models.TrelloLabel{{ID: someday, Name: "Someday"}}},
+		"undated": {ID: "undated", Name: "Undated", IDBoard: boardID, IDList: doing},
+		"closed":  {ID: "closed", Name: "Closed", Due: due, IDBoard: boardID, IDList: doing, Closed: true},
+	}
+	h.SetTrelloClient("default", trello)
+
+	stored := models.Card{ID: "backlog", Name: "Backlog", BoardID: boardID, ListID: backlog, EventID: "event1", CalendarID: calendarA}
+	if err := database.SaveCard(db, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(simulateRulesRequest{BoardID: boardID, Rules: []rules.Rule{
+		{Name: "backlog", Lists: []string{backlog}, Action: rules.ActionExclude},
+		{Name: "someday", Labels: []string{"someday"}, Action: rules.ActionExclude},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/rules/simulate", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.SimulateRulesHandler(c)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp simulateRulesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Evaluated != 3 {
+		t.Errorf("evaluated %d cards, want the 3 open ones with due dates", resp.Evaluated)
+	}
+	if len(resp.Gain) != 1 || resp.Gain[0].CardID != "new" {
+		t.Errorf("gain %+v, want the card that was never stored", resp.Gain)
+	}
+	if len(resp.Lose) != 1 || resp.Lose[0].CardID != "backlog" || resp.Lose[0].EventID != "event1" {
+		t.Errorf("lose %+v, want the stored backlog card's event1", resp.Lose)
+	}
+	if resp.Unchanged != 1 {
+		t.Errorf("%d cards unchanged, want the excluded card without an event", resp.Unchanged)
+	}
+}
+
+func TestSimulateRulesWithoutTrelloAccount(t *testing.T) {
+	db := database.Init(filepath.Join(t.TempDir(), "cards.db"))
+	cfg := &config.Config{}
+	h := &Handler{DB: db, CalClient: calendartest.NewFake(&cfg.Google)}
+	h.SetConfig(cfg)
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/rules/simulate", bytes.NewReader([]byte(`{"board_id":"5f00000000000000000b0a4d"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	h.SimulateRulesHandler(c)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d when no account can read the board", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSearchLimit = 25
+	maxSearchLimit     = 100
+)
+
+func (h *Handler) SearchCardsHandler(c *gin.Context) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	db := h.DB
+	if tenant := RequestTenant(c); tenant != "" {
+		db = db.Scopes(database.TenantCards(tenant))
+	}
+	cards, err := database.SearchCards(db, query, limit)
+	if err == nil {
+		err = database.LoadCardListEvents(h.DB, cards)
+	}
+	if err != nil {
+		zap.L().Error("Card search failed", zap.String("query", query), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "count": len(cards), "cards": cards})
+}
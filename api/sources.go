@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/notify"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/chxlky/trello-gcal-sync/internal/titles"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// syncSourceCard stores the latest state of a card from a source other than
+// Trello, given in update, and brings its calendar event in line with it.
+// Such cards are synced to Google Calendar only.
+func (h *Handler) syncSourceCard(ctx context.Context, update models.Card) error {
+	unlock, err := h.lockCard(ctx, update.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var card models.Card
+	db := h.DB.WithContext(ctx)
+	err = db.First(&card, "id = ?", update.ID).Error
+	if err == nil {
+		err = database.LoadCardEvents(db, &card)
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	card.ID = update.ID
+	card.Source = update.Source
+	card.Name = titles.Clean(update.Name, h.Config().Sync.Titles)
+	card.Description = update.Description
+	card.URL = update.URL
+	card.BoardID = update.BoardID
+	card.ListID = update.ListID
+	card.DueDate = update.DueDate
+	card.Archived = update.Archived
+
+	if err := h.applyCalendarEvent(ctx, &card); err != nil {
+		return err
+	}
+	if err := database.SaveCard(db, &card); err != nil {
+		return fmt.Errorf("failed to save final card state: %w", err)
+	}
+	return nil
+}
+
+// removeSourceCard deletes a card that is gone from its source, and its
+// event.
+func (h *Handler) removeSourceCard(ctx context.Context, cardID string) error {
+	unlock, err := h.lockCard(ctx, cardID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var card models.Card
+	db := h.DB.WithContext(ctx)
+	err = db.First(&card, "id = ?", cardID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err == nil {
+		err = database.LoadCardEvents(db, &card)
+	}
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	if err := h.removeCalendarEvent(ctx, &card); err != nil {
+		return err
+	}
+	if err := database.DeleteCard(db, &card); err != nil {
+		return fmt.Errorf("failed to delete card: %w", err)
+	}
+	return nil
+}
+
+// lockCard takes the card lock and the webhook claim on cardID, as syncs of
+// Trello cards do, and returns the function releasing both.
+func (h *Handler) lockCard(ctx context.Context, cardID string) (func(), error) {
+	ttl := h.claimTTL()
+	claimCtx, cancel := context.WithTimeout(ctx, ttl)
+	defer cancel()
+
+	unlock, err := h.CardLocks.Lock(claimCtx, cardID)
+	if err != nil {
+		return nil, fmt.Errorf("timed out waiting for another update to card %s: %w", cardID, err)
+	}
+	if !h.Claims.Claim(claimCtx, cardID, claims.OwnerWebhook, ttl) {
+		unlock()
+		return nil, fmt.Errorf("timed out waiting to claim card %s", cardID)
+	}
+	return func() {
+		h.Claims.Release(cardID, claims.OwnerWebhook)
+		unlock()
+	}, nil
+}
+
+// applyCalendarEvent creates, updates, moves or deletes the card's event so
+// it matches the stored card and the sync rules.
+func (h *Handler) applyCalendarEvent(ctx context.Context, card *models.Card) error {
+	if !h.wantsEvent(*card) {
+		return h.removeCalendarEvent(ctx, card)
+	}
+
+	targetCalendarID := h.targetCalendarID(*card)
+	if card.EventID == "" {
+		if h.staleOverdue(ctx, *card) {
+			return nil
+		}
+		card.CalendarID = targetCalendarID
+		logging.FromContext(ctx).Info("Due date set for card; creating new event in Google Calendar", zap.String("cardID", card.ID), zap.String("calendarID", targetCalendarID))
+		created, err := h.CalClient.CreateEvent(ctx, *card)
+		if err != nil {
+			return fmt.Errorf("failed to create event in Google Calendar: %w", err)
+		}
+		card.EventID = created.Id
+		h.Notifier.Notify(notify.EventCreatedNotification(*card, "Google Calendar"))
+		h.Outbound.Send(outbound.CardEvent(outbound.Created, *card, outbound.TargetCalendar, card.EventID))
+		return nil
+	}
+
+	if currentCalendarID := h.CalClient.CalendarFor(*card); currentCalendarID != targetCalendarID {
+		logging.FromContext(ctx).Info("Calendar routing changed for card; moving event", zap.String("cardID", card.ID), zap.String("from", currentCalendarID), zap.String("to", targetCalendarID))
+		_, err := h.CalClient.MoveEvent(ctx, card.EventID, currentCalendarID, targetCalendarID)
+		if err != nil && !errors.Is(err, integrations.ErrNotFound) {
+			return fmt.Errorf("failed to move event between calendars: %w", err)
+		}
+	}
+	card.CalendarID = targetCalendarID
+	// An event deleted in Google Calendar is recreated by the update
+	updated, err := h.CalClient.UpdateEvent(ctx, *card, card.EventID)
+	if err != nil {
+		return fmt.Errorf("failed to update event in Google Calendar: %w", err)
+	}
+	operation := outbound.Updated
+	if updated.Id != card.EventID {
+		operation = outbound.Created
+	}
+	card.EventID = updated.Id
+	h.Outbound.Send(outbound.CardEvent(operation, *card, outbound.TargetCalendar, card.EventID))
+	return nil
+}
+
+// utcDay returns the date t falls on in UTC, at midnight, as cards' due
+// dates are held. Sources that keep due dates as a time of day on the date,
+// such as GitHub milestones, go through it.
+func utcDay(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	year, month, day := t.UTC().Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &date
+}
+
+// sourceCalendar returns the calendar reference the card's Jira project or
+// GitHub repository is mapped to, if any.
+func (h *Handler) sourceCalendar(card models.Card) string {
+	switch card.Source {
+	case models.SourceJira:
+		return h.Config().Jira.Projects[strings.ToLower(card.BoardID)]
+	case models.SourceGitHub:
+		repo, _ := h.Config().GitHub.Repo(card.BoardID)
+		return repo.Calendar
+	case models.SourceAsana:
+		project, _ := h.Config().Asana.Project(card.BoardID)
+		return project.Calendar
+	}
+	return ""
+}
+
+// validHubSignature checks a "sha256=<hex HMAC-SHA256 of body>" signature,
+// as GitHub and Jira Cloud send them.
+func validHubSignature(secret, signature string, body []byte) bool {
+	signature, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
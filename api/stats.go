@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type cardStats struct {
+	Total      int64 `json:"total"`
+	WithEvents int64 `json:"with_events"`
+	Archived   int64 `json:"archived"`
+}
+
+// StatsHandler reports card counts, today's external API usage, the job
+// queue and each tenant's limits and use of them. A tenant's API key only
+// gets the counts of the tenant's cards and its own limits, since the rest
+// covers the whole service.
+func (h *Handler) StatsHandler(c *gin.Context) {
+	tenant := RequestTenant(c)
+	cards, err := h.countCards(c.Request.Context(), tenant)
+	if err != nil {
+		zap.L().Error("Failed to count cards for stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load stats"})
+		return
+	}
+	if tenant != "" {
+		c.JSON(http.StatusOK, gin.H{"tenant": tenant, "cards": cards, "limits": h.tenantLimits(tenant, integrations.TenantCallUsage())})
+		return
+	}
+	paused, err := h.Paused(c.Request.Context())
+	if err != nil {
+		zap.L().Error("Failed to load pause state for stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load stats"})
+		return
+	}
+	closedBoards, err := h.closedBoards()
+	if err != nil {
+		zap.L().Error("Failed to load closed boards for stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load stats"})
+		return
+	}
+	calls := integrations.TenantCallUsage()
+	tenants := make(map[string]gin.H)
+	for name := range h.Tenancy().Calendars {
+		tenants[name] = h.tenantLimits(name, calls)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"paused":        paused,
+		"cards":         cards,
+		"closed_boards": closedBoards,
+		"api_usage": gin.H{
+			"google_calendar": h.CalClient.Usage(),
+			"google_tasks":    h.TasksClient.Usage(),
+			"skipped_updates": h.skippedEventUpdates.Load(),
+		},
+		"circuit_breakers": integrations.BreakerStates(),
+		"jobs": gin.H{
+			"queued":   h.Jobs.Len(),
+			"buffered": h.Jobs.Buffered(),
+		},
+		"webhooks": gin.H{
+			"unknown_board": h.unknownBoardDeliveries.Load(),
+		},
+		"backfill": h.BackfillProgress(),
+		"tenants":  tenants,
+	})
+}
+
+// tenantLimits reports the tenant's limits, the cards it was refused for
+// reaching its card limit and its Google calls this hour, from calls
+func (h *Handler) tenantLimits(tenant string, calls map[string]integrations.CallLimitSnapshot) gin.H {
+	limits := h.Config().Tenants.LimitsFor(tenant)
+	return gin.H{
+		"max_boards":            limits.MaxBoards,
+		"max_cards":             limits.MaxCards,
+		"google_calls_per_hour": limits.GoogleCallsPerHour,
+		"refused_cards":         h.refusedCards.get(tenant),
+		"google_calls":          calls[tenant],
+	}
+}
+
+// countCards counts the tenant's cards, or everyone's if tenant is empty
+func (h *Handler) countCards(ctx context.Context, tenant string) (cardStats, error) {
+	db := h.DB.WithContext(ctx)
+	if tenant != "" {
+		db = db.Scopes(database.TenantCards(tenant)).Session(&gorm.Session{})
+	}
+	var cards cardStats
+	counts := []struct {
+		dest  *int64
+		query string
+		args  []any
+	}{
+		{&cards.Total, "", nil},
+		{&cards.Archived, "archived = ?", []any{true}},
+	}
+	for _, count := range counts {
+		tx := db.Model(&models.Card{})
+		if count.query != "" {
+			tx = tx.Where(count.query, count.args...)
+		}
+		if err := tx.Count(count.dest).Error; err != nil {
+			return cards, err
+		}
+	}
+	withEvents, err := database.CountCardsWithEvents(db, models.TargetCalendar)
+	cards.WithEvents = withEvents
+	return cards, err
+}
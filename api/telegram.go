@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// telegramRetryInterval is how long RunTelegramBot waits after failing to
+// fetch messages, or while no bot is configured
+const telegramRetryInterval = 30 * time.Second
+
+const telegramHelp = `/status - queue, card and API status
+/resync [board] - re-apply stored cards to the calendar, for one board (ID or name) or all
+/pause - stop syncing Trello changes
+/resume - sync again, catching up on changes made while paused`
+
+// RunTelegramBot answers commands sent to the Telegram bot from
+// telegram.chat_id until ctx is done. Messages from any other chat are
+// ignored. The bot settings are read afresh each poll, so reloads apply.
+func (h *Handler) RunTelegramBot(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		bot := h.Notifier.Bot()
+		chatID, err := strconv.ParseInt(h.Config().Telegram.ChatID, 10, 64)
+		if bot == nil || err != nil {
+			sleepCtx(ctx, telegramRetryInterval)
+			continue
+		}
+
+		updates, err := bot.GetUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() == nil {
+				zap.L().Warn("Failed to fetch Telegram messages", zap.Error(err))
+				sleepCtx(ctx, telegramRetryInterval)
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.ID + 1
+			if update.Message == nil || update.Message.Chat.ID != chatID || !strings.HasPrefix(update.Message.Text, "/") {
+				continue
+			}
+			reply := h.telegramCommand(ctx, update.Message.Text)
+			if err := bot.SendMessage(ctx, strconv.FormatInt(chatID, 10), reply); err != nil {
+				zap.L().Error("Failed to answer Telegram command", zap.Error(err))
+			}
+		}
+	}
+}
+
+// telegramCommand runs a bot command and returns the HTML reply.
+func (h *Handler) telegramCommand(ctx context.Context, text string) string {
+	fields := strings.Fields(text)
+	// In groups commands may be addressed as /status@botname
+	command, _, _ := strings.Cut(fields[0], "@")
+	arg := strings.Join(fields[1:], " ")
+	zap.L().Info("Received Telegram command", zap.String("command", command))
+
+	switch command {
+	case "/status":
+		return h.telegramStatus(ctx)
+	case "/resync":
+		return h.telegramResync(ctx, arg)
+	case "/pause", "/resume":
+		if err := h.SetPaused(ctx, command == "/pause"); err != nil {
+			zap.L().Error("Failed to change pause state", zap.String("command", command), zap.Error(err))
+			return "Failed to " + command[1:] + " syncing: " + html.EscapeString(logging.Redact(err.Error()))
+		}
+		if command == "/pause" {
+			return "Syncing paused. Trello changes are kept and synced on /resume."
+		}
+		return "Syncing resumed."
+	default:
+		return html.EscapeString(telegramHelp)
+	}
+}
+
+func (h *Handler) telegramStatus(ctx context.Context) string {
+	var reply strings.Builder
+	paused, err := h.Paused(ctx)
+	switch {
+	case err != nil:
+		fmt.Fprintf(&reply, "Syncing: unknown (%s)\n", html.EscapeString(logging.Redact(err.Error())))
+	case paused:
+		reply.WriteString("Syncing: <b>paused</b>\n")
+	default:
+		reply.WriteString("Syncing: running\n")
+	}
+	fmt.Fprintf(&reply, "Queue: %d queued, %d buffered\n", h.Jobs.Len(), h.Jobs.Buffered())
+
+	if cards, err := h.countCards(ctx, ""); err != nil {
+		fmt.Fprintf(&reply, "Cards: unknown (%s)\n", html.EscapeString(logging.Redact(err.Error())))
+	} else {
+		fmt.Fprintf(&reply, "Cards: %d synced, %d with events, %d archived\n", cards.Total, cards.WithEvents, cards.Archived)
+	}
+
+	breakers := integrations.BreakerStates()
+	for _, name := range slices.Sorted(maps.Keys(breakers)) {
+		fmt.Fprintf(&reply, "%s API: %s\n", html.EscapeString(name), html.EscapeString(breakers[name]))
+	}
+	return reply.String()
+}
+
+func (h *Handler) telegramResync(ctx context.Context, board string) string {
+	boardID, err := h.findBoard(ctx, board)
+	if err != nil {
+		return html.EscapeString(logging.Redact(err.Error()))
+	}
+	summary, err := h.reconcileCards(ctx, "", boardID)
+	if err != nil {
+		zap.L().Error("Reconciliation failed", zap.String("boardID", boardID), zap.Error(err))
+		return "Resync failed: " + html.EscapeString(logging.Redact(err.Error()))
+	}
+	return fmt.Sprintf("Resync done: %d created, %d updated, %d deleted, %d failed, %d skipped",
+		summary.Created, summary.Updated, summary.Deleted, summary.Failed, summary.Skipped)
+}
+
+// findBoard returns the ID of the synced board with the given ID or name, or
+// "" for every board if board is empty.
+func (h *Handler) findBoard(ctx context.Context, board string) (string, error) {
+	if board == "" {
+		return "", nil
+	}
+	var boardIDs []string
+	if err := h.DB.WithContext(ctx).Model(&models.Card{}).Distinct().Pluck("board_id", &boardIDs).Error; err != nil {
+		return "", fmt.Errorf("failed to load boards: %w", err)
+	}
+	if slices.Contains(boardIDs, board) {
+		return board, nil
+	}
+	for _, boardID := range boardIDs {
+		if strings.EqualFold(h.boardName(ctx, boardID), board) {
+			return boardID, nil
+		}
+	}
+	return "", errors.New("no synced board is called " + board)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// errCardLimit is returned instead of syncing a tenant's card once the tenant
+// has as many cards as tenants.limits.max_cards allows. The card is synced by
+// its next update after enough of the tenant's cards are archived.
+var errCardLimit = errors.New("tenant has reached its card limit")
+
+// Tenancy records what the tenants own. Accounts, boards and calendars it
+// doesn't list are the operator's.
+type Tenancy struct {
+	Accounts  map[string]string // Tenant owning each tenant's Trello account, by account name
+	Boards    map[string]string // Tenant owning each tenant's board, by board ID
+	Calendars map[string]string // Calendar each tenant's events go to, by tenant
+}
+
+// AccountTenant returns the tenant owning the Trello account, or "" for the
+// operator's.
+func (t *Tenancy) AccountTenant(account string) string {
+	if t == nil {
+		return ""
+	}
+	return t.Accounts[account]
+}
+
+// BoardTenant returns the tenant owning the board, or "" for the operator's.
+func (t *Tenancy) BoardTenant(boardID string) string {
+	if t == nil {
+		return ""
+	}
+	return t.Boards[boardID]
+}
+
+// Owns reports whether the account's updates to boardID are synced: a board
+// is only ever synced for its owner, even when another tenant or the operator
+// can see it too.
+func (t *Tenancy) Owns(account, boardID string) bool {
+	return t.AccountTenant(account) == t.BoardTenant(boardID)
+}
+
+// Tenancy returns what the tenants being synced own.
+func (h *Handler) Tenancy() *Tenancy {
+	return h.tenancy.Load()
+}
+
+func (h *Handler) SetTenancy(t *Tenancy) {
+	h.tenancy.Store(t)
+}
+
+// RequestTenant returns the tenant whose API key authenticated the admin
+// request, which only reaches that tenant's data, or "" for the operator's
+// credentials, which reach everyone's.
+func RequestTenant(c *gin.Context) string {
+	return c.GetString(tenantKey)
+}
+
+// checkCardLimit returns errCardLimit if syncing card would take its tenant
+// past its card limit. Syncs running at once, and bulk syncs whose saves are
+// still queued, can take a tenant a little past it.
+func (h *Handler) checkCardLimit(ctx context.Context, card models.Card) error {
+	limit := h.Config().Tenants.LimitsFor(card.Tenant).MaxCards
+	if card.Tenant == "" || limit <= 0 {
+		return nil
+	}
+	var count int64
+	err := h.DB.WithContext(ctx).Model(&models.Card{}).Scopes(database.TenantCards(card.Tenant)).Where("archived = ?", false).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("database query failed: %w", err)
+	}
+	if count < limit {
+		return nil
+	}
+	h.refusedCards.add(card.Tenant)
+	return fmt.Errorf("%w: tenant %s already syncs %d card(s), so card %s isn't synced", errCardLimit, card.Tenant, count, card.ID)
+}
+
+// tenantCounts counts something per tenant
+type tenantCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (t *tenantCounts) add(tenant string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]int64)
+	}
+	t.counts[tenant]++
+}
+
+func (t *tenantCounts) get(tenant string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts[tenant]
+}
@@ -0,0 +1,690 @@
+// Package app wires the sync service together: clients, HTTP routes,
+// background work and shutdown. main only sets up logging and config and
+// runs an App, so the service can also be embedded in other programs or
+// driven from tests with fake clients.
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/apikeys"
+	"github.com/chxlky/trello-gcal-sync/internal/cardlock"
+	"github.com/chxlky/trello-gcal-sync/internal/claims"
+	"github.com/chxlky/trello-gcal-sync/internal/debounce"
+	"github.com/chxlky/trello-gcal-sync/internal/digest"
+	"github.com/chxlky/trello-gcal-sync/internal/ipallow"
+	"github.com/chxlky/trello-gcal-sync/internal/jobs"
+	"github.com/chxlky/trello-gcal-sync/internal/leader"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/notify"
+	"github.com/chxlky/trello-gcal-sync/internal/outbound"
+	"github.com/chxlky/trello-gcal-sync/internal/recording"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+	ginzap "github.com/gin-contrib/zap"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// brokerConnectTimeout bounds connecting to an external job queue at startup
+const brokerConnectTimeout = 30 * time.Second
+
+// Options are the dependencies of an App. Config and DB are required; clients
+// left nil are built from Config.
+type Options struct {
+	Config      *config.Config
+	DB          *gorm.DB
+	CalClient   integrations.CalendarAPI
+	TasksClient integrations.TasksAPI
+
+	// Trello replaces the client of the named Trello accounts
+	Trello map[string]integrations.TrelloAPI
+
+	// TenantCalendars replaces the Google client of the named tenants
+	TenantCalendars map[string]integrations.CalendarAPI
+
+	// Listener is served instead of listening on server.listen or server.port
+	Listener net.Listener
+
+	// ObserveJob is called with the outcome of each run of a queued sync
+	ObserveJob func(job jobs.Job, err error)
+}
+
+// App is one running instance of the sync service.
+type App struct {
+	cfg      *config.Config
+	db       *gorm.DB
+	handler  *api.Handler
+	router   *gin.Engine
+	server   *http.Server
+	listener net.Listener
+	tls      *tlsSetup // nil when serving plain HTTP
+	accounts []*TrelloAccount
+	tenants  map[string]models.Tenant // The tenants being synced, by name
+	// calendars reaches the tenants' calendars; tenants started later are
+	// added to it
+	calendars *integrations.TenantCalendars
+	// Clients replacing those of accounts and tenants started later, as given
+	// in Options
+	replaceTrello    map[string]integrations.TrelloAPI
+	replaceCalendars map[string]integrations.CalendarAPI
+	// sourceIPs restricts the Trello webhook routes; nil unless
+	// trello.source_ips is enabled
+	sourceIPs *ipallow.List
+	recorder  *recording.Recorder // nil unless server.record_webhooks is set
+
+	elector *leader.Elector // nil without leader election
+	leading atomic.Bool
+
+	reloadMu       sync.Mutex // Held by Reload, Stop and lead
+	stopped        bool
+	bgCtx          context.Context
+	stopBackground context.CancelFunc
+	leadCtx        context.Context    // Cancelled when this instance stops leading
+	stopWatch      context.CancelFunc // Stops renewing calendar watch channels
+}
+
+// New builds an App from opts without starting it.
+func New(opts Options) (*App, error) {
+	if opts.Config == nil {
+		return nil, errors.New("app: no config given")
+	}
+	if opts.DB == nil {
+		return nil, errors.New("app: no database given")
+	}
+	cfg := opts.Config
+	integrations.ConfigureBreakers(cfg.Google.CircuitBreaker, cfg.Trello.CircuitBreaker)
+	if err := integrations.ConfigureTransports(cfg.Google.HTTP, cfg.Trello.HTTP); err != nil {
+		return nil, err
+	}
+
+	calClient := opts.CalClient
+	if calClient == nil {
+		var err error
+		if calClient, err = integrations.NewCalendarClient(&cfg.Google); err != nil {
+			return nil, fmt.Errorf("failed to initialise Google Calendar client: %w", err)
+		}
+		zap.L().Info("Successfully authenticated with Google Calendar API.")
+	}
+
+	tasksClient := opts.TasksClient
+	if tasksClient == nil {
+		var err error
+		if tasksClient, err = integrations.NewTasksClient(&cfg.Google); err != nil {
+			return nil, fmt.Errorf("failed to initialise Google Tasks client: %w", err)
+		}
+	}
+
+	if cfg.Sync.DryRun {
+		zap.L().Warn("Dry run: Trello webhooks and changes to Google and other sync targets are logged but not made")
+		calClient = integrations.NewDryRunCalendar(calClient)
+		tasksClient = integrations.DryRunTasks{TasksAPI: tasksClient}
+	}
+	calClient.Configure(&cfg.Google)
+	tasksClient.Configure(&cfg.Google)
+	if err := prepareConfig(context.Background(), opts.DB, calClient, cfg); err != nil {
+		return nil, err
+	}
+
+	tenants, tenantClients, err := loadTenants(opts.DB, cfg, calClient.ConfiguredCalendarIDs(), opts.TenantCalendars)
+	if err != nil {
+		return nil, err
+	}
+	// Always wrapped, so tenants set up later can be added
+	calendars := integrations.NewTenantCalendars(calClient, tenantClients)
+	calendars.Configure(&cfg.Google)
+	calClient = calendars
+
+	syncRules, err := buildRules(cfg, tenants)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync rules: %w", err)
+	}
+	warnRuleRefs(cfg)
+
+	targets, err := loadTargets(cfg, syncRules, calClient, tasksClient)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsSetup, err := newTLSSetup(cfg.Server.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceIPs *ipallow.List
+	if allow := cfg.Trello.SourceIPs; allow.Enabled {
+		if allow.RefreshInterval <= 0 {
+			return nil, fmt.Errorf("trello.source_ips.refresh_interval must be positive")
+		}
+		if sourceIPs, err = ipallow.New(allow.URL, "trello", allow.Extra, allow.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("invalid trello.source_ips: %w", err)
+		}
+	}
+
+	var recorder *recording.Recorder
+	if dir := cfg.Server.RecordWebhooks; dir != "" {
+		if recorder, err = recording.NewRecorder(dir); err != nil {
+			return nil, err
+		}
+		zap.L().Warn("Recording every webhook delivery", zap.String("dir", dir))
+	}
+
+	accounts, err := LoadTrelloAccounts(opts.DB, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Trello configuration: %w", err)
+	}
+	accounts = activeAccounts(accounts, tenants)
+	for _, account := range accounts {
+		account.useClient(cfg, opts.Trello)
+	}
+
+	var jira *integrations.JiraClient
+	if cfg.Jira.URL != "" {
+		if jira, err = integrations.NewJiraClient(cfg.Jira); err != nil {
+			return nil, fmt.Errorf("invalid Jira configuration: %w", err)
+		}
+	}
+
+	var asana *integrations.AsanaClient
+	if len(cfg.Asana.Projects) > 0 {
+		if asana, err = integrations.NewAsanaClient(cfg.Asana); err != nil {
+			return nil, fmt.Errorf("invalid Asana configuration: %w", err)
+		}
+	}
+
+	handler := &api.Handler{
+		DB:          opts.DB,
+		CalClient:   calClient,
+		TasksClient: tasksClient,
+		Claims:      claims.NewRegistry(),
+		CardLocks:   cardlock.New(),
+		Debounce:    debounce.New(),
+		Targets:     targets,
+		Notifier:    notify.New(cfg),
+		Outbound:    outbound.New(cfg),
+		Jira:        jira,
+		Asana:       asana,
+		Writes:      database.NewCardBatch(opts.DB),
+	}
+	handler.SetConfig(cfg)
+	handler.SetRules(syncRules)
+	handler.SetTenancy(tenancy(accounts, tenants))
+	if err := database.AssignCardTenants(opts.DB, handler.Tenancy().Boards); err != nil {
+		return nil, fmt.Errorf("failed to assign stored cards to tenants: %w", err)
+	}
+	workers := cfg.Sync.Workers
+	if workers <= 0 {
+		workers = 10
+	}
+	queueSize := cfg.Sync.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+
+	var elector *leader.Elector
+	var owner string
+	if election := cfg.LeaderElection; election.Enabled {
+		id, err := instanceID(election)
+		if err != nil {
+			return nil, err
+		}
+		elector = leader.New(opts.DB, id, election.LeaseTTL)
+		owner = id
+	}
+	process := handler.ProcessJob
+	if observe := opts.ObserveJob; observe != nil {
+		process = func(ctx context.Context, job jobs.Job) error {
+			err := handler.ProcessJob(ctx, job)
+			observe(job, err)
+			return err
+		}
+	}
+	if queue := cfg.Sync.Queue; queue.Backend == "" || strings.EqualFold(queue.Backend, jobs.BackendDatabase) {
+		handler.Jobs = jobs.NewQueue(opts.DB, owner, workers, queueSize, process)
+	} else {
+		consumer, err := instanceID(cfg.LeaderElection)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), brokerConnectTimeout)
+		broker, err := jobs.OpenBroker(ctx, queue, consumer)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s job queue: %w", queue.Backend, err)
+		}
+		handler.Jobs = jobs.NewBrokerQueue(broker, workers, queue.Consume, process)
+		zap.L().Info("Queueing card syncs through external broker", zap.String("backend", queue.Backend), zap.Bool("consume", queue.Consume))
+	}
+
+	a := &App{
+		cfg:       cfg,
+		db:        opts.DB,
+		handler:   handler,
+		listener:  opts.Listener,
+		tls:       tlsSetup,
+		accounts:  accounts,
+		tenants:   tenants,
+		calendars: calendars,
+		sourceIPs: sourceIPs,
+		recorder:  recorder,
+		elector:   elector,
+
+		replaceTrello:    opts.Trello,
+		replaceCalendars: opts.TenantCalendars,
+	}
+	a.router = a.routes()
+	a.server = &http.Server{Handler: a.router}
+	return a, nil
+}
+
+func (a *App) routes() *gin.Engine {
+	router := gin.Default()
+	router.Use(api.RequestID())
+	router.Use(ginzap.GinzapWithConfig(zap.L(), &ginzap.Config{
+		TimeFormat:   time.RFC3339,
+		UTC:          true,
+		DefaultLevel: zapcore.InfoLevel,
+		Context: func(c *gin.Context) []zapcore.Field {
+			return []zapcore.Field{zap.String("requestID", requestid.FromContext(c.Request.Context()))}
+		},
+	}))
+	router.Use(ginzap.RecoveryWithZap(zap.L(), true))
+	router.Use(tracing.Middleware())
+	root := router.Group(basePath(a.cfg.Server))
+
+	// Deliveries are recorded before any check, so rejected ones can be
+	// looked into too
+	var record []gin.HandlerFunc
+	if a.recorder != nil {
+		record = append(record, api.RecordWebhooks(a.recorder))
+	}
+	webhook := func(handler gin.HandlerFunc) []gin.HandlerFunc {
+		return append(slices.Clone(record), handler)
+	}
+
+	// An unguessable callback path keeps strangers from posting fake events
+	trelloSource := slices.Clone(record)
+	if a.sourceIPs != nil {
+		trelloSource = append(trelloSource, api.RequireSourceIP(a.sourceIPs))
+	}
+	for _, account := range a.accounts {
+		a.handler.SetTrelloClient(account.Name, account.Client)
+		if account.Tenant != "" {
+			continue
+		}
+		webhook := append(slices.Clone(trelloSource), a.handler.TrelloWebhookHandler(account.Name))
+		root.POST(account.CallbackPath, webhook...)
+		root.HEAD(account.CallbackPath, webhook...)
+	}
+	// Tenants share one route, which reaches those started later too
+	tenantWebhook := append(slices.Clone(trelloSource), a.tenantWebhookHandler)
+	root.POST(tenantCallbackPath, tenantWebhook...)
+	root.HEAD(tenantCallbackPath, tenantWebhook...)
+
+	apiGroup := root.Group("/api")
+	{
+		apiGroup.POST("/gcal-webhook", webhook(a.handler.GoogleCalendarWebhookHandler)...)
+		apiGroup.GET("/health", a.handler.HealthCheckHandler)
+		apiGroup.GET("/cards/search", append(a.adminAuth(), api.RequireScope(apikeys.Read), a.handler.SearchCardsHandler)...)
+		apiGroup.GET("/feed.ics", a.handler.FeedHandler)
+		apiGroup.POST("/github-webhook", webhook(a.handler.GitHubWebhookHandler)...)
+		if a.handler.Jira != nil {
+			apiGroup.POST("/jira-webhook", webhook(a.handler.JiraWebhookHandler)...)
+		}
+		if a.handler.Asana != nil {
+			apiGroup.POST("/asana-webhook", webhook(a.handler.AsanaWebhookHandler)...)
+		}
+	}
+	// Tenants' API keys reach the routes confining them to the tenant's own
+	// cards, calendar and webhooks; the others act on the whole service
+	adminGroup := apiGroup.Group("/admin", a.adminAuth()...)
+	{
+		read, resync := api.RequireScope(apikeys.Read), api.RequireScope(apikeys.Resync)
+		operator := api.RequireOperator()
+		adminGroup.POST("/reconcile", resync, a.requireLeader(), a.handler.ReconcileHandler)
+		adminGroup.POST("/rules/simulate", operator, read, a.handler.SimulateRulesHandler)
+		adminGroup.POST("/cleanup", resync, a.requireLeader(), a.handler.CleanupOrphansHandler)
+		adminGroup.GET("/stats", read, a.handler.StatsHandler)
+		adminGroup.POST("/digest", operator, resync, a.handler.SendDigestHandler)
+		adminGroup.POST("/backfill", operator, resync, a.requireLeader(), a.startBackfillHandler)
+		adminGroup.GET("/backfill", operator, read, a.handler.BackfillStatusHandler)
+		adminGroup.GET("/loglevel", operator, read, a.handler.GetLogLevelHandler)
+		adminGroup.PUT("/loglevel", operator, api.RequireScope(apikeys.Admin), a.handler.SetLogLevelHandler)
+		adminGroup.POST("/tenants/:tenant/invite", operator, api.RequireScope(apikeys.Admin), a.inviteTenantHandler)
+		adminGroup.GET("/webhooks", api.RequireScope(apikeys.Webhooks), a.requireLeader(), a.listWebhooksHandler)
+		adminGroup.POST("/webhooks/check", api.RequireScope(apikeys.Webhooks), a.requireLeader(), a.checkWebhooksHandler)
+		if a.cfg.Server.Pprof {
+			pprofRoutes(adminGroup.Group("", operator, api.RequireScope(apikeys.Admin)))
+		}
+	}
+	a.onboardingRoutes(root)
+	return router
+}
+
+// adminAuth guards the admin routes: with the admin token or an API key and,
+// when server.tls.client_ca_file is set, a client certificate as well.
+func (a *App) adminAuth() []gin.HandlerFunc {
+	auth := []gin.HandlerFunc{a.handler.RequireAdminAuth()}
+	if tlsCfg := a.cfg.Server.TLS; a.tls != nil && tlsCfg.ClientCAFile != "" {
+		auth = append([]gin.HandlerFunc{api.RequireClientCert(tlsCfg.AdminClientNames)}, auth...)
+	}
+	return auth
+}
+
+// Router returns the App's HTTP routes, for serving them without Start.
+func (a *App) Router() http.Handler {
+	return a.router
+}
+
+// Start resumes unfinished syncs, begins serving HTTP, and starts the
+// calendar watch, orphan sweeps and every Trello account.
+func (a *App) Start(ctx context.Context) error {
+	if resumed, err := a.handler.Jobs.Resume(); err != nil {
+		zap.L().Error("Failed to resume card syncs from the previous run", zap.Error(err))
+	} else if resumed > 0 {
+		zap.L().Info("Resumed card syncs left over from the previous run", zap.Int("count", resumed))
+	}
+
+	if a.listener == nil {
+		listener, err := listen(a.cfg.Server)
+		if err != nil {
+			return err
+		}
+		a.listener = listener
+	}
+
+	serveListener := a.listener
+	if a.tls != nil {
+		serveListener = tls.NewListener(a.listener, a.tls.config)
+		if a.tls.httpServer != nil {
+			go func() {
+				if err := a.tls.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					zap.L().Error("ACME challenge server error", zap.Error(err))
+				}
+			}()
+		}
+	}
+
+	zap.L().Info("Starting server", zap.String("address", a.listener.Addr().String()), zap.String("basePath", basePath(a.cfg.Server)), zap.Bool("tls", a.tls != nil))
+	go func() {
+		if err := a.server.Serve(serveListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Fatal("Server error", zap.Error(err))
+		}
+	}()
+
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	a.reloadMu.Lock()
+	a.bgCtx = bgCtx
+	a.stopBackground = stopBackground
+	a.reloadMu.Unlock()
+
+	// Every instance may take webhook deliveries, so each keeps Trello's
+	// addresses up to date
+	if a.sourceIPs != nil {
+		if err := a.sourceIPs.Refresh(ctx); err != nil {
+			zap.L().Error("Failed to fetch Trello's webhook addresses; only trello.source_ips.extra is allowed until a refresh succeeds", zap.Error(err))
+		}
+		go a.sourceIPs.Run(bgCtx, a.cfg.Trello.SourceIPs.RefreshInterval)
+	}
+
+	if a.elector != nil {
+		zap.L().Info("Campaigning for leadership; webhooks and background work start once elected", zap.String("instance", a.elector.ID()))
+		go a.elector.Run(bgCtx, a.lead)
+		return nil
+	}
+	return a.lead(bgCtx)
+}
+
+// Stop shuts the App down in order: stop accepting HTTP requests, finish
+// queued syncs, then stop background work and release the channels and
+// webhooks it registered. Each phase is bounded by server.shutdown_timeout.
+// The database is left open for the caller to close.
+func (a *App) Stop() {
+	shutdownTimeout := a.cfg.Server.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if a.elector != nil {
+		// Deferred first so it runs once reloadMu is released, since a
+		// campaign that has just been won waits for it
+		defer func() {
+			resignCtx, cancelResign := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancelResign()
+			if a.bgCtx != nil {
+				a.elector.Resign(resignCtx)
+			}
+		}()
+	}
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	a.stopped = true
+	leading := a.leading.Load()
+
+	zap.L().Info("Shutting down HTTP server...")
+	if err := a.server.Shutdown(ctx); err != nil {
+		zap.L().Error("Error shutting down server", zap.Error(err))
+	} else {
+		zap.L().Info("HTTP server shut down gracefully.")
+	}
+	if a.tls != nil && a.tls.httpServer != nil {
+		a.tls.httpServer.Shutdown(ctx)
+	}
+
+	// Finish in-flight syncs before the database is closed under them
+	zap.L().Info("Waiting for queued card syncs to finish...", zap.Int("queued", a.handler.Jobs.Len()))
+	if remaining, err := a.handler.Jobs.Drain(ctx); err != nil {
+		zap.L().Warn("Timed out waiting for card syncs to finish", zap.Int("abandoned", remaining), zap.Error(err))
+	} else {
+		zap.L().Info("All card syncs finished.")
+	}
+
+	if a.stopBackground != nil {
+		a.stopBackground()
+	}
+
+	// The drain may have used up the shutdown timeout, so the remaining
+	// API calls get a fresh one
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelStop()
+	if !leading {
+		return // The leader's channels and webhooks aren't this instance's to remove
+	}
+	a.handler.StopCalendarWatch(stopCtx)
+
+	for _, account := range a.accounts {
+		account.stop(stopCtx)
+	}
+}
+
+// loadTargets builds the sync targets the rules route cards to. Targets other
+// than Google Calendar and Tasks must have been registered with
+// integrations.RegisterTarget.
+func loadTargets(cfg *config.Config, syncRules *rules.Set, calClient integrations.CalendarAPI, tasksClient integrations.TasksAPI) (map[string]integrations.SyncTarget, error) {
+	targets := map[string]integrations.SyncTarget{
+		rules.TargetCalendar: integrations.CalendarTarget{Client: calClient},
+		rules.TargetTasks:    integrations.TasksTarget{Client: tasksClient},
+	}
+	for _, name := range syncRules.Targets() {
+		if _, ok := targets[name]; ok {
+			continue
+		}
+		target, err := integrations.NewTarget(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync rules: %w (registered targets: %s)", err, strings.Join(integrations.RegisteredTargets(), ", "))
+		}
+		if cfg.Sync.DryRun {
+			target = integrations.DryRunTarget{Name: name, Target: target}
+		}
+		targets[name] = target
+	}
+	return targets, nil
+}
+
+// startCalendarWatch opens calendar watch channels that are renewed until
+// Stop or the next restartCalendarWatch. Callers hold reloadMu.
+func (a *App) startCalendarWatch() {
+	ctx, stopWatch := context.WithCancel(a.leadCtx)
+	a.stopWatch = stopWatch
+	if err := a.handler.StartCalendarWatch(ctx); err != nil {
+		zap.L().Error("Failed to start watching Google Calendar; calendar-side changes will not be detected", zap.Error(err))
+	}
+}
+
+// prepareConfig fills in the settings derived at startup: the ID of the
+// target calendar and the default calendar watch callback URL.
+func prepareConfig(ctx context.Context, db *gorm.DB, calClient integrations.CalendarAPI, cfg *config.Config) error {
+	if err := resolveCalendarID(ctx, db, calClient, &cfg.Google.Calendar); err != nil {
+		return fmt.Errorf("failed to resolve target Google Calendar: %w", err)
+	}
+	if watch := &cfg.Google.Calendar.Watch; watch.CallbackURL == "" {
+		watch.CallbackURL = publicURL(cfg.Server, "/api/gcal-webhook")
+	}
+	policies := []string{cfg.Google.Calendar.ConflictPolicy}
+	for _, policy := range cfg.Google.Calendar.BoardConflictPolicies {
+		policies = append(policies, policy)
+	}
+	for _, policy := range policies {
+		switch policy {
+		case config.TrelloWins, config.CalendarWins, config.NewestWins:
+		default:
+			return fmt.Errorf("invalid conflict policy %q: must be trello_wins, calendar_wins or newest_wins", policy)
+		}
+	}
+	if len(cfg.Digest.To) > 0 {
+		if _, err := digest.Next(cfg.Digest, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := outbound.Validate(cfg.Webhooks); err != nil {
+		return err
+	}
+	if jira := cfg.Jira; jira.URL != "" {
+		switch {
+		case len(jira.Projects) == 0:
+			return errors.New("jira.projects must list at least one project")
+		case jira.Mode == "webhook" && jira.WebhookSecret == "":
+			return errors.New("jira.webhook_secret must be set to receive Jira webhooks")
+		case jira.Mode == "poll" && jira.PollInterval <= 0:
+			return errors.New("jira.poll_interval must be positive")
+		case jira.Mode != "poll" && jira.Mode != "webhook":
+			return fmt.Errorf("invalid jira.mode %q: must be poll or webhook", jira.Mode)
+		}
+	}
+	if asana := &cfg.Asana; len(asana.Projects) > 0 {
+		if asana.CallbackURL == "" {
+			asana.CallbackURL = publicURL(cfg.Server, "/api/asana-webhook")
+		}
+		switch {
+		case asana.CallbackURL == "":
+			return errors.New("asana.callback_url or server.public_url must be set to receive Asana webhooks")
+		case asana.CheckInterval <= 0:
+			return errors.New("asana.check_interval must be positive")
+		}
+		for _, project := range asana.Projects {
+			if project.GID == "" || project.Workspace == "" {
+				return errors.New("each of asana.projects needs its gid and workspace")
+			}
+		}
+	}
+	if github := cfg.GitHub; len(github.Repos) > 0 {
+		if github.WebhookSecret == "" {
+			return errors.New("github.webhook_secret must be set to receive GitHub webhooks")
+		}
+		for _, repo := range github.Repos {
+			if owner, name, ok := strings.Cut(repo.Name, "/"); !ok || owner == "" || name == "" {
+				return fmt.Errorf("invalid github.repos name %q: must be owner/name", repo.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveCalendarID turns google.calendar.calendar_id into a usable calendar ID.
+// When it is missing or holds a calendar name, the calendar is looked up or
+// created and its ID is remembered in the database for subsequent runs.
+func resolveCalendarID(ctx context.Context, db *gorm.DB, calClient integrations.CalendarAPI, cfg *config.Calendar) error {
+	configured := cfg.CalendarID
+	if integrations.IsCalendarID(configured) {
+		return nil
+	}
+
+	name := configured
+	if name == "" {
+		name = integrations.DefaultCalendarName
+	}
+	settingKey := calendarSettingKey(name)
+
+	calendarID, err := database.GetSetting(db, settingKey)
+	if err != nil {
+		return err
+	}
+	if calendarID == "" || !calClient.CalendarExists(ctx, calendarID) {
+		if calendarID, err = calClient.EnsureCalendar(ctx, name); err != nil {
+			return err
+		}
+		if err := database.PutSetting(db, settingKey, calendarID); err != nil {
+			return err
+		}
+	}
+
+	zap.L().Info("Using Google Calendar", zap.String("name", name), zap.String("calendarID", calendarID))
+	cfg.CalendarID = calendarID
+	return nil
+}
+
+// calendarSettingKey is where the ID of the calendar called name is stored
+func calendarSettingKey(name string) string {
+	return "google.calendar_id:" + name
+}
+
+// basePath is the server.base_path prefix all routes are served under, for
+// running behind a reverse proxy that routes a sub-path to the service. It is
+// normalised to "" or a path with a leading and no trailing slash.
+func basePath(server config.Server) string {
+	p := strings.Trim(server.BasePath, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// publicURL returns the externally reachable URL of a route, built from
+// server.public_url, or the autocert domain, and the base path, or "" if no
+// public URL is configured.
+func publicURL(server config.Server, route string) string {
+	origin := strings.TrimSuffix(server.PublicURL, "/")
+	if domains := server.TLS.Autocert.Domains; origin == "" && len(domains) > 0 {
+		// The certificate's domain is, by definition, where the service is reachable
+		origin = "https://" + domains[0]
+		if server.Port != "" && server.Port != "443" {
+			origin += ":" + server.Port
+		}
+	}
+	if origin == "" {
+		return ""
+	}
+	return origin + basePath(server) + route
+}
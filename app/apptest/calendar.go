@@ -0,0 +1,313 @@
+package apptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"google.golang.org/api/calendar/v3"
+)
+
+// calendarBasePath is where CalendarServer serves the Calendar API, which
+// the client is given as its endpoint
+const calendarBasePath = "/calendar/v3/"
+
+// CalendarServer stands in for the Google Calendar API, keeping events in
+// memory. Deleted events are kept as cancelled, so incremental listings can
+// report them as Google's do.
+type CalendarServer struct {
+	*server
+
+	mu        sync.Mutex
+	calendars map[string]string                     // Names by ID
+	events    map[string]map[string]*calendar.Event // By calendar ID, then event ID
+	changedAt map[string]int                        // Change counter when each event last changed, by calendar and event ID
+	changes   int
+	nextID    int
+}
+
+// NewCalendarServer starts a stand-in holding the calendars with the given
+// IDs, all empty.
+func NewCalendarServer(calendarIDs ...string) *CalendarServer {
+	s := &CalendarServer{
+		calendars: make(map[string]string),
+		events:    make(map[string]map[string]*calendar.Event),
+		changedAt: make(map[string]int),
+	}
+	for _, id := range calendarIDs {
+		s.calendars[id] = id
+		s.events[id] = make(map[string]*calendar.Event)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+calendarBasePath+"users/me/calendarList", s.listCalendars)
+	mux.HandleFunc("GET "+calendarBasePath+"users/me/calendarList/{calendar}", s.getCalendar)
+	mux.HandleFunc("POST "+calendarBasePath+"calendars", s.insertCalendar)
+	mux.HandleFunc("POST "+calendarBasePath+"calendars/{calendar}/acl", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, calendar.AclRule{})
+	})
+	mux.HandleFunc("GET "+calendarBasePath+"calendars/{calendar}/events", s.listEvents)
+	mux.HandleFunc("POST "+calendarBasePath+"calendars/{calendar}/events", s.insertEvent)
+	mux.HandleFunc("POST "+calendarBasePath+"calendars/{calendar}/events/watch", s.watch)
+	mux.HandleFunc("GET "+calendarBasePath+"calendars/{calendar}/events/{event}", s.getEvent)
+	mux.HandleFunc("PATCH "+calendarBasePath+"calendars/{calendar}/events/{event}", s.patchEvent)
+	mux.HandleFunc("DELETE "+calendarBasePath+"calendars/{calendar}/events/{event}", s.deleteEvent)
+	mux.HandleFunc("POST "+calendarBasePath+"calendars/{calendar}/events/{event}/move", s.moveEvent)
+	mux.HandleFunc("POST "+calendarBasePath+"channels/stop", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.server = newServer(mux)
+	return s
+}
+
+// Endpoint is the base URL to give the Calendar client.
+func (s *CalendarServer) Endpoint() string {
+	return s.URL + calendarBasePath
+}
+
+// Events returns the live events on calendarID, by ID.
+func (s *CalendarServer) Events(calendarID string) map[string]*calendar.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make(map[string]*calendar.Event)
+	for id, event := range s.events[calendarID] {
+		if event.Status != "cancelled" {
+			events[id] = event
+		}
+	}
+	return events
+}
+
+// CardEvent returns the live event synced from the card on calendarID.
+func (s *CalendarServer) CardEvent(calendarID, cardID string) (*calendar.Event, bool) {
+	for _, event := range s.Events(calendarID) {
+		if integrations.EventCardID(event) == cardID {
+			return event, true
+		}
+	}
+	return nil, false
+}
+
+// googleError writes an error in the shape the Google API client parses
+func googleError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"error": map[string]any{"code": status, "message": message}})
+}
+
+// touch marks the event changed and stores it; callers hold s.mu
+func (s *CalendarServer) touch(calendarID string, event *calendar.Event) {
+	s.changes++
+	s.changedAt[calendarID+"/"+event.Id] = s.changes
+	event.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	s.events[calendarID][event.Id] = event
+}
+
+// event looks up a live event, writing the error for one that isn't; callers
+// hold s.mu
+func (s *CalendarServer) event(w http.ResponseWriter, r *http.Request) (*calendar.Event, bool) {
+	events, ok := s.events[r.PathValue("calendar")]
+	if !ok {
+		googleError(w, http.StatusNotFound, "Not Found")
+		return nil, false
+	}
+	event, ok := events[r.PathValue("event")]
+	switch {
+	case !ok:
+		googleError(w, http.StatusNotFound, "Not Found")
+		return nil, false
+	case event.Status == "cancelled":
+		googleError(w, http.StatusGone, "Resource has been deleted")
+		return nil, false
+	}
+	return event, true
+}
+
+func (s *CalendarServer) listCalendars(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := calendar.CalendarList{}
+	for _, id := range slices.Sorted(maps.Keys(s.calendars)) {
+		list.Items = append(list.Items, &calendar.CalendarListEntry{Id: id, Summary: s.calendars[id]})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *CalendarServer) getCalendar(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("calendar")
+	name, ok := s.calendars[id]
+	if !ok {
+		googleError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, calendar.CalendarListEntry{Id: id, Summary: name})
+}
+
+func (s *CalendarServer) insertCalendar(w http.ResponseWriter, r *http.Request) {
+	var cal calendar.Calendar
+	if err := json.NewDecoder(r.Body).Decode(&cal); err != nil {
+		googleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	cal.Id = fmt.Sprintf("calendar%d@group.calendar.google.com", s.nextID)
+	s.calendars[cal.Id] = cal.Summary
+	s.events[cal.Id] = make(map[string]*calendar.Event)
+	writeJSON(w, http.StatusOK, cal)
+}
+
+// listEvents lists the live events with the requested private extended
+// property, or with a sync token every event changed since it was issued
+func (s *CalendarServer) listEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calendarID := r.PathValue("calendar")
+	events, ok := s.events[calendarID]
+	if !ok {
+		googleError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	since := -1
+	if token := r.FormValue("syncToken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n > s.changes {
+			googleError(w, http.StatusGone, "Sync token is no longer valid, a full sync is required.")
+			return
+		}
+		since = n
+	}
+	property, value, filtered := strings.Cut(r.FormValue("privateExtendedProperty"), "=")
+	showDeleted := r.FormValue("showDeleted") == "true" || since >= 0
+
+	list := calendar.Events{NextSyncToken: strconv.Itoa(s.changes)}
+	for _, id := range slices.Sorted(maps.Keys(events)) {
+		event := events[id]
+		switch {
+		case since >= 0 && s.changedAt[calendarID+"/"+id] <= since:
+		case event.Status == "cancelled" && !showDeleted:
+		case filtered && (event.ExtendedProperties == nil || event.ExtendedProperties.Private[property] != value):
+		default:
+			list.Items = append(list.Items, event)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *CalendarServer) insertEvent(w http.ResponseWriter, r *http.Request) {
+	var event calendar.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		googleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calendarID := r.PathValue("calendar")
+	if _, ok := s.events[calendarID]; !ok {
+		googleError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	s.nextID++
+	event.Id = fmt.Sprintf("event%06d", s.nextID)
+	event.Status = "confirmed"
+	s.touch(calendarID, &event)
+	writeJSON(w, http.StatusOK, event)
+}
+
+func (s *CalendarServer) getEvent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event, ok := s.event(w, r); ok {
+		writeJSON(w, http.StatusOK, event)
+	}
+}
+
+// patchEvent overwrites the fields the patch sends, as Google does
+func (s *CalendarServer) patchEvent(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		googleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.event(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		googleError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	maps.Copy(fields, patch)
+	data, _ = json.Marshal(fields)
+	var patched calendar.Event
+	if err := json.Unmarshal(data, &patched); err != nil {
+		googleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	patched.Id = event.Id
+	s.touch(r.PathValue("calendar"), &patched)
+	writeJSON(w, http.StatusOK, patched)
+}
+
+func (s *CalendarServer) deleteEvent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.event(w, r)
+	if !ok {
+		return
+	}
+	cancelled := *event
+	cancelled.Status = "cancelled"
+	s.touch(r.PathValue("calendar"), &cancelled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *CalendarServer) moveEvent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.event(w, r)
+	if !ok {
+		return
+	}
+	to := r.FormValue("destination")
+	if _, ok := s.events[to]; !ok {
+		googleError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	cancelled := *event
+	cancelled.Status = "cancelled"
+	s.touch(r.PathValue("calendar"), &cancelled)
+	moved := *event
+	s.touch(to, &moved)
+	writeJSON(w, http.StatusOK, moved)
+}
+
+func (s *CalendarServer) watch(w http.ResponseWriter, r *http.Request) {
+	var channel calendar.Channel
+	if err := json.NewDecoder(r.Body).Decode(&channel); err != nil {
+		googleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl, err := strconv.Atoi(channel.Params["ttl"])
+	if err != nil || ttl <= 0 {
+		ttl = 7 * 24 * 60 * 60 // Google's default of a week
+	}
+	channel.ResourceId = "resource-" + r.PathValue("calendar")
+	channel.Expiration = time.Now().Add(time.Duration(ttl) * time.Second).UnixMilli()
+	writeJSON(w, http.StatusOK, channel)
+}
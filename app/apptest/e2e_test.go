@@ -0,0 +1,165 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/integrations/trellotest"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		logging.SetLevel(zapcore.FatalLevel)
+		gin.DefaultWriter = io.Discard
+	}
+	os.Exit(m.Run())
+}
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end scenarios are skipped in short mode")
+	}
+	for _, scenario := range Scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			if err := runScenario(t.Context(), scenario); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// startInMemory starts a harness syncing against the fakes themselves
+func startInMemory(t *testing.T) *Harness {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), scenarioTimeout)
+	t.Cleanup(cancel)
+	h, err := Start(ctx, Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("starting harness: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestInMemorySync(t *testing.T) {
+	h := startInMemory(t)
+	ctx := t.Context()
+
+	if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.Calendar.Created); n != 1 {
+		t.Fatalf("%d events created, want 1", n)
+	}
+	created := h.Calendar.Created[0]
+	if created.CardID != "c1" || created.CalendarID != CalendarID || created.Event.Start.Date != "2030-03-04" {
+		t.Fatalf("created event %+v on %s for card %s, want one on 2030-03-04 on %s for c1", created.Event.Start, created.CalendarID, created.CardID, CalendarID)
+	}
+
+	if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-09")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.Calendar.Updated); n != 1 {
+		t.Fatalf("%d events updated, want 1", n)
+	}
+	if updated := h.Calendar.Updated[0]; updated.EventID != created.EventID || updated.Event.Start.Date != "2030-03-09" {
+		t.Fatalf("updated event %s to start %+v, want %s to start on 2030-03-09", updated.EventID, updated.Event.Start, created.EventID)
+	}
+
+	if err := h.SyncCard(ctx, models.TrelloCard{ID: "c1", Name: "Ship it"}); err != nil {
+		t.Fatal(err)
+	}
+	if events := h.Calendar.CardEvents("c1"); len(events) != 0 {
+		t.Fatalf("card still has %d events after its due date was removed", len(events))
+	}
+	if n := len(h.Calendar.Deleted); n != 1 {
+		t.Fatalf("%d events deleted, want 1", n)
+	}
+}
+
+func TestInMemoryNotFoundEventIsRecreated(t *testing.T) {
+	h := startInMemory(t)
+	ctx := t.Context()
+
+	if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted in Google Calendar behind the service's back
+	h.Calendar.Events[CalendarID] = nil
+	if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-05")); err != nil {
+		t.Fatal(err)
+	}
+	events := h.Calendar.CardEvents("c1")
+	if len(events) != 1 || events[0].Start.Date != "2030-03-05" {
+		t.Fatalf("card has events %v, want one on 2030-03-05", events)
+	}
+}
+
+func TestInMemoryCalendarErrorFailsSync(t *testing.T) {
+	h := startInMemory(t)
+	h.Calendar.Errors["CreateEvent"] = errors.New("calendar is read-only")
+
+	err := h.SyncCard(t.Context(), dueCard("c1", "Ship it", "2030-03-04"))
+	if err == nil {
+		t.Fatal("sync succeeded, want the calendar's error")
+	}
+	if events := h.Calendar.CardEvents("c1"); len(events) != 0 {
+		t.Fatalf("card has %d events, want none", len(events))
+	}
+}
+
+func TestInMemoryTrelloErrorSyncsFromPayload(t *testing.T) {
+	h := startInMemory(t)
+	h.Trello.Fake.Edit(func(f *trellotest.Fake) { f.Errors["GetCard"] = trellotest.NotFound("card") })
+
+	if err := h.SyncCard(t.Context(), dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+		t.Fatal(err)
+	}
+	h.Trello.Fake.Edit(func(f *trellotest.Fake) {
+		if !slices.Contains(f.Calls, "GetCard c1") {
+			t.Errorf("card wasn't fetched; calls were %v", f.Calls)
+		}
+	})
+	if events := h.Calendar.CardEvents("c1"); len(events) != 1 || events[0].Start.Date != "2030-03-04" {
+		t.Fatalf("card has events %v, want one on 2030-03-04", events)
+	}
+}
+
+func TestInMemoryLabelRuleExcludesCard(t *testing.T) {
+	ctx, cancel := context.WithTimeout(t.Context(), scenarioTimeout)
+	defer cancel()
+	h, err := Start(ctx, Options{InMemory: true, Configure: func(cfg *config.Config) {
+		cfg.Sync.Rules = []rules.Rule{{Name: "someday", Labels: []string{"someday"}, Action: rules.ActionExclude}}
+	}})
+	if err != nil {
+		t.Fatalf("starting harness: %v", err)
+	}
+	defer h.Close()
+
+	card := dueCard("c1", "Ship it", "2030-03-04")
+	if err := h.SyncCard(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	if events := h.Calendar.CardEvents("c1"); len(events) != 1 {
+		t.Fatalf("unlabelled card has %d events, want 1", len(events))
+	}
+	card.Labels = []models.TrelloLabel{{ID: "5f00000000000000001abe11", Name: "Someday"}}
+	if err := h.SyncCard(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	if events := h.Calendar.CardEvents("c1"); len(events) != 0 {
+		t.Fatalf("card labelled Someday has %d events, want none", len(events))
+	}
+}
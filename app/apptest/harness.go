@@ -0,0 +1,346 @@
+package apptest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/app"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/integrations/calendartest"
+	"github.com/chxlky/trello-gcal-sync/integrations/googletest"
+	"github.com/chxlky/trello-gcal-sync/integrations/trellotest"
+	"github.com/chxlky/trello-gcal-sync/internal/jobs"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+	"gorm.io/gorm"
+)
+
+// The board and calendar every harness starts with
+const (
+	BoardID    = "5f00000000000000000b0a4d"
+	BoardName  = "Harness"
+	CalendarID = "primary"
+)
+
+const callbackPath = "/api/trello-webhook"
+
+// TrelloServer stands in for the Trello API, serving Fake.
+type TrelloServer struct {
+	*server
+	Fake *trellotest.Fake
+}
+
+// Options adjust a Harness.
+type Options struct {
+	// Configure changes the config before the service is built from it
+	Configure func(cfg *config.Config)
+
+	// InMemory syncs against Trello.Fake and Calendar called directly, rather
+	// than over HTTP through the stand-in servers, so their faults and
+	// latency don't apply. Inject errors through the fakes' Errors instead.
+	InMemory bool
+}
+
+// Harness is the service running against stand-in Trello and Google servers
+// and a scratch database, listening on a loopback port. Trello webhook
+// deliveries are posted to it over HTTP, as Trello would.
+type Harness struct {
+	Config *config.Config
+	DB     *gorm.DB
+	App    *app.App
+	Trello *TrelloServer
+	Google *CalendarServer
+
+	// Calendar holds the events when the harness runs InMemory
+	Calendar *calendartest.Fake
+
+	dir     string
+	addr    string
+	syncs   *syncResults
+	actions atomic.Int64
+}
+
+// Start builds the service in a fresh harness and starts it, which registers
+// its webhook on BoardID. Close the harness when done with it.
+func Start(ctx context.Context, opts Options) (*Harness, error) {
+	dir, err := os.MkdirTemp("", "trello-gcal-sync-apptest-")
+	if err != nil {
+		return nil, err
+	}
+	h := &Harness{dir: dir, syncs: &syncResults{done: make(map[string]chan error), putOff: make(map[string]chan error)}}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.addr = listener.Addr().String()
+
+	cfg, err := config.Decode(viper.New())
+	if err != nil {
+		listener.Close()
+		h.Close()
+		return nil, err
+	}
+	cfg.Database.Path = filepath.Join(dir, "cards.db")
+	cfg.Server.PublicURL = "http://" + h.addr
+	cfg.Server.BasePath = ""
+	cfg.Trello.APIKey = "harness-key"
+	cfg.Trello.APIToken = "harness-token"
+	cfg.Trello.CallbackPath = callbackPath
+	cfg.Trello.CallbackURL = cfg.Server.PublicURL + callbackPath
+	cfg.Trello.BoardIDs = []string{BoardID}
+	cfg.Google.Calendar.CalendarID = CalendarID
+	// Syncs run as soon as they are delivered, so checks needn't wait
+	cfg.Sync.Debounce = 0
+	if opts.Configure != nil {
+		opts.Configure(cfg)
+	}
+	h.Config = cfg
+
+	fake := trellotest.NewFake(cfg.Trello.CallbackURL)
+	fake.Me = models.TrelloMember{ID: "5f00000000000000000e0001", Username: "harness"}
+	fake.Boards[BoardID] = models.TrelloBoard{ID: BoardID, Name: BoardName}
+	h.Trello = &TrelloServer{server: newServer(fake.Handler()), Fake: fake}
+	h.Google = NewCalendarServer(CalendarID)
+	if opts.InMemory {
+		h.Calendar = calendartest.NewFake(&cfg.Google)
+	}
+
+	h.DB = database.Init(cfg.Database.Path)
+	if err := h.start(ctx, listener); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// start builds and starts the App, serving listener
+func (h *Harness) start(ctx context.Context, listener net.Listener) error {
+	var calClient integrations.CalendarAPI = h.Calendar
+	var trello integrations.TrelloAPI = h.Trello.Fake
+	if h.Calendar == nil {
+		google := option.WithEndpoint(h.Google.Endpoint())
+		googleClient, err := integrations.NewCalendarClient(&h.Config.Google, google, option.WithHTTPClient(h.Google.Client()))
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		calClient = googleClient
+
+		trelloClient := integrations.NewTrelloClient(h.Config.Trello.APIKey, h.Config.Trello.APIToken, h.Config.Trello.CallbackURL)
+		trelloClient.Client = h.Trello.Client()
+		trelloClient.BaseURL = h.Trello.URL + "/1"
+		trello = trelloClient
+	}
+	tasksClient, err := integrations.NewTasksClient(&h.Config.Google, option.WithHTTPClient((&googletest.Transport{}).Client()))
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	a, err := app.New(app.Options{
+		Config:      h.Config,
+		DB:          h.DB,
+		CalClient:   calClient,
+		TasksClient: tasksClient,
+		Trello:      map[string]integrations.TrelloAPI{app.DefaultAccountName: trello},
+		Listener:    listener,
+		ObserveJob:  h.syncs.observe,
+	})
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	if err := a.Start(ctx); err != nil {
+		a.Stop()
+		return err
+	}
+	h.App = a
+	return nil
+}
+
+// Stop shuts the service down as on SIGTERM, leaving the stand-ins and the
+// database for Restart.
+func (h *Harness) Stop() {
+	if h.App != nil {
+		h.App.Stop()
+		h.App = nil
+	}
+}
+
+// Restart stops the service if it is running and starts a new one on the
+// same address and database, as a redeploy would.
+func (h *Harness) Restart(ctx context.Context) error {
+	h.Stop()
+	listener, err := net.Listen("tcp", h.addr)
+	if err != nil {
+		return err
+	}
+	return h.start(ctx, listener)
+}
+
+// Close stops the service and the stand-ins and removes the scratch
+// database.
+func (h *Harness) Close() {
+	h.Stop()
+	if h.Trello != nil {
+		h.Trello.Close()
+	}
+	if h.Google != nil {
+		h.Google.Close()
+	}
+	if h.DB != nil {
+		if sqlDB, err := h.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	os.RemoveAll(h.dir)
+}
+
+// UpdateCard stores card in the stand-in Trello and delivers the
+// updateCard action Trello would send for it, returning the delivery's
+// request ID. The card's board defaults to BoardID.
+func (h *Harness) UpdateCard(ctx context.Context, card models.TrelloCard) (string, error) {
+	if card.IDBoard == "" {
+		card.IDBoard = BoardID
+	}
+	h.Trello.Fake.Edit(func(f *trellotest.Fake) { f.Cards[card.ID] = card })
+
+	var action models.TrelloAction
+	action.ID = fmt.Sprintf("%08x%016x", time.Now().Unix(), h.actions.Add(1))
+	action.Type = "updateCard"
+	action.Date = time.Now().UTC().Format(time.RFC3339)
+	action.Data.Board.ID = card.IDBoard
+	action.Data.Board.Name = BoardName
+	action.Data.Card = models.TrelloCardData{ID: card.ID, Name: card.Name, Desc: card.Desc, Due: card.Due, Closed: card.Closed, IDList: card.IDList}
+	return h.Deliver(ctx, action)
+}
+
+// Deliver posts action to the callback of the webhook registered on its
+// board, as Trello would, and returns the delivery's request ID. It fails if
+// there is no such webhook or the service doesn't accept the delivery.
+func (h *Harness) Deliver(ctx context.Context, action models.TrelloAction) (string, error) {
+	var callbackURL string
+	h.Trello.Fake.Edit(func(f *trellotest.Fake) {
+		for _, webhook := range f.Webhooks {
+			if webhook.IDModel == action.Data.Board.ID && webhook.Active {
+				callbackURL = webhook.CallbackURL
+			}
+		}
+	})
+	if callbackURL == "" {
+		return "", fmt.Errorf("no webhook is registered on board %s", action.Data.Board.ID)
+	}
+
+	body, err := json.Marshal(models.TrelloWebhookPayload{Action: action})
+	if err != nil {
+		return "", err
+	}
+	id := "apptest-" + action.ID
+	h.syncs.expect(id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestid.Header, id)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("delivery of action %s got %s", action.ID, resp.Status)
+	}
+	return id, nil
+}
+
+// WaitSynced waits for the sync of the delivery with requestID to finish and
+// returns its error. Syncs put off by rate limiting or an outage are waited
+// for until they run again.
+func (h *Harness) WaitSynced(ctx context.Context, requestID string) error {
+	return h.syncs.wait(ctx, requestID)
+}
+
+// WaitPutOff waits for the sync of the delivery with requestID to be put off
+// by rate limiting or an outage and returns the error that put it off.
+func (h *Harness) WaitPutOff(ctx context.Context, requestID string) error {
+	return h.syncs.waitPutOff(ctx, requestID)
+}
+
+// SyncCard is UpdateCard followed by WaitSynced.
+func (h *Harness) SyncCard(ctx context.Context, card models.TrelloCard) error {
+	id, err := h.UpdateCard(ctx, card)
+	if err != nil {
+		return err
+	}
+	return h.WaitSynced(ctx, id)
+}
+
+// syncResults hands the outcome of each delivery's sync to whoever waits for
+// it
+type syncResults struct {
+	mu     sync.Mutex
+	done   map[string]chan error // By request ID
+	putOff map[string]chan error // By request ID
+}
+
+func (s *syncResults) expect(id string) chan error {
+	return s.channel(s.done, id)
+}
+
+func (s *syncResults) channel(results map[string]chan error, id string) chan error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := results[id]
+	if !ok {
+		ch = make(chan error, 1)
+		results[id] = ch
+	}
+	return ch
+}
+
+func (s *syncResults) observe(job jobs.Job, err error) {
+	results := s.done
+	var retryErr *jobs.RetryError
+	var outageErr *jobs.OutageError
+	if errors.As(err, &retryErr) || errors.As(err, &outageErr) {
+		results = s.putOff
+	}
+	select {
+	case s.channel(results, job.RequestID) <- err:
+	default:
+	}
+}
+
+func (s *syncResults) waitPutOff(ctx context.Context, id string) error {
+	select {
+	case err := <-s.channel(s.putOff, id):
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the sync of %s to be put off: %w", id, ctx.Err())
+	}
+}
+
+func (s *syncResults) wait(ctx context.Context, id string) error {
+	select {
+	case err := <-s.expect(id):
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the sync of %s: %w", id, ctx.Err())
+	}
+}
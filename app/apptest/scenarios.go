@@ -0,0 +1,228 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// scenarioTimeout bounds each scenario, harness start-up included
+const scenarioTimeout = time.Minute
+
+// Scenario is one end-to-end check, run in a harness of its own.
+type Scenario struct {
+	Name    string
+	Options Options
+	Run     func(ctx context.Context, h *Harness) error
+}
+
+// Scenarios cover the webhook to calendar event flows, retries of failed API
+// calls, and shutdown.
+var Scenarios = []Scenario{
+	{Name: "card with a due date gets an event", Run: func(ctx context.Context, h *Harness) error {
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		return h.expectEvent("c1", "2030-03-04", "Ship it")
+	}},
+	{Name: "due date change moves the event", Run: func(ctx context.Context, h *Harness) error {
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		first, _ := h.Google.CardEvent(CalendarID, "c1")
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-09")); err != nil {
+			return err
+		}
+		if err := h.expectEvent("c1", "2030-03-09", "Ship it"); err != nil {
+			return err
+		}
+		if event, _ := h.Google.CardEvent(CalendarID, "c1"); event.Id != first.Id {
+			return fmt.Errorf("event was recreated as %s rather than updated", event.Id)
+		}
+		return nil
+	}},
+	{Name: "removing the due date deletes the event", Run: func(ctx context.Context, h *Harness) error {
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		if err := h.SyncCard(ctx, models.TrelloCard{ID: "c1", Name: "Ship it"}); err != nil {
+			return err
+		}
+		return h.expectNoEvent("c1")
+	}},
+	{Name: "archiving the card deletes the event", Run: func(ctx context.Context, h *Harness) error {
+		card := dueCard("c1", "Ship it", "2030-03-04")
+		if err := h.SyncCard(ctx, card); err != nil {
+			return err
+		}
+		card.Closed = true
+		if err := h.SyncCard(ctx, card); err != nil {
+			return err
+		}
+		return h.expectNoEvent("c1")
+	}},
+	{Name: "Google server errors are retried", Run: func(ctx context.Context, h *Harness) error {
+		insert := calendarBasePath + "calendars/" + CalendarID + "/events"
+		h.Google.FailNext(2, http.StatusServiceUnavailable, http.MethodPost, insert)
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		if n := h.Google.Requests(http.MethodPost, insert); n != 3 {
+			return fmt.Errorf("event was inserted in %d attempts, want 3", n)
+		}
+		return h.expectEvent("c1", "2030-03-04", "Ship it")
+	}},
+	{Name: "Trello server errors are retried", Run: func(ctx context.Context, h *Harness) error {
+		h.Trello.FailNext(1, http.StatusInternalServerError, http.MethodGet, "/1/cards/c1")
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		if n := h.Trello.Requests(http.MethodGet, "/1/cards/c1"); n != 2 {
+			return fmt.Errorf("card was fetched in %d attempts, want 2", n)
+		}
+		return h.expectEvent("c1", "2030-03-04", "Ship it")
+	}},
+	{Name: "network failures deleting an event keep it for the replay", Run: func(ctx context.Context, h *Harness) error {
+		if err := h.SyncCard(ctx, dueCard("c1", "Ship it", "2030-03-04")); err != nil {
+			return err
+		}
+		event, _ := h.Google.CardEvent(CalendarID, "c1")
+		h.Google.DropNext(3, http.MethodDelete, calendarBasePath+"calendars/"+CalendarID+"/events/"+event.Id)
+		id, err := h.UpdateCard(ctx, models.TrelloCard{ID: "c1", Name: "Ship it"})
+		if err != nil {
+			return err
+		}
+		if err := h.WaitPutOff(ctx, id); !errors.Is(err, integrations.ErrTransient) {
+			return fmt.Errorf("sync was put off with %v, want a temporary failure", err)
+		}
+		card := models.Card{ID: "c1"}
+		if err := database.LoadCardEvents(h.DB, &card); err != nil {
+			return err
+		}
+		if card.EventID != event.Id {
+			return fmt.Errorf("card's event is %q after the failed deletion, want %s", card.EventID, event.Id)
+		}
+		return h.expectEvent("c1", "2030-03-04", "Ship it")
+	}},
+	{Name: "shutdown finishes queued syncs and removes the webhook", Run: func(ctx context.Context, h *Harness) error {
+		h.Google.SetLatency(50 * time.Millisecond)
+		cards := deliverCards(ctx, h, 5)
+		if err := errors.Join(cards...); err != nil {
+			return err
+		}
+		h.Stop()
+		for i := range len(cards) {
+			if err := h.expectEvent(fmt.Sprintf("c%d", i), "2030-03-04", ""); err != nil {
+				return err
+			}
+		}
+		if n := len(h.Trello.Fake.Webhooks); n != 0 {
+			return fmt.Errorf("%d webhooks still registered after shutdown", n)
+		}
+		return nil
+	}},
+	{
+		Name: "syncs cut off by shutdown resume after a restart",
+		Options: Options{Configure: func(cfg *config.Config) {
+			cfg.Server.ShutdownTimeout = 100 * time.Millisecond
+			cfg.Sync.Workers = 1
+		}},
+		Run: func(ctx context.Context, h *Harness) error {
+			h.Google.SetLatency(200 * time.Millisecond)
+			if err := errors.Join(deliverCards(ctx, h, 5)...); err != nil {
+				return err
+			}
+			h.Stop()
+			if n := len(h.Google.Events(CalendarID)); n == 5 {
+				return errors.New("every sync finished before shutdown; nothing was left to resume")
+			}
+			h.Google.SetLatency(0)
+			if err := h.Restart(ctx); err != nil {
+				return err
+			}
+			// A sync cut off once its event was inserted inserts another when
+			// it resumes, so only check every card has one
+			return eventually(ctx, func() error {
+				for i := range 5 {
+					if err := h.expectEvent(fmt.Sprintf("c%d", i), "2030-03-04", ""); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	},
+}
+
+// runScenario runs scenario in a fresh harness
+func runScenario(ctx context.Context, scenario Scenario) error {
+	ctx, cancel := context.WithTimeout(ctx, scenarioTimeout)
+	defer cancel()
+	h, err := Start(ctx, scenario.Options)
+	if err != nil {
+		return fmt.Errorf("starting harness: %w", err)
+	}
+	defer h.Close()
+	return scenario.Run(ctx, h)
+}
+
+func dueCard(id, name, day string) models.TrelloCard {
+	return models.TrelloCard{ID: id, Name: name, Due: day + "T12:00:00.000Z"}
+}
+
+// deliverCards delivers n cards due on the same day without waiting for
+// their syncs
+func deliverCards(ctx context.Context, h *Harness, n int) []error {
+	errs := make([]error, n)
+	for i := range n {
+		_, errs[i] = h.UpdateCard(ctx, dueCard(fmt.Sprintf("c%d", i), fmt.Sprintf("Card %d", i), "2030-03-04"))
+	}
+	return errs
+}
+
+// expectEvent checks the card's event is on day and, unless name is empty,
+// titled with it
+func (h *Harness) expectEvent(cardID, day, name string) error {
+	event, ok := h.Google.CardEvent(CalendarID, cardID)
+	switch {
+	case !ok:
+		return fmt.Errorf("no event for card %s", cardID)
+	case event.Start == nil || event.Start.Date != day:
+		return fmt.Errorf("event for card %s starts %+v, want %s", cardID, event.Start, day)
+	case !strings.Contains(event.Summary, name):
+		return fmt.Errorf("event for card %s is titled %q, want %q", cardID, event.Summary, name)
+	}
+	return nil
+}
+
+func (h *Harness) expectNoEvent(cardID string) error {
+	if event, ok := h.Google.CardEvent(CalendarID, cardID); ok {
+		return fmt.Errorf("card %s still has event %s", cardID, event.Id)
+	}
+	return nil
+}
+
+// eventually retries check until it passes or ctx is done
+func eventually(ctx context.Context, check func() error) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package apptest runs the whole service, HTTP routes, queue, workers and
+// real API clients included, against stand-in Trello and Google Calendar
+// servers and a scratch SQLite database, for end-to-end checks of the sync.
+// Harnesses started InMemory call in-memory fakes of the clients instead.
+// Its scenarios run under go test, which shows the service's logs with -v.
+package apptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// server is what the stand-in API servers share: a log of the requests they
+// received and failures injected into them
+type server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string // Method and path of each request
+	faults   []*fault
+	latency  time.Duration
+}
+
+// dropConnection is the fault status of requests whose connection is closed
+// without a response, as when the network fails
+const dropConnection = -1
+
+type fault struct {
+	method, path      string
+	status, remaining int
+}
+
+func newServer(handler http.Handler) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, delay := s.intercept(r); status == dropConnection {
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		} else if status != 0 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(status), status)
+			return
+		} else if delay > 0 {
+			time.Sleep(delay)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// intercept logs the request and returns the status of a fault it matches,
+// or the latency to add before serving it
+func (s *server) intercept(r *http.Request) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	for i, f := range s.faults {
+		if f.method == r.Method && f.path == r.URL.Path {
+			if f.remaining--; f.remaining == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+			return f.status, 0
+		}
+	}
+	return 0, s.latency
+}
+
+// FailNext makes the next n requests with method to path fail with status.
+func (s *server) FailNext(n, status int, method, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{method: method, path: path, status: status, remaining: n})
+}
+
+// DropNext makes the next n requests with method to path fail without a
+// response.
+func (s *server) DropNext(n int, method, path string) {
+	s.FailNext(n, dropConnection, method, path)
+}
+
+// SetLatency delays every request by d, to keep syncs in flight.
+func (s *server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests counts the requests received with method to path, failed ones
+// included.
+func (s *server) Requests(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, request := range s.requests {
+		if request == method+" "+path {
+			n++
+		}
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
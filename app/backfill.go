@@ -0,0 +1,36 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// startBackfillHandler starts a backfill of every tracked board, or of
+// ?board_id=, which runs for as long as this instance leads. Pass
+// ?restart=true to discard the progress of an interrupted one.
+func (a *App) startBackfillHandler(c *gin.Context) {
+	a.reloadMu.Lock()
+	ctx := a.leadCtx
+	a.reloadMu.Unlock()
+	if ctx == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the service hasn't started"})
+		return
+	}
+
+	err := a.handler.StartBackfill(ctx, c.Query("board_id"), c.Query("restart") == "true")
+	switch {
+	case errors.Is(err, api.ErrBackfillRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "progress": a.handler.BackfillProgress()})
+	case errors.Is(err, api.ErrNothingToBackfill):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		zap.L().Error("Failed to start backfill", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start backfill"})
+	default:
+		c.JSON(http.StatusAccepted, a.handler.BackfillProgress())
+	}
+}
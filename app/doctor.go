@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"gorm.io/gorm"
+)
+
+// errDoctorRollback undoes the database write check
+var errDoctorRollback = errors.New("rollback")
+
+// errSkipped marks a check that couldn't run because an earlier one failed
+var errSkipped = errors.New("skipped")
+
+// doctor runs checks in order and writes a line per result to out.
+type doctor struct {
+	out    io.Writer
+	failed int
+}
+
+func (d *doctor) check(name string, run func() (string, error)) bool {
+	detail, err := run()
+	switch {
+	case errors.Is(err, errSkipped):
+		fmt.Fprintf(d.out, "SKIP  %s\n", name)
+		return false
+	case err != nil:
+		d.failed++
+		fmt.Fprintf(d.out, "FAIL  %s: %v\n", name, err)
+		return false
+	case detail != "":
+		fmt.Fprintf(d.out, "PASS  %s: %s\n", name, detail)
+	default:
+		fmt.Fprintf(d.out, "PASS  %s\n", name)
+	}
+	return true
+}
+
+// Doctor checks that the service can run with cfg: that the config is
+// consistent, the database is writable, every Trello account's token and
+// boards work, the Google calendar is writable, and Trello can reach each
+// callback URL. It writes a pass/fail report to out and returns an error if
+// anything failed. Nothing is left behind: test writes are rolled back or
+// deleted.
+func Doctor(ctx context.Context, cfg *config.Config, db *gorm.DB, out io.Writer) error {
+	d := &doctor{out: out}
+
+	var accounts []*TrelloAccount
+	configOK := d.check("config", func() (string, error) {
+		syncRules, err := rules.New(cfg.Sync.Rules)
+		if err != nil {
+			return "", fmt.Errorf("invalid sync rules: %w", err)
+		}
+		for _, name := range syncRules.Targets() {
+			if name == rules.TargetCalendar || name == rules.TargetTasks {
+				continue
+			}
+			if _, err := integrations.NewTarget(name, cfg); err != nil {
+				return "", fmt.Errorf("invalid sync rules: %w", err)
+			}
+		}
+		if accounts, err = LoadTrelloAccounts(db, cfg); err != nil {
+			return "", fmt.Errorf("invalid Trello configuration: %w", err)
+		}
+		return fmt.Sprintf("%d Trello account(s), %d sync rule(s)", len(accounts), len(cfg.Sync.Rules)), nil
+	})
+
+	d.check("database writable", func() (string, error) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := database.PutSetting(tx, "doctor", time.Now().UTC().Format(time.RFC3339)); err != nil {
+				return err
+			}
+			return errDoctorRollback
+		})
+		if !errors.Is(err, errDoctorRollback) {
+			return "", err
+		}
+		return cfg.Database.Path, nil
+	})
+
+	for _, account := range accounts {
+		if account.Tenant != "" {
+			d.checkTenant(ctx, cfg, db, account)
+			continue
+		}
+		d.checkTrelloAccount(ctx, account)
+	}
+	if !configOK {
+		d.check("trello accounts", func() (string, error) { return "", errSkipped })
+	}
+
+	d.checkGoogle(ctx, cfg, db)
+
+	if d.failed > 0 {
+		fmt.Fprintf(out, "\n%d check(s) failed\n", d.failed)
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	fmt.Fprintln(out, "\nAll checks passed")
+	return nil
+}
+
+// checkTenant checks that the tenant is fully set up, that its Trello account
+// works like any other, and that its Google account can reach its calendar.
+func (d *doctor) checkTenant(ctx context.Context, cfg *config.Config, db *gorm.DB, account *TrelloAccount) {
+	prefix := fmt.Sprintf("tenant %q", account.Tenant)
+
+	var tenant *models.Tenant
+	ready := d.check(prefix+": setup", func() (string, error) {
+		var err error
+		if tenant, err = database.GetTenant(db, account.Tenant); err != nil {
+			return "", err
+		}
+		problem, err := TenantProblem(db, *tenant)
+		if err != nil {
+			return "", err
+		}
+		if problem != "" {
+			return "", errors.New(problem)
+		}
+		return fmt.Sprintf("%d board(s) synced to %s", len(tenant.BoardIDs), tenant.CalendarID), nil
+	})
+	if !ready {
+		return
+	}
+
+	d.checkTrelloAccount(ctx, account)
+	d.check(prefix+": calendar", func() (string, error) {
+		oauth, err := integrations.NewGoogleOAuth(cfg.Google.OAuth, "")
+		if err != nil {
+			return "", err
+		}
+		token, err := database.GetCredential(db, GoogleCredentialName(tenant.Name))
+		if err != nil {
+			return "", err
+		}
+		client, err := integrations.NewTenantCalendarClient(integrations.TenantSettings(&cfg.Google, tenant.CalendarID), oauth, token, nil)
+		if err != nil {
+			return "", err
+		}
+		if !client.CalendarExists(ctx, tenant.CalendarID) {
+			return "", fmt.Errorf("%s can't reach calendar %s; reconnect it with `trello-gcal-sync tenant auth %s google`", tenant.GoogleAccount, tenant.CalendarID, tenant.Name)
+		}
+		return fmt.Sprintf("%s can reach %s", tenant.GoogleAccount, tenant.CalendarID), nil
+	})
+}
+
+func (d *doctor) checkTrelloAccount(ctx context.Context, account *TrelloAccount) {
+	prefix := fmt.Sprintf("trello account %q", account.Name)
+
+	tokenOK := d.check(prefix+": token", func() (string, error) {
+		if account.APIToken == "" {
+			return "", fmt.Errorf("no token; set its api_token or run `trello-gcal-sync trello auth %s`", account.Name)
+		}
+		member, err := account.Client.GetMe(ctx)
+		if err != nil {
+			return "", fmt.Errorf("token was rejected by Trello: %w", err)
+		}
+		return fmt.Sprintf("authorised as @%s", member.Username), nil
+	})
+
+	var reachable []string
+	d.check(prefix+": boards", func() (string, error) {
+		if !tokenOK {
+			return "", errSkipped
+		}
+		if len(account.BoardIDs) == 0 && account.OrganizationID == "" {
+			return "", errors.New("needs board_ids or organization_id")
+		}
+		boards, err := webhooks.ValidateBoards(ctx, account.Client, account.BoardIDs)
+		reachable = boardIDs(boards)
+		if err != nil {
+			return "", err
+		}
+		if account.OrganizationID != "" {
+			orgBoards, err := account.Client.ListOrganizationBoards(ctx, account.OrganizationID)
+			if err != nil {
+				return "", fmt.Errorf("failed to list boards in workspace %s: %w", account.OrganizationID, err)
+			}
+			for _, board := range orgBoards {
+				reachable = append(reachable, board.ID)
+			}
+		}
+		return fmt.Sprintf("%d board(s) reachable", len(reachable)), nil
+	})
+
+	if account.Mode == "poll" {
+		return
+	}
+	d.check(prefix+": callback URL", func() (string, error) {
+		if !tokenOK {
+			return "", errSkipped
+		}
+		return checkCallback(ctx, account, reachable)
+	})
+}
+
+// checkCallback tests the account's callback URL the way Trello does when a
+// webhook is created: with a HEAD request that must answer 200. When no webhook
+// this service registered is active yet, one is registered and deleted again
+// so Trello itself performs the check from the public internet.
+func checkCallback(ctx context.Context, account *TrelloAccount, boardIDs []string) (string, error) {
+	if account.CallbackURL == "" {
+		return "", errors.New("no callback URL; set callback_url or server.public_url")
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodHead, account.CallbackURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid callback URL %s: %w", account.CallbackURL, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s is unreachable from here (is the service running?): %w", account.CallbackURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered HEAD with %s; Trello requires 200", account.CallbackURL, resp.Status)
+	}
+
+	registered, err := account.Client.ListWebhooks(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	active := 0
+	for _, webhook := range registered {
+		if integrations.OwnsWebhook(account.Client, webhook) && webhook.Active {
+			active++
+		}
+	}
+	if active > 0 {
+		return fmt.Sprintf("%s answers HEAD; Trello has %d active webhook(s) delivering to it", account.CallbackURL, active), nil
+	}
+
+	if len(boardIDs) == 0 {
+		return fmt.Sprintf("%s answers HEAD; no board to test Trello's validation with", account.CallbackURL), nil
+	}
+	webhookID, err := account.Client.RegisterWebhook(ctx, boardIDs[0])
+	if err != nil {
+		return "", fmt.Errorf("%s answers HEAD here but Trello could not validate it: %w", account.CallbackURL, err)
+	}
+	if err := account.Client.DeleteWebhook(ctx, webhookID); err != nil {
+		return "", fmt.Errorf("validated by Trello, but deleting the test webhook %s failed: %w", webhookID, err)
+	}
+	return fmt.Sprintf("%s validated by Trello", account.CallbackURL), nil
+}
+
+func (d *doctor) checkGoogle(ctx context.Context, cfg *config.Config, db *gorm.DB) {
+	var calClient *integrations.CalendarClient
+	credentialsOK := d.check("google credentials", func() (string, error) {
+		var err error
+		calClient, err = integrations.NewCalendarClient(&cfg.Google)
+		return "", err
+	})
+
+	var calendarIDs []string
+	d.check("google calendar", func() (string, error) {
+		if !credentialsOK {
+			return "", errSkipped
+		}
+		// Named calendars are created on first start, so only look them up
+		configured := cfg.Google.Calendar.CalendarID
+		if !integrations.IsCalendarID(configured) {
+			name := configured
+			if name == "" {
+				name = integrations.DefaultCalendarName
+			}
+			id, err := database.GetSetting(db, calendarSettingKey(name))
+			if err != nil {
+				return "", err
+			}
+			if id == "" {
+				return "", fmt.Errorf("calendar %q has not been created yet; it is created when the service starts", name)
+			}
+			cfg.Google.Calendar.CalendarID = id
+		}
+
+		calendarIDs = calClient.ConfiguredCalendarIDs()
+		for _, id := range calendarIDs {
+			if !calClient.CalendarExists(ctx, id) {
+				return "", fmt.Errorf("calendar %s is not visible to the service account; share it with the service account's address", id)
+			}
+		}
+		return fmt.Sprintf("%d calendar(s) visible", len(calendarIDs)), nil
+	})
+
+	d.check("google calendar writable", func() (string, error) {
+		if len(calendarIDs) == 0 {
+			return "", errSkipped
+		}
+		for _, id := range calendarIDs {
+			if err := calClient.CheckWritable(ctx, id); err != nil {
+				return "", fmt.Errorf("calendar %s: %w", id, err)
+			}
+		}
+		return "", nil
+	})
+}
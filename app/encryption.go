@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/envelope"
+	"github.com/chxlky/trello-gcal-sync/internal/secrets"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// SetupEncryption turns on encryption of the credentials stored in db when
+// database.encryption names a key, encrypting any stored before it was.
+func SetupEncryption(ctx context.Context, db *gorm.DB, cfg *config.Config) error {
+	settings := cfg.Database.Encryption
+	if !settings.Enabled() {
+		if settings.PreviousKey != "" || settings.PreviousKMSKey != "" {
+			return errors.New("database.encryption has a previous key but no key or kms_key to rotate to")
+		}
+		return nil
+	}
+	if settings.Key != "" && settings.KMSKey != "" {
+		return errors.New("database.encryption.key and kms_key are mutually exclusive")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Secrets.Timeout)
+	defer cancel()
+	current, err := keyWrapper(ctx, cfg, settings.Key, settings.KMSKey)
+	if err != nil {
+		return err
+	}
+	var previous []envelope.Wrapper
+	for _, key := range []struct{ local, kms string }{{settings.PreviousKey, ""}, {"", settings.PreviousKMSKey}} {
+		if key.local == "" && key.kms == "" {
+			continue
+		}
+		wrapper, err := keyWrapper(ctx, cfg, key.local, key.kms)
+		if err != nil {
+			return fmt.Errorf("previous key: %w", err)
+		}
+		previous = append(previous, wrapper)
+	}
+
+	encrypted, err := database.EncryptCredentials(ctx, db, current, previous...)
+	if err != nil {
+		return fmt.Errorf("encrypting stored credentials: %w", err)
+	}
+	if encrypted > 0 {
+		zap.L().Info("Encrypted credentials that were stored in plaintext", zap.Int("count", encrypted))
+	}
+	return nil
+}
+
+// keyWrapper returns the wrapper for a key given in the config, or kept in
+// a KMS
+func keyWrapper(ctx context.Context, cfg *config.Config, key, kmsKey string) (envelope.Wrapper, error) {
+	if kmsKey != "" {
+		return secrets.NewKMS(ctx, cfg.Secrets, kmsKey)
+	}
+	wrapper, err := envelope.Local(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database.encryption key: %w", err)
+	}
+	return wrapper, nil
+}
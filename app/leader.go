@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// lead starts the work only one instance may do: watching calendars, sweeping
+// orphaned events, emailing digests, resuming backfills, answering the
+// Telegram bot, polling Jira, keeping Asana webhooks registered, and
+// registering webhooks for or polling each Trello account. It all stops when
+// ctx is done. Without leader election the App leads from Start until Stop.
+func (a *App) lead(ctx context.Context) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	if a.stopped {
+		return errors.New("app is stopping")
+	}
+
+	a.leadCtx = ctx
+	a.leading.Store(true)
+	context.AfterFunc(ctx, func() { a.leading.Store(false) })
+
+	a.startCalendarWatch()
+	if interval := a.cfg.Sync.OrphanSweepInterval; interval > 0 {
+		go a.handler.RunOrphanSweeps(ctx, interval)
+	}
+	go a.handler.RunDigests(ctx)
+	go a.handler.ResumeBackfill(ctx)
+	go a.handler.RunTelegramBot(ctx)
+	if a.handler.Jira != nil && a.cfg.Jira.Mode == "poll" {
+		go a.handler.RunJiraPoller(ctx, a.cfg.Jira.PollInterval)
+	}
+	if a.handler.Asana != nil {
+		go a.handler.RunAsanaWebhooks(ctx, a.cfg.Asana.CheckInterval)
+	}
+
+	for _, account := range a.accounts {
+		err := account.start(ctx, a.handler)
+		switch {
+		case err != nil && account.Tenant != "":
+			// One tenant's revoked token or deleted board mustn't stop the rest
+			zap.L().Error("Failed to start syncing tenant's Trello boards", zap.String("tenant", account.Tenant), zap.Error(err))
+		case err != nil:
+			return fmt.Errorf("failed to start syncing Trello account %q: %w", account.Name, err)
+		}
+	}
+	return nil
+}
+
+// requireLeader turns away work only the leader may do when this instance
+// isn't leading, naming the leader so the caller can go there instead.
+func (a *App) requireLeader() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.elector == nil || a.leading.Load() {
+			c.Next()
+			return
+		}
+		leader, _ := a.elector.Leader()
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "this instance is not the leader", "leader": leader})
+	}
+}
+
+// instanceID names this replica: leader_election.instance_id, or the hostname
+func instanceID(election config.LeaderElection) (string, error) {
+	if election.InstanceID != "" {
+		return election.InstanceID, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("set leader_election.instance_id; the hostname is unavailable: %w", err)
+	}
+	return hostname, nil
+}
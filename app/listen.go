@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+)
+
+const (
+	defaultPort       = "8080"
+	defaultSocketMode = 0o660
+)
+
+// ListenAddress is the address the service listens on: server.listen, or
+// server.port on every interface when no listen address is set.
+func ListenAddress(server config.Server) string {
+	if server.Listen != "" {
+		return server.Listen
+	}
+	port := server.Port
+	if port == "" {
+		port = defaultPort
+	}
+	return ":" + port
+}
+
+// listen opens the listener configured by server.listen, or by server.port
+// when no listen address is set.
+func listen(server config.Server) (net.Listener, error) {
+	address := ListenAddress(server)
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		return listener, nil
+	}
+	return listenUnix(path, server)
+}
+
+// listenUnix creates the socket at path with server.socket_mode and
+// server.socket_group, replacing a socket left behind by an unclean exit.
+func listenUnix(path string, server config.Server) (net.Listener, error) {
+	mode := fs.FileMode(defaultSocketMode)
+	if server.SocketMode != "" {
+		parsed, err := strconv.ParseUint(server.SocketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server.socket_mode %q: must be octal, like 0660", server.SocketMode)
+		}
+		mode = fs.FileMode(parsed)
+	}
+
+	gid := -1
+	if server.SocketGroup != "" {
+		group, err := user.LookupGroup(server.SocketGroup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server.socket_group: %w", err)
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return nil, fmt.Errorf("invalid server.socket_group: %w", err)
+		}
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// A live socket still accepts connections; only remove dead ones
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set group of %s: %w", path, err)
+		}
+	}
+	return listener, nil
+}
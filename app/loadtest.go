@@ -0,0 +1,470 @@
+package app
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/integrations/googletest"
+	"github.com/chxlky/trello-gcal-sync/integrations/trellotest"
+	"github.com/chxlky/trello-gcal-sync/internal/jobs"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/recording"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/option"
+)
+
+// LoadTestOptions configures a LoadTest run.
+type LoadTestOptions struct {
+	Dir     string  // Holds the recorded payloads, one JSON file each, or recordings saved by server.record_webhooks
+	Account string  // Trello account the payloads are delivered to; the first configured by default
+	Rate    float64 // Deliveries a second; unlimited if not positive
+	// Workers and QueueSize override sync.workers and sync.queue_size when
+	// positive
+	Workers   int
+	QueueSize int
+	// Latencies added to each stubbed call, standing in for the round trips
+	// to Google and Trello
+	GoogleLatency time.Duration
+	TrelloLatency time.Duration
+	// Timeout bounds the wait for queued syncs after the last delivery
+	Timeout time.Duration
+}
+
+// LoadTest replays the webhook payloads recorded in opts.Dir through the
+// webhook route, queue and workers at opts.Rate, with Google, Trello and
+// other sync targets stubbed out and a scratch database, then writes the
+// throughput and latency percentiles to out. Notifications, outbound
+// webhooks, Jira, Asana and leader election are switched off, so nothing
+// leaves the process.
+func LoadTest(ctx context.Context, cfg *config.Config, opts LoadTestOptions, out io.Writer) error {
+	payloads, err := readPayloads(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if len(payloads) == 0 {
+		return fmt.Errorf("no recorded payloads (*.json) in %s", opts.Dir)
+	}
+
+	scratch, err := os.MkdirTemp("", "trello-gcal-sync-loadtest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	test := loadTestConfig(cfg, opts, filepath.Join(scratch, "loadtest.db"))
+	db := database.Init(test.Database.Path)
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	accountName := opts.Account
+	if accountName == "" {
+		accountName = DefaultAccountName
+		if len(test.Trello.Accounts) > 0 {
+			accountName = test.Trello.Accounts[0].Name
+		}
+	}
+
+	google := &googletest.Transport{Latency: opts.GoogleLatency}
+	calClient, err := integrations.NewCalendarClient(&test.Google, option.WithHTTPClient(google.Client()))
+	if err != nil {
+		return err
+	}
+	tasksClient, err := integrations.NewTasksClient(&test.Google, option.WithHTTPClient(google.Client()))
+	if err != nil {
+		return err
+	}
+	trello := &slowTrello{TrelloAPI: seedTrello(payloads), latency: opts.TrelloLatency}
+
+	results := newLoadTestResults()
+	a, err := New(Options{
+		Config:      test,
+		DB:          db,
+		CalClient:   calClient,
+		TasksClient: tasksClient,
+		Trello:      map[string]integrations.TrelloAPI{accountName: trello},
+		ObserveJob:  results.observe,
+	})
+	if err != nil {
+		return err
+	}
+	defer a.Stop()
+
+	i := slices.IndexFunc(a.accounts, func(account *TrelloAccount) bool { return account.Name == accountName })
+	if i < 0 {
+		return fmt.Errorf("no Trello account named %q is configured", accountName)
+	}
+	callbackPath := basePath(test.Server) + a.accounts[i].CallbackPath
+	for name := range a.handler.Targets {
+		if name != rules.TargetCalendar && name != rules.TargetTasks {
+			a.handler.Targets[name] = &stubTarget{latency: opts.GoogleLatency}
+		}
+	}
+
+	var boardIDs []string
+	for _, payload := range payloads {
+		if id := payload.Action.Data.Board.ID; id != "" && !slices.Contains(boardIDs, id) {
+			boardIDs = append(boardIDs, id)
+		}
+	}
+	if err := a.handler.TrackBoards(accountName, boardIDs); err != nil {
+		return err
+	}
+
+	limit := rate.NewLimiter(rate.Inf, 0)
+	if opts.Rate > 0 {
+		limit = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+	started := time.Now()
+	for n, payload := range payloads {
+		if err := limit.Wait(ctx); err != nil {
+			return err
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("loadtest-%06d", n)
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, callbackPath, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestid.Header, id)
+		rec := httptest.NewRecorder()
+
+		results.deliver(id)
+		sent := time.Now()
+		a.router.ServeHTTP(rec, req)
+		results.responded(id, time.Since(sent), rec.Code == http.StatusOK)
+	}
+	sendTime := time.Since(started)
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results.wait(waitCtx)
+	total := time.Since(started)
+
+	report := results.report()
+	fmt.Fprintf(out, "Replayed %d deliveries from %s in %s (%.1f/s)\n", len(payloads), opts.Dir, sendTime.Round(time.Millisecond), perSecond(len(payloads), sendTime))
+	fmt.Fprintf(out, "Accepted %d, rejected %d; synced %d, failed %d, unfinished %d\n", report.accepted, report.rejected, report.synced, report.failed, report.unfinished)
+	fmt.Fprintf(out, "Throughput: %.1f syncs/s over %s\n", perSecond(report.synced+report.failed, total), total.Round(time.Millisecond))
+	fmt.Fprintf(out, "Sync latency, delivery to done: %s\n", percentiles(report.syncLatencies))
+	fmt.Fprintf(out, "Webhook response time: %s\n", percentiles(report.responseTimes))
+	fmt.Fprintf(out, "Stubbed calls: %d to Google, %d to Trello\n", google.Requests(), trello.calls())
+	if report.unfinished > 0 {
+		return fmt.Errorf("%d syncs hadn't finished after %s", report.unfinished, timeout)
+	}
+	return nil
+}
+
+// loadTestConfig copies cfg, pointing it at a scratch database and switching
+// off everything that would reach outside the process
+func loadTestConfig(cfg *config.Config, opts LoadTestOptions, dbPath string) *config.Config {
+	test := *cfg
+	test.Database = config.Database{Path: dbPath}
+	test.Server.TLS = config.TLS{}
+	test.LeaderElection = config.LeaderElection{}
+	test.Sync.Queue = config.Queue{}
+	test.Trello.SourceIPs.Enabled = false
+	test.Jira = config.Jira{}
+	test.Asana = config.Asana{}
+	test.Slack = config.Slack{}
+	test.Telegram = config.Telegram{}
+	test.Digest = config.Digest{}
+	test.Webhooks = nil
+	if opts.Workers > 0 {
+		test.Sync.Workers = opts.Workers
+	}
+	if opts.QueueSize > 0 {
+		test.Sync.QueueSize = opts.QueueSize
+	}
+	return &test
+}
+
+// readPayloads reads the webhook payloads in dir, in file name order. Files
+// may hold a bare payload or a delivery recorded by server.record_webhooks.
+func readPayloads(dir string) ([]models.TrelloWebhookPayload, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	payloads := make([]models.TrelloWebhookPayload, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var rec recording.Recording
+		if err := json.Unmarshal(data, &rec); err == nil && rec.Path != "" {
+			data = rec.Payload()
+		}
+		var payload models.TrelloWebhookPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("reading payload %s: %w", path, err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// seedTrello returns a fake Trello holding the boards and cards the payloads
+// name, each card as the last payload to name it left it
+func seedTrello(payloads []models.TrelloWebhookPayload) *trellotest.Fake {
+	fake := trellotest.NewFake("")
+	for _, payload := range payloads {
+		data := payload.Action.Data
+		if data.Board.ID != "" {
+			fake.Boards[data.Board.ID] = models.TrelloBoard{ID: data.Board.ID, Name: data.Board.Name, Closed: data.Board.Closed}
+		}
+		if data.Card.ID == "" {
+			continue
+		}
+		listID := cmp.Or(data.Card.IDList, data.ListAfter.ID, data.List.ID)
+		fake.Cards[data.Card.ID] = models.TrelloCard{
+			ID:        data.Card.ID,
+			Name:      data.Card.Name,
+			Desc:      data.Card.Desc,
+			Due:       data.Card.Due,
+			Start:     data.Card.Start,
+			Closed:    data.Card.Closed,
+			ShortLink: data.Card.ShortLink,
+			IDBoard:   data.Board.ID,
+			IDList:    listID,
+			IDMembers: data.Card.IDMembers,
+			Labels:    data.Card.Labels,
+		}
+	}
+	return fake
+}
+
+// slowTrello adds latency to the Trello calls a sync makes, and counts them
+type slowTrello struct {
+	integrations.TrelloAPI
+	latency time.Duration
+
+	mu    sync.Mutex
+	count int
+}
+
+func (s *slowTrello) GetCard(ctx context.Context, cardID string) (*models.TrelloCard, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.TrelloAPI.GetCard(ctx, cardID)
+}
+
+func (s *slowTrello) GetBoard(ctx context.Context, boardID string) (*models.TrelloBoard, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.TrelloAPI.GetBoard(ctx, boardID)
+}
+
+func (s *slowTrello) ListCards(ctx context.Context, boardID, filter string) ([]models.TrelloCard, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.TrelloAPI.ListCards(ctx, boardID, filter)
+}
+
+func (s *slowTrello) wait(ctx context.Context) error {
+	s.mu.Lock()
+	s.count++
+	s.mu.Unlock()
+	return sleep(ctx, s.latency)
+}
+
+func (s *slowTrello) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// stubTarget stands in for pluggable sync targets
+type stubTarget struct {
+	latency time.Duration
+
+	mu     sync.Mutex
+	nextID int
+}
+
+func (t *stubTarget) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	if err := sleep(ctx, t.latency); err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	return fmt.Sprintf("stub%d", t.nextID), nil
+}
+
+func (t *stubTarget) UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error) {
+	return eventID, sleep(ctx, t.latency)
+}
+
+func (t *stubTarget) DeleteEvent(ctx context.Context, card models.Card, eventID string) error {
+	return sleep(ctx, t.latency)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadTestResults times each delivery from being sent to its sync finishing
+type loadTestResults struct {
+	mu        sync.Mutex
+	sent      map[string]time.Time // Accepted deliveries still syncing, by request ID
+	latencies []time.Duration
+	responses []time.Duration
+	rejected  int
+	failed    int
+	synced    int
+	finished  chan struct{} // Signalled as each sync finishes
+}
+
+type loadTestReport struct {
+	accepted, rejected, synced, failed, unfinished int
+	syncLatencies, responseTimes                   []time.Duration
+}
+
+func newLoadTestResults() *loadTestResults {
+	return &loadTestResults{sent: make(map[string]time.Time), finished: make(chan struct{}, 1)}
+}
+
+// deliver notes that the delivery with request ID id is being sent. It is
+// noted before the response, since a fast worker may finish the sync first.
+func (r *loadTestResults) deliver(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[id] = time.Now()
+}
+
+func (r *loadTestResults) responded(id string, took time.Duration, accepted bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, took)
+	if !accepted {
+		r.rejected++
+		delete(r.sent, id)
+	}
+}
+
+func (r *loadTestResults) observe(job jobs.Job, err error) {
+	// Postponed and buffered syncs run again later
+	var retryErr *jobs.RetryError
+	var outageErr *jobs.OutageError
+	if errors.As(err, &retryErr) || errors.As(err, &outageErr) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent, ok := r.sent[job.RequestID]
+	if !ok {
+		return
+	}
+	delete(r.sent, job.RequestID)
+	r.latencies = append(r.latencies, time.Since(sent))
+	if err != nil {
+		r.failed++
+	} else {
+		r.synced++
+	}
+	select {
+	case r.finished <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns once every accepted delivery has synced or ctx is done
+func (r *loadTestResults) wait(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		remaining := len(r.sent)
+		r.mu.Unlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-r.finished:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *loadTestResults) report() loadTestReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return loadTestReport{
+		accepted:      len(r.responses) - r.rejected,
+		rejected:      r.rejected,
+		synced:        r.synced,
+		failed:        r.failed,
+		unfinished:    len(r.sent),
+		syncLatencies: slices.Clone(r.latencies),
+		responseTimes: slices.Clone(r.responses),
+	}
+}
+
+// percentiles describes the spread of durations
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "none recorded"
+	}
+	slices.Sort(durations)
+	at := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(durations)))) - 1
+		return durations[max(i, 0)].Round(time.Microsecond)
+	}
+	parts := []string{
+		"p50 " + at(0.50).String(),
+		"p90 " + at(0.90).String(),
+		"p99 " + at(0.99).String(),
+		"max " + durations[len(durations)-1].Round(time.Microsecond).String(),
+	}
+	return strings.Join(parts, "  ")
+}
+
+func perSecond(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
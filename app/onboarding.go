@@ -0,0 +1,488 @@
+package app
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/apikeys"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+)
+
+// onboardingPath is where tenants' onboarding pages are served
+const onboardingPath = "/onboarding"
+
+// googleStateCookie carries the state and PKCE verifier of a tenant's Google
+// authorisation from the redirect to Google to its callback, for up to
+// googleAuthTimeout
+const (
+	googleStateCookie = "tgs_onboarding_google"
+	googleAuthTimeout = 10 * time.Minute
+)
+
+//go:embed onboarding.html
+var onboardingHTML string
+
+var onboardingTemplates = template.Must(template.New("onboarding").Parse(onboardingHTML))
+
+// errSyncing turns away changes onboarding can't make to a syncing tenant,
+// whose clients are built as it starts
+var errSyncing = errors.New("your accounts and calendar can't be changed while your boards are syncing; the operator can change them for you")
+
+// InviteTenant issues a key for the tenant's onboarding pages, adding the
+// tenant if there's none by that name, and returns the invite link carrying
+// the key along with its record. Without server.public_url the link is only
+// a path.
+func InviteTenant(db *gorm.DB, cfg *config.Config, name string) (string, *models.APIKey, error) {
+	if err := CheckTenantName(name); err != nil {
+		return "", nil, err
+	}
+	tenant, err := database.GetTenant(db, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if tenant == nil {
+		if err := database.SaveTenant(db, &models.Tenant{Name: name}); err != nil {
+			return "", nil, fmt.Errorf("storing tenant: %w", err)
+		}
+	}
+
+	key, record, err := apikeys.New("onboarding", []string{apikeys.Onboard})
+	if err != nil {
+		return "", nil, err
+	}
+	record.Tenant = name
+	if err := database.CreateAPIKey(db, &record); err != nil {
+		return "", nil, fmt.Errorf("storing API key: %w", err)
+	}
+	link := publicURL(cfg.Server, onboardingPath)
+	if link == "" {
+		link = basePath(cfg.Server) + onboardingPath
+	}
+	return link + "?key=" + key, &record, nil
+}
+
+// onboardingRoutes serves the pages a tenant's invite link opens, where the
+// tenant connects Trello and Google, chooses boards and a calendar, and
+// starts syncing.
+func (a *App) onboardingRoutes(root *gin.RouterGroup) {
+	onboarding := root.Group(onboardingPath, a.handler.RequireOnboardingKey(basePath(a.cfg.Server)+onboardingPath))
+	onboarding.GET("", a.onboardingHandler)
+	onboarding.GET("/trello", a.connectTrelloHandler)
+	onboarding.GET("/trello/callback", a.trelloCallbackHandler)
+	onboarding.POST("/trello", a.saveTrelloTokenHandler)
+	onboarding.GET("/google", a.connectGoogleHandler)
+	onboarding.GET("/google/callback", a.googleCallbackHandler)
+	onboarding.POST("/boards", a.chooseBoardsHandler)
+	onboarding.POST("/calendar", a.chooseCalendarHandler)
+	onboarding.POST("/start", a.startSyncingHandler)
+}
+
+// inviteTenantHandler issues an invite link for the tenant named in the
+// path, adding the tenant if need be.
+func (a *App) inviteTenantHandler(c *gin.Context) {
+	name := c.Param("tenant")
+	if err := CheckTenantName(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, key, err := InviteTenant(a.db, a.handler.Config(), name)
+	if err != nil {
+		zap.L().Error("Failed to invite tenant", zap.String("tenant", name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to invite tenant"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"tenant": name, "keyID": key.ID, "inviteURL": link})
+}
+
+// onboardingPage is what the onboarding page shows
+type onboardingPage struct {
+	Base    string
+	Tenant  *models.Tenant
+	Error   string
+	Syncing bool
+	Ready   bool // Everything is set up for syncing to start
+
+	Boards         []onboardingChoice
+	BoardsError    string
+	MaxBoards      int // 0 for no limit
+	Calendars      []onboardingChoice
+	CalendarsError string
+	CalendarName   string
+}
+
+type onboardingChoice struct {
+	ID     string
+	Name   string
+	Chosen bool
+}
+
+// onboardingBase is the path of the onboarding page
+func (a *App) onboardingBase() string {
+	return basePath(a.handler.Config().Server) + onboardingPath
+}
+
+// onboardingURL returns the address of an onboarding route for Trello and
+// Google to send the browser back to: under server.public_url, or without
+// one, the address the request was made to.
+func (a *App) onboardingURL(c *gin.Context, route string) string {
+	server := a.handler.Config().Server
+	if u := publicURL(server, onboardingPath+route); u != "" {
+		return u
+	}
+	scheme := "http"
+	if api.IsHTTPS(c) {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + basePath(server) + onboardingPath + route
+}
+
+// tenantSyncing reports whether the tenant's boards are being synced
+func (a *App) tenantSyncing(name string) bool {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	_, ok := a.tenants[name]
+	return ok
+}
+
+// onboardingTenant loads the tenant the request's key was issued for,
+// answering the request itself if it can't
+func (a *App) onboardingTenant(c *gin.Context) (*models.Tenant, bool) {
+	tenant, err := database.GetTenant(a.db, api.RequestTenant(c))
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to load tenant for onboarding", zap.Error(err))
+		c.String(http.StatusInternalServerError, "Something went wrong; try again shortly.")
+		return nil, false
+	}
+	if tenant == nil {
+		c.String(http.StatusNotFound, "This invite's tenant has been removed.")
+		return nil, false
+	}
+	return tenant, true
+}
+
+// trelloClient acts as the tenant's Trello account, or is nil if the tenant
+// hasn't connected one
+func (a *App) trelloClient(tenant string) (*integrations.TrelloClient, error) {
+	token, err := database.GetCredential(a.db, CredentialName(TenantAccountName(tenant)))
+	if err != nil || token == "" {
+		return nil, err
+	}
+	logging.AddSecrets(token)
+	cfg := a.handler.Config()
+	client := integrations.NewTrelloClient(cfg.Trello.APIKey, token, "")
+	client.Timeout = cfg.Trello.RequestTimeout
+	return client, nil
+}
+
+// renderOnboarding shows the tenant where onboarding has got to, with
+// problem, if any, as what went wrong with the last step.
+func (a *App) renderOnboarding(c *gin.Context, status int, tenant *models.Tenant, problem error) {
+	ctx := c.Request.Context()
+	log := logging.FromContext(ctx).With(zap.String("tenant", tenant.Name))
+	page := onboardingPage{Base: a.onboardingBase(), Tenant: tenant, Syncing: a.tenantSyncing(tenant.Name)}
+	page.MaxBoards = a.handler.Config().Tenants.LimitsFor(tenant.Name).MaxBoards
+	if problem != nil {
+		page.Error = problem.Error()
+	}
+	if remaining, err := TenantProblem(a.db, *tenant); err == nil {
+		page.Ready = remaining == ""
+	}
+
+	if client, err := a.trelloClient(tenant.Name); err != nil || client != nil {
+		var boards []models.TrelloBoard
+		if err == nil {
+			boards, err = client.ListMemberBoards(ctx)
+		}
+		if err != nil {
+			log.Warn("Failed to list tenant's Trello boards", zap.Error(err))
+			page.BoardsError = "Your Trello boards can't be listed right now; try reloading the page, or connect Trello again."
+		}
+		for _, board := range boards {
+			page.Boards = append(page.Boards, onboardingChoice{ID: board.ID, Name: board.Name, Chosen: slices.Contains(tenant.BoardIDs, board.ID)})
+		}
+	}
+
+	if tenant.GoogleAccount != "" {
+		page.CalendarName = tenant.CalendarID
+		var calendars []*calendar.CalendarListEntry
+		client, err := TenantCalendarClient(a.db, a.handler.Config(), tenant.Name)
+		if err == nil {
+			calendars, err = client.ListWritableCalendars(ctx)
+		}
+		if err != nil {
+			log.Warn("Failed to list tenant's Google calendars", zap.Error(err))
+			page.CalendarsError = "Your Google calendars can't be listed right now; try reloading the page, or connect Google again."
+		}
+		for _, entry := range calendars {
+			page.Calendars = append(page.Calendars, onboardingChoice{ID: entry.Id, Name: entry.Summary, Chosen: entry.Id == tenant.CalendarID})
+			if entry.Id == tenant.CalendarID {
+				page.CalendarName = entry.Summary
+			}
+		}
+	}
+
+	c.Render(status, render.HTML{Template: onboardingTemplates, Name: "page", Data: page})
+}
+
+// onboardingDone sends the browser back to the onboarding page once a step
+// has succeeded, so reloading it doesn't repeat the step
+func (a *App) onboardingDone(c *gin.Context) {
+	c.Redirect(http.StatusSeeOther, a.onboardingBase())
+}
+
+func (a *App) onboardingHandler(c *gin.Context) {
+	if tenant, ok := a.onboardingTenant(c); ok {
+		a.renderOnboarding(c, http.StatusOK, tenant, nil)
+	}
+}
+
+// connectTrelloHandler sends the tenant to approve access on Trello, which
+// sends the browser back to the Trello callback page with the token. The
+// service's address must be among the allowed origins of trello.api_key.
+func (a *App) connectTrelloHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	apiKey := a.handler.Config().Trello.APIKey
+	switch {
+	case a.tenantSyncing(tenant.Name):
+		a.renderOnboarding(c, http.StatusConflict, tenant, errSyncing)
+	case apiKey == "":
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, errors.New("Trello can't be connected yet: the service has no trello.api_key"))
+	default:
+		c.Redirect(http.StatusSeeOther, integrations.AuthorizeURL(apiKey, a.onboardingURL(c, "/trello/callback")))
+	}
+}
+
+// trelloCallbackHandler serves the page Trello sends the browser back to,
+// which posts the token from its fragment to saveTrelloTokenHandler.
+func (a *App) trelloCallbackHandler(c *gin.Context) {
+	c.Render(http.StatusOK, render.HTML{Template: onboardingTemplates, Name: "trello-callback", Data: onboardingPage{Base: a.onboardingBase()}})
+}
+
+// saveTrelloTokenHandler stores the tenant's Trello token once Trello accepts
+// it.
+func (a *App) saveTrelloTokenHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	if a.tenantSyncing(tenant.Name) {
+		a.renderOnboarding(c, http.StatusConflict, tenant, errSyncing)
+		return
+	}
+	token := strings.TrimSpace(c.PostForm("token"))
+	if token == "" {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, errors.New("Trello didn't grant access; connect Trello again"))
+		return
+	}
+	logging.AddSecrets(token)
+
+	cfg := a.handler.Config()
+	client := integrations.NewTrelloClient(cfg.Trello.APIKey, token, "")
+	client.Timeout = cfg.Trello.RequestTimeout
+	member, err := client.GetMe(c.Request.Context())
+	if err != nil {
+		a.renderOnboarding(c, http.StatusBadGateway, tenant, fmt.Errorf("Trello rejected the token: %w", err))
+		return
+	}
+	if err := database.PutCredential(a.db, CredentialName(TenantAccountName(tenant.Name)), token); err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("storing token: %w", err))
+		return
+	}
+	tenant.TrelloMember = member.Username
+	if err := database.SaveTenant(a.db, tenant); err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("storing tenant: %w", err))
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("Tenant connected Trello", zap.String("tenant", tenant.Name), zap.String("member", member.Username))
+	a.onboardingDone(c)
+}
+
+// googleOAuth is the tenant's Google OAuth client, sending the browser back
+// to the Google callback page. That page's address must be an authorised
+// redirect URI of the google.oauth client.
+func (a *App) googleOAuth(c *gin.Context) (*oauth2.Config, error) {
+	return integrations.NewGoogleOAuth(a.handler.Config().Google.OAuth, a.onboardingURL(c, "/google/callback"))
+}
+
+// connectGoogleHandler sends the tenant to grant access to their calendars
+// on Google.
+func (a *App) connectGoogleHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	if a.tenantSyncing(tenant.Name) {
+		a.renderOnboarding(c, http.StatusConflict, tenant, errSyncing)
+		return
+	}
+	oauth, err := a.googleOAuth(c)
+	if err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("Google can't be connected yet: %w", err))
+		return
+	}
+
+	state, verifier := rand.Text(), oauth2.GenerateVerifier()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(googleStateCookie, state+"."+verifier, int(googleAuthTimeout.Seconds()), a.onboardingBase(), "", api.IsHTTPS(c), true)
+	c.Redirect(http.StatusSeeOther, oauth.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier)))
+}
+
+// googleCallbackHandler stores the refresh token Google grants the tenant.
+func (a *App) googleCallbackHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	saved, _ := c.Cookie(googleStateCookie)
+	c.SetCookie(googleStateCookie, "", -1, a.onboardingBase(), "", api.IsHTTPS(c), true)
+	state, verifier, _ := strings.Cut(saved, ".")
+	switch {
+	case a.tenantSyncing(tenant.Name):
+		a.renderOnboarding(c, http.StatusConflict, tenant, errSyncing)
+		return
+	case state == "" || c.Query("state") != state:
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, errors.New("Google's answer doesn't match this browser's request; connect Google again"))
+		return
+	case c.Query("error") != "":
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, fmt.Errorf("Google access was not granted: %s", c.Query("error")))
+		return
+	}
+
+	oauth, err := a.googleOAuth(c)
+	if err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("Google can't be connected yet: %w", err))
+		return
+	}
+	ctx := c.Request.Context()
+	token, err := oauth.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		a.renderOnboarding(c, http.StatusBadGateway, tenant, fmt.Errorf("exchanging Google's authorization code: %w", err))
+		return
+	}
+	if token.RefreshToken == "" {
+		a.renderOnboarding(c, http.StatusBadGateway, tenant, errors.New("Google granted no refresh token; remove the service's access at https://myaccount.google.com/permissions and connect Google again"))
+		return
+	}
+	logging.AddSecrets(token.AccessToken, token.RefreshToken)
+	if err := ConnectTenantGoogle(ctx, a.db, a.handler.Config(), tenant, oauth, token.RefreshToken); err != nil {
+		a.renderOnboarding(c, http.StatusBadGateway, tenant, err)
+		return
+	}
+	logging.FromContext(ctx).Info("Tenant connected Google", zap.String("tenant", tenant.Name), zap.String("googleAccount", tenant.GoogleAccount))
+	a.onboardingDone(c)
+}
+
+// chooseBoardsHandler stores the boards the tenant chose, which must be open
+// boards of its Trello account that nobody else syncs. A syncing tenant's
+// boards are switched over at once.
+func (a *App) chooseBoardsHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	refs := c.PostFormArray("board")
+	if len(refs) == 0 {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, errors.New("choose at least one board"))
+		return
+	}
+	client, err := a.trelloClient(tenant.Name)
+	if err == nil && client == nil {
+		err = errors.New("connect Trello first")
+	}
+	if err != nil {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, err)
+		return
+	}
+	boards, err := webhooks.ValidateBoards(c.Request.Context(), client, refs)
+	if err != nil {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, err)
+		return
+	}
+	boardIDs := boardIDs(boards)
+	if err := CheckTenantBoards(a.db, a.handler.Config(), tenant.Name, boardIDs); err != nil {
+		a.renderOnboarding(c, http.StatusConflict, tenant, err)
+		return
+	}
+	tenant.BoardIDs = boardIDs
+	if err := database.SaveTenant(a.db, tenant); err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("storing tenant: %w", err))
+		return
+	}
+	if a.tenantSyncing(tenant.Name) {
+		if err := a.SyncTenant(tenant.Name); err != nil {
+			a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("syncing the boards you chose: %w", err))
+			return
+		}
+	}
+	a.onboardingDone(c)
+}
+
+// chooseCalendarHandler stores the calendar the tenant chose, which its
+// Google account must be able to reach.
+func (a *App) chooseCalendarHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	if a.tenantSyncing(tenant.Name) {
+		a.renderOnboarding(c, http.StatusConflict, tenant, errSyncing)
+		return
+	}
+	calendarID := c.PostForm("calendar")
+	client, err := TenantCalendarClient(a.db, a.handler.Config(), tenant.Name)
+	if err != nil {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, err)
+		return
+	}
+	if calendarID == "" || !client.CalendarExists(c.Request.Context(), calendarID) {
+		a.renderOnboarding(c, http.StatusBadRequest, tenant, fmt.Errorf("%s can't reach that calendar", tenant.GoogleAccount))
+		return
+	}
+	tenant.CalendarID = calendarID
+	if err := database.SaveTenant(a.db, tenant); err != nil {
+		a.renderOnboarding(c, http.StatusInternalServerError, tenant, fmt.Errorf("storing tenant: %w", err))
+		return
+	}
+	a.onboardingDone(c)
+}
+
+// startSyncingHandler starts syncing the tenant's boards. Only the leader
+// can, since it registers the webhooks.
+func (a *App) startSyncingHandler(c *gin.Context) {
+	tenant, ok := a.onboardingTenant(c)
+	if !ok {
+		return
+	}
+	if a.elector != nil && !a.leading.Load() {
+		a.renderOnboarding(c, http.StatusServiceUnavailable, tenant, errors.New("this instance of the service can't start syncing right now; try again shortly"))
+		return
+	}
+	if err := a.SyncTenant(tenant.Name); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("Failed to start syncing tenant", zap.String("tenant", tenant.Name), zap.Error(err))
+		a.renderOnboarding(c, http.StatusConflict, tenant, fmt.Errorf("syncing couldn't start: %w", err))
+		return
+	}
+	a.onboardingDone(c)
+}
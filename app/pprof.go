@@ -0,0 +1,24 @@
+package app
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pprofRoutes serves the runtime profiles of net/http/pprof under group, for
+// diagnosing memory and goroutine growth in a running instance. Named
+// profiles such as heap and goroutine are fetched by name, as the index
+// links to them.
+func pprofRoutes(group *gin.RouterGroup) {
+	debug := group.Group("/debug/pprof")
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	debug.GET("/:name", func(c *gin.Context) {
+		pprof.Handler(c.Param("name")).ServeHTTP(c.Writer, c.Request)
+	})
+}
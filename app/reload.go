@@ -0,0 +1,243 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// Reload applies a changed configuration to the running App without
+// interrupting queued or in-flight syncs. Sync rules and Google settings take
+// effect for the next sync, log.level applies at once, calendar watches move
+// to the configured calendars, and boards added to or removed from an
+// account's board_ids, or a tenant's boards, gain or lose their webhook.
+// Tenants set up since startup start syncing. Settings that only take effect
+// on restart, such as the port or Trello credentials, are logged and
+// otherwise left as they were at startup.
+func (a *App) Reload(cfg *config.Config) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	if a.stopped || a.bgCtx == nil {
+		return errors.New("app is not running")
+	}
+	ctx := a.bgCtx
+
+	// Prepare and validate everything before any of it is applied, so a
+	// broken edit leaves the running config in place
+	if err := prepareConfig(ctx, a.db, a.handler.CalClient, cfg); err != nil {
+		return err
+	}
+	tenants, added, err := a.reloadTenants(cfg)
+	if err != nil {
+		return err
+	}
+	syncRules, err := buildRules(cfg, tenants)
+	if err != nil {
+		return fmt.Errorf("invalid sync rules: %w", err)
+	}
+	warnRuleRefs(cfg)
+	for _, name := range syncRules.Targets() {
+		if _, ok := a.handler.Targets[name]; !ok {
+			return fmt.Errorf("sync target %q was not in use at startup; restart to add it", name)
+		}
+	}
+	accounts, err := LoadTrelloAccounts(a.db, cfg)
+	if err != nil {
+		return fmt.Errorf("invalid Trello configuration: %w", err)
+	}
+	accounts = activeAccounts(accounts, tenants)
+	for _, account := range accounts {
+		if account.Tenant != "" {
+			account.BoardIDs = tenants[account.Tenant].BoardIDs
+		}
+	}
+
+	if err := integrations.ConfigureTransports(cfg.Google.HTTP, cfg.Trello.HTTP); err != nil {
+		return err
+	}
+
+	for _, key := range restartRequired(a.cfg, cfg, a.accounts, accounts) {
+		zap.L().Warn("Changed setting takes effect on restart", zap.String("setting", key))
+	}
+
+	previous := a.cfg
+	integrations.ConfigureBreakers(cfg.Google.CircuitBreaker, cfg.Trello.CircuitBreaker)
+	for name := range tenants {
+		integrations.TenantCallLimit(name, cfg.Tenants.LimitsFor(name).GoogleCallsPerHour)
+	}
+	a.handler.CalClient.Configure(&cfg.Google)
+	a.handler.TasksClient.Configure(&cfg.Google)
+	a.handler.Notifier.Configure(cfg)
+	a.handler.Outbound.Configure(cfg)
+	a.handler.SetRules(syncRules)
+	a.handler.SetConfig(cfg)
+	a.handler.SetTenancy(tenancy(accounts, tenants))
+	a.cfg = cfg
+	a.tenants = tenants
+	if err := database.AssignCardTenants(a.db, a.handler.Tenancy().Boards); err != nil {
+		zap.L().Error("Failed to assign stored cards to tenants; they are assigned as they sync", zap.Error(err))
+	}
+	if previous.Log.Level != cfg.Log.Level {
+		logging.SetConfiguredLevel(cfg.Log.Level)
+	}
+
+	// Replicas that aren't leading take the new boards and calendars when
+	// they are elected
+	leading := a.leading.Load()
+	if leading && (!reflect.DeepEqual(previous.Google.Calendar.Watch, cfg.Google.Calendar.Watch) ||
+		!sameCalendars(previous.Google, cfg.Google)) {
+		zap.L().Info("Calendars to watch changed; reopening watch channels")
+		a.stopWatch()
+		a.handler.StopCalendarWatch(ctx)
+		a.startCalendarWatch()
+	}
+
+	var errs []error
+	for _, account := range a.accounts {
+		i := slices.IndexFunc(accounts, func(b *TrelloAccount) bool { return b.Name == account.Name })
+		if i < 0 {
+			continue
+		}
+		account.trello = &cfg.Trello
+		if slices.Equal(account.BoardIDs, accounts[i].BoardIDs) {
+			continue
+		}
+		if !leading {
+			account.BoardIDs = accounts[i].BoardIDs
+			continue
+		}
+		if err := account.updateBoards(a.leadCtx, a.handler, accounts[i].BoardIDs); err != nil {
+			errs = append(errs, fmt.Errorf("trello account %q: %w", account.Name, err))
+		}
+	}
+	for _, tenant := range added {
+		if err := a.startTenant(tenant); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", tenant.Name, err))
+		}
+	}
+
+	zap.L().Info("Configuration reloaded")
+	return errors.Join(errs...)
+}
+
+// reloadTenants returns the tenants to keep syncing: each one already
+// syncing, with its boards as they are now stored, and those set up since,
+// for startTenant to start. Tenants removed or moved to another calendar, or
+// no longer fully set up, are logged and otherwise left as they were until a
+// restart, since their calendar clients are already built, as are those now
+// given boards someone else syncs or more than their limit. Callers hold
+// reloadMu.
+func (a *App) reloadTenants(cfg *config.Config) (map[string]models.Tenant, []models.Tenant, error) {
+	stored, err := database.ListTenants(a.db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading tenants: %w", err)
+	}
+	tenants := maps.Clone(a.tenants)
+	var added []models.Tenant
+	for _, tenant := range stored {
+		problem, err := TenantProblem(a.db, tenant)
+		if err != nil {
+			return nil, nil, fmt.Errorf("checking tenant %q: %w", tenant.Name, err)
+		}
+		running, ok := a.tenants[tenant.Name]
+		if err := checkBoardLimit(cfg, tenant.Name, tenant.BoardIDs); problem == "" && err != nil {
+			if ok {
+				zap.L().Error("Tenant has more boards than its limit; keeping the boards it already syncs", zap.String("tenant", tenant.Name), zap.Error(err))
+			} else {
+				zap.L().Error("Tenant has more boards than its limit; not syncing its boards", zap.String("tenant", tenant.Name), zap.Error(err))
+			}
+			continue
+		}
+		switch {
+		case ok && problem == "" && tenant.CalendarID == running.CalendarID:
+			others := maps.Clone(tenants)
+			delete(others, tenant.Name)
+			if boardID := takenBoard(tenant.Name, tenant.BoardIDs, boardOwners(others), operatorBoards(cfg)); boardID != "" {
+				zap.L().Error("Tenant's board is already synced by another tenant or the operator; keeping its previous boards", zap.String("tenant", tenant.Name), zap.String("boardID", boardID))
+				continue
+			}
+			tenants[tenant.Name] = tenant
+		case ok:
+			zap.L().Warn("Changed tenant takes effect on restart", zap.String("tenant", tenant.Name))
+		case problem == "":
+			added = append(added, tenant)
+		}
+	}
+	for name := range a.tenants {
+		if !slices.ContainsFunc(stored, func(tenant models.Tenant) bool { return tenant.Name == name }) {
+			zap.L().Warn("Removed tenant is synced until restart", zap.String("tenant", name))
+		}
+	}
+	return tenants, added, nil
+}
+
+// sameCalendars reports whether two configs route to the same calendars
+func sameCalendars(a, b config.Google) bool {
+	return a.Calendar.CalendarID == b.Calendar.CalendarID && reflect.DeepEqual(a.Calendars, b.Calendars)
+}
+
+// restartRequired lists the changed settings Reload can't apply.
+func restartRequired(old, cfg *config.Config, oldAccounts, accounts []*TrelloAccount) []string {
+	var keys []string
+	changed := func(key string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			keys = append(keys, key)
+		}
+	}
+
+	oldServer, server := old.Server, cfg.Server
+	oldServer.AdminToken, server.AdminToken = "", ""
+	changed("server", oldServer, server)
+	changed("database", old.Database, cfg.Database)
+	oldLog, log := old.Log, cfg.Log
+	oldLog.Level, log.Level = "", ""
+	changed("log", oldLog, log)
+	changed("tracing", old.Tracing, cfg.Tracing)
+	changed("leader_election", old.LeaderElection, cfg.LeaderElection)
+	changed("sync.workers", old.Sync.Workers, cfg.Sync.Workers)
+	changed("sync.queue_size", old.Sync.QueueSize, cfg.Sync.QueueSize)
+	changed("sync.queue", old.Sync.Queue, cfg.Sync.Queue)
+	changed("sync.dry_run", old.Sync.DryRun, cfg.Sync.DryRun)
+	changed("targets", old.Targets, cfg.Targets)
+	// Jira projects and the webhook secret are read per issue
+	oldJira, jira := old.Jira, cfg.Jira
+	oldJira.Projects, jira.Projects = nil, nil
+	oldJira.WebhookSecret, jira.WebhookSecret = "", ""
+	changed("jira", oldJira, jira)
+	// Asana projects are rechecked every check_interval; only turning Asana
+	// on or off needs a restart
+	oldAsana, asana := old.Asana, cfg.Asana
+	oldAsana.Projects, asana.Projects = nil, nil
+	changed("asana", oldAsana, asana)
+	changed("asana.projects", len(old.Asana.Projects) > 0, len(cfg.Asana.Projects) > 0)
+	changed("google.service_account", old.Google.ServiceAccount, cfg.Google.ServiceAccount)
+	changed("google.service_account_file", old.Google.ServiceAccountFile, cfg.Google.ServiceAccountFile)
+	changed("trello.request_timeout", old.Trello.RequestTimeout, cfg.Trello.RequestTimeout)
+	changed("trello.poll_interval", old.Trello.PollInterval, cfg.Trello.PollInterval)
+	changed("trello.webhook_check_interval", old.Trello.WebhookCheckInterval, cfg.Trello.WebhookCheckInterval)
+	changed("trello.board_discovery_interval", old.Trello.BoardDiscoveryInterval, cfg.Trello.BoardDiscoveryInterval)
+	changed("trello.webhook_alert_url", old.Trello.WebhookAlertURL, cfg.Trello.WebhookAlertURL)
+	changed("trello.source_ips", old.Trello.SourceIPs, cfg.Trello.SourceIPs)
+
+	// Accounts are compared without their boards, which Reload does apply
+	settings := func(accounts []*TrelloAccount) map[string]config.TrelloAccount {
+		m := make(map[string]config.TrelloAccount, len(accounts))
+		for _, account := range accounts {
+			s := account.TrelloAccount
+			s.BoardIDs = nil
+			m[account.Name] = s
+		}
+		return m
+	}
+	changed("trello accounts", settings(oldAccounts), settings(accounts))
+	return keys
+}
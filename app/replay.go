@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/jobs"
+	"github.com/chxlky/trello-gcal-sync/internal/recording"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"gorm.io/gorm"
+)
+
+// replaySyncTimeout bounds the wait for the sync of one replayed delivery
+const replaySyncTimeout = 2 * time.Minute
+
+// Replay posts the recorded webhook deliveries through the service's routes
+// one at a time, in the order they were received, waiting for the sync each
+// Trello delivery queues before sending the next, and writes what came of
+// each to out. Unlike LoadTest nothing is stubbed: the syncs read and write
+// db, Trello and Google as the service would have.
+func Replay(ctx context.Context, cfg *config.Config, db *gorm.DB, recs []recording.Recording, out io.Writer) error {
+	replayCfg := *cfg
+	// A single worker keeps the syncs in delivery order, and the queue is
+	// local so the running service's workers don't pick them up
+	replayCfg.Sync.Workers = 1
+	replayCfg.Sync.Queue = config.Queue{}
+	replayCfg.LeaderElection = config.LeaderElection{}
+	replayCfg.Server.TLS = config.TLS{}
+	replayCfg.Server.RecordWebhooks = ""
+	replayCfg.Trello.SourceIPs.Enabled = false
+
+	syncs := &replaySyncs{waiting: make(map[string]chan error)}
+	a, err := New(Options{Config: &replayCfg, DB: db, ObserveJob: syncs.observe})
+	if err != nil {
+		return err
+	}
+	defer a.Stop()
+
+	callbackPaths := make(map[string]bool)
+	for _, account := range a.accounts {
+		callbackPaths[basePath(replayCfg.Server)+account.CallbackPath] = true
+	}
+
+	failed := 0
+	for n, rec := range recs {
+		id := fmt.Sprintf("replay-%06d", n)
+		synced := syncs.expect(id)
+
+		target := rec.Path
+		if rec.Query != "" {
+			target += "?" + rec.Query
+		}
+		req := httptest.NewRequestWithContext(ctx, rec.Method, target, bytes.NewReader(rec.Payload()))
+		for name, values := range rec.Header {
+			// Set again from the body; the request ID is replaced below
+			if name != "Content-Length" && name != requestid.Header {
+				req.Header[name] = values
+			}
+		}
+		req.Header.Set(requestid.Header, id)
+		resp := httptest.NewRecorder()
+		a.router.ServeHTTP(resp, req)
+
+		fmt.Fprintf(out, "%s  %s %s (received %s): %d %s\n", rec.File, rec.Method, rec.Path, rec.ReceivedAt.Local().Format(time.DateTime), resp.Code, strings.TrimSpace(resp.Body.String()))
+		if resp.Code != http.StatusOK {
+			failed++
+		}
+		if resp.Code != http.StatusOK || !callbackPaths[rec.Path] || rec.Method != http.MethodPost {
+			syncs.forget(id)
+			continue
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, replaySyncTimeout)
+		select {
+		case err := <-synced:
+			var retryErr *jobs.RetryError
+			var outageErr *jobs.OutageError
+			switch {
+			case errors.As(err, &retryErr), errors.As(err, &outageErr):
+				fmt.Fprintf(out, "  sync postponed: %v\n", err)
+			case err != nil:
+				failed++
+				fmt.Fprintf(out, "  sync failed: %v\n", err)
+			default:
+				fmt.Fprintln(out, "  synced")
+			}
+		case <-waitCtx.Done():
+			failed++
+			fmt.Fprintf(out, "  sync hadn't finished after %s\n", replaySyncTimeout)
+		}
+		cancel()
+		syncs.forget(id)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	fmt.Fprintf(out, "Replayed %d deliveries, %d failed\n", len(recs), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replayed deliveries failed", failed, len(recs))
+	}
+	return nil
+}
+
+// replaySyncs hands the outcome of each replayed delivery's sync to the
+// replay waiting on it
+type replaySyncs struct {
+	mu      sync.Mutex
+	waiting map[string]chan error // By request ID
+}
+
+func (s *replaySyncs) expect(id string) <-chan error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan error, 1)
+	s.waiting[id] = ch
+	return ch
+}
+
+func (s *replaySyncs) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.waiting, id)
+}
+
+func (s *replaySyncs) observe(job jobs.Job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.waiting[job.RequestID]; ok {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+}
@@ -0,0 +1,527 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const tenantAccountPrefix = "tenant/"
+
+// tenantCallbackPath is the route every tenant's webhooks are delivered to
+const tenantCallbackPath = defaultCallbackPath + "/" + tenantAccountPrefix + ":tenant"
+
+// Tenant names end up in callback paths and credential names
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// trelloIDPattern matches Trello board and list IDs, which sync rules match by
+var trelloIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// CheckTenantName reports whether name can be given to a tenant.
+func CheckTenantName(name string) error {
+	if !tenantNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid tenant name %q: use up to 63 lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// TenantAccountName is the Trello account a tenant's boards are synced
+// through, whose webhooks are delivered to /api/trello-webhook/tenant/<name>.
+func TenantAccountName(tenant string) string {
+	return tenantAccountPrefix + tenant
+}
+
+// GoogleCredentialName is where `tenant auth <name> google` stores the
+// tenant's refresh token
+func GoogleCredentialName(tenant string) string {
+	return database.GoogleTokenCredential + ":" + tenant
+}
+
+// TenantCredentials names every credential stored for the tenant.
+func TenantCredentials(tenant string) []string {
+	return []string{CredentialName(TenantAccountName(tenant)), GoogleCredentialName(tenant)}
+}
+
+// tenantAccounts returns a Trello account for each tenant, using the
+// instance's trello.api_key with the tenant's own token.
+func tenantAccounts(db *gorm.DB, cfg *config.Config) ([]*TrelloAccount, error) {
+	tenants, err := database.ListTenants(db)
+	if err != nil {
+		return nil, fmt.Errorf("loading tenants: %w", err)
+	}
+	accounts := make([]*TrelloAccount, 0, len(tenants))
+	for _, tenant := range tenants {
+		accounts = append(accounts, &TrelloAccount{
+			TrelloAccount: config.TrelloAccount{
+				Name:     TenantAccountName(tenant.Name),
+				APIKey:   cfg.Trello.APIKey,
+				BoardIDs: tenant.BoardIDs,
+			},
+			Tenant: tenant.Name,
+		})
+	}
+	return accounts, nil
+}
+
+// TenantProblem says what is left to set up before the tenant's boards can
+// be synced, or "" if nothing is.
+func TenantProblem(db *gorm.DB, tenant models.Tenant) (string, error) {
+	trelloToken, err := database.GetCredential(db, CredentialName(TenantAccountName(tenant.Name)))
+	if err != nil {
+		return "", err
+	}
+	googleToken, err := database.GetCredential(db, GoogleCredentialName(tenant.Name))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case trelloToken == "":
+		return fmt.Sprintf("Trello isn't connected; run `trello-gcal-sync tenant auth %s trello`", tenant.Name), nil
+	case googleToken == "":
+		return fmt.Sprintf("Google isn't connected; run `trello-gcal-sync tenant auth %s google`", tenant.Name), nil
+	case tenant.CalendarID == "":
+		return fmt.Sprintf("no calendar is chosen; run `trello-gcal-sync tenant calendar %s <calendar ID>`", tenant.Name), nil
+	case len(tenant.BoardIDs) == 0:
+		return fmt.Sprintf("no boards are chosen; run `trello-gcal-sync tenant boards %s <board>...`", tenant.Name), nil
+	}
+	return "", nil
+}
+
+// operatorBoards lists the boards configured for the operator's own accounts
+func operatorBoards(cfg *config.Config) []string {
+	if len(cfg.Trello.Accounts) == 0 {
+		return cfg.Trello.BoardIDs
+	}
+	var boardIDs []string
+	for _, account := range cfg.Trello.Accounts {
+		boardIDs = append(boardIDs, account.BoardIDs...)
+	}
+	return boardIDs
+}
+
+// boardOwners returns the tenant owning each of the tenants' boards, by
+// board ID
+func boardOwners(tenants map[string]models.Tenant) map[string]string {
+	owners := make(map[string]string)
+	for name, tenant := range tenants {
+		for _, boardID := range tenant.BoardIDs {
+			owners[boardID] = name
+		}
+	}
+	return owners
+}
+
+// takenBoard returns the first of the tenant's boards owned by another
+// tenant in owners or configured for the operator, or "" if none is. Each
+// board is synced for one owner only, so no card is shared between them.
+func takenBoard(tenant string, boardIDs []string, owners map[string]string, operator []string) string {
+	for _, boardID := range boardIDs {
+		if owner, ok := owners[boardID]; ok && owner != tenant || slices.Contains(operator, boardID) {
+			return boardID
+		}
+	}
+	return ""
+}
+
+// checkBoardLimit reports whether boardIDs are within the tenant's
+// tenants.limits.max_boards
+func checkBoardLimit(cfg *config.Config, tenant string, boardIDs []string) error {
+	if limit := cfg.Tenants.LimitsFor(tenant).MaxBoards; limit > 0 && len(boardIDs) > limit {
+		return fmt.Errorf("tenant %s can sync at most %d board(s), not %d", tenant, limit, len(boardIDs))
+	}
+	return nil
+}
+
+// CheckTenantBoards reports whether the tenant can be given boardIDs, which
+// must be within its board limit, none of them another tenant's or
+// configured for the operator.
+func CheckTenantBoards(db *gorm.DB, cfg *config.Config, tenant string, boardIDs []string) error {
+	if err := checkBoardLimit(cfg, tenant, boardIDs); err != nil {
+		return err
+	}
+	all, err := database.ListTenants(db)
+	if err != nil {
+		return fmt.Errorf("loading tenants: %w", err)
+	}
+	tenants := make(map[string]models.Tenant, len(all))
+	for _, t := range all {
+		tenants[t.Name] = t
+	}
+	owners := boardOwners(tenants)
+	if boardID := takenBoard(tenant, boardIDs, owners, operatorBoards(cfg)); boardID != "" {
+		if owner, ok := owners[boardID]; ok && owner != tenant {
+			return fmt.Errorf("board %s is already synced for tenant %s", boardID, owner)
+		}
+		return fmt.Errorf("board %s is already synced for the operator in config.toml", boardID)
+	}
+	return nil
+}
+
+// loadTenants returns the tenants ready to sync, by name, and a client for
+// each one's calendar acting as its Google account, by calendar ID. Clients
+// in replace, by tenant name, are used instead of building them. Tenants not
+// fully set up are logged and left out, as are those with more boards than
+// their limit allows and those whose calendar or boards are already synced by
+// someone else, since events are routed by calendar and cards belong to their
+// board's owner.
+func loadTenants(db *gorm.DB, cfg *config.Config, taken []string, replace map[string]integrations.CalendarAPI) (map[string]models.Tenant, map[string]integrations.CalendarAPI, error) {
+	all, err := database.ListTenants(db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading tenants: %w", err)
+	}
+
+	tenants := make(map[string]models.Tenant)
+	clients := make(map[string]integrations.CalendarAPI)
+	owners := make(map[string]string)
+	operator := operatorBoards(cfg)
+	for _, tenant := range all {
+		log := zap.L().With(zap.String("tenant", tenant.Name))
+		problem, err := TenantProblem(db, tenant)
+		if err != nil {
+			return nil, nil, fmt.Errorf("checking tenant %q: %w", tenant.Name, err)
+		}
+		if problem != "" {
+			log.Warn("Tenant isn't set up yet; not syncing its boards", zap.String("problem", problem))
+			continue
+		}
+		if err := checkBoardLimit(cfg, tenant.Name, tenant.BoardIDs); err != nil {
+			log.Error("Tenant has more boards than its limit; not syncing its boards", zap.Error(err))
+			continue
+		}
+		if _, ok := clients[tenant.CalendarID]; ok || slices.Contains(taken, tenant.CalendarID) {
+			log.Error("Tenant's calendar is already synced by another tenant or the service account; not syncing its boards", zap.String("calendarID", tenant.CalendarID))
+			continue
+		}
+		if boardID := takenBoard(tenant.Name, tenant.BoardIDs, owners, operator); boardID != "" {
+			log.Error("Tenant's board is already synced by another tenant or the operator; not syncing its boards", zap.String("boardID", boardID))
+			continue
+		}
+
+		client, err := tenantCalendar(db, cfg, tenant, replace)
+		if err != nil {
+			log.Error("Can't act as the tenant's Google account; not syncing its boards", zap.Error(err))
+			continue
+		}
+		tenants[tenant.Name] = tenant
+		clients[tenant.CalendarID] = client
+		for _, boardID := range tenant.BoardIDs {
+			owners[boardID] = tenant.Name
+		}
+	}
+	if len(tenants) > 0 {
+		zap.L().Info("Loaded tenants", zap.Int("ready", len(tenants)), zap.Int("total", len(all)))
+	}
+	return tenants, clients, nil
+}
+
+// tenantCalendar returns a client for the tenant's calendar acting as its
+// Google account within its hourly call limit, or the one in replace under
+// its name, wrapped when sync.dry_run is set.
+func tenantCalendar(db *gorm.DB, cfg *config.Config, tenant models.Tenant, replace map[string]integrations.CalendarAPI) (integrations.CalendarAPI, error) {
+	client, ok := replace[tenant.Name]
+	if !ok {
+		oauth, err := integrations.NewGoogleOAuth(cfg.Google.OAuth, "")
+		if err != nil {
+			return nil, err
+		}
+		token, err := database.GetCredential(db, GoogleCredentialName(tenant.Name))
+		if err != nil {
+			return nil, fmt.Errorf("loading Google token: %w", err)
+		}
+		logging.AddSecrets(token)
+		limit := integrations.TenantCallLimit(tenant.Name, cfg.Tenants.LimitsFor(tenant.Name).GoogleCallsPerHour)
+		if client, err = integrations.NewTenantCalendarClient(integrations.TenantSettings(&cfg.Google, tenant.CalendarID), oauth, token, limit); err != nil {
+			return nil, fmt.Errorf("building Google client: %w", err)
+		}
+	}
+	if cfg.Sync.DryRun {
+		client = integrations.NewDryRunCalendar(client)
+	}
+	return client, nil
+}
+
+// TenantCalendarClient acts as the Google account the tenant connected, with
+// no calendar of its own as the default.
+func TenantCalendarClient(db *gorm.DB, cfg *config.Config, tenant string) (*integrations.CalendarClient, error) {
+	token, err := database.GetCredential(db, GoogleCredentialName(tenant))
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("tenant %q hasn't connected a Google account", tenant)
+	}
+	oauth, err := integrations.NewGoogleOAuth(cfg.Google.OAuth, "")
+	if err != nil {
+		return nil, err
+	}
+	logging.AddSecrets(token)
+	return integrations.NewTenantCalendarClient(integrations.TenantSettings(&cfg.Google, ""), oauth, token, nil)
+}
+
+// ConnectTenantGoogle stores the refresh token the tenant's Google account
+// granted through oauth and records the account. Unless the tenant has chosen
+// a calendar the account can reach, events go to one named like the service
+// account's, created if the account has none.
+func ConnectTenantGoogle(ctx context.Context, db *gorm.DB, cfg *config.Config, tenant *models.Tenant, oauth *oauth2.Config, refreshToken string) error {
+	client, err := integrations.NewTenantCalendarClient(integrations.TenantSettings(&cfg.Google, ""), oauth, refreshToken, nil)
+	if err != nil {
+		return err
+	}
+	account, err := client.PrimaryCalendarID(ctx)
+	if err != nil {
+		return err
+	}
+	if tenant.CalendarID == "" || !client.CalendarExists(ctx, tenant.CalendarID) {
+		if tenant.CalendarID, err = client.EnsureCalendar(ctx, integrations.DefaultCalendarName); err != nil {
+			return err
+		}
+	}
+
+	if err := database.PutCredential(db, GoogleCredentialName(tenant.Name), refreshToken); err != nil {
+		return fmt.Errorf("storing token: %w", err)
+	}
+	tenant.GoogleAccount = account
+	if err := database.SaveTenant(db, tenant); err != nil {
+		return fmt.Errorf("storing tenant: %w", err)
+	}
+	return nil
+}
+
+// buildRules builds the sync rules: one per tenant sending its boards to its
+// calendar, so none of the configured rules can route them elsewhere, then
+// sync.rules.
+func buildRules(cfg *config.Config, tenants map[string]models.Tenant) (*rules.Set, error) {
+	var all []rules.Rule
+	for _, name := range slices.Sorted(maps.Keys(tenants)) {
+		tenant := tenants[name]
+		all = append(all, rules.Rule{Name: "tenant " + name, Boards: tenant.BoardIDs, Calendar: tenant.CalendarID})
+	}
+	return rules.New(append(all, cfg.Sync.Rules...))
+}
+
+// warnRuleRefs warns about boards and lists in sync.rules that look like
+// names, which match no card: rules see Trello boards and lists by ID, and
+// other sources' boards by the projects and repositories configured for them.
+// Other sources' lists are named, so lists are only checked without them.
+func warnRuleRefs(cfg *config.Config) {
+	namedLists := cfg.Jira.URL != "" || len(cfg.GitHub.Repos) > 0 || len(cfg.Asana.Projects) > 0
+	for i, rule := range cfg.Sync.Rules {
+		for _, board := range rule.Boards {
+			if !trelloIDPattern.MatchString(board) && !sourceBoard(cfg, board) {
+				zap.L().Warn("Sync rule board is neither a Trello board ID nor a configured project or repository, so it matches no cards",
+					zap.Int("rule", i), zap.String("name", rule.Name), zap.String("board", board))
+			}
+		}
+		if namedLists {
+			continue
+		}
+		for _, list := range rule.Lists {
+			if !trelloIDPattern.MatchString(list) {
+				zap.L().Warn("Sync rule list isn't a Trello list ID, so it matches no cards; rules match lists by ID, not name",
+					zap.Int("rule", i), zap.String("name", rule.Name), zap.String("list", list))
+			}
+		}
+	}
+}
+
+// sourceBoard reports whether board is a Jira project, GitHub repository or
+// Asana project configured to sync
+func sourceBoard(cfg *config.Config, board string) bool {
+	for project := range cfg.Jira.Projects {
+		if strings.EqualFold(project, board) {
+			return true
+		}
+	}
+	if _, ok := cfg.GitHub.Repo(board); ok {
+		return true
+	}
+	_, ok := cfg.Asana.Project(board)
+	return ok
+}
+
+// tenancy records what the tenants in tenants own, given their accounts
+func tenancy(accounts []*TrelloAccount, tenants map[string]models.Tenant) *api.Tenancy {
+	t := &api.Tenancy{
+		Accounts:  make(map[string]string),
+		Boards:    boardOwners(tenants),
+		Calendars: make(map[string]string, len(tenants)),
+	}
+	for _, account := range accounts {
+		if account.Tenant != "" {
+			t.Accounts[account.Name] = account.Tenant
+		}
+	}
+	for name, tenant := range tenants {
+		t.Calendars[name] = tenant.CalendarID
+	}
+	return t
+}
+
+// activeAccounts leaves out the accounts of tenants not in tenants
+func activeAccounts(accounts []*TrelloAccount, tenants map[string]models.Tenant) []*TrelloAccount {
+	return slices.DeleteFunc(accounts, func(account *TrelloAccount) bool {
+		_, ok := tenants[account.Tenant]
+		return account.Tenant != "" && !ok
+	})
+}
+
+// SyncTenant starts syncing a tenant set up since startup or the last
+// reload, or applies the boards it has since chosen if it's syncing already,
+// without a restart. Only the leader can register the tenant's webhooks;
+// other replicas take the tenant up when they next reload or are elected.
+func (a *App) SyncTenant(name string) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	if a.stopped || a.bgCtx == nil {
+		return errors.New("app is not running")
+	}
+
+	tenant, err := database.GetTenant(a.db, name)
+	if err != nil {
+		return err
+	}
+	if tenant == nil {
+		return fmt.Errorf("no tenant named %q", name)
+	}
+	running, ok := a.tenants[name]
+	if !ok {
+		return a.startTenant(*tenant)
+	}
+	if tenant.CalendarID != running.CalendarID {
+		return fmt.Errorf("tenant %q is already syncing to calendar %s; its new calendar takes effect on restart", name, running.CalendarID)
+	}
+	if problem, err := TenantProblem(a.db, *tenant); err != nil {
+		return err
+	} else if problem != "" {
+		return errors.New(problem)
+	}
+	if err := checkBoardLimit(a.cfg, name, tenant.BoardIDs); err != nil {
+		return err
+	}
+	others := maps.Clone(a.tenants)
+	delete(others, name)
+	if boardID := takenBoard(name, tenant.BoardIDs, boardOwners(others), operatorBoards(a.cfg)); boardID != "" {
+		return fmt.Errorf("board %s is already synced by another tenant or the operator", boardID)
+	}
+
+	tenants := maps.Clone(a.tenants)
+	tenants[name] = *tenant
+	syncRules, err := buildRules(a.cfg, tenants)
+	if err != nil {
+		return fmt.Errorf("invalid sync rules: %w", err)
+	}
+	return a.applyTenants(tenants, syncRules)
+}
+
+// startTenant begins syncing a tenant that isn't yet: its calendar is added
+// to those reached and its Trello account started, as lead would have at
+// startup. Callers hold reloadMu.
+func (a *App) startTenant(tenant models.Tenant) error {
+	log := zap.L().With(zap.String("tenant", tenant.Name))
+	if problem, err := TenantProblem(a.db, tenant); err != nil {
+		return err
+	} else if problem != "" {
+		return errors.New(problem)
+	}
+	if err := checkBoardLimit(a.cfg, tenant.Name, tenant.BoardIDs); err != nil {
+		return err
+	}
+	if slices.Contains(a.calendars.ConfiguredCalendarIDs(), tenant.CalendarID) {
+		return fmt.Errorf("calendar %s is already synced by another tenant or the service account", tenant.CalendarID)
+	}
+	if boardID := takenBoard(tenant.Name, tenant.BoardIDs, boardOwners(a.tenants), operatorBoards(a.cfg)); boardID != "" {
+		return fmt.Errorf("board %s is already synced by another tenant or the operator", boardID)
+	}
+
+	client, err := tenantCalendar(a.db, a.cfg, tenant, a.replaceCalendars)
+	if err != nil {
+		return fmt.Errorf("can't act as the tenant's Google account: %w", err)
+	}
+	account, err := FindTrelloAccount(a.db, a.cfg, TenantAccountName(tenant.Name))
+	if err != nil {
+		return err
+	}
+	account.useClient(a.cfg, a.replaceTrello)
+	tenants := maps.Clone(a.tenants)
+	tenants[tenant.Name] = tenant
+	syncRules, err := buildRules(a.cfg, tenants)
+	if err != nil {
+		return fmt.Errorf("invalid sync rules: %w", err)
+	}
+
+	a.calendars.AddTenant(tenant.CalendarID, client)
+	a.handler.SetTrelloClient(account.Name, account.Client)
+	a.accounts = append(a.accounts, account)
+	if err := a.applyTenants(tenants, syncRules); err != nil {
+		return err
+	}
+	log.Info("Started syncing tenant", zap.String("calendarID", tenant.CalendarID), zap.Int("boards", len(tenant.BoardIDs)))
+
+	// Replicas that aren't leading start the account when they are elected
+	if !a.leading.Load() {
+		return nil
+	}
+	a.stopWatch()
+	a.handler.StopCalendarWatch(a.bgCtx)
+	a.startCalendarWatch()
+	if err := account.start(a.leadCtx, a.handler); err != nil {
+		return fmt.Errorf("failed to start syncing the tenant's Trello boards: %w", err)
+	}
+	return nil
+}
+
+// applyTenants syncs tenants, each already reached through its calendar
+// client, with syncRules built for them, moving tenants' accounts to the
+// boards they now have. Callers hold reloadMu.
+func (a *App) applyTenants(tenants map[string]models.Tenant, syncRules *rules.Set) error {
+	a.handler.SetRules(syncRules)
+	a.handler.SetTenancy(tenancy(a.accounts, tenants))
+	a.tenants = tenants
+	if err := database.AssignCardTenants(a.db, a.handler.Tenancy().Boards); err != nil {
+		zap.L().Error("Failed to assign stored cards to tenants; they are assigned as they sync", zap.Error(err))
+	}
+
+	var errs []error
+	for _, account := range a.accounts {
+		tenant, ok := tenants[account.Tenant]
+		if account.Tenant == "" || !ok || slices.Equal(account.BoardIDs, tenant.BoardIDs) {
+			continue
+		}
+		if !a.leading.Load() {
+			account.BoardIDs = tenant.BoardIDs
+			continue
+		}
+		if err := account.updateBoards(a.leadCtx, a.handler, tenant.BoardIDs); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", account.Tenant, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tenantWebhookHandler hands deliveries on a tenant's callback path to the
+// tenant's account, whether it started with the App or since.
+func (a *App) tenantWebhookHandler(c *gin.Context) {
+	account := TenantAccountName(c.Param("tenant"))
+	if a.handler.TrelloClient(account) == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no such tenant"})
+		return
+	}
+	a.handler.TrelloWebhookHandler(account)(c)
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const defaultAutocertCache = "autocert"
+
+// certRecheckInterval is how often the certificate file is checked for
+// renewal, e.g. by certbot
+const certRecheckInterval = time.Minute
+
+// tlsSetup is the server's TLS config and, for autocert with an HTTP port,
+// the plain HTTP server answering ACME challenges.
+type tlsSetup struct {
+	config     *tls.Config
+	httpServer *http.Server
+}
+
+// newTLSSetup builds the TLS config from server.tls, or returns nil if TLS is
+// not configured.
+func newTLSSetup(cfg config.TLS) (*tlsSetup, error) {
+	if !cfg.Enabled() {
+		if cfg.ClientCAFile != "" {
+			return nil, errors.New("server.tls: client_ca_file needs cert_file and key_file or autocert.domains")
+		}
+		return nil, nil
+	}
+	setup, err := newServerTLS(cfg)
+	if err != nil || cfg.ClientCAFile == "" {
+		return setup, err
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("server.tls.client_ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("server.tls.client_ca_file: no PEM certificates in %s", cfg.ClientCAFile)
+	}
+	// Trello can't present a certificate, so one is only checked if given;
+	// routes needing one turn away requests without
+	setup.config.ClientAuth = tls.VerifyClientCertIfGiven
+	setup.config.ClientCAs = pool
+	return setup, nil
+}
+
+// newServerTLS builds the TLS config serving the server's certificate
+func newServerTLS(cfg config.TLS) (*tlsSetup, error) {
+	usesFiles := cfg.CertFile != "" || cfg.KeyFile != ""
+	if usesFiles && len(cfg.Autocert.Domains) > 0 {
+		return nil, errors.New("server.tls: set either cert_file and key_file or autocert.domains, not both")
+	}
+
+	if usesFiles {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("server.tls: cert_file and key_file must be set together")
+		}
+		certs := &keyPair{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if err := certs.load(); err != nil {
+			return nil, err
+		}
+		return &tlsSetup{config: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}}, nil
+	}
+
+	cacheDir := cfg.Autocert.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultAutocertCache
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Autocert.Domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      cfg.Autocert.Email,
+	}
+
+	// The TLS config answers tls-alpn-01 challenges itself
+	setup := &tlsSetup{config: manager.TLSConfig()}
+	setup.config.MinVersion = tls.VersionTLS12
+	if port := cfg.Autocert.HTTPPort; port != "" {
+		setup.httpServer = &http.Server{
+			Addr:              ":" + port,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	zap.L().Info("Obtaining TLS certificates from Let's Encrypt", zap.Strings("domains", cfg.Autocert.Domains), zap.String("cache", cacheDir))
+	return setup, nil
+}
+
+// keyPair serves a certificate from files, picking up renewed files without a
+// restart.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (k *keyPair) load() error {
+	info, err := os.Stat(k.certFile)
+	if err != nil {
+		return fmt.Errorf("server.tls.cert_file: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return fmt.Errorf("server.tls: failed to load certificate: %w", err)
+	}
+	k.cert, k.modTime = &cert, info.ModTime()
+	return nil
+}
+
+func (k *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.checkedAt) >= certRecheckInterval {
+		k.checkedAt = time.Now()
+		if info, err := os.Stat(k.certFile); err == nil && info.ModTime().After(k.modTime) {
+			if err := k.load(); err != nil {
+				// Keep serving the old certificate until the new one loads
+				zap.L().Error("Failed to load renewed TLS certificate", zap.Error(err))
+			} else {
+				zap.L().Info("Loaded renewed TLS certificate", zap.String("file", k.certFile))
+			}
+		}
+	}
+	return k.cert, nil
+}
@@ -0,0 +1,319 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/notify"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultAccountName  = "default"
+	defaultCallbackPath = "/api/trello-webhook"
+)
+
+// TrelloAccount is one configured Trello account and its client. Without
+// [[trello.accounts]] the top-level trello settings form a single account
+// named "default". Each tenant has an account of its own as well.
+type TrelloAccount struct {
+	config.TrelloAccount
+
+	Tenant   string // Name of the tenant the account belongs to, if any
+	Client   integrations.TrelloAPI
+	trello   *config.Trello    // Settings shared by all accounts
+	boards   []string          // IDs of the valid boards in BoardIDs
+	webhooks *webhooks.Manager // nil in poll mode
+
+	stopPolling context.CancelFunc
+}
+
+// CredentialName is where `trello auth` stores the account's token
+func CredentialName(account string) string {
+	if account == DefaultAccountName {
+		return database.TrelloTokenCredential
+	}
+	return database.TrelloTokenCredential + ":" + account
+}
+
+// LoadTrelloAccounts reads the configured accounts and those of tenants,
+// filling in defaults and tokens stored by `trello auth`.
+func LoadTrelloAccounts(db *gorm.DB, cfg *config.Config) ([]*TrelloAccount, error) {
+	var accounts []*TrelloAccount
+	if len(cfg.Trello.Accounts) > 0 {
+		for _, settings := range cfg.Trello.Accounts {
+			accounts = append(accounts, &TrelloAccount{TrelloAccount: settings})
+		}
+	} else {
+		account := &TrelloAccount{TrelloAccount: cfg.Trello.TrelloAccount}
+		account.Name = DefaultAccountName
+		if account.CallbackPath == "" {
+			account.CallbackPath = defaultCallbackPath
+		}
+		accounts = append(accounts, account)
+	}
+	tenants, err := tenantAccounts(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	accounts = append(accounts, tenants...)
+
+	var names, paths []string
+	for _, account := range accounts {
+		if account.Name == "" {
+			return nil, fmt.Errorf("every entry in trello.accounts needs a name")
+		}
+		if slices.Contains(names, account.Name) {
+			return nil, fmt.Errorf("trello account %q is configured twice", account.Name)
+		}
+		names = append(names, account.Name)
+
+		if account.CallbackPath == "" {
+			account.CallbackPath = defaultCallbackPath + "/" + account.Name
+		}
+		if slices.Contains(paths, account.CallbackPath) {
+			return nil, fmt.Errorf("trello account %q reuses callback path %s", account.Name, account.CallbackPath)
+		}
+		paths = append(paths, account.CallbackPath)
+
+		if account.Mode == "" {
+			account.Mode = cfg.Trello.Mode
+		}
+		if account.Description == "" {
+			account.Description = integrations.DefaultWebhookDescription
+		}
+
+		if account.APIToken == "" {
+			token, err := database.GetCredential(db, CredentialName(account.Name))
+			if err != nil {
+				return nil, fmt.Errorf("loading stored token for trello account %q: %w", account.Name, err)
+			}
+			account.APIToken = token
+			logging.AddSecrets(token)
+		}
+
+		if account.CallbackURL == "" {
+			account.CallbackURL = publicURL(cfg.Server, account.CallbackPath)
+		}
+
+		client := integrations.NewTrelloClient(account.APIKey, account.APIToken, account.CallbackURL)
+		client.Description = account.Description
+		client.Timeout = cfg.Trello.RequestTimeout
+		account.Client = client
+		account.trello = &cfg.Trello
+	}
+	return accounts, nil
+}
+
+// FindTrelloAccount loads the configured accounts and returns the one named name.
+func FindTrelloAccount(db *gorm.DB, cfg *config.Config, name string) (*TrelloAccount, error) {
+	accounts, err := LoadTrelloAccounts(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(accounts, func(a *TrelloAccount) bool { return a.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("no Trello account named %q is configured", name)
+	}
+	return accounts[i], nil
+}
+
+// useClient replaces the account's client with the one in replace under its
+// name, if any, and wraps it when sync.dry_run is set.
+func (a *TrelloAccount) useClient(cfg *config.Config, replace map[string]integrations.TrelloAPI) {
+	if client, ok := replace[a.Name]; ok {
+		a.Client = client
+	}
+	if cfg.Sync.DryRun {
+		a.Client = integrations.NewDryRunTrello(a.Client)
+	}
+}
+
+// start validates the account's boards and begins receiving their updates,
+// either by registering webhooks or by polling.
+func (a *TrelloAccount) start(ctx context.Context, h *api.Handler) error {
+	log := zap.L().With(zap.String("account", a.Name))
+
+	if a.APIToken == "" {
+		return fmt.Errorf("no Trello token for account %q; set its api_token or run `trello-gcal-sync trello auth %s`", a.Name, a.Name)
+	}
+	if len(a.BoardIDs) == 0 && a.OrganizationID == "" {
+		return fmt.Errorf("trello account %q needs board_ids or organization_id", a.Name)
+	}
+
+	if u, err := url.Parse(a.CallbackURL); err == nil && a.CallbackURL != "" && !strings.HasSuffix(u.Path, a.CallbackPath) {
+		log.Warn("Callback URL does not point at the webhook route; Trello deliveries will not reach the service",
+			zap.String("callbackURL", a.CallbackURL), zap.String("callbackPath", a.CallbackPath))
+	}
+
+	boards, err := webhooks.ValidateBoards(ctx, a.Client, a.BoardIDs)
+	if err != nil {
+		if !a.trello.SkipInvalidBoards {
+			return fmt.Errorf("invalid Trello board configuration: %w", err)
+		}
+		log.Warn("Skipping invalid Trello boards", zap.Error(err))
+	}
+	a.boards = boardIDs(boards)
+
+	switch a.Mode {
+	case "", "webhook":
+		log.Info("Registering Trello webhook for boards", zap.Strings("boardIDs", a.BoardIDs))
+
+		a.webhooks = webhooks.NewManager(a.Client)
+		var postAlert func(webhooks.Alert)
+		if alertURL := a.trello.WebhookAlertURL; alertURL != "" {
+			postAlert = webhooks.PostAlerts(alertURL)
+		}
+		a.webhooks.OnAlert = func(alert webhooks.Alert) {
+			if postAlert != nil {
+				postAlert(alert)
+			}
+			h.Notifier.Notify(notify.WebhookDisabledNotification(alert.BoardID, alert.Recovered, alert.Err))
+		}
+		a.webhooks.OnChange = func(boardIDs []string) {
+			if err := h.TrackBoards(a.Name, boardIDs); err != nil {
+				log.Error("Failed to record the boards webhooks are registered for; deliveries may be rejected", zap.Error(err))
+			}
+		}
+		if err := a.webhooks.LoadExisting(ctx); err != nil {
+			log.Warn("Could not look up existing webhooks; registering new ones", zap.Error(err))
+		}
+		for _, board := range boards {
+			if err := a.webhooks.Register(ctx, board.ID); err != nil {
+				return fmt.Errorf("failed to register webhook for board %s (%q): %w", board.ID, board.Name, err)
+			}
+		}
+
+		if a.OrganizationID != "" {
+			if err := a.webhooks.DiscoverBoards(ctx, a.OrganizationID); err != nil {
+				return err
+			}
+
+			if discoveryInterval := a.trello.BoardDiscoveryInterval; discoveryInterval > 0 {
+				go a.webhooks.WatchOrganization(ctx, a.OrganizationID, discoveryInterval)
+			}
+		}
+
+		if checkInterval := a.trello.WebhookCheckInterval; checkInterval > 0 {
+			go a.webhooks.Monitor(ctx, checkInterval)
+		}
+	case "poll":
+		if a.trello.PollInterval <= 0 {
+			return fmt.Errorf("trello.poll_interval must be positive in poll mode")
+		}
+		return a.startPolling(ctx, h)
+	default:
+		return fmt.Errorf("unknown mode %q for trello account %q; expected \"webhook\" or \"poll\"", a.Mode, a.Name)
+	}
+	return nil
+}
+
+// startPolling polls the account's boards, plus every board in its workspace,
+// until ctx is done or stopPolling is called.
+func (a *TrelloAccount) startPolling(ctx context.Context, h *api.Handler) error {
+	pollBoardIDs := slices.Clone(a.boards)
+	if a.OrganizationID != "" {
+		orgBoards, err := a.Client.ListOrganizationBoards(ctx, a.OrganizationID)
+		if err != nil {
+			return fmt.Errorf("failed to discover boards in Trello workspace %s: %w", a.OrganizationID, err)
+		}
+		for _, board := range orgBoards {
+			if !slices.Contains(pollBoardIDs, board.ID) {
+				pollBoardIDs = append(pollBoardIDs, board.ID)
+			}
+		}
+	}
+
+	if err := h.TrackBoards(a.Name, pollBoardIDs); err != nil {
+		return fmt.Errorf("failed to record polled boards: %w", err)
+	}
+
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	a.stopPolling = stopPolling
+	go h.RunPoller(pollCtx, a.Name, a.Client, pollBoardIDs, a.trello.PollInterval)
+	return nil
+}
+
+// updateBoards switches a running account over to the boards in refs. In
+// webhook mode new boards get a webhook and removed boards lose theirs; in
+// poll mode the poller restarts with the new boards. Boards found through the
+// account's workspace are left to discovery.
+func (a *TrelloAccount) updateBoards(ctx context.Context, h *api.Handler, refs []string) error {
+	log := zap.L().With(zap.String("account", a.Name))
+
+	boards, err := webhooks.ValidateBoards(ctx, a.Client, refs)
+	if err != nil {
+		if !a.trello.SkipInvalidBoards {
+			return fmt.Errorf("invalid Trello board configuration: %w", err)
+		}
+		log.Warn("Skipping invalid Trello boards", zap.Error(err))
+	}
+	ids := boardIDs(boards)
+
+	if a.webhooks != nil {
+		var errs []error
+		var tracked []string
+		for _, id := range ids {
+			if !slices.Contains(a.boards, id) {
+				log.Info("Registering Trello webhook for added board", zap.String("boardID", id))
+				if err := a.webhooks.Register(ctx, id); err != nil {
+					errs = append(errs, fmt.Errorf("failed to register webhook for board %s: %w", id, err))
+					continue
+				}
+			}
+			tracked = append(tracked, id)
+		}
+		for _, id := range a.boards {
+			if slices.Contains(ids, id) {
+				continue
+			}
+			log.Info("Deleting Trello webhook for removed board", zap.String("boardID", id))
+			if err := a.webhooks.Deregister(ctx, id); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete webhook for board %s: %w", id, err))
+				tracked = append(tracked, id) // Still registered; retried on the next reload
+			}
+		}
+		a.BoardIDs, a.boards = refs, tracked
+		return errors.Join(errs...)
+	}
+
+	a.BoardIDs, a.boards = refs, ids
+	if a.stopPolling != nil {
+		a.stopPolling()
+	}
+	return a.startPolling(ctx, h)
+}
+
+func boardIDs(boards []models.TrelloBoard) []string {
+	ids := make([]string, 0, len(boards))
+	for _, board := range boards {
+		ids = append(ids, board.ID)
+	}
+	return ids
+}
+
+// stop removes the account's webhooks unless they should outlive the process
+func (a *TrelloAccount) stop(ctx context.Context) {
+	// Leaving webhooks registered means events during a brief restart are
+	// retried by Trello instead of lost
+	switch {
+	case a.webhooks == nil:
+		// Polling mode registers no webhooks
+	case a.trello.KeepWebhooksOnShutdown:
+		zap.L().Info("Leaving Trello webhooks registered for the next run", zap.String("account", a.Name))
+	default:
+		a.webhooks.DeleteAll(ctx)
+	}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// webhookManagers returns each webhook-mode account's manager by account
+// name, only those of tenant's account unless tenant is empty. They're set
+// up by lead, so this waits for it to finish starting.
+func (a *App) webhookManagers(tenant string) map[string]*webhooks.Manager {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	managers := map[string]*webhooks.Manager{}
+	for _, account := range a.accounts {
+		if account.webhooks != nil && (tenant == "" || account.Tenant == tenant) {
+			managers[account.Name] = account.webhooks
+		}
+	}
+	return managers
+}
+
+// listWebhooksHandler returns each webhook-mode account's Trello webhooks by
+// board ID. A tenant's API key only gets the tenant's account.
+func (a *App) listWebhooksHandler(c *gin.Context) {
+	accounts := gin.H{}
+	for name, manager := range a.webhookManagers(api.RequestTenant(c)) {
+		accounts[name] = manager.Webhooks()
+	}
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// checkWebhooksHandler verifies every webhook now rather than at the next
+// monitor tick, re-enabling or re-registering any Trello has dropped.
+func (a *App) checkWebhooksHandler(c *gin.Context) {
+	for _, manager := range a.webhookManagers(api.RequestTenant(c)) {
+		manager.CheckAll(c.Request.Context())
+	}
+	a.listWebhooksHandler(c)
+}
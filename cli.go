@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/app"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/integrations/trellotest"
+	"github.com/chxlky/trello-gcal-sync/internal/apikeys"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/recording"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage:
+  %[1]s [--dry-run] [--dev]
+      run the sync service; with --dry-run, which any command below also
+      takes first, Trello webhooks and changes to Google and other sync
+      targets are logged instead of made, as with sync.dry_run. --dev starts
+      a cloudflared or ngrok tunnel (dev.tunnel) to the local port and uses
+      its public URL for the Trello callback until the service exits
+  %[1]s init-config [path] [--force]
+      write an annotated starter config, config.toml by default
+  %[1]s doctor
+      check the config, database, Trello accounts, Google calendar and
+      callback URLs, and print a pass/fail report
+  %[1]s trello auth [account]
+      authorise access to Trello and store the token
+  %[1]s trello webhooks [account]
+      list the webhooks registered on the account's token
+  %[1]s trello webhooks cleanup [account] [--dry-run]
+      delete this service's webhooks for boards no longer configured
+  %[1]s api-keys create <name> --scopes read[,resync,webhooks,onboard,admin] [--tenant <name>]
+      issue an admin API key limited to the given scopes and, with
+      --tenant, to the tenant's own cards, calendar and webhooks
+  %[1]s api-keys list
+      list the issued API keys
+  %[1]s api-keys revoke <id>
+      stop accepting an API key
+  %[1]s tenant add <name>
+      register someone to share the service with their own Trello and
+      Google accounts
+  %[1]s tenant invite <name>
+      issue a link to the onboarding pages, where the tenant, added if
+      need be, connects Trello and Google, chooses boards and a calendar,
+      and starts syncing without a restart
+  %[1]s tenant auth <name> trello|google
+      authorise access to the tenant's Trello account, with trello.api_key,
+      or Google calendars, through the google.oauth client, and store the
+      token; connecting Google picks a calendar for the tenant's events,
+      creating one if need be
+  %[1]s tenant boards <name> <board>...
+      choose the Trello boards synced to the tenant's calendar
+  %[1]s tenant calendar <name> <calendar ID>
+      send the tenant's new events to another of its calendars
+  %[1]s tenant list
+      list the tenants and what each still has to set up
+  %[1]s tenant remove <name>
+      delete a tenant and its stored tokens, and revoke its API keys
+  %[1]s encryption rotate
+      re-encrypt the stored credentials under a new data key wrapped by the
+      current database.encryption key, dropping keys wrapped by a previous one
+  %[1]s loadtest <dir> [--rate N] [--account name] [--workers N]
+          [--queue-size N] [--google-latency D] [--trello-latency D]
+          [--timeout D] [--verbose]
+      replay the webhook payloads recorded in dir (one JSON file each)
+      through the queue and workers with Google and Trello stubbed out, and
+      report throughput and latency percentiles
+  %[1]s replay <file|dir>... [--since T] [--until T] [--path prefix]
+          [--contains text]
+      re-post webhook deliveries saved by server.record_webhooks through the
+      service, one at a time, waiting for each sync; T is RFC 3339 or local
+      "2006-01-02 15:04". Syncs run for real, so stop the service first or
+      point database.path at a copy
+  %[1]s genfixture <kind> [--board ID] [--board-name N] [--card ID]
+          [--card-name N] [--old-name N] [--list ID] [--list-name N]
+          [--target-list ID] [--target-list-name N] [--target-board ID]
+          [--member ID] [--due T] [--old-due T] [--at T] [--count N]
+          [--out dir]
+      print fabricated Trello webhook payloads of kind due, remove-due,
+      archive, rename, move, create or move-all, with the IDs given or made
+      up; --count N makes N, each for a card of its own unless --card is
+      given, and --out writes them to dir one file each, for loadtest
+`
+
+// runCommand handles CLI subcommands and returns the process exit code.
+func runCommand(args []string, cfg *config.Config, db *gorm.DB) int {
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "doctor":
+		err = app.Doctor(context.Background(), cfg, db, os.Stdout)
+	case len(args) >= 2 && len(args) <= 3 && args[0] == "trello" && args[1] == "auth":
+		account := app.DefaultAccountName
+		if len(args) == 3 {
+			account = args[2]
+		}
+		err = trelloAuth(db, cfg, account)
+	case len(args) >= 2 && args[0] == "trello" && args[1] == "webhooks":
+		err = trelloWebhooks(db, cfg, args[2:])
+	case len(args) >= 2 && args[0] == "api-keys":
+		err = apiKeys(db, args[1], args[2:])
+	case len(args) >= 2 && args[0] == "tenant":
+		err = tenants(db, cfg, args[1], args[2:])
+	case len(args) == 2 && args[0] == "encryption" && args[1] == "rotate":
+		err = rotateEncryption(db, cfg)
+	case len(args) >= 2 && args[0] == "loadtest":
+		err = loadTest(cfg, args[1:])
+	case len(args) >= 2 && args[0] == "replay":
+		err = replay(cfg, db, args[1:])
+	case len(args) >= 2 && args[0] == "genfixture":
+		err = genFixture(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", strings.Join(args, " "))
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// initConfig writes the starter config. It runs before the config is loaded,
+// since there may not be one yet.
+func initConfig(args []string) int {
+	path, force := "config.toml", false
+	for _, arg := range args {
+		switch {
+		case arg == "--force":
+			force = true
+		case !strings.HasPrefix(arg, "-"):
+			path = arg
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown flag %q\n\n", arg)
+			fmt.Fprintf(os.Stderr, usage, os.Args[0])
+			return 2
+		}
+	}
+
+	if err := config.WriteStarter(path, force); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			err = fmt.Errorf("%s already exists; pass --force to replace it", path)
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s. Fill in the Trello and Google credentials, then run \"%s doctor\" to check it.\n", path, os.Args[0])
+	return 0
+}
+
+// trelloAuth walks the user through Trello's authorize page and stores the
+// resulting token in the database, so it doesn't need to live in config.toml.
+func trelloAuth(db *gorm.DB, cfg *config.Config, accountName string) error {
+	account, err := app.FindTrelloAccount(db, cfg, accountName)
+	if err != nil {
+		return err
+	}
+
+	token, member, err := authorizeTrello(account)
+	if err != nil {
+		return err
+	}
+	if err := database.PutCredential(db, app.CredentialName(accountName), token); err != nil {
+		return fmt.Errorf("storing token: %w", err)
+	}
+
+	fmt.Printf("Authorised as %s (@%s). The token for account %q has been stored; its api_token can be removed from config.toml.\n", member.FullName, member.Username, accountName)
+	return nil
+}
+
+// authorizeTrello has the user approve access to Trello with the account's
+// API key and paste the token Trello shows, returning it once Trello accepts
+// it along with the member it belongs to.
+func authorizeTrello(account *app.TrelloAccount) (string, *models.TrelloMember, error) {
+	apiKey := account.APIKey
+	if apiKey == "" {
+		return "", nil, fmt.Errorf("no api_key is configured for Trello account %q; generate one at https://trello.com/power-ups/admin and add it to config.toml", account.Name)
+	}
+
+	fmt.Println("Open this URL in your browser and approve access:")
+	fmt.Println()
+	fmt.Println("  " + integrations.AuthorizeURL(apiKey, ""))
+	fmt.Println()
+	fmt.Print("Paste the token Trello shows you: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("reading token: %w", err)
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", nil, fmt.Errorf("no token entered")
+	}
+
+	member, err := integrations.NewTrelloClient(apiKey, token, "").GetMe(context.Background())
+	if err != nil {
+		return "", nil, fmt.Errorf("token was rejected by Trello: %w", err)
+	}
+	return token, member, nil
+}
+
+// trelloWebhooks lists the webhooks on an account's token or, with
+// "cleanup", deletes the stale ones this service registered.
+func trelloWebhooks(db *gorm.DB, cfg *config.Config, args []string) error {
+	cleanup, dryRun := false, false
+	accountName := app.DefaultAccountName
+	for _, arg := range args {
+		switch arg {
+		case "cleanup":
+			cleanup = true
+		case "--dry-run":
+			dryRun = true
+		default:
+			accountName = arg
+		}
+	}
+
+	account, err := app.FindTrelloAccount(db, cfg, accountName)
+	if err != nil {
+		return err
+	}
+	client := account.Client
+	ctx := context.Background()
+
+	if !cleanup {
+		registered, err := client.ListWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+		for _, webhook := range registered {
+			owner := "other"
+			if integrations.OwnsWebhook(client, webhook) {
+				owner = "this service"
+			}
+			fmt.Printf("%s  board=%s  active=%t  owner=%s  callback=%s\n", webhook.ID, webhook.IDModel, webhook.Active, owner, webhook.CallbackURL)
+		}
+		return nil
+	}
+
+	boards, _ := webhooks.ValidateBoards(ctx, client, account.BoardIDs)
+	var boardIDs []string
+	for _, board := range boards {
+		boardIDs = append(boardIDs, board.ID)
+	}
+	if account.OrganizationID != "" {
+		orgBoards, err := client.ListOrganizationBoards(ctx, account.OrganizationID)
+		if err != nil {
+			return err
+		}
+		for _, board := range orgBoards {
+			boardIDs = append(boardIDs, board.ID)
+		}
+	}
+
+	stale, err := webhooks.FindStale(ctx, client, boardIDs)
+	if err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		fmt.Println("No stale webhooks found.")
+		return nil
+	}
+
+	for _, webhook := range stale {
+		if dryRun {
+			fmt.Printf("Would delete %s (board %s)\n", webhook.ID, webhook.IDModel)
+			continue
+		}
+		if err := client.DeleteWebhook(ctx, webhook.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s (board %s)\n", webhook.ID, webhook.IDModel)
+	}
+	return nil
+}
+
+// apiKeys issues, lists or revokes the scoped keys the admin API accepts.
+func apiKeys(db *gorm.DB, command string, args []string) error {
+	switch command {
+	case "create":
+		var name, scopeList, tenant string
+		for i := 0; i < len(args); i++ {
+			switch {
+			case args[i] == "--scopes" && i+1 < len(args):
+				i++
+				scopeList = args[i]
+			case strings.HasPrefix(args[i], "--scopes="):
+				scopeList = strings.TrimPrefix(args[i], "--scopes=")
+			case args[i] == "--tenant" && i+1 < len(args):
+				i++
+				tenant = args[i]
+			case strings.HasPrefix(args[i], "--tenant="):
+				tenant = strings.TrimPrefix(args[i], "--tenant=")
+			case !strings.HasPrefix(args[i], "-") && name == "":
+				name = args[i]
+			default:
+				return fmt.Errorf("unexpected argument %q", args[i])
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("a name for the key is required")
+		}
+		scopes, err := apikeys.ParseScopes(scopeList)
+		if err != nil {
+			return err
+		}
+		if tenant != "" {
+			// Admin would also grant the routes acting on the whole service
+			if slices.Contains(scopes, apikeys.Admin) {
+				return fmt.Errorf("a tenant's key can't have the %s scope", apikeys.Admin)
+			}
+			if _, err := findTenant(db, tenant); err != nil {
+				return err
+			}
+		} else if slices.Contains(scopes, apikeys.Onboard) {
+			return fmt.Errorf("the %s scope is for a tenant's key; give the tenant with --tenant", apikeys.Onboard)
+		}
+
+		key, record, err := apikeys.New(name, scopes)
+		if err != nil {
+			return err
+		}
+		record.Tenant = tenant
+		if err := database.CreateAPIKey(db, &record); err != nil {
+			return fmt.Errorf("storing API key: %w", err)
+		}
+		if tenant != "" {
+			fmt.Printf("Created API key %s (%s) for tenant %s with scopes %s. It won't be shown again:\n\n  %s\n", record.ID, name, tenant, record.Scopes, key)
+			return nil
+		}
+		fmt.Printf("Created API key %s (%s) with scopes %s. It won't be shown again:\n\n  %s\n", record.ID, name, record.Scopes, key)
+		return nil
+
+	case "list":
+		if len(args) > 0 {
+			return fmt.Errorf("unexpected argument %q", args[0])
+		}
+		keys, err := database.ListAPIKeys(db)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			fmt.Println("No API keys have been issued.")
+			return nil
+		}
+		for _, key := range keys {
+			lastUsed, status := "never", "active"
+			if key.LastUsedAt != nil {
+				lastUsed = key.LastUsedAt.Format(time.RFC3339)
+			}
+			if key.RevokedAt != nil {
+				status = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s  name=%s  tenant=%s  scopes=%s  created=%s  last_used=%s  status=%s\n", key.ID, key.Name, orNone(key.Tenant), key.Scopes, key.CreatedAt.Format(time.RFC3339), lastUsed, status)
+		}
+		return nil
+
+	case "revoke":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: api-keys revoke <id>")
+		}
+		if err := database.RevokeAPIKey(db, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %s.\n", args[0])
+		return nil
+	}
+	return fmt.Errorf("unknown api-keys command %q; expected create, list or revoke", command)
+}
+
+// rotateEncryption re-encrypts the stored credentials under a fresh data key.
+// SetupEncryption has already run with the configured keys.
+func rotateEncryption(db *gorm.DB, cfg *config.Config) error {
+	if !cfg.Database.Encryption.Enabled() {
+		return fmt.Errorf("set database.encryption.key or kms_key to encrypt stored credentials")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Secrets.Timeout)
+	defer cancel()
+	rotated, err := database.RotateDataKey(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted %d credentials under a new data key. Any previous_key or previous_kms_key can now be removed.\n", rotated)
+	return nil
+}
+
+// loadTest replays recorded webhook payloads against a stubbed pipeline.
+func loadTest(cfg *config.Config, args []string) error {
+	var opts app.LoadTestOptions
+	verbose := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "-") {
+			if opts.Dir != "" {
+				return fmt.Errorf("unexpected argument %q", arg)
+			}
+			opts.Dir = arg
+			continue
+		}
+		if name == "--verbose" {
+			verbose = true
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch name {
+		case "--rate":
+			opts.Rate, err = strconv.ParseFloat(value, 64)
+		case "--account":
+			opts.Account = value
+		case "--workers":
+			opts.Workers, err = strconv.Atoi(value)
+		case "--queue-size":
+			opts.QueueSize, err = strconv.Atoi(value)
+		case "--google-latency":
+			opts.GoogleLatency, err = time.ParseDuration(value)
+		case "--trello-latency":
+			opts.TrelloLatency, err = time.ParseDuration(value)
+		case "--timeout":
+			opts.Timeout, err = time.ParseDuration(value)
+		default:
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	if opts.Dir == "" {
+		return fmt.Errorf("a directory of recorded payloads is required")
+	}
+
+	// A log line per sync would drown the report and slow the run down
+	if !verbose {
+		if logging.Level() < zapcore.WarnLevel {
+			logging.SetLevel(zapcore.WarnLevel)
+		}
+		gin.DefaultWriter = io.Discard
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return app.LoadTest(ctx, cfg, opts, os.Stdout)
+}
+
+// replay re-posts recorded webhook deliveries through the service.
+func replay(cfg *config.Config, db *gorm.DB, args []string) error {
+	var paths []string
+	var filter recording.Filter
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "-") {
+			paths = append(paths, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch name {
+		case "--since":
+			filter.Since, err = parseReplayTime(value)
+		case "--until":
+			filter.Until, err = parseReplayTime(value)
+		case "--path":
+			filter.PathPrefix = value
+		case "--contains":
+			filter.Contains = value
+		default:
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("a recording file or directory is required")
+	}
+
+	recs, err := recording.Load(paths...)
+	if err != nil {
+		return err
+	}
+	recs = filter.Select(recs)
+	if len(recs) == 0 {
+		return fmt.Errorf("no recordings match")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return app.Replay(ctx, cfg, db, recs, os.Stdout)
+}
+
+// parseReplayTime reads an RFC 3339 time, or a minute in local time
+func parseReplayTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+}
+
+// genFixture prints fabricated Trello webhook payloads, or with --out writes
+// them to a directory one file each, as loadtest reads them.
+func genFixture(args []string) error {
+	var kind, out string
+	var opts trellotest.FixtureOptions
+	count := 1
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "-") {
+			if kind != "" {
+				return fmt.Errorf("unexpected argument %q", arg)
+			}
+			kind = arg
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch name {
+		case "--board":
+			opts.BoardID = value
+		case "--board-name":
+			opts.BoardName = value
+		case "--card":
+			opts.CardID = value
+		case "--card-name":
+			opts.CardName = value
+		case "--old-name":
+			opts.OldName = value
+		case "--list":
+			opts.ListID = value
+		case "--list-name":
+			opts.ListName = value
+		case "--target-list":
+			opts.TargetListID = value
+		case "--target-list-name":
+			opts.TargetListName = value
+		case "--target-board":
+			opts.TargetBoardID = value
+		case "--member":
+			opts.MemberID = value
+		case "--due":
+			opts.Due, err = parseReplayTime(value)
+		case "--old-due":
+			opts.OldDue, err = parseReplayTime(value)
+		case "--at":
+			opts.At, err = parseReplayTime(value)
+		case "--count":
+			if count, err = strconv.Atoi(value); err == nil && count < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "--out":
+			out = value
+		default:
+			return fmt.Errorf("unknown flag %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	if kind == "" {
+		return fmt.Errorf("a payload kind is required: %s", strings.Join(trellotest.FixtureKinds, ", "))
+	}
+	if out != "" {
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return err
+		}
+	}
+
+	// The board and lists are shared, so a bulk run reads as one board's
+	// activity
+	start := opts.At
+	if start.IsZero() {
+		start = time.Now().UTC()
+	}
+	created := start.Add(-24 * time.Hour)
+	for _, id := range []*string{&opts.BoardID, &opts.ListID, &opts.TargetListID, &opts.MemberID} {
+		if *id == "" {
+			*id = trellotest.NewID(created)
+		}
+	}
+	for i := range count {
+		payloadOpts := opts
+		payloadOpts.At = start.Add(time.Duration(i) * time.Second)
+		// Each payload is about a card of its own unless --card names one
+		if count > 1 && opts.CardID == "" {
+			payloadOpts.CardID = trellotest.NewID(created)
+			if opts.CardName == "" {
+				payloadOpts.CardName = fmt.Sprintf("Fixture card %d", i+1)
+			}
+		}
+		payload, err := trellotest.Payload(kind, payloadOpts)
+		if err != nil {
+			return err
+		}
+
+		if out == "" {
+			fmt.Println(string(payload))
+			continue
+		}
+		path := filepath.Join(out, fmt.Sprintf("%04d-%s.json", i+1, kind))
+		if err := os.WriteFile(path, append(payload, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	if out != "" {
+		fmt.Printf("Wrote %d %s payloads to %s\n", count, kind, out)
+	}
+	return nil
+}
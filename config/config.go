@@ -0,0 +1,886 @@
+// Package config is the service's configuration. It is read once at startup
+// from config.toml and the environment and handed to the components that need
+// it, so nothing deeper in the tree has to know where a setting came from.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/rules"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Server   Server    `mapstructure:"server"`
+	Database Database  `mapstructure:"database"`
+	Google   Google    `mapstructure:"google"`
+	Trello   Trello    `mapstructure:"trello"`
+	Jira     Jira      `mapstructure:"jira"`
+	GitHub   GitHub    `mapstructure:"github"`
+	Asana    Asana     `mapstructure:"asana"`
+	Sync     Sync      `mapstructure:"sync"`
+	Targets  Targets   `mapstructure:"targets"`
+	Feed     Feed      `mapstructure:"feed"`
+	Log      Log       `mapstructure:"log"`
+	Tracing  Tracing   `mapstructure:"tracing"`
+	Slack    Slack     `mapstructure:"slack"`
+	Telegram Telegram  `mapstructure:"telegram"`
+	Digest   Digest    `mapstructure:"digest"`
+	Webhooks []Webhook `mapstructure:"webhooks"`
+	Secrets  Secrets   `mapstructure:"secrets"`
+	Dev      Dev       `mapstructure:"dev"`
+	Tenants  Tenants   `mapstructure:"tenants"`
+
+	LeaderElection LeaderElection `mapstructure:"leader_election"`
+}
+
+type Server struct {
+	Port string `mapstructure:"port"`
+	// Listen overrides Port with an address to listen on: "host:port", or
+	// "unix:/path/to.sock" for a Unix socket created with SocketMode and
+	// owned by SocketGroup
+	Listen          string        `mapstructure:"listen"`
+	SocketMode      string        `mapstructure:"socket_mode"` // Octal, e.g. "0660"
+	SocketGroup     string        `mapstructure:"socket_group"`
+	PublicURL       string        `mapstructure:"public_url"`
+	BasePath        string        `mapstructure:"base_path"`
+	AdminToken      string        `mapstructure:"admin_token"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// Pprof serves Go's runtime profiles under /api/admin/debug/pprof, to
+	// holders of the admin token or an admin API key
+	Pprof bool `mapstructure:"pprof"`
+	// RecordWebhooks is a directory every webhook delivery is saved to, for
+	// the replay command
+	RecordWebhooks string `mapstructure:"record_webhooks"`
+	TLS            TLS    `mapstructure:"tls"`
+}
+
+// TLS makes the server terminate HTTPS itself, with either a certificate and
+// key from files or certificates obtained from Let's Encrypt. With
+// ClientCAFile, admin routes also require a client certificate signed by one
+// of its CAs, naming one of AdminClientNames if any are given, while the
+// webhook routes stay open to clients without one.
+type TLS struct {
+	CertFile         string   `mapstructure:"cert_file"`
+	KeyFile          string   `mapstructure:"key_file"`
+	Autocert         Autocert `mapstructure:"autocert"`
+	ClientCAFile     string   `mapstructure:"client_ca_file"`
+	AdminClientNames []string `mapstructure:"admin_client_names"`
+}
+
+// Autocert obtains and renews certificates for Domains from Let's Encrypt.
+// HTTPPort, usually "80", additionally serves ACME HTTP challenges and
+// redirects plain HTTP to HTTPS; without it challenges are answered over TLS
+// on the main port, which then has to be 443.
+type Autocert struct {
+	Domains  []string `mapstructure:"domains"`
+	Email    string   `mapstructure:"email"`
+	CacheDir string   `mapstructure:"cache_dir"`
+	HTTPPort string   `mapstructure:"http_port"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.Autocert.Domains) > 0
+}
+
+// Secrets is the secret manager settings ending in _secret are fetched from
+// at startup: "vault", "aws" (Secrets Manager) or "gcp" (Secret Manager).
+// References name a secret as the provider does, optionally followed by
+// "#field" to take one field of a secret holding a JSON object.
+type Secrets struct {
+	Provider string        `mapstructure:"provider"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Vault    VaultSecrets  `mapstructure:"vault"`
+	AWS      AWSSecrets    `mapstructure:"aws"`
+}
+
+// VaultSecrets reads KV secrets from the Vault server at Address, whose
+// paths include the mount, such as "secret/data/trello" for KV version 2.
+// Address and Token default to VAULT_ADDR and VAULT_TOKEN.
+type VaultSecrets struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	Namespace string `mapstructure:"namespace"`
+}
+
+// AWSSecrets reads secrets by name or ARN from Secrets Manager in Region,
+// with the credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. Region defaults to AWS_REGION.
+type AWSSecrets struct {
+	Region string `mapstructure:"region"`
+}
+
+type Database struct {
+	Path       string     `mapstructure:"path"`
+	Encryption Encryption `mapstructure:"encryption"`
+}
+
+// Encryption encrypts the credentials stored in the database with data keys
+// that are themselves encrypted by a key-encryption key: Key, 32 bytes in
+// base64 (KeySecret fetches it from the secret manager), or KMSKey, an AWS
+// KMS key ARN or alias or a Google Cloud KMS key name. PreviousKey and
+// PreviousKMSKey name the key being rotated away from, until `encryption
+// rotate` has re-encrypted everything under the new one.
+type Encryption struct {
+	Key            string `mapstructure:"key"`
+	KeySecret      string `mapstructure:"key_secret"`
+	KMSKey         string `mapstructure:"kms_key"`
+	PreviousKey    string `mapstructure:"previous_key"`
+	PreviousKMSKey string `mapstructure:"previous_kms_key"`
+}
+
+// Enabled reports whether a key-encryption key is configured
+func (e Encryption) Enabled() bool {
+	return e.Key != "" || e.KMSKey != ""
+}
+
+type Google struct {
+	// ServiceAccount is the service account key, either as a TOML table or,
+	// from the environment, as its JSON. ServiceAccountFile points at the key
+	// file instead, for keys mounted as secrets.
+	ServiceAccount     map[string]any `mapstructure:"service_account"`
+	ServiceAccountFile string         `mapstructure:"service_account_file"`
+	// ServiceAccountSecret is fetched from the secret manager into
+	// ServiceAccount, in place of ServiceAccountFile
+	ServiceAccountSecret string        `mapstructure:"service_account_secret"`
+	RequestTimeout       time.Duration `mapstructure:"request_timeout"`
+	HTTP                 HTTPTransport `mapstructure:"http"`
+	OAuth                OAuth         `mapstructure:"oauth"`
+
+	Calendar       Calendar          `mapstructure:"calendar"`
+	Calendars      map[string]string `mapstructure:"calendars"` // Alias -> calendar ID
+	Tasks          Tasks             `mapstructure:"tasks"`
+	Quota          Quota             `mapstructure:"quota"`
+	CircuitBreaker Breaker           `mapstructure:"circuit_breaker"`
+}
+
+// OAuth is the OAuth client tenants authorise access to their own Google
+// calendars through: a "Desktop app" client from the Google Cloud console for
+// `tenant auth`, or a "Web application" client for the onboarding pages.
+// ClientSecretSecret is fetched from the secret manager into ClientSecret.
+type OAuth struct {
+	ClientID           string `mapstructure:"client_id"`
+	ClientSecret       string `mapstructure:"client_secret"`
+	ClientSecretSecret string `mapstructure:"client_secret_secret"`
+}
+
+type Calendar struct {
+	CalendarID       string            `mapstructure:"calendar_id"`
+	Visibility       string            `mapstructure:"visibility"`
+	Transparency     string            `mapstructure:"transparency"`
+	DefaultColorID   string            `mapstructure:"default_color_id"`
+	BoardColorIDs    map[string]string `mapstructure:"board_color_ids"`
+	LabelColorIDs    map[string]string `mapstructure:"label_color_ids"` // By label name or ID
+	ShareWith        []string          `mapstructure:"share_with"`
+	BatchConcurrency int               `mapstructure:"batch_concurrency"`
+	Watch            Watch             `mapstructure:"watch"`
+
+	// ConflictPolicy decides which side wins when a watched event's date no
+	// longer matches its card's due date; BoardConflictPolicies overrides it
+	// per board ID
+	ConflictPolicy        string            `mapstructure:"conflict_policy"`
+	BoardConflictPolicies map[string]string `mapstructure:"board_conflict_policies"`
+}
+
+// Conflict policies
+const (
+	TrelloWins   = "trello_wins"   // The event is moved back to the due date
+	CalendarWins = "calendar_wins" // The card's due date is moved to the event
+	NewestWins   = "newest_wins"   // Whichever changed last wins
+)
+
+// PolicyFor returns the conflict policy for cards on the board boardID.
+func (c Calendar) PolicyFor(boardID string) string {
+	if policy, ok := c.BoardConflictPolicies[strings.ToLower(boardID)]; ok {
+		return policy
+	}
+	return c.ConflictPolicy
+}
+
+type Watch struct {
+	CallbackURL string        `mapstructure:"callback_url"`
+	TTL         time.Duration `mapstructure:"ttl"`
+}
+
+type Tasks struct {
+	TasklistID string `mapstructure:"tasklist_id"`
+}
+
+type Quota struct {
+	DailyBudget int64   `mapstructure:"daily_budget"`
+	WarnRatio   float64 `mapstructure:"warn_ratio"`
+}
+
+// HTTPTransport tunes the connections to an API. The timeouts bound each
+// stage of a request, while request_timeout bounds it as a whole; zero
+// values keep Go's defaults. TLSMinVersion is "1.2" or "1.3".
+type HTTPTransport struct {
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	KeepAlive             time.Duration `mapstructure:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `mapstructure:"idle_conn_timeout"`
+	MaxIdleConns          int           `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `mapstructure:"max_conns_per_host"`
+	TLSMinVersion         string        `mapstructure:"tls_min_version"`
+}
+
+// Breaker configures a circuit breaker. A FailureThreshold of 0 disables it.
+type Breaker struct {
+	FailureThreshold int           `mapstructure:"failure_threshold"`
+	Cooldown         time.Duration `mapstructure:"cooldown"`
+}
+
+// TrelloAccount is one set of Trello credentials and the boards synced with
+// them.
+type TrelloAccount struct {
+	Name     string `mapstructure:"name"`
+	APIKey   string `mapstructure:"api_key"`
+	APIToken string `mapstructure:"api_token"`
+	// APITokenSecret is fetched from the secret manager into APIToken
+	APITokenSecret string   `mapstructure:"api_token_secret"`
+	CallbackURL    string   `mapstructure:"callback_url"`
+	CallbackPath   string   `mapstructure:"callback_path"`
+	Description    string   `mapstructure:"webhook_description"`
+	BoardIDs       []string `mapstructure:"board_ids"`
+	OrganizationID string   `mapstructure:"organization_id"`
+	Mode           string   `mapstructure:"mode"`
+}
+
+// Trello holds the settings shared by every Trello account. Without
+// [[trello.accounts]] the top-level account settings form a single account.
+type Trello struct {
+	TrelloAccount `mapstructure:",squash"`
+	Accounts      []TrelloAccount `mapstructure:"accounts"`
+
+	RequestTimeout         time.Duration `mapstructure:"request_timeout"`
+	HTTP                   HTTPTransport `mapstructure:"http"`
+	SkipInvalidBoards      bool          `mapstructure:"skip_invalid_boards"`
+	WebhookAlertURL        string        `mapstructure:"webhook_alert_url"`
+	WebhookCheckInterval   time.Duration `mapstructure:"webhook_check_interval"`
+	BoardDiscoveryInterval time.Duration `mapstructure:"board_discovery_interval"`
+	PollInterval           time.Duration `mapstructure:"poll_interval"`
+	KeepWebhooksOnShutdown bool          `mapstructure:"keep_webhooks_on_shutdown"`
+	CircuitBreaker         Breaker       `mapstructure:"circuit_breaker"`
+	// RemoveClosedBoardEvents deletes the events of a board's cards when the
+	// board is closed in Trello; they are kept, no longer synced, otherwise
+	RemoveClosedBoardEvents bool      `mapstructure:"remove_closed_board_events"`
+	SourceIPs               SourceIPs `mapstructure:"source_ips"`
+
+	// Event titles start with the prefix BoardPrefixes maps the card's board
+	// ID or name to, or else the board name's first letter, unless
+	// PrefixTitles is off. A board mapped to "" gets no prefix.
+	PrefixTitles  bool              `mapstructure:"prefix_titles"`
+	BoardPrefixes map[string]string `mapstructure:"board_prefixes"`
+}
+
+// SourceIPs restricts the webhook routes to the addresses Trello sends
+// webhooks from, as published at URL and refreshed every RefreshInterval,
+// and to the Extra CIDRs. Behind a reverse proxy, its addresses go in
+// TrustedProxies so the X-Forwarded-For address it adds is checked instead.
+type SourceIPs struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	URL             string        `mapstructure:"url"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Extra           []string      `mapstructure:"extra"`
+	TrustedProxies  []string      `mapstructure:"trusted_proxies"`
+}
+
+// BoardPrefix returns the prefix for titles of cards on the board, or "" for
+// none.
+func (t Trello) BoardPrefix(boardID, boardName string) string {
+	if !t.PrefixTitles {
+		return ""
+	}
+	for _, key := range []string{boardID, boardName} {
+		if prefix, ok := t.BoardPrefixes[strings.ToLower(key)]; ok {
+			return prefix
+		}
+	}
+	if boardName == "" {
+		return ""
+	}
+	return string([]rune(boardName)[0])
+}
+
+// Jira syncs the due dates of issues in the projects keyed in Projects, on
+// the Jira site at URL, to Google Calendar: each project's to the calendar
+// alias or ID it maps to, or to the one the sync rules pick if that is empty.
+// Rules see an issue's project key as its board and its status as its list.
+// Jira Cloud is signed in to with Email and an APIToken, Data Center with a
+// personal access token in APIToken alone. Mode "poll" fetches the issues
+// updated every PollInterval; "webhook" takes them from a Jira webhook to
+// /api/jira-webhook, which must be signed with WebhookSecret or carry it as
+// its secret query parameter. Jira is off without a URL.
+type Jira struct {
+	URL            string            `mapstructure:"url"`
+	Email          string            `mapstructure:"email"`
+	APIToken       string            `mapstructure:"api_token"`
+	Projects       map[string]string `mapstructure:"projects"`
+	Mode           string            `mapstructure:"mode"`
+	PollInterval   time.Duration     `mapstructure:"poll_interval"`
+	WebhookSecret  string            `mapstructure:"webhook_secret"`
+	RequestTimeout time.Duration     `mapstructure:"request_timeout"`
+}
+
+// GitHub syncs issues and milestones from Repos to Google Calendar, taking
+// them from a GitHub webhook to /api/github-webhook signed with
+// WebhookSecret. Rules see the repository as the board and the milestone
+// title as the list. A milestone's event falls on its due date, and an
+// issue's on the date of a label such as "due:2025-03-31", with
+// DueLabelPrefix, or else on its milestone's due date.
+type GitHub struct {
+	WebhookSecret  string       `mapstructure:"webhook_secret"`
+	Repos          []GitHubRepo `mapstructure:"repos"`
+	DueLabelPrefix string       `mapstructure:"due_label_prefix"`
+}
+
+// GitHubRepo is a repository to sync, named "owner/name", and the calendar
+// alias or ID its events go to, or "" for the one the sync rules pick. They
+// are listed rather than keyed by name since names may contain dots.
+type GitHubRepo struct {
+	Name     string `mapstructure:"name"`
+	Calendar string `mapstructure:"calendar"`
+}
+
+// Repo returns the listed repository named name, ignoring case.
+func (g GitHub) Repo(name string) (GitHubRepo, bool) {
+	for _, repo := range g.Repos {
+		if strings.EqualFold(repo.Name, name) {
+			return repo, true
+		}
+	}
+	return GitHubRepo{}, false
+}
+
+// Asana syncs the due dates of tasks in Projects to Google Calendar,
+// signed in with a personal access Token. The leader registers a webhook on
+// each project pointing at CallbackURL, /api/asana-webhook by default,
+// checks every CheckInterval that it is still active, and imports the
+// project's open tasks whenever it registers one. Rules see the project GID
+// as the board and the section name as the list. Asana is off without
+// projects.
+type Asana struct {
+	Token          string         `mapstructure:"token"`
+	Projects       []AsanaProject `mapstructure:"projects"`
+	CallbackURL    string         `mapstructure:"callback_url"`
+	CheckInterval  time.Duration  `mapstructure:"check_interval"`
+	RequestTimeout time.Duration  `mapstructure:"request_timeout"`
+}
+
+// AsanaProject is a project to sync, by GID, in the Workspace it belongs to,
+// and the calendar alias or ID its tasks go to, or "" for the one the sync
+// rules pick.
+type AsanaProject struct {
+	Workspace string `mapstructure:"workspace"`
+	GID       string `mapstructure:"gid"`
+	Calendar  string `mapstructure:"calendar"`
+}
+
+// Project returns the listed project with the GID gid.
+func (a Asana) Project(gid string) (AsanaProject, bool) {
+	for _, project := range a.Projects {
+		if project.GID == gid {
+			return project, true
+		}
+	}
+	return AsanaProject{}, false
+}
+
+type Sync struct {
+	Workers             int           `mapstructure:"workers"`
+	QueueSize           int           `mapstructure:"queue_size"`
+	ClaimTTL            time.Duration `mapstructure:"claim_ttl"`
+	FetchFullCard       bool          `mapstructure:"fetch_full_card"`
+	Debounce            time.Duration `mapstructure:"debounce"`
+	SkipOverdue         bool          `mapstructure:"skip_overdue"`
+	OverdueThreshold    time.Duration `mapstructure:"overdue_threshold"`
+	OrphanSweepInterval time.Duration `mapstructure:"orphan_sweep_interval"`
+	DryRun              bool          `mapstructure:"dry_run"` // Log Trello webhook and Google changes instead of making them
+	Rules               []rules.Rule  `mapstructure:"rules"`
+	Queue               Queue         `mapstructure:"queue"`
+	Titles              Titles        `mapstructure:"titles"`
+	Backfill            Backfill      `mapstructure:"backfill"`
+}
+
+// Backfill paces a backfill of every card on the tracked boards: Concurrency
+// cards are synced at once, with at most TrelloRate Trello requests and
+// GoogleRate card syncs a second between them. Progress is logged every
+// ProgressInterval.
+type Backfill struct {
+	Concurrency      int           `mapstructure:"concurrency"`
+	TrelloRate       float64       `mapstructure:"trello_rate"`
+	GoogleRate       float64       `mapstructure:"google_rate"`
+	ProgressInterval time.Duration `mapstructure:"progress_interval"`
+}
+
+// Titles is the cleanup applied to card names to make event titles.
+// MaxLength cuts longer titles short with an ellipsis; 0 leaves them whole.
+type Titles struct {
+	StripMarkdown        bool `mapstructure:"strip_markdown"`
+	StripEmojiShortcodes bool `mapstructure:"strip_emoji_shortcodes"`
+	CollapseWhitespace   bool `mapstructure:"collapse_whitespace"`
+	MaxLength            int  `mapstructure:"max_length"`
+}
+
+// Queue moves sync jobs through an external broker instead of the database,
+// so the instances accepting webhooks and those running syncs can be scaled
+// and restarted independently. Backend is "database", the default, "redis"
+// for Redis Streams or "nats" for NATS JetStream, reached at URL, such as
+// "redis://localhost:6379/0" or "nats://localhost:4222". Instances with
+// Consume off only accept webhooks and leave syncing to the others. A job
+// taken by a worker that stopped before finishing it is handed to another
+// after RedeliverAfter.
+type Queue struct {
+	Backend        string        `mapstructure:"backend"`
+	URL            string        `mapstructure:"url"`
+	Stream         string        `mapstructure:"stream"` // Redis stream key or JetStream stream name
+	Group          string        `mapstructure:"group"`  // Consumer group the workers share
+	Consume        bool          `mapstructure:"consume"`
+	RedeliverAfter time.Duration `mapstructure:"redeliver_after"`
+}
+
+// Targets holds the settings of the sync targets other than Google, each
+// named after the target sync rules route cards to with it.
+type Targets struct {
+	Outlook Outlook `mapstructure:"outlook"`
+	CalDAV  CalDAV  `mapstructure:"caldav"`
+	Notion  Notion  `mapstructure:"notion"`
+}
+
+// Outlook syncs cards routed to the "outlook" target to a Microsoft 365 or
+// Outlook.com calendar through Microsoft Graph. With RefreshToken the service
+// acts as the user who granted it (delegated access); otherwise it signs in
+// as the app with ClientSecret (client credentials) and writes to User's
+// calendar, which needs a tenant ID rather than "common".
+type Outlook struct {
+	TenantID       string        `mapstructure:"tenant_id"`
+	ClientID       string        `mapstructure:"client_id"`
+	ClientSecret   string        `mapstructure:"client_secret"`
+	RefreshToken   string        `mapstructure:"refresh_token"`
+	User           string        `mapstructure:"user"`        // User ID or principal name, for client credentials
+	CalendarID     string        `mapstructure:"calendar_id"` // The user's default calendar if empty
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+}
+
+// CalDAV syncs cards routed to the "caldav" target to the calendar
+// collection at URL on a CalDAV server such as Nextcloud, Radicale or
+// Fastmail, signing in with Username and Password using Auth, "basic" or
+// "digest".
+type CalDAV struct {
+	URL            string        `mapstructure:"url"`
+	Username       string        `mapstructure:"username"`
+	Password       string        `mapstructure:"password"`
+	Auth           string        `mapstructure:"auth"`
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+}
+
+// Notion syncs cards routed to the "notion" target to pages in the Notion
+// database DatabaseID, using an internal integration's Token. The database
+// must be shared with the integration.
+type Notion struct {
+	Token          string           `mapstructure:"token"`
+	DatabaseID     string           `mapstructure:"database_id"`
+	Properties     NotionProperties `mapstructure:"properties"`
+	RequestTimeout time.Duration    `mapstructure:"request_timeout"`
+}
+
+// NotionProperties name the database properties card fields are written to.
+// Title is the database's title property and Date a date property; the
+// others are left out when empty. Description and CardID are text properties
+// and URL a URL property.
+type NotionProperties struct {
+	Title       string `mapstructure:"title"`
+	Date        string `mapstructure:"date"`
+	URL         string `mapstructure:"url"`
+	Description string `mapstructure:"description"`
+	CardID      string `mapstructure:"card_id"`
+}
+
+type Feed struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+}
+
+// Log configures the service's logs. Format is "console", colored for
+// development, or "json" for log shippers. With File set, logs are written
+// there instead of stdout and rotated once the file reaches MaxSizeMB; rotated
+// files are kept for MaxAgeDays and at most MaxBackups of them.
+type Log struct {
+	Level      string `mapstructure:"level"`
+	Format     string `mapstructure:"format"`
+	File       string `mapstructure:"file"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxAgeDays int    `mapstructure:"max_age_days"`
+	MaxBackups int    `mapstructure:"max_backups"`
+	Compress   bool   `mapstructure:"compress"`
+}
+
+// Dev configures the --dev flag's tunnel: Tunnel is "cloudflared" or
+// "ngrok", or empty to use whichever is installed, and TunnelArgs are added
+// to its command line, such as ["--domain", "me.ngrok.app"] to keep the
+// same ngrok URL across sessions.
+type Dev struct {
+	Tunnel     string   `mapstructure:"tunnel"`
+	TunnelArgs []string `mapstructure:"tunnel_args"`
+}
+
+// Tracing exports OpenTelemetry spans over OTLP/HTTP to Endpoint, such as
+// "http://localhost:4318". Tracing is off when Endpoint is empty.
+type Tracing struct {
+	Endpoint    string  `mapstructure:"endpoint"`
+	ServiceName string  `mapstructure:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio"` // Share of traces kept, from 0 to 1
+}
+
+// Slack posts sync activity to Slack channels through their incoming
+// webhooks: events created for new due dates ("event_created"), cards whose
+// syncs failed FailureThreshold times in a row ("sync_failed") and Trello
+// webhooks found disabled ("webhook_disabled"). A channel gets the kinds in
+// its Events, or all of them, for the boards in its Boards, or every board.
+type Slack struct {
+	Channels         []SlackChannel `mapstructure:"channels"`
+	FailureThreshold int            `mapstructure:"failure_threshold"`
+}
+
+type SlackChannel struct {
+	Name       string   `mapstructure:"name"` // Used in logs
+	WebhookURL string   `mapstructure:"webhook_url"`
+	Events     []string `mapstructure:"events"`
+	Boards     []string `mapstructure:"boards"`
+}
+
+// Telegram sends alerts to ChatID through the bot with BotToken: the kinds
+// of notification in Events, as for Slack, or by default repeated sync
+// failures and disabled webhooks, for the boards in Boards or every board.
+// The bot also answers /status, /resync, /pause and /resume from that chat,
+// which for commands must be given as a numeric chat ID.
+type Telegram struct {
+	BotToken string   `mapstructure:"bot_token"`
+	ChatID   string   `mapstructure:"chat_id"`
+	Events   []string `mapstructure:"events"`
+	Boards   []string `mapstructure:"boards"`
+}
+
+// Webhook is a URL sent a JSON POST whenever an event is created, updated or
+// deleted for a card, for the operations in Operations ("created", "updated",
+// "deleted") or all of them and the boards in Boards or every board. With a
+// Secret each request is signed with HMAC-SHA256 so the receiver can check it
+// came from this service.
+type Webhook struct {
+	Name       string   `mapstructure:"name"` // Used in logs
+	URL        string   `mapstructure:"url"`
+	Secret     string   `mapstructure:"secret"`
+	Operations []string `mapstructure:"operations"`
+	Boards     []string `mapstructure:"boards"`
+}
+
+// Digest emails To a summary of the cards due in the next Days days, grouped
+// by board. Schedule is "daily" or "weekly"; the digest is sent at At, as
+// "15:04" in Timezone (the server's by default), and weekly digests on
+// Weekday. It is off without recipients.
+type Digest struct {
+	To       []string `mapstructure:"to"`
+	From     string   `mapstructure:"from"`
+	Schedule string   `mapstructure:"schedule"`
+	At       string   `mapstructure:"at"`
+	Weekday  string   `mapstructure:"weekday"`
+	Timezone string   `mapstructure:"timezone"`
+	Days     int      `mapstructure:"days"`
+	SMTP     SMTP     `mapstructure:"smtp"`
+}
+
+// SMTP is the mail server digests are sent through. Port 465 uses TLS from
+// the start; other ports upgrade with STARTTLS when the server offers it.
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Tenants caps what each tenant syncs, so one tenant can't use up the API
+// quotas the whole service shares. Limits apply to every tenant; Overrides
+// replace the limits they set for the tenants named, by tenant name.
+type Tenants struct {
+	Limits    TenantLimits            `mapstructure:"limits"`
+	Overrides map[string]TenantLimits `mapstructure:"overrides"`
+}
+
+// TenantLimits caps the boards a tenant syncs, its cards that aren't
+// archived and the calls made to Google as its account in an hour. 0 leaves
+// a limit as it is (unlimited in Limits), and a negative value lifts it.
+type TenantLimits struct {
+	MaxBoards          int   `mapstructure:"max_boards"`
+	MaxCards           int64 `mapstructure:"max_cards"`
+	GoogleCallsPerHour int   `mapstructure:"google_calls_per_hour"`
+}
+
+// LimitsFor returns the tenant's limits, with 0 for those it doesn't have.
+func (t Tenants) LimitsFor(tenant string) TenantLimits {
+	limits := t.Limits
+	if override, ok := t.Overrides[tenant]; ok {
+		if override.MaxBoards != 0 {
+			limits.MaxBoards = override.MaxBoards
+		}
+		if override.MaxCards != 0 {
+			limits.MaxCards = override.MaxCards
+		}
+		if override.GoogleCallsPerHour != 0 {
+			limits.GoogleCallsPerHour = override.GoogleCallsPerHour
+		}
+	}
+	limits.MaxBoards = max(limits.MaxBoards, 0)
+	limits.MaxCards = max(limits.MaxCards, 0)
+	limits.GoogleCallsPerHour = max(limits.GoogleCallsPerHour, 0)
+	return limits
+}
+
+// LeaderElection lets several replicas share one database. Every replica
+// accepts webhook deliveries, but only the one holding the leader lease
+// registers webhooks, watches calendars, polls Trello and runs sweeps and
+// reconciliation. InstanceID tells replicas apart and must survive a
+// replica's restart, so it resumes its own unfinished jobs; it defaults to the
+// hostname.
+type LeaderElection struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	InstanceID string        `mapstructure:"instance_id"`
+	LeaseTTL   time.Duration `mapstructure:"lease_ttl"`
+}
+
+// Defaults for settings where the zero value means something else, such as a
+// disabled interval or breaker.
+var defaults = map[string]any{
+	"trello.board_discovery_interval":          time.Hour,
+	"trello.webhook_check_interval":            15 * time.Minute,
+	"trello.poll_interval":                     time.Minute,
+	"trello.prefix_titles":                     true,
+	"trello.source_ips.url":                    "https://ip-ranges.atlassian.com/",
+	"trello.source_ips.refresh_interval":       24 * time.Hour,
+	"trello.circuit_breaker.failure_threshold": 5,
+	"trello.http.dial_timeout":                 10 * time.Second,
+	"trello.http.response_header_timeout":      10 * time.Second,
+	"trello.http.max_idle_conns_per_host":      16,
+	"trello.http.tls_min_version":              "1.2",
+	"jira.mode":                                "poll",
+	"jira.poll_interval":                       5 * time.Minute,
+	"github.due_label_prefix":                  "due:",
+	"google.circuit_breaker.failure_threshold": 5,
+	"google.http.dial_timeout":                 10 * time.Second,
+	"google.http.response_header_timeout":      20 * time.Second,
+	"google.http.max_idle_conns_per_host":      16,
+	"google.http.tls_min_version":              "1.2",
+	"sync.fetch_full_card":                     true,
+	"google.calendar.conflict_policy":          TrelloWins,
+	"sync.debounce":                            2 * time.Second,
+	"sync.backfill.concurrency":                4,
+	"sync.backfill.trello_rate":                5,
+	"sync.backfill.google_rate":                5,
+	"sync.backfill.progress_interval":          30 * time.Second,
+	"sync.overdue_threshold":                   7 * 24 * time.Hour,
+	"sync.titles.collapse_whitespace":          true,
+	"sync.queue.stream":                        "trello-gcal-sync",
+	"sync.queue.group":                         "sync",
+	"sync.queue.consume":                       true,
+	"secrets.timeout":                          30 * time.Second,
+	"sync.queue.redeliver_after":               5 * time.Minute,
+	"targets.outlook.tenant_id":                "common",
+	"targets.caldav.auth":                      "basic",
+	"targets.notion.properties.title":          "Name",
+	"targets.notion.properties.date":           "Due",
+	"tracing.sample_ratio":                     1.0,
+	"digest.schedule":                          "daily",
+	"digest.at":                                "08:00",
+	"digest.weekday":                           "monday",
+	"digest.days":                              7,
+	"digest.smtp.port":                         "587",
+	"leader_election.lease_ttl":                15 * time.Second,
+}
+
+// envAliases are shorter names for settings whose derived variable is
+// awkward. The derived name (GOOGLE_CALENDAR_CALENDAR_ID) works as well.
+var envAliases = map[string]string{
+	"google.calendar.calendar_id": "GOOGLE_CALENDAR_ID",
+}
+
+// Load reads config.toml from the working directory, if there is one, and
+// overrides it with environment variables. Every setting has a variable named
+// after its key in upper case with dots replaced by underscores, such as
+// TRELLO_API_KEY for trello.api_key. Lists take comma-separated values and
+// tables such as google.service_account take JSON.
+func Load(v *viper.Viper) (*Config, error) {
+	v.SetConfigName("config")
+	v.SetConfigType("toml")
+	v.AddConfigPath(".")
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+	}
+	return Decode(v)
+}
+
+// Decode builds a Config from settings already loaded into v, applying
+// defaults and environment variables.
+func Decode(v *viper.Viper) (*Config, error) {
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	// viper only consults the environment for keys it knows, so every leaf
+	// of Config is bound explicitly
+	for _, key := range leafKeys(reflect.TypeOf(Config{}), "") {
+		names := []string{strings.ToUpper(strings.ReplaceAll(key, ".", "_"))}
+		if alias, ok := envAliases[key]; ok {
+			names = append(names, alias)
+		}
+		if err := v.BindEnv(append([]string{key}, names...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	var cfg Config
+	err := v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		jsonStringHook,
+	)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Calendar aliases, board IDs and Jira projects are matched
+	// case-insensitively; keys from TOML arrive lowercased but JSON from the
+	// environment keeps its case
+	cfg.Google.Calendars = lowerKeys(cfg.Google.Calendars)
+	cfg.Google.Calendar.BoardColorIDs = lowerKeys(cfg.Google.Calendar.BoardColorIDs)
+	cfg.Google.Calendar.LabelColorIDs = lowerKeys(cfg.Google.Calendar.LabelColorIDs)
+	cfg.Google.Calendar.BoardConflictPolicies = lowerKeys(cfg.Google.Calendar.BoardConflictPolicies)
+	cfg.Jira.Projects = lowerKeys(cfg.Jira.Projects)
+	cfg.Trello.BoardPrefixes = lowerKeys(cfg.Trello.BoardPrefixes)
+	return &cfg, nil
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	lowered := make(map[string]string, len(m))
+	for k, v := range m {
+		lowered[strings.ToLower(k)] = v
+	}
+	return lowered
+}
+
+// leafKeys lists the dotted keys of every setting in t that can come from a
+// single environment variable. Lists of tables such as trello.accounts can't.
+func leafKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+
+		key := prefix + name
+		if opts == "squash" {
+			key = strings.TrimSuffix(prefix, ".")
+		}
+
+		switch {
+		case field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)):
+			if opts == "squash" {
+				keys = append(keys, leafKeys(field.Type, prefix)...)
+			} else {
+				keys = append(keys, leafKeys(field.Type, key+".")...)
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// jsonStringHook decodes tables given as a JSON string, as they are when set
+// from an environment variable
+func jsonStringHook(from, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String || to.Kind() != reflect.Map {
+		return data, nil
+	}
+	s, _ := data.(string)
+	if strings.TrimSpace(s) == "" {
+		return map[string]any{}, nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, fmt.Errorf("expected a JSON object: %w", err)
+	}
+	return m, nil
+}
+
+// ReadServiceAccount returns the Google service account key as JSON.
+func (g Google) ReadServiceAccount() ([]byte, error) {
+	if g.ServiceAccountFile != "" {
+		key, err := os.ReadFile(g.ServiceAccountFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read google.service_account_file: %w", err)
+		}
+		return key, nil
+	}
+	if len(g.ServiceAccount) == 0 {
+		return nil, errors.New("no Google service account configured; set google.service_account or google.service_account_file")
+	}
+	return json.Marshal(g.ServiceAccount)
+}
+
+// secretSuffixes end the names of settings holding credentials
+var secretSuffixes = []string{"token", "secret", "password", "api_key", "webhook_url"}
+
+// SecretValues returns the values of every credential in c, such as API
+// tokens and the service account's private key, for keeping them out of
+// logs.
+func (c *Config) SecretValues() []string {
+	values := secretValues(reflect.ValueOf(*c))
+	for _, key := range []string{c.Database.Encryption.Key, c.Database.Encryption.PreviousKey} {
+		if key != "" {
+			values = append(values, key)
+		}
+	}
+	for _, field := range []string{"private_key", "private_key_id"} {
+		if value, ok := c.Google.ServiceAccount[field].(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func secretValues(v reflect.Value) []string {
+	var values []string
+	for i := range v.NumField() {
+		field, value := v.Type().Field(i), v.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			values = append(values, secretValues(value)...)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			for j := range value.Len() {
+				values = append(values, secretValues(value.Index(j))...)
+			}
+		case field.Type.Kind() == reflect.String && value.String() != "":
+			if slices.ContainsFunc(secretSuffixes, func(suffix string) bool { return strings.HasSuffix(name, suffix) }) {
+				values = append(values, value.String())
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+)
+
+// starter is the annotated config.toml written by WriteStarter
+//
+//go:embed starter.toml
+var starter []byte
+
+// WriteStarter writes an annotated starter config to path. It won't replace
+// an existing file unless overwrite is set. The file is readable only by its
+// owner, since it is where credentials go.
+func WriteStarter(path string, overwrite bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(starter); err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return file.Close()
+}
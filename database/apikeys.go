@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// GetAPIKey returns the API key with id, or nil if there is none.
+func GetAPIKey(db *gorm.DB, id string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := db.First(&key, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key, including revoked ones, oldest first.
+func ListAPIKeys(db *gorm.DB) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := db.Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+func CreateAPIKey(db *gorm.DB, key *models.APIKey) error {
+	return db.Create(key).Error
+}
+
+// RevokeAPIKey stops the key with id from being accepted.
+func RevokeAPIKey(db *gorm.DB, id string) error {
+	result := db.Model(&models.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no active API key with ID %q", id)
+	}
+	return nil
+}
+
+// TouchAPIKey records that the key with id was used at.
+func TouchAPIKey(db *gorm.DB, id string, at time.Time) error {
+	return db.Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// insertChunk bounds the rows written by one INSERT, whose bound parameters
+// are a dozen per card
+const insertChunk = 50
+
+// batchSize is how many cards a CardBatch holds before writing them
+const batchSize = 200
+
+// SaveCards stores the cards along with their Google Calendar events and
+// tasks, as SaveCard does for one, in a transaction per chunk of cards.
+func SaveCards(db *gorm.DB, cards []*models.Card) error {
+	now := time.Now()
+	for chunk := range slices.Chunk(cards, loadChunk) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, card := range chunk {
+				// As Save does; the upsert only fills in zero timestamps
+				card.UpdatedAt = now
+			}
+			err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(chunk, insertChunk).Error
+			if err != nil {
+				return err
+			}
+			return saveCardsEvents(tx, chunk)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveCardsEvents is SaveCardEvents for many cards, in a transaction per
+// chunk of cards.
+func SaveCardsEvents(db *gorm.DB, cards []*models.Card) error {
+	for chunk := range slices.Chunk(cards, loadChunk) {
+		if err := db.Transaction(func(tx *gorm.DB) error { return saveCardsEvents(tx, chunk) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveCardsEvents(db *gorm.DB, cards []*models.Card) error {
+	var links []models.TargetEvent
+	unlinked := make(map[string][]string) // Card IDs by target
+	for _, card := range cards {
+		for _, link := range []models.TargetEvent{
+			{CardID: card.ID, Target: models.TargetCalendar, EventID: card.EventID, Container: card.CalendarID},
+			{CardID: card.ID, Target: models.TargetTasks, EventID: card.TaskID, Container: card.TaskListID},
+		} {
+			if link.EventID == "" {
+				unlinked[link.Target] = append(unlinked[link.Target], link.CardID)
+			} else {
+				links = append(links, link)
+			}
+		}
+	}
+
+	for _, target := range mirroredTargets {
+		if ids := unlinked[target]; len(ids) > 0 {
+			if err := db.Delete(&models.TargetEvent{}, "target = ? AND card_id IN ?", target, ids).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(links, insertChunk).Error
+}
+
+// CardBatch collects the card saves of a bulk operation so they can be
+// written together with SaveCards rather than in a transaction each. A card
+// waiting in the batch is not yet in the database, so reads of it should come
+// after FlushCard.
+type CardBatch struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	pending map[string]*models.Card
+	order   []string
+}
+
+// NewCardBatch returns an empty batch that saves to db.
+func NewCardBatch(db *gorm.DB) *CardBatch {
+	return &CardBatch{db: db, pending: make(map[string]*models.Card)}
+}
+
+// Add queues the card to be saved, replacing any earlier save of it still
+// waiting, and writes the batch if that fills it.
+func (b *CardBatch) Add(card models.Card) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[card.ID]; !ok {
+		b.order = append(b.order, card.ID)
+	}
+	b.pending[card.ID] = &card
+	if len(b.order) < batchSize {
+		return nil
+	}
+	return b.flush()
+}
+
+// Flush writes every card waiting in the batch. Cards that fail to save stay
+// queued for the next flush.
+func (b *CardBatch) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// FlushCard writes the batch if the card is waiting in it.
+func (b *CardBatch) FlushCard(cardID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[cardID]; !ok {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *CardBatch) flush() error {
+	if len(b.order) == 0 {
+		return nil
+	}
+	cards := make([]*models.Card, 0, len(b.order))
+	for _, id := range b.order {
+		cards = append(cards, b.pending[id])
+	}
+	if err := SaveCards(b.db, cards); err != nil {
+		return err
+	}
+	clear(b.pending)
+	b.order = b.order[:0]
+	return nil
+}
@@ -0,0 +1,19 @@
+package database
+
+import (
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// ListCardsDueBetween returns the unarchived cards due from from up to to,
+// soonest first.
+func ListCardsDueBetween(db *gorm.DB, from, to time.Time) ([]models.Card, error) {
+	var cards []models.Card
+	// Due dates are stored in UTC, and compared as text
+	err := db.Where("archived = ? AND due_date >= ? AND due_date < ?", false, from.UTC(), to.UTC()).
+		Order("due_date").
+		Find(&cards).Error
+	return cards, err
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrelloTokenCredential names the Trello token stored by `trello auth`.
+const TrelloTokenCredential = "trello.api_token"
+
+// GetCredential returns the stored secret for name, or "" if there is none,
+// decrypting it if it was stored encrypted.
+func GetCredential(db *gorm.DB, name string) (string, error) {
+	var credential models.Credential
+	err := db.First(&credential, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if ring := credentialKeys.Load(); ring != nil {
+		secret, err := ring.decrypt(db.Statement.Context, db, name, credential.Secret)
+		if err != nil {
+			return "", fmt.Errorf("decrypting credential %q: %w", name, err)
+		}
+		return secret, nil
+	}
+	if strings.HasPrefix(credential.Secret, encryptedPrefix) {
+		return "", fmt.Errorf("credential %q is encrypted; configure database.encryption to read it", name)
+	}
+	return credential.Secret, nil
+}
+
+// PutCredential stores secret for name, encrypted if database.encryption
+// is configured.
+func PutCredential(db *gorm.DB, name, secret string) error {
+	if ring := credentialKeys.Load(); ring != nil {
+		var err error
+		if secret, err = ring.encrypt(db.Statement.Context, db, name, secret); err != nil {
+			return fmt.Errorf("encrypting credential %q: %w", name, err)
+		}
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Credential{Name: name, Secret: secret}).Error
+}
@@ -1,7 +1,12 @@
 package database
 
 import (
+	"errors"
+	"io/fs"
+	"os"
+
 	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -9,14 +14,28 @@ import (
 
 func Init(dbPath string) *gorm.DB {
 	dbFile := sqlite.Open(dbPath)
-	db, err := gorm.Open(dbFile, &gorm.Config{})
+	db, err := gorm.Open(dbFile, &gorm.Config{Logger: gormLogger})
 	if err != nil {
 		zap.L().Fatal("Failed to connect to database", zap.Error(err))
 	}
 
-	if err := db.AutoMigrate(&models.Card{}); err != nil {
+	if err := db.AutoMigrate(&models.Card{}, &models.WatchChannel{}, &models.Setting{}, &models.Credential{}, &models.PendingJob{}, &models.TargetEvent{}, &models.Lease{}, &models.APIKey{}, &models.Tenant{}); err != nil {
 		zap.L().Fatal("Failed to migrate database", zap.Error(err))
 	}
+	if err := migrateCardEvents(db); err != nil {
+		zap.L().Fatal("Failed to move card event IDs into target_events", zap.Error(err))
+	}
+
+	// The database holds credentials, so keep it readable by the owner only
+	if err := os.Chmod(dbPath, 0o600); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		zap.L().Warn("Failed to restrict database file permissions", zap.String("path", dbPath), zap.Error(err))
+	}
+
+	initSearchIndex(db)
+
+	if err := tracing.InstrumentDB(db); err != nil {
+		zap.L().Warn("Failed to trace database queries", zap.Error(err))
+	}
 
 	zap.L().Info("Database initialised and migrated successfully")
 
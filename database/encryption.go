@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chxlky/trello-gcal-sync/internal/envelope"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// dataKeyPrefix is where the data keys that encrypt credentials are stored,
+// wrapped by the key-encryption key, by key ID
+const dataKeyPrefix = "encryption.data_key."
+
+// encryptedPrefix marks a credential encrypted under a data key, stored as
+// enc:v1:<data key ID>:<base64 ciphertext>
+const encryptedPrefix = "enc:v1:"
+
+// storedDataKey is a data key as stored in settings
+type storedDataKey struct {
+	Wrapper string `json:"wrapper"` // ID of the key-encryption key
+	Key     []byte `json:"key"`
+}
+
+// keyring unwraps data keys with the configured key-encryption keys and
+// keeps them for reuse
+type keyring struct {
+	wrappers []envelope.Wrapper // The current one first
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+var credentialKeys atomic.Pointer[keyring]
+
+// EncryptCredentials encrypts credentials stored from now on under a data key
+// wrapped by wrapper, and encrypts those already stored in plaintext.
+// previous are the wrappers of keys being rotated away from, which can still
+// unwrap the data keys they wrapped until RotateDataKey replaces them.
+func EncryptCredentials(ctx context.Context, db *gorm.DB, wrapper envelope.Wrapper, previous ...envelope.Wrapper) (int, error) {
+	ring := &keyring{wrappers: append([]envelope.Wrapper{wrapper}, previous...), keys: map[string][]byte{}}
+	credentialKeys.Store(ring)
+
+	var credentials []models.Credential
+	if err := db.Find(&credentials).Error; err != nil {
+		return 0, err
+	}
+	encrypted := 0
+	for _, credential := range credentials {
+		if strings.HasPrefix(credential.Secret, encryptedPrefix) {
+			continue
+		}
+		secret, err := ring.encrypt(ctx, db, credential.Name, credential.Secret)
+		if err != nil {
+			return encrypted, err
+		}
+		if err := db.Model(&credential).Update("secret", secret).Error; err != nil {
+			return encrypted, err
+		}
+		encrypted++
+	}
+	return encrypted, nil
+}
+
+// RotateDataKey re-encrypts every credential under a new data key and
+// deletes the old data keys, along with any wrapped by a previous key.
+func RotateDataKey(ctx context.Context, db *gorm.DB) (int, error) {
+	ring := credentialKeys.Load()
+	if ring == nil {
+		return 0, errors.New("database.encryption isn't configured")
+	}
+
+	var credentials []models.Credential
+	if err := db.Find(&credentials).Error; err != nil {
+		return 0, err
+	}
+	secrets := make(map[string]string, len(credentials))
+	for _, credential := range credentials {
+		secret, err := ring.decrypt(ctx, db, credential.Name, credential.Secret)
+		if err != nil {
+			return 0, fmt.Errorf("decrypting credential %q: %w", credential.Name, err)
+		}
+		secrets[credential.Name] = secret
+	}
+
+	id, key, err := ring.create(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for name, secret := range secrets {
+			sealed, err := seal(id, key, name, secret)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Credential{}).Where("name = ?", name).Update("secret", sealed).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("substr(key, 1, ?) = ? AND key <> ?", len(dataKeyPrefix), dataKeyPrefix, dataKeyPrefix+id).Delete(&models.Setting{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ring.mu.Lock()
+	ring.keys = map[string][]byte{id: key}
+	ring.mu.Unlock()
+	return len(secrets), nil
+}
+
+// encrypt seals the credential name's secret under the newest data key
+// wrapped by the current key-encryption key, creating one if there is none.
+// Looking it up on every write picks up rotations by other instances.
+func (r *keyring) encrypt(ctx context.Context, db *gorm.DB, name, secret string) (string, error) {
+	var stored []models.Setting
+	if err := db.Where("substr(key, 1, ?) = ?", len(dataKeyPrefix), dataKeyPrefix).Order("updated_at DESC").Find(&stored).Error; err != nil {
+		return "", err
+	}
+	for _, setting := range stored {
+		var dataKey storedDataKey
+		if json.Unmarshal([]byte(setting.Value), &dataKey) != nil || dataKey.Wrapper != r.wrappers[0].ID() {
+			continue
+		}
+		id := setting.Key[len(dataKeyPrefix):]
+		key, err := r.key(ctx, id, dataKey)
+		if err != nil {
+			return "", err
+		}
+		return seal(id, key, name, secret)
+	}
+
+	id, key, err := r.create(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	return seal(id, key, name, secret)
+}
+
+// decrypt opens the credential name's stored value. Values without the
+// encrypted prefix were stored before encryption was enabled.
+func (r *keyring) decrypt(ctx context.Context, db *gorm.DB, name, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	r.mu.Lock()
+	key, ok := r.keys[id]
+	r.mu.Unlock()
+	if !ok {
+		raw, err := GetSetting(db, dataKeyPrefix+id)
+		if err != nil {
+			return "", err
+		}
+		var dataKey storedDataKey
+		if raw == "" || json.Unmarshal([]byte(raw), &dataKey) != nil {
+			return "", fmt.Errorf("data key %s is missing", id)
+		}
+		if key, err = r.key(ctx, id, dataKey); err != nil {
+			return "", err
+		}
+	}
+
+	plaintext, err := envelope.Open(key, sealed, []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// key unwraps a stored data key, or returns it from an earlier unwrap
+func (r *keyring) key(ctx context.Context, id string, dataKey storedDataKey) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.keys[id]; ok {
+		return key, nil
+	}
+
+	for _, wrapper := range r.wrappers {
+		if wrapper.ID() != dataKey.Wrapper {
+			continue
+		}
+		key, err := wrapper.Unwrap(ctx, dataKey.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unwrapping data key %s: %w", id, err)
+		}
+		r.keys[id] = key
+		return key, nil
+	}
+	return nil, fmt.Errorf("data key %s was encrypted with %s, which isn't configured; set it as database.encryption.previous_key or previous_kms_key", id, dataKey.Wrapper)
+}
+
+// create stores a new data key wrapped by the current key-encryption key
+func (r *keyring) create(ctx context.Context, db *gorm.DB) (string, []byte, error) {
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := r.wrappers[0].Wrap(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	value, _ := json.Marshal(storedDataKey{Wrapper: r.wrappers[0].ID(), Key: wrapped})
+	if err := PutSetting(db, dataKeyPrefix+id, string(value)); err != nil {
+		return "", nil, fmt.Errorf("storing data key: %w", err)
+	}
+
+	r.mu.Lock()
+	r.keys[id] = key
+	r.mu.Unlock()
+	return id, key, nil
+}
+
+// seal encrypts the credential name's secret under the data key id. The
+// name is bound in so one credential's value can't be copied to another.
+func seal(id string, key []byte, name, secret string) (string, error) {
+	sealed, err := envelope.Seal(key, []byte(secret), []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
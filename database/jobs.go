@@ -0,0 +1,22 @@
+package database
+
+import (
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+func CreatePendingJob(db *gorm.DB, job *models.PendingJob) error {
+	return db.Create(job).Error
+}
+
+func DeletePendingJob(db *gorm.DB, id uint) error {
+	return db.Delete(&models.PendingJob{}, id).Error
+}
+
+// ListPendingJobs returns the jobs owner accepted but left unprocessed, oldest
+// first.
+func ListPendingJobs(db *gorm.DB, owner string) ([]models.PendingJob, error) {
+	var jobs []models.PendingJob
+	err := db.Where("owner = ?", owner).Order("id").Find(&jobs).Error
+	return jobs, err
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// AcquireLease takes the named lease for holder, or extends it if holder
+// already has it, unless someone else holds it and it hasn't expired. It
+// reports whether holder has the lease for ttl from now.
+func AcquireLease(db *gorm.DB, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.Exec(`INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < ?`,
+		name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it.
+func ReleaseLease(db *gorm.DB, name, holder string) error {
+	return db.Where("name = ? AND holder = ?", name, holder).Delete(&models.Lease{}).Error
+}
+
+// LeaseHolder returns who holds the named lease, or "" if nobody does.
+func LeaseHolder(db *gorm.DB, name string) (string, error) {
+	var lease models.Lease
+	err := db.Where("name = ? AND expires_at >= ?", name, time.Now().UnixMilli()).First(&lease).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return lease.Holder, err
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// zapWriter sends gorm's log lines to the service's logger, so they follow
+// the configured format and output instead of going colored to stdout
+type zapWriter struct{}
+
+func (zapWriter) Printf(format string, args ...any) {
+	zap.L().Warn(strings.TrimSpace(strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " ")))
+}
+
+var gormLogger = gormlogger.New(zapWriter{}, gormlogger.Config{
+	SlowThreshold:             200 * time.Millisecond,
+	LogLevel:                  gormlogger.Warn,
+	IgnoreRecordNotFoundError: true,
+})
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ftsEnabled reports whether the cards_fts virtual table is available. SQLite
+// only ships FTS5 when the binary is built with the sqlite_fts5 tag, so search
+// falls back to LIKE matching otherwise.
+var ftsEnabled bool
+
+// ftsTriggers keep cards_fts in step with the cards table
+var ftsTriggers = map[string]string{
+	"cards_fts_ai": `CREATE TRIGGER IF NOT EXISTS cards_fts_ai AFTER INSERT ON cards BEGIN
+			INSERT INTO cards_fts(rowid, name, description) VALUES (new.rowid, new.name, new.description);
+		END`,
+	"cards_fts_ad": `CREATE TRIGGER IF NOT EXISTS cards_fts_ad AFTER DELETE ON cards BEGIN
+			INSERT INTO cards_fts(cards_fts, rowid, name, description) VALUES ('delete', old.rowid, old.name, old.description);
+		END`,
+	"cards_fts_au": `CREATE TRIGGER IF NOT EXISTS cards_fts_au AFTER UPDATE ON cards BEGIN
+			INSERT INTO cards_fts(cards_fts, rowid, name, description) VALUES ('delete', old.rowid, old.name, old.description);
+			INSERT INTO cards_fts(rowid, name, description) VALUES (new.rowid, new.name, new.description);
+		END`,
+}
+
+// initSearchIndex creates the FTS5 index over card names and descriptions,
+// along with the triggers that keep it in step with the cards table. The
+// triggers need FTS5 too, so without it they are dropped, as a database
+// first opened by a binary with FTS5 would otherwise refuse every card
+// write. The index is rebuilt when it is new or its triggers were missing.
+func initSearchIndex(db *gorm.DB) {
+	if db.Dialector.Name() != "sqlite" {
+		return
+	}
+
+	var hasFTS5 bool
+	if err := db.Raw(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&hasFTS5).Error; err != nil || !hasFTS5 {
+		for name := range ftsTriggers {
+			if err := db.Exec(`DROP TRIGGER IF EXISTS ` + name).Error; err != nil {
+				zap.L().Error("Failed to drop full-text search trigger; card writes may fail", zap.String("trigger", name), zap.Error(err))
+			}
+		}
+		zap.L().Warn("Full-text search unavailable; falling back to LIKE search", zap.Error(err))
+		return
+	}
+
+	names := append(slices.Sorted(maps.Keys(ftsTriggers)), "cards_fts")
+	var existing []string
+	if err := db.Raw(`SELECT name FROM sqlite_master WHERE name IN ?`, names).Scan(&existing).Error; err != nil {
+		zap.L().Warn("Full-text search unavailable; falling back to LIKE search", zap.Error(err))
+		return
+	}
+	stale := len(existing) < len(names)
+
+	statements := []string{`CREATE VIRTUAL TABLE IF NOT EXISTS cards_fts USING fts5(name, description, content='cards', content_rowid='rowid')`}
+	for _, name := range names[:len(ftsTriggers)] {
+		statements = append(statements, ftsTriggers[name])
+	}
+	if stale {
+		statements = append(statements, `INSERT INTO cards_fts(cards_fts) VALUES ('rebuild')`)
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			zap.L().Warn("Full-text search unavailable; falling back to LIKE search", zap.Error(err))
+			return
+		}
+	}
+
+	ftsEnabled = true
+	zap.L().Debug("Full-text search index initialised", zap.Bool("rebuilt", stale))
+}
+
+// SearchCards returns cards whose name or description matches query. User
+// input is only ever passed as a bound parameter.
+func SearchCards(db *gorm.DB, query string, limit int) ([]models.Card, error) {
+	var cards []models.Card
+
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return cards, nil
+	}
+
+	if ftsEnabled {
+		err := db.
+			Joins("JOIN cards_fts ON cards_fts.rowid = cards.rowid").
+			Where("cards_fts MATCH ?", ftsQuery(terms)).
+			Order("cards_fts.rank").
+			Limit(limit).
+			Find(&cards).Error
+		return cards, err
+	}
+
+	tx := db.Model(&models.Card{})
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		tx = tx.Where("(name LIKE ? ESCAPE '\\' OR description LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	err := tx.Order("updated_at DESC").Limit(limit).Find(&cards).Error
+	return cards, err
+}
+
+// ftsQuery quotes each term so FTS5 operators in user input are matched
+// literally, and adds a prefix wildcard so partially typed words still match.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// closeDB closes the database so it can be opened again
+func closeDB(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSearchAfterReopening opens a database holding the search triggers, as
+// one first opened by a binary built with FTS5 does, and checks cards can
+// still be written and found whether or not this binary has FTS5.
+func TestSearchAfterReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cards.db")
+	db := Init(path)
+	if err := SaveCard(db, &models.Card{ID: "c1", Name: "Quarterly report"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, trigger := range ftsTriggers {
+		if err := db.Exec(trigger).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	closeDB(t, db)
+
+	db = Init(path)
+	t.Cleanup(func() { closeDB(t, db) })
+	if err := SaveCard(db, &models.Card{ID: "c2", Name: "Annual report"}); err != nil {
+		t.Fatalf("saving a card: %v", err)
+	}
+	if err := db.Model(&models.Card{ID: "c1"}).Update("name", "Quarterly summary").Error; err != nil {
+		t.Fatalf("updating a card: %v", err)
+	}
+
+	cards, err := SearchCards(db, "report", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].ID != "c2" {
+		t.Errorf("search for report found %v, want c2", cards)
+	}
+}
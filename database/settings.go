@@ -0,0 +1,41 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetSetting returns the stored value for key, or "" if it has never been set.
+func GetSetting(db *gorm.DB, key string) (string, error) {
+	var setting models.Setting
+	err := db.First(&setting, "key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return setting.Value, err
+}
+
+func PutSetting(db *gorm.DB, key, value string) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Setting{Key: key, Value: value}).Error
+}
+
+func DeleteSetting(db *gorm.DB, key string) error {
+	return db.Delete(&models.Setting{}, "key = ?", key).Error
+}
+
+// ListSettings returns the values of every setting whose key starts with
+// prefix, keyed by the rest of the key.
+func ListSettings(db *gorm.DB, prefix string) (map[string]string, error) {
+	var settings []models.Setting
+	if err := db.Where("substr(key, 1, ?) = ?", len(prefix), prefix).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		values[setting.Key[len(prefix):]] = setting.Value
+	}
+	return values, nil
+}
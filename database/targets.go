@@ -0,0 +1,188 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loadChunk bounds the card IDs looked up in one query, under SQLite's limit
+// on bound parameters
+const loadChunk = 500
+
+// mirroredTargets are the targets whose events are held on Card's fields
+var mirroredTargets = []string{models.TargetCalendar, models.TargetTasks}
+
+// ListTargetEvents returns the card's events on pluggable sync targets.
+func ListTargetEvents(db *gorm.DB, cardID string) ([]models.TargetEvent, error) {
+	var events []models.TargetEvent
+	err := db.Where("card_id = ? AND target NOT IN ?", cardID, mirroredTargets).Find(&events).Error
+	return events, err
+}
+
+// GetTargetEvent returns the ID of the card's event on target, or "" if it has none.
+func GetTargetEvent(db *gorm.DB, cardID, target string) (string, error) {
+	var event models.TargetEvent
+	err := db.First(&event, "card_id = ? AND target = ?", cardID, target).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return event.EventID, err
+}
+
+func PutTargetEvent(db *gorm.DB, cardID, target, eventID string) error {
+	return putTargetEvent(db, models.TargetEvent{CardID: cardID, Target: target, EventID: eventID})
+}
+
+func putTargetEvent(db *gorm.DB, event models.TargetEvent) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&event).Error
+}
+
+func DeleteTargetEvent(db *gorm.DB, cardID, target string) error {
+	return db.Delete(&models.TargetEvent{}, "card_id = ? AND target = ?", cardID, target).Error
+}
+
+// FindCardByEvent returns the ID of the card linked to eventID on target, or
+// "" if there is none.
+func FindCardByEvent(db *gorm.DB, target, eventID string) (string, error) {
+	var event models.TargetEvent
+	err := db.First(&event, "target = ? AND event_id = ?", target, eventID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return event.CardID, err
+}
+
+// RelinkTargetEvent stores event if its card exists and has no event on the
+// target, reporting whether it did.
+func RelinkTargetEvent(db *gorm.DB, event models.TargetEvent) (bool, error) {
+	result := db.Exec(`INSERT INTO target_events (card_id, target, event_id, container, updated_at)
+		SELECT id, ?, ?, ?, ? FROM cards WHERE id = ?
+		ON CONFLICT DO NOTHING`, event.Target, event.EventID, event.Container, time.Now(), event.CardID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountCardsWithEvents returns how many cards have an event on target. db
+// may be confined to some cards, as with TenantCards.
+func CountCardsWithEvents(db *gorm.DB, target string) (int64, error) {
+	var count int64
+	err := db.Model(&models.TargetEvent{}).
+		Joins("JOIN cards ON cards.id = target_events.card_id").
+		Where("target_events.target = ?", target).
+		Count(&count).Error
+	return count, err
+}
+
+// LoadCardEvents fills in the cards' Google Calendar events and tasks.
+func LoadCardEvents(db *gorm.DB, cards ...*models.Card) error {
+	byID := make(map[string]*models.Card, len(cards))
+	ids := make([]string, 0, len(cards))
+	for _, card := range cards {
+		card.EventID, card.CalendarID, card.TaskID, card.TaskListID = "", "", "", ""
+		byID[card.ID] = card
+		ids = append(ids, card.ID)
+	}
+
+	for start := 0; start < len(ids); start += loadChunk {
+		var events []models.TargetEvent
+		chunk := ids[start:min(start+loadChunk, len(ids))]
+		if err := db.Where("card_id IN ? AND target IN ?", chunk, mirroredTargets).Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			card := byID[event.CardID]
+			switch event.Target {
+			case models.TargetCalendar:
+				card.EventID, card.CalendarID = event.EventID, event.Container
+			case models.TargetTasks:
+				card.TaskID, card.TaskListID = event.EventID, event.Container
+			}
+		}
+	}
+	return nil
+}
+
+// LoadCardListEvents is LoadCardEvents for a slice of cards.
+func LoadCardListEvents(db *gorm.DB, cards []models.Card) error {
+	pointers := make([]*models.Card, len(cards))
+	for i := range cards {
+		pointers[i] = &cards[i]
+	}
+	return LoadCardEvents(db, pointers...)
+}
+
+// SaveCard stores the card along with its Google Calendar event and task.
+func SaveCard(db *gorm.DB, card *models.Card) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(card).Error; err != nil {
+			return err
+		}
+		return SaveCardEvents(tx, card)
+	})
+}
+
+// SaveCardEvents stores the card's Google Calendar event and task, forgetting
+// those it no longer has.
+func SaveCardEvents(db *gorm.DB, card *models.Card) error {
+	links := []models.TargetEvent{
+		{CardID: card.ID, Target: models.TargetCalendar, EventID: card.EventID, Container: card.CalendarID},
+		{CardID: card.ID, Target: models.TargetTasks, EventID: card.TaskID, Container: card.TaskListID},
+	}
+	for _, link := range links {
+		var err error
+		if link.EventID == "" {
+			err = DeleteTargetEvent(db, link.CardID, link.Target)
+		} else {
+			err = putTargetEvent(db, link)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCard deletes the card and forgets its events on every target.
+func DeleteCard(db *gorm.DB, card *models.Card) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.TargetEvent{}, "card_id = ?", card.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(card).Error
+	})
+}
+
+// migrateCardEvents moves the Google Calendar and Tasks IDs that older
+// versions kept in columns on cards into target_events, and drops the
+// columns.
+func migrateCardEvents(db *gorm.DB) error {
+	migrator := db.Migrator()
+	columns := []struct{ id, container, target string }{
+		{"event_id", "calendar_id", models.TargetCalendar},
+		{"task_id", "task_list_id", models.TargetTasks},
+	}
+	for _, column := range columns {
+		if !migrator.HasColumn(&models.Card{}, column.id) {
+			continue
+		}
+		err := db.Exec(`INSERT INTO target_events (card_id, target, event_id, container, updated_at)
+			SELECT id, ?, `+column.id+`, COALESCE(`+column.container+`, ''), updated_at FROM cards
+			WHERE `+column.id+` IS NOT NULL AND `+column.id+` <> ''
+			ON CONFLICT DO NOTHING`, column.target).Error
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{column.id, column.container} {
+			if !migrator.HasColumn(&models.Card{}, name) {
+				continue
+			}
+			if err := migrator.DropColumn(&models.Card{}, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
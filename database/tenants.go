@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// GoogleTokenCredential names the Google refresh token a tenant granted;
+// each tenant's is suffixed with its name.
+const GoogleTokenCredential = "google.refresh_token"
+
+// GetTenant returns the tenant called name, or nil if there is none.
+func GetTenant(db *gorm.DB, name string) (*models.Tenant, error) {
+	var tenant models.Tenant
+	err := db.First(&tenant, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// ListTenants returns every tenant, in order of name.
+func ListTenants(db *gorm.DB) ([]models.Tenant, error) {
+	var tenants []models.Tenant
+	err := db.Order("name").Find(&tenants).Error
+	return tenants, err
+}
+
+func SaveTenant(db *gorm.DB, tenant *models.Tenant) error {
+	return db.Save(tenant).Error
+}
+
+// DeleteTenant removes the tenant called name along with the credentials
+// named and revokes its API keys, so none of its tokens outlive it.
+func DeleteTenant(db *gorm.DB, name string, credentials ...string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Tenant{}, "name = ?", name)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no tenant named %q", name)
+		}
+		if len(credentials) > 0 {
+			if err := tx.Delete(&models.Credential{}, "name IN ?", credentials).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.APIKey{}).Where("tenant = ? AND revoked_at IS NULL", name).Update("revoked_at", time.Now()).Error
+	})
+}
+
+// TenantCards confines a query on cards to those of tenant, or the
+// operator's own for "".
+func TenantCards(tenant string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("cards.tenant = ?", tenant)
+	}
+}
+
+// AssignCardTenants hands the stored Trello cards on each board in owners,
+// by board ID, to the tenant owning it. Cards on boards a tenant no longer
+// owns stay its own until they're next synced.
+func AssignCardTenants(db *gorm.DB, owners map[string]string) error {
+	byTenant := make(map[string][]string)
+	for boardID, tenant := range owners {
+		byTenant[tenant] = append(byTenant[tenant], boardID)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, tenant := range slices.Sorted(maps.Keys(byTenant)) {
+			err := tx.Model(&models.Card{}).
+				Where("source = '' AND board_id IN ? AND tenant <> ?", byTenant[tenant], tenant).
+				Update("tenant", tenant).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
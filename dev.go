@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/app"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/tunnel"
+	"go.uber.org/zap"
+)
+
+// startDevTunnel starts the dev.tunnel to the address the service will
+// listen on, or to listener if one was passed in.
+func startDevTunnel(ctx context.Context, cfg *config.Config, listener net.Listener) (*tunnel.Tunnel, error) {
+	if cfg.Server.TLS.Enabled() {
+		return nil, errors.New("--dev can't be used with server.tls; the tunnel serves HTTPS itself")
+	}
+	name, err := tunnel.Find(cfg.Dev.Tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	address := app.ListenAddress(cfg.Server)
+	switch {
+	case listener != nil:
+		address = listener.Addr().String()
+	case strings.HasPrefix(address, "unix:"):
+		return nil, errors.New("--dev needs server.listen to be a TCP address")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	zap.L().Info("Starting tunnel for development", zap.String("tunnel", name), zap.String("address", net.JoinHostPort(host, port)))
+	t, err := tunnel.Start(ctx, name, "http://"+net.JoinHostPort(host, port), cfg.Dev.TunnelArgs...)
+	if err != nil {
+		return nil, err
+	}
+	zap.L().Info("Tunnel is up; Trello will deliver webhooks through it", zap.String("publicURL", t.URL))
+	return t, nil
+}
+
+// useTunnel points the public URL at the tunnel and drops the callback URLs
+// set in the config, so they're all derived from it.
+func useTunnel(cfg *config.Config, t *tunnel.Tunnel) {
+	cfg.Server.PublicURL = t.URL
+	cfg.Trello.CallbackURL = ""
+	for i := range cfg.Trello.Accounts {
+		cfg.Trello.Accounts[i].CallbackURL = ""
+	}
+	cfg.Google.Calendar.Watch.CallbackURL = ""
+	cfg.Asana.CallbackURL = ""
+}
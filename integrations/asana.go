@@ -0,0 +1,133 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+)
+
+const (
+	asanaBaseURL  = "https://app.asana.com/api/1.0"
+	asanaPageSize = 100
+)
+
+// asanaTaskFields are the task fields requested from the API
+const asanaTaskFields = "name,notes,due_on,due_at,completed,permalink_url,memberships.project.gid,memberships.section.name"
+
+// AsanaClient reads tasks from Asana and manages the webhooks on projects.
+type AsanaClient struct {
+	client  *http.Client
+	token   string
+	timeout time.Duration
+	baseURL string
+}
+
+// NewAsanaClient checks the asana settings and builds a client from them.
+func NewAsanaClient(cfg config.Asana) (*AsanaClient, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("asana.token is not set")
+	}
+	client := &http.Client{Transport: requestid.Transport(tracing.Transport(http.DefaultTransport, "asana"))}
+	return &AsanaClient{client: client, token: cfg.Token, timeout: cfg.RequestTimeout, baseURL: asanaBaseURL}, nil
+}
+
+// call makes a request, decoding the "data" member of the response into out
+func (c *AsanaClient) call(ctx context.Context, method, path string, params url.Values, body, out any) error {
+	target := c.baseURL + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	call := restCall{
+		API:     "Asana",
+		Method:  method,
+		URL:     target,
+		Header:  http.Header{"Authorization": {"Bearer " + c.token}},
+		Body:    body,
+		Timeout: c.timeout,
+	}
+	if out != nil {
+		call.Out = &struct {
+			Data any `json:"data"`
+		}{out}
+	}
+	return call.do(ctx, c.client)
+}
+
+// asanaList fetches every page of a collection
+func asanaList[T any](ctx context.Context, c *AsanaClient, path string, params url.Values) ([]T, error) {
+	params.Set("limit", strconv.Itoa(asanaPageSize))
+	var all []T
+	for {
+		var page struct {
+			Data     []T `json:"data"`
+			NextPage *struct {
+				Offset string `json:"offset"`
+			} `json:"next_page"`
+		}
+		err := restCall{
+			API:     "Asana",
+			Method:  http.MethodGet,
+			URL:     c.baseURL + path + "?" + params.Encode(),
+			Header:  http.Header{"Authorization": {"Bearer " + c.token}},
+			Out:     &page,
+			Timeout: c.timeout,
+		}.do(ctx, c.client)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		if page.NextPage == nil || page.NextPage.Offset == "" {
+			return all, nil
+		}
+		params.Set("offset", page.NextPage.Offset)
+	}
+}
+
+// GetTask returns the task with the GID gid.
+func (c *AsanaClient) GetTask(ctx context.Context, gid string) (models.AsanaTask, error) {
+	var task models.AsanaTask
+	err := c.call(ctx, http.MethodGet, "/tasks/"+url.PathEscape(gid), url.Values{"opt_fields": {asanaTaskFields}}, nil, &task)
+	return task, err
+}
+
+// ListOpenTasks returns the project's incomplete tasks.
+func (c *AsanaClient) ListOpenTasks(ctx context.Context, projectGID string) ([]models.AsanaTask, error) {
+	params := url.Values{"opt_fields": {asanaTaskFields}, "completed_since": {"now"}}
+	return asanaList[models.AsanaTask](ctx, c, "/projects/"+url.PathEscape(projectGID)+"/tasks", params)
+}
+
+// ListWebhooks returns the webhooks on resource in the workspace.
+func (c *AsanaClient) ListWebhooks(ctx context.Context, workspace, resource string) ([]models.AsanaWebhook, error) {
+	params := url.Values{"workspace": {workspace}, "resource": {resource}, "opt_fields": {"active,target,resource.gid"}}
+	return asanaList[models.AsanaWebhook](ctx, c, "/webhooks", params)
+}
+
+// CreateWebhook registers a webhook on the tasks of resource. Asana confirms
+// it with a handshake request to target before this returns.
+func (c *AsanaClient) CreateWebhook(ctx context.Context, resource, target string) (models.AsanaWebhook, error) {
+	body := map[string]any{"data": map[string]any{
+		"resource": resource,
+		"target":   target,
+		"filters":  []map[string]string{{"resource_type": "task"}},
+	}}
+	var webhook models.AsanaWebhook
+	err := c.call(ctx, http.MethodPost, "/webhooks", nil, body, &webhook)
+	return webhook, err
+}
+
+// DeleteWebhook removes the webhook with the GID gid.
+func (c *AsanaClient) DeleteWebhook(ctx context.Context, gid string) error {
+	err := c.call(ctx, http.MethodDelete, "/webhooks/"+url.PathEscape(gid), nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
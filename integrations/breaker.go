@@ -0,0 +1,192 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned instead of calling an API whose circuit breaker
+// has tripped.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// One breaker per dependency, shared by every client that calls it
+var (
+	googleBreaker = NewCircuitBreaker("google")
+	trelloBreaker = NewCircuitBreaker("trello")
+)
+
+// ConfigureBreakers applies the google.circuit_breaker and
+// trello.circuit_breaker settings to the shared breakers.
+func ConfigureBreakers(google, trello config.Breaker) {
+	googleBreaker.Configure(google)
+	trelloBreaker.Configure(trello)
+}
+
+// BreakerStates reports whether each external API is currently being called
+// ("closed"), skipped ("open"), or probed for recovery ("half_open").
+func BreakerStates() map[string]string {
+	return map[string]string{
+		googleBreaker.name: googleBreaker.State(),
+		trelloBreaker.name: trelloBreaker.State(),
+	}
+}
+
+// CircuitBreaker stops calls to an API after FailureThreshold consecutive
+// failures. Once the cooldown has passed a single call is let through as a
+// probe; it closes the breaker if it succeeds and re-opens it otherwise. A
+// threshold of 0 disables the breaker.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	name     string
+	settings config.Breaker
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(name string) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:     name,
+		settings: config.Breaker{FailureThreshold: defaultBreakerThreshold},
+		state:    breakerClosed,
+	}
+}
+
+// Configure replaces the breaker's threshold and cooldown.
+func (b *CircuitBreaker) Configure(settings config.Breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = settings
+}
+
+// cooldown is how long the breaker stays open. Callers hold mu.
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.settings.Cooldown > 0 {
+		return b.settings.Cooldown
+	}
+	return defaultBreakerCooldown
+}
+
+// Allow returns ErrCircuitOpen if the call should be skipped.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.settings.FailureThreshold <= 0 {
+		return nil
+	}
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown() {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		zap.L().Info("Probing whether API has recovered", zap.String("api", b.name))
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen // Wait for the probe's result
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record reports the outcome of a call let through by Allow.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state != breakerClosed {
+			zap.L().Info("API recovered; closing circuit breaker", zap.String("api", b.name))
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.settings.FailureThreshold) {
+		zap.L().Error("API keeps failing; pausing calls to it",
+			zap.String("api", b.name),
+			zap.Int("consecutiveFailures", b.failures),
+			zap.Duration("cooldown", b.cooldown()),
+		)
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakerTransport guards every request made through an HTTP client. Network
+// errors, server errors and rate limiting count as failures; other responses
+// show the API is up.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *CircuitBreaker
+}
+
+func withBreaker(client *http.Client, breaker *CircuitBreaker) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &breakerTransport{next: next, breaker: breaker}
+	return client
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case errors.Is(req.Context().Err(), context.Canceled):
+		// Abandoned by the caller rather than failed by the API; free up
+		// the probe without counting it either way
+		t.breaker.release()
+	case err != nil:
+		t.breaker.Record(true)
+	default:
+		t.breaker.Record(resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests)
+	}
+	return resp, err
+}
+
+// release lets another call probe a half-open breaker
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
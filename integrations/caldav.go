@@ -0,0 +1,299 @@
+package integrations
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/ical"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+)
+
+// TargetCalDAV is the sync target name of the CalDAV calendar.
+const TargetCalDAV = "caldav"
+
+func init() {
+	RegisterTarget(TargetCalDAV, func(cfg *config.Config) (SyncTarget, error) {
+		return NewCalDAVClient(cfg.Targets.CalDAV)
+	})
+}
+
+// CalDAVClient syncs cards to a CalDAV calendar collection. Each card is
+// stored as <card ID>.ics holding one VEVENT whose UID is the card ID; the
+// object's URL is the event ID.
+type CalDAVClient struct {
+	cfg        config.CalDAV
+	client     *http.Client
+	collection *url.URL
+}
+
+// NewCalDAVClient checks the caldav settings and builds a client from them.
+func NewCalDAVClient(cfg config.CalDAV) (*CalDAVClient, error) {
+	collection, err := url.Parse(cfg.URL)
+	if err != nil || (collection.Scheme != "http" && collection.Scheme != "https") || collection.Host == "" {
+		return nil, errors.New("targets.caldav.url must be the http or https URL of a calendar")
+	}
+	if !strings.HasSuffix(collection.Path, "/") {
+		collection.Path += "/"
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	switch strings.ToLower(cfg.Auth) {
+	case "", "basic":
+		transport = &basicAuthTransport{next: transport, username: cfg.Username, password: cfg.Password}
+	case "digest":
+		transport = &digestAuthTransport{next: transport, username: cfg.Username, password: cfg.Password}
+	default:
+		return nil, fmt.Errorf("invalid targets.caldav.auth %q: must be basic or digest", cfg.Auth)
+	}
+	client := &http.Client{Transport: requestid.Transport(tracing.Transport(transport, "caldav"))}
+	return &CalDAVClient{cfg: cfg, client: client, collection: collection}, nil
+}
+
+// calendarObject renders card as a calendar object resource
+func calendarObject(card models.Card) []byte {
+	return []byte(ical.Calendar{Events: []ical.Event{{
+		UID:         card.ID,
+		Summary:     card.Name,
+		Description: card.Description,
+		URL:         card.URL,
+		Date:        *card.DueDate,
+		Modified:    time.Now(),
+	}}}.Render())
+}
+
+func (c *CalDAVClient) put(ctx context.Context, objectURL string, card models.Card) error {
+	return restCall{
+		API:     "CalDAV",
+		Method:  http.MethodPut,
+		URL:     objectURL,
+		Header:  http.Header{"Content-Type": {"text/calendar; charset=utf-8"}, "Accept": {"*/*"}},
+		Body:    calendarObject(card),
+		Timeout: c.cfg.RequestTimeout,
+	}.do(ctx, c.client)
+}
+
+func (c *CalDAVClient) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot create event")
+	}
+
+	objectURL := c.collection.JoinPath(card.ID + ".ics").String()
+	// The object is named after the card, so one left behind by an earlier
+	// sync is simply overwritten
+	if err := c.put(ctx, objectURL, card); err != nil {
+		return "", fmt.Errorf("unable to create event on CalDAV server: %w", err)
+	}
+	return objectURL, nil
+}
+
+func (c *CalDAVClient) UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot update event")
+	}
+	// Overwriting the object recreates it if it was deleted meanwhile
+	if err := c.put(ctx, eventID, card); err != nil {
+		return "", fmt.Errorf("unable to update event on CalDAV server: %w", err)
+	}
+	return eventID, nil
+}
+
+func (c *CalDAVClient) DeleteEvent(ctx context.Context, card models.Card, eventID string) error {
+	err := restCall{
+		API:     "CalDAV",
+		Method:  http.MethodDelete,
+		URL:     eventID,
+		Header:  http.Header{"Accept": {"*/*"}},
+		Timeout: c.cfg.RequestTimeout,
+	}.do(ctx, c.client)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to delete event from CalDAV server: %w", err)
+	}
+	return nil
+}
+
+type basicAuthTransport struct {
+	next               http.RoundTripper
+	username, password string
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, t.password)
+	return t.next.RoundTrip(req)
+}
+
+// digestAuthTransport answers HTTP Digest challenges (RFC 7616) with qop
+// "auth", remembering the last challenge so later requests skip the 401.
+type digestAuthTransport struct {
+	next               http.RoundTripper
+	username, password string
+
+	mu        sync.Mutex
+	challenge map[string]string
+	count     int // Requests made with the challenge's nonce
+}
+
+func (t *digestAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth, ok := t.authorization(req); ok {
+		signed := req.Clone(req.Context())
+		signed.Header.Set("Authorization", auth)
+		resp, err := t.next.RoundTrip(signed)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		// The nonce went stale; take the new challenge below
+		t.challengeFrom(resp)
+		resp.Body.Close()
+	} else {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || !t.challengeFrom(resp) {
+			return resp, err
+		}
+		resp.Body.Close()
+	}
+
+	auth, ok := t.authorization(req)
+	if !ok {
+		return nil, errors.New("caldav: server's digest challenge is unsupported")
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", auth)
+	return t.next.RoundTrip(retry)
+}
+
+// challengeFrom stores the Digest challenge in resp, reporting whether it
+// had one
+func (t *digestAuthTransport) challengeFrom(resp *http.Response) bool {
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		t.mu.Lock()
+		t.challenge = parseAuthParams(params)
+		t.count = 0
+		t.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+// authorization builds the Authorization header for req from the stored
+// challenge, if there is a usable one
+func (t *digestAuthTransport) authorization(req *http.Request) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.challenge == nil {
+		return "", false
+	}
+	c := t.challenge
+
+	var newHash func() hash.Hash
+	algorithm := c["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", false
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	t.count++
+	nc := fmt.Sprintf("%08x", t.count)
+	cnonce := rand.Text()
+	uri := req.URL.RequestURI()
+	ha1 := digest(t.username, c["realm"], t.password)
+	ha2 := digest(req.Method, uri)
+
+	var response string
+	qop := ""
+	for option := range strings.SplitSeq(c["qop"], ",") {
+		if strings.TrimSpace(option) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		response = digest(ha1, c["nonce"], nc, cnonce, qop, ha2)
+	} else {
+		response = digest(ha1, c["nonce"], ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username=%q`, t.username),
+		fmt.Sprintf(`realm=%q`, c["realm"]),
+		fmt.Sprintf(`nonce=%q`, c["nonce"]),
+		fmt.Sprintf(`uri=%q`, uri),
+		fmt.Sprintf(`response=%q`, response),
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	if c["opaque"] != "" {
+		fields = append(fields, fmt.Sprintf(`opaque=%q`, c["opaque"]))
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce=%q`, cnonce))
+	}
+	return "Digest " + strings.Join(fields, ", "), true
+}
+
+// parseAuthParams splits a challenge's comma-separated key=value pairs,
+// whose values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, ", ") {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var quoted strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				quoted.WriteByte(rest[i])
+			}
+			value = quoted.String()
+			rest = rest[min(i+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+		s = rest
+	}
+	return params
+}
+
+var _ SyncTarget = (*CalDAVClient)(nil)
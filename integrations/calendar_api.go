@@ -0,0 +1,41 @@
+package integrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalendarAPI is the part of Google Calendar the service syncs with.
+// CalendarClient implements it against the Google API; calendartest.Fake
+// implements it in memory for exercising the sync without credentials.
+type CalendarAPI interface {
+	// Configure replaces the google settings calls work from
+	Configure(cfg *config.Google)
+	ResolveCalendarID(ref string) string
+	ConfiguredCalendarIDs() []string
+	CalendarFor(card models.Card) string
+
+	// UpdateEvent recreates events that no longer exist; DeleteEvent treats
+	// them as deleted
+	CreateEvent(ctx context.Context, card models.Card) (*calendar.Event, error)
+	UpdateEvent(ctx context.Context, card models.Card, eventID string) (*calendar.Event, error)
+	DeleteEvent(ctx context.Context, calendarID, eventID string) error
+	MoveEvent(ctx context.Context, eventID, fromCalendarID, toCalendarID string) (*calendar.Event, error)
+	ApplyBatch(ctx context.Context, ops []EventOp) ([]EventOpResult, BatchSummary)
+
+	CalendarExists(ctx context.Context, calendarID string) bool
+	EnsureCalendar(ctx context.Context, name string) (string, error)
+	ListManagedEvents(ctx context.Context, calendarID string) ([]*calendar.Event, error)
+
+	WatchEvents(ctx context.Context, calendarID, channelID, address, token string, ttl time.Duration) (*calendar.Channel, error)
+	StopChannel(ctx context.Context, channelID, resourceID string) error
+	ListChangedEvents(ctx context.Context, calendarID, syncToken string) ([]*calendar.Event, string, error)
+
+	Usage() UsageSnapshot
+}
+
+var _ CalendarAPI = (*CalendarClient)(nil)
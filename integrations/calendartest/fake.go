@@ -0,0 +1,407 @@
+// Package calendartest provides an in-memory implementation of
+// integrations.CalendarAPI for exercising the sync without Google
+// credentials.
+package calendartest
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Fake is a CalendarAPI that keeps events in memory, keyed by calendar and
+// event ID, and records every change made to them in Created, Updated and
+// Deleted. Calendar IDs resolve from the google settings it is configured
+// with, as the real client's do.
+type Fake struct {
+	mu  sync.Mutex
+	cfg *config.Google
+
+	Calendars map[string]string                     // Calendar names by ID
+	Events    map[string]map[string]*calendar.Event // By calendar ID, then event ID
+
+	// The events created, updated and deleted, in order. Updates of events
+	// that no longer exist are recorded as creates, as the real client
+	// recreates them.
+	Created []Change
+	Updated []Change
+	Deleted []Change
+
+	// Errors makes the named method fail with the given error
+	Errors map[string]error
+
+	// Calls records each method called, with its first argument
+	Calls []string
+
+	nextID  int
+	changes []Change // Every change, for ListChangedEvents
+}
+
+// Change is one event written to or deleted from a calendar.
+type Change struct {
+	CalendarID string
+	EventID    string
+	CardID     string
+	Event      *calendar.Event // As written; cancelled for deletes
+}
+
+var _ integrations.CalendarAPI = (*Fake)(nil)
+
+// NewFake returns an empty fake working from cfg. Its default calendar is
+// created, so CalendarExists reports it.
+func NewFake(cfg *config.Google) *Fake {
+	f := &Fake{
+		cfg:       cfg,
+		Calendars: make(map[string]string),
+		Events:    make(map[string]map[string]*calendar.Event),
+		Errors:    make(map[string]error),
+	}
+	if id := cfg.Calendar.CalendarID; id != "" {
+		f.Calendars[id] = integrations.DefaultCalendarName
+	}
+	return f
+}
+
+// NotFound returns an error matching integrations.ErrNotFound, as the real
+// client produces for a 404.
+func NotFound(what string) error {
+	return fmt.Errorf("%s: %w", what, integrations.ErrNotFound)
+}
+
+// Event returns the event eventID on calendarID.
+func (f *Fake) Event(calendarID, eventID string) (*calendar.Event, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	event, ok := f.Events[calendarID][eventID]
+	return event, ok
+}
+
+// CardEvents returns the events synced from the card, on any calendar.
+func (f *Fake) CardEvents(cardID string) []*calendar.Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []*calendar.Event
+	for _, calendarID := range slices.Sorted(maps.Keys(f.Events)) {
+		for _, event := range f.Events[calendarID] {
+			if integrations.EventCardID(event) == cardID {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// record logs the call and returns any injected error, or the context's
+// error once it is cancelled; callers hold f.mu
+func (f *Fake) record(ctx context.Context, method, arg string) error {
+	f.Calls = append(f.Calls, method+" "+arg)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Errors[method]
+}
+
+func (f *Fake) newID() string {
+	f.nextID++
+	return fmt.Sprintf("event%06d", f.nextID)
+}
+
+// write stores event on calendarID and logs the change; callers hold f.mu
+func (f *Fake) write(calendarID string, event *calendar.Event) Change {
+	if f.Events[calendarID] == nil {
+		f.Events[calendarID] = make(map[string]*calendar.Event)
+	}
+	f.Events[calendarID][event.Id] = event
+	change := Change{CalendarID: calendarID, EventID: event.Id, CardID: integrations.EventCardID(event), Event: clone(event)}
+	f.changes = append(f.changes, change)
+	return change
+}
+
+func clone(event *calendar.Event) *calendar.Event {
+	copied := *event
+	if event.ExtendedProperties != nil {
+		props := *event.ExtendedProperties
+		props.Private = maps.Clone(event.ExtendedProperties.Private)
+		copied.ExtendedProperties = &props
+	}
+	return &copied
+}
+
+// fillEvent sets the fields the real client derives from the card
+func fillEvent(event *calendar.Event, card models.Card) {
+	event.Summary = card.Name
+	event.Description = card.Description
+	event.Source = &calendar.EventSource{Title: "Open in Trello", Url: card.URL}
+	event.Start = &calendar.EventDateTime{Date: card.DueDate.Format("2006-01-02")}
+	event.End = &calendar.EventDateTime{Date: card.DueDate.AddDate(0, 0, 1).Format("2006-01-02")}
+	event.Status = "confirmed"
+	event.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	event.ExtendedProperties = &calendar.EventExtendedProperties{Private: map[string]string{
+		integrations.PropSource:  integrations.PropSourceValue,
+		integrations.PropCardID:  card.ID,
+		integrations.PropBoardID: card.BoardID,
+	}}
+}
+
+func (f *Fake) Configure(cfg *config.Google) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg = cfg
+}
+
+func (f *Fake) ResolveCalendarID(ref string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ref == "" {
+		return f.cfg.Calendar.CalendarID
+	}
+	if id, ok := f.cfg.Calendars[strings.ToLower(ref)]; ok {
+		return id
+	}
+	return ref
+}
+
+func (f *Fake) ConfiguredCalendarIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := []string{f.cfg.Calendar.CalendarID}
+	for _, id := range f.cfg.Calendars {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (f *Fake) CalendarFor(card models.Card) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calendarFor(card)
+}
+
+func (f *Fake) calendarFor(card models.Card) string {
+	if card.CalendarID != "" {
+		return card.CalendarID
+	}
+	return f.cfg.Calendar.CalendarID
+}
+
+func (f *Fake) CreateEvent(ctx context.Context, card models.Card) (*calendar.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "CreateEvent", card.ID); err != nil {
+		return nil, err
+	}
+	return f.create(card)
+}
+
+func (f *Fake) create(card models.Card) (*calendar.Event, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot create event")
+	}
+	calendarID := f.calendarFor(card)
+	if calendarID == "" {
+		return nil, fmt.Errorf("google calendar ID is not configured")
+	}
+	event := &calendar.Event{Id: f.newID()}
+	fillEvent(event, card)
+	f.Created = append(f.Created, f.write(calendarID, event))
+	return clone(event), nil
+}
+
+func (f *Fake) UpdateEvent(ctx context.Context, card models.Card, eventID string) (*calendar.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "UpdateEvent", eventID); err != nil {
+		return nil, err
+	}
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot update event")
+	}
+	calendarID := f.calendarFor(card)
+	existing, ok := f.Events[calendarID][eventID]
+	if !ok {
+		return f.create(card)
+	}
+	event := clone(existing)
+	fillEvent(event, card)
+	f.Updated = append(f.Updated, f.write(calendarID, event))
+	return clone(event), nil
+}
+
+func (f *Fake) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "DeleteEvent", eventID); err != nil {
+		return err
+	}
+	if calendarID == "" {
+		calendarID = f.cfg.Calendar.CalendarID
+	}
+	f.delete(calendarID, eventID)
+	return nil
+}
+
+// delete removes the event, logging the deletion if it existed; callers hold
+// f.mu
+func (f *Fake) delete(calendarID, eventID string) {
+	event, ok := f.Events[calendarID][eventID]
+	if !ok {
+		return
+	}
+	delete(f.Events[calendarID], eventID)
+	cancelled := clone(event)
+	cancelled.Status = "cancelled"
+	change := Change{CalendarID: calendarID, EventID: eventID, CardID: integrations.EventCardID(event), Event: cancelled}
+	f.changes = append(f.changes, change)
+	f.Deleted = append(f.Deleted, change)
+}
+
+func (f *Fake) MoveEvent(ctx context.Context, eventID, fromCalendarID, toCalendarID string) (*calendar.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "MoveEvent", eventID); err != nil {
+		return nil, err
+	}
+	if fromCalendarID == "" {
+		fromCalendarID = f.cfg.Calendar.CalendarID
+	}
+	event, ok := f.Events[fromCalendarID][eventID]
+	if !ok {
+		return nil, fmt.Errorf("unable to move event between calendars: %w", NotFound("event "+eventID))
+	}
+	// Deleted from one and written to the other, as a sync of each sees it
+	f.delete(fromCalendarID, eventID)
+	moved := clone(event)
+	moved.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+	f.Updated = append(f.Updated, f.write(toCalendarID, moved))
+	return clone(moved), nil
+}
+
+// ApplyBatch applies the ops one at a time, in order.
+func (f *Fake) ApplyBatch(ctx context.Context, ops []integrations.EventOp) ([]integrations.EventOpResult, integrations.BatchSummary) {
+	return integrations.ApplyEventOps(ctx, f, 1, ops)
+}
+
+func (f *Fake) CalendarExists(ctx context.Context, calendarID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "CalendarExists", calendarID); err != nil {
+		return false
+	}
+	_, ok := f.Calendars[calendarID]
+	return ok
+}
+
+func (f *Fake) EnsureCalendar(ctx context.Context, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "EnsureCalendar", name); err != nil {
+		return "", err
+	}
+	for id, existing := range f.Calendars {
+		if existing == name {
+			return id, nil
+		}
+	}
+	f.nextID++
+	id := fmt.Sprintf("calendar%06d@group.calendar.google.com", f.nextID)
+	f.Calendars[id] = name
+	return id, nil
+}
+
+func (f *Fake) ListManagedEvents(ctx context.Context, calendarID string) ([]*calendar.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListManagedEvents", calendarID); err != nil {
+		return nil, err
+	}
+	var events []*calendar.Event
+	for _, id := range slices.Sorted(maps.Keys(f.Events[calendarID])) {
+		if event := f.Events[calendarID][id]; integrations.EventCardID(event) != "" {
+			events = append(events, clone(event))
+		}
+	}
+	return events, nil
+}
+
+func (f *Fake) WatchEvents(ctx context.Context, calendarID, channelID, address, token string, ttl time.Duration) (*calendar.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "WatchEvents", calendarID); err != nil {
+		return nil, err
+	}
+	return &calendar.Channel{
+		Id:         channelID,
+		ResourceId: "resource-" + calendarID,
+		Address:    address,
+		Token:      token,
+		Expiration: time.Now().Add(ttl).UnixMilli(),
+	}, nil
+}
+
+func (f *Fake) StopChannel(ctx context.Context, channelID, resourceID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record(ctx, "StopChannel", channelID)
+}
+
+// ListChangedEvents lists every event on calendarID for an empty syncToken,
+// and otherwise the latest version of each event changed since the token was
+// issued, deleted ones included as cancelled.
+func (f *Fake) ListChangedEvents(ctx context.Context, calendarID, syncToken string) ([]*calendar.Event, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListChangedEvents", calendarID); err != nil {
+		return nil, "", err
+	}
+	next := strconv.Itoa(len(f.changes))
+	if syncToken == "" {
+		var events []*calendar.Event
+		for _, id := range slices.Sorted(maps.Keys(f.Events[calendarID])) {
+			events = append(events, clone(f.Events[calendarID][id]))
+		}
+		return events, next, nil
+	}
+
+	since, err := strconv.Atoi(syncToken)
+	if err != nil || since > len(f.changes) {
+		return nil, "", integrations.ErrSyncTokenExpired
+	}
+	var events []*calendar.Event
+	latest := make(map[string]int) // Index in events, by event ID
+	for _, change := range f.changes[since:] {
+		if change.CalendarID != calendarID {
+			continue
+		}
+		if i, ok := latest[change.EventID]; ok {
+			events[i] = clone(change.Event)
+			continue
+		}
+		latest[change.EventID] = len(events)
+		events = append(events, clone(change.Event))
+	}
+	return events, next, nil
+}
+
+func (f *Fake) Usage() integrations.UsageSnapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	usage := integrations.UsageSnapshot{Calls: make(map[string]int64), Errors: make(map[string]int64)}
+	for _, call := range f.Calls {
+		method, _, _ := strings.Cut(call, " ")
+		usage.Calls[method]++
+		usage.Total++
+	}
+	return usage
+}
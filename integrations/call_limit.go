@@ -0,0 +1,154 @@
+package integrations
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const callLimitWindow = time.Hour
+
+// CallLimitError is returned instead of calling Google as a tenant that has
+// made all the calls its limit allows this hour. It is classified as rate
+// limiting, so the work is retried once the hour is up.
+type CallLimitError struct {
+	Tenant     string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *CallLimitError) Error() string {
+	return fmt.Sprintf("tenant %s has made the %d Google calls it is allowed an hour; retry after %s", e.Tenant, e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+// One limit per tenant, shared by every client acting as its Google account
+var (
+	callLimitsMu sync.Mutex
+	callLimits   = make(map[string]*CallLimit)
+)
+
+// TenantCallLimit returns the tenant's hourly limit on Google calls, set to
+// perHour; 0 leaves its calls unlimited.
+func TenantCallLimit(tenant string, perHour int) *CallLimit {
+	callLimitsMu.Lock()
+	defer callLimitsMu.Unlock()
+	limit, ok := callLimits[tenant]
+	if !ok {
+		limit = &CallLimit{tenant: tenant}
+		callLimits[tenant] = limit
+	}
+	limit.Configure(perHour)
+	return limit
+}
+
+// TenantCallUsage reports the Google calls each tenant synced since startup
+// has made this hour, by tenant name.
+func TenantCallUsage() map[string]CallLimitSnapshot {
+	callLimitsMu.Lock()
+	limits := maps.Clone(callLimits)
+	callLimitsMu.Unlock()
+
+	usage := make(map[string]CallLimitSnapshot, len(limits))
+	for tenant, limit := range limits {
+		usage[tenant] = limit.Snapshot()
+	}
+	return usage
+}
+
+type CallLimitSnapshot struct {
+	Limit    int        `json:"limit,omitempty"`
+	Calls    int64      `json:"calls"`
+	Refused  int64      `json:"refused"`
+	ResetsAt *time.Time `json:"resets_at,omitempty"`
+}
+
+// CallLimit counts a tenant's calls to Google in hour-long windows, starting
+// with the first call after the last one ended, and refuses those past the
+// limit until the window ends.
+type CallLimit struct {
+	mu      sync.Mutex
+	tenant  string
+	perHour int
+	start   time.Time
+	calls   int64
+	refused int64
+}
+
+// Configure replaces the number of calls allowed an hour.
+func (l *CallLimit) Configure(perHour int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perHour = perHour
+}
+
+// Allow counts a call, or returns a *CallLimitError if the limit is reached.
+func (l *CallLimit) Allow() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.rollover(now)
+	if l.start.IsZero() {
+		l.start = now
+	}
+	if l.perHour > 0 && l.calls >= int64(l.perHour) {
+		if l.refused == 0 {
+			zap.L().Warn("Tenant reached its hourly limit of Google calls; holding its syncs", zap.String("tenant", l.tenant), zap.Int("limit", l.perHour), zap.Time("resetsAt", l.start.Add(callLimitWindow)))
+		}
+		l.refused++
+		return &CallLimitError{Tenant: l.tenant, Limit: l.perHour, RetryAfter: l.start.Add(callLimitWindow).Sub(now)}
+	}
+	l.calls++
+	return nil
+}
+
+func (l *CallLimit) Snapshot() CallLimitSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rollover(time.Now())
+	snapshot := CallLimitSnapshot{Limit: l.perHour, Calls: l.calls, Refused: l.refused}
+	if !l.start.IsZero() {
+		resetsAt := l.start.Add(callLimitWindow)
+		snapshot.ResetsAt = &resetsAt
+	}
+	return snapshot
+}
+
+// rollover forgets the last window once it has ended. Callers hold mu.
+func (l *CallLimit) rollover(now time.Time) {
+	if l.start.IsZero() || now.Sub(l.start) < callLimitWindow {
+		return
+	}
+	l.start = time.Time{}
+	l.calls = 0
+	l.refused = 0
+}
+
+// callLimitTransport checks every request made through an HTTP client against
+// the limit. It sits outside the circuit breaker, so refused calls don't count
+// as Google failing.
+type callLimitTransport struct {
+	next  http.RoundTripper
+	limit *CallLimit
+}
+
+func withCallLimit(client *http.Client, limit *CallLimit) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &callLimitTransport{next: next, limit: limit}
+	return client
+}
+
+func (t *callLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limit.Allow(); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
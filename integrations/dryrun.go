@@ -0,0 +1,240 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/tasks/v1"
+)
+
+// dryRunID is the ID given to what a dry run pretends to create for key, made
+// recognisable in the database and logs
+func dryRunID(key string) string {
+	return "dry-run-" + key
+}
+
+// DryRunCalendar is a CalendarAPI that logs the changes it is asked to make
+// and returns what Google would have, without making them. Lookups go to the
+// wrapped CalendarAPI.
+type DryRunCalendar struct {
+	CalendarAPI
+	cfg atomic.Pointer[config.Google]
+}
+
+// NewDryRunCalendar wraps c in a dry run.
+func NewDryRunCalendar(c CalendarAPI) *DryRunCalendar {
+	return &DryRunCalendar{CalendarAPI: c}
+}
+
+var _ CalendarAPI = (*DryRunCalendar)(nil)
+
+func (d *DryRunCalendar) Configure(cfg *config.Google) {
+	d.cfg.Store(cfg)
+	d.CalendarAPI.Configure(cfg)
+}
+
+// event builds the event the card would be written as
+func (d *DryRunCalendar) event(card models.Card, eventID string) *calendar.Event {
+	event := &calendar.Event{Id: eventID, Status: "confirmed"}
+	applyCard(d.cfg.Load(), event, card)
+	return event
+}
+
+func (d *DryRunCalendar) CreateEvent(ctx context.Context, card models.Card) (*calendar.Event, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot create event")
+	}
+	event := d.event(card, dryRunID(card.ID))
+	logging.FromContext(ctx).Info("Dry run: would have created event in Google Calendar",
+		zap.String("cardID", card.ID), zap.String("calendarID", d.CalendarFor(card)), zap.String("summary", event.Summary), zap.String("date", event.Start.Date))
+	return event, nil
+}
+
+func (d *DryRunCalendar) UpdateEvent(ctx context.Context, card models.Card, eventID string) (*calendar.Event, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot update event")
+	}
+	event := d.event(card, eventID)
+	logging.FromContext(ctx).Info("Dry run: would have updated event in Google Calendar",
+		zap.String("cardID", card.ID), zap.String("eventID", eventID), zap.String("calendarID", d.CalendarFor(card)), zap.String("summary", event.Summary), zap.String("date", event.Start.Date))
+	return event, nil
+}
+
+func (d *DryRunCalendar) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	logging.FromContext(ctx).Info("Dry run: would have deleted event from Google Calendar", zap.String("eventID", eventID), zap.String("calendarID", calendarID))
+	return nil
+}
+
+func (d *DryRunCalendar) MoveEvent(ctx context.Context, eventID, fromCalendarID, toCalendarID string) (*calendar.Event, error) {
+	logging.FromContext(ctx).Info("Dry run: would have moved event to another calendar",
+		zap.String("eventID", eventID), zap.String("fromCalendarID", fromCalendarID), zap.String("toCalendarID", toCalendarID))
+	return &calendar.Event{Id: eventID}, nil
+}
+
+func (d *DryRunCalendar) ApplyBatch(ctx context.Context, ops []EventOp) ([]EventOpResult, BatchSummary) {
+	return ApplyEventOps(ctx, d, 1, ops)
+}
+
+// EnsureCalendar fails, since it can't tell finding the calendar from
+// creating it; a dry run needs the calendar's ID configured or already
+// remembered.
+func (d *DryRunCalendar) EnsureCalendar(ctx context.Context, name string) (string, error) {
+	return "", fmt.Errorf("a dry run can't look up or create calendar %q; set google.calendar.calendar_id to its ID", name)
+}
+
+func (d *DryRunCalendar) WatchEvents(ctx context.Context, calendarID, channelID, address, token string, ttl time.Duration) (*calendar.Channel, error) {
+	logging.FromContext(ctx).Info("Dry run: would have opened Google Calendar watch channel",
+		zap.String("calendarID", calendarID), zap.String("channelID", channelID), zap.String("address", address))
+	return &calendar.Channel{
+		Id:         channelID,
+		ResourceId: dryRunID(calendarID),
+		Address:    address,
+		Token:      token,
+		Expiration: time.Now().Add(ttl).UnixMilli(),
+	}, nil
+}
+
+func (d *DryRunCalendar) StopChannel(ctx context.Context, channelID, resourceID string) error {
+	logging.FromContext(ctx).Info("Dry run: would have stopped Google Calendar watch channel", zap.String("channelID", channelID))
+	return nil
+}
+
+// DryRunTasks is a TasksAPI that logs the changes it is asked to make and
+// returns what Google would have, without making them.
+type DryRunTasks struct {
+	TasksAPI
+}
+
+var _ TasksAPI = DryRunTasks{}
+
+func (d DryRunTasks) CreateTask(ctx context.Context, card models.Card) (*tasks.Task, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot create task")
+	}
+	task := buildTask(card)
+	task.Id = dryRunID(card.ID)
+	logging.FromContext(ctx).Info("Dry run: would have created task in Google Tasks",
+		zap.String("cardID", card.ID), zap.String("taskListID", d.TaskListFor(card)), zap.String("title", task.Title), zap.String("due", task.Due))
+	return task, nil
+}
+
+func (d DryRunTasks) UpdateTask(ctx context.Context, card models.Card, taskID string) (*tasks.Task, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot update task")
+	}
+	task := buildTask(card)
+	task.Id = taskID
+	logging.FromContext(ctx).Info("Dry run: would have updated task in Google Tasks",
+		zap.String("cardID", card.ID), zap.String("taskID", taskID), zap.String("title", task.Title), zap.String("due", task.Due))
+	return task, nil
+}
+
+func (d DryRunTasks) DeleteTask(ctx context.Context, taskListID, taskID string) error {
+	logging.FromContext(ctx).Info("Dry run: would have deleted task from Google Tasks", zap.String("taskID", taskID), zap.String("taskListID", taskListID))
+	return nil
+}
+
+// DryRunTarget is a SyncTarget that logs the writes it is asked to make
+// without making them.
+type DryRunTarget struct {
+	Name   string
+	Target SyncTarget
+}
+
+var _ SyncTarget = DryRunTarget{}
+
+func (t DryRunTarget) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	logging.FromContext(ctx).Info("Dry run: would have created event", zap.String("target", t.Name), zap.String("cardID", card.ID))
+	return dryRunID(card.ID), nil
+}
+
+func (t DryRunTarget) UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error) {
+	logging.FromContext(ctx).Info("Dry run: would have updated event", zap.String("target", t.Name), zap.String("cardID", card.ID), zap.String("eventID", eventID))
+	return eventID, nil
+}
+
+func (t DryRunTarget) DeleteEvent(ctx context.Context, card models.Card, eventID string) error {
+	logging.FromContext(ctx).Info("Dry run: would have deleted event", zap.String("target", t.Name), zap.String("cardID", card.ID), zap.String("eventID", eventID))
+	return nil
+}
+
+// DryRunTrello is a TrelloAPI that logs the webhook and card changes it is
+// asked to make without making them. The webhooks it pretends to register
+// are listed, checked and deleted as though Trello had them.
+type DryRunTrello struct {
+	TrelloAPI
+
+	mu       sync.Mutex
+	webhooks map[string]models.TrelloWebhook // Pretend webhooks by ID
+}
+
+// NewDryRunTrello wraps client in a dry run.
+func NewDryRunTrello(client TrelloAPI) *DryRunTrello {
+	return &DryRunTrello{TrelloAPI: client, webhooks: make(map[string]models.TrelloWebhook)}
+}
+
+var _ TrelloAPI = (*DryRunTrello)(nil)
+
+func (d *DryRunTrello) RegisterWebhook(ctx context.Context, boardID string) (string, error) {
+	webhook := models.TrelloWebhook{
+		ID:          dryRunID(boardID),
+		Description: d.WebhookDescription(),
+		IDModel:     boardID,
+		CallbackURL: d.WebhookCallbackURL(),
+		Active:      true,
+	}
+	d.mu.Lock()
+	d.webhooks[webhook.ID] = webhook
+	d.mu.Unlock()
+	logging.FromContext(ctx).Info("Dry run: would have registered Trello webhook", zap.String("boardID", boardID), zap.String("callbackURL", webhook.CallbackURL))
+	return webhook.ID, nil
+}
+
+func (d *DryRunTrello) DeleteWebhook(ctx context.Context, webhookID string) error {
+	d.mu.Lock()
+	delete(d.webhooks, webhookID)
+	d.mu.Unlock()
+	logging.FromContext(ctx).Info("Dry run: would have deleted Trello webhook", zap.String("webhookID", webhookID))
+	return nil
+}
+
+func (d *DryRunTrello) GetWebhook(ctx context.Context, webhookID string) (*models.TrelloWebhook, error) {
+	d.mu.Lock()
+	webhook, ok := d.webhooks[webhookID]
+	d.mu.Unlock()
+	if !ok {
+		return d.TrelloAPI.GetWebhook(ctx, webhookID)
+	}
+	return &webhook, nil
+}
+
+func (d *DryRunTrello) ActivateWebhook(ctx context.Context, webhookID string) error {
+	logging.FromContext(ctx).Info("Dry run: would have re-enabled Trello webhook", zap.String("webhookID", webhookID))
+	return nil
+}
+
+func (d *DryRunTrello) ListWebhooks(ctx context.Context) ([]models.TrelloWebhook, error) {
+	webhooks, err := d.TrelloAPI.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, webhook := range d.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (d *DryRunTrello) SetCardDue(ctx context.Context, cardID string, due time.Time) error {
+	logging.FromContext(ctx).Info("Dry run: would have set Trello card's due date", zap.String("cardID", cardID), zap.Time("due", due))
+	return nil
+}
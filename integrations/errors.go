@@ -0,0 +1,130 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"google.golang.org/api/googleapi"
+)
+
+// Classes of failure returned by the Trello and Google clients, for callers
+// deciding whether to retry, requeue or drop work. Match them with errors.Is;
+// rate limiting is matched with errors.As on *RateLimitedError. The original
+// API error stays reachable with errors.As as well.
+var (
+	ErrNotFound     = errors.New("resource not found")
+	ErrUnauthorized = errors.New("not authorised")
+	ErrTransient    = errors.New("temporary failure")
+)
+
+// RateLimitedError means the API asked for calls to slow down. RetryAfter is
+// the wait it asked for, or 0 if it didn't say.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited; retry after %s", e.RetryAfter)
+	}
+	return "rate limited"
+}
+
+// classifiedError labels err with its class without hiding it
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// statusClass maps an HTTP status to its error class, or nil for statuses
+// with no class of their own
+func statusClass(code int, retryAfter time.Duration) error {
+	switch {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return ErrNotFound
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrUnauthorized
+	case code == http.StatusTooManyRequests:
+		return &RateLimitedError{RetryAfter: retryAfter}
+	case code >= 500:
+		return ErrTransient
+	default:
+		return nil
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// googleError classifies an error from the Google API client. Google reports
+// most quota errors as 403 with a rate limit reason rather than 429, and calls
+// over a tenant's own limit are rate limited too.
+func googleError(err error) error {
+	var limitErr *CallLimitError
+	if errors.As(err, &limitErr) {
+		return &classifiedError{class: &RateLimitedError{RetryAfter: limitErr.RetryAfter}, err: err}
+	}
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return networkError(err)
+	}
+
+	class := statusClass(gerr.Code, parseRetryAfter(gerr.Header))
+	if gerr.Code == http.StatusForbidden && isRateLimitReason(gerr) {
+		class = &RateLimitedError{RetryAfter: parseRetryAfter(gerr.Header)}
+	}
+	if class == nil {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// networkError marks failures to reach an API at all as transient. Errors
+// from the caller cancelling are left alone.
+func networkError(err error) error {
+	if err == nil {
+		return err
+	}
+	// Failed requests are reported with their URL, which for Trello carries
+	// the key and token
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = logging.Redact(urlErr.URL)
+	}
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) {
+		return &classifiedError{class: ErrTransient, err: err}
+	}
+	return err
+}
@@ -2,75 +2,271 @@ package integrations
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/avast/retry-go"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
 	"github.com/chxlky/trello-gcal-sync/internal/models"
-	"github.com/spf13/viper"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
 	"go.uber.org/zap"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+// Keys of the private extended properties written to every synced event
+const (
+	PropSource      = "source"
+	PropCardID      = "trello_card_id"
+	PropBoardID     = "board_id"
+	PropSourceValue = "trello-gcal-sync"
+)
+
+const defaultGoogleTimeout = 30 * time.Second
+
+// googleCallContext bounds a single Google API call by google.request_timeout
+// so a hung request can't hold up a worker indefinitely.
+func googleCallContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultGoogleTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 type CalendarClient struct {
 	service *calendar.Service
+	usage   *APIUsage
+	cfg     atomic.Pointer[config.Google]
 }
 
-func NewCalendarClient() (*CalendarClient, error) {
-	ctx := context.Background()
+// Configure replaces the google settings the client works from. Each call
+// reads them once, so one applied during a reload sees old or new settings,
+// never a mix.
+func (c *CalendarClient) Configure(cfg *config.Google) {
+	c.cfg.Store(cfg)
+	c.usage.Configure(cfg.Quota)
+}
 
-	settings := viper.Get("google.service_account")
+func (c *CalendarClient) settings() *config.Google {
+	return c.cfg.Load()
+}
 
-	jsonBytes, err := json.Marshal(settings)
+// serviceAccountClient returns an HTTP client authenticated as the configured
+// service account.
+func serviceAccountClient(ctx context.Context, cfg *config.Google, scopes ...string) (*http.Client, error) {
+	jsonBytes, err := cfg.ReadServiceAccount()
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal service account settings to JSON: %w", err)
+		return nil, err
 	}
 
 	// create credentials from JSON data
-	config, err := google.JWTConfigFromJSON(jsonBytes, calendar.CalendarScope)
+	jwtConfig, err := google.JWTConfigFromJSON(jsonBytes, scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse service account credentials from JSON: %w", err)
 	}
 
-	client := config.Client(ctx)
+	return authorisedClient(ctx, cfg, jwtConfig.TokenSource), nil
+}
 
-	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+// authorisedClient returns an HTTP client sending the tokens from the source
+// tokens builds, calling Google and fetching tokens through the tuned
+// transport.
+func authorisedClient(ctx context.Context, cfg *config.Google, tokens func(ctx context.Context) oauth2.TokenSource) *http.Client {
+	// Token fetches share the tuned transport, bounded like any other call
+	tokenClient := &http.Client{Transport: googleTransport, Timeout: cfg.RequestTimeout}
+	if tokenClient.Timeout <= 0 {
+		tokenClient.Timeout = defaultGoogleTimeout
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, tokenClient)
+	client := oauth2.NewClient(ctx, tokens(ctx))
+	client.Transport = requestid.Transport(tracing.Transport(client.Transport, "google"))
+	return withBreaker(client, googleBreaker)
+}
+
+// NewCalendarClient builds a client from the google settings. cfg is kept,
+// not copied, so the calendar ID resolved at startup is seen by the client.
+// Options, such as an HTTP client talking to a stand-in for the API, replace
+// the service account's authenticated client.
+func NewCalendarClient(cfg *config.Google, opts ...option.ClientOption) (*CalendarClient, error) {
+	ctx := context.Background()
+
+	switch visibility := cfg.Calendar.Visibility; visibility {
+	case "", "default", "public", "private", "confidential":
+	default:
+		return nil, fmt.Errorf("invalid google.calendar.visibility %q: must be default, public, private or confidential", visibility)
+	}
+
+	if len(opts) == 0 {
+		client, err := serviceAccountClient(ctx, cfg, calendar.CalendarScope)
+		if err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithHTTPClient(client)}
+	}
+
+	srv, err := calendar.NewService(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Calendar client: %w", err)
 	}
 
-	return &CalendarClient{service: srv}, nil
+	c := &CalendarClient{service: srv, usage: NewAPIUsage("google_calendar", cfg.Quota)}
+	c.cfg.Store(cfg)
+	return c, nil
+}
+
+// applyCard sets the event fields derived from the card. The card ID and board
+// are also written to private extended properties so the mapping can be
+// recovered from the calendar alone.
+func applyCard(cfg *config.Google, event *calendar.Event, card models.Card) {
+	event.Summary = card.Name
+	// The card link goes in Source so clients can render it as a link back to
+	// Trello, leaving the description for the card's own content
+	event.Description = card.Description
+	event.Source = &calendar.EventSource{
+		Title: "Open in Trello",
+		Url:   card.URL,
+	}
+	event.Start = &calendar.EventDateTime{
+		Date: card.DueDate.Format("2006-01-02"),
+	}
+	event.End = &calendar.EventDateTime{
+		Date: card.DueDate.AddDate(0, 0, 1).Format("2006-01-02"), // all-day event ends the next day
+	}
+	event.ColorId = eventColorID(cfg, card)
+	event.Visibility = cfg.Calendar.Visibility
+	event.Transparency = eventTransparency(cfg)
+
+	if event.ExtendedProperties == nil {
+		event.ExtendedProperties = &calendar.EventExtendedProperties{}
+	}
+	if event.ExtendedProperties.Private == nil {
+		event.ExtendedProperties.Private = make(map[string]string)
+	}
+	event.ExtendedProperties.Private[PropSource] = PropSourceValue
+	event.ExtendedProperties.Private[PropCardID] = card.ID
+	event.ExtendedProperties.Private[PropBoardID] = card.BoardID
+}
+
+// eventColorID picks the event colour for a card: the mapping from
+// google.calendar.label_color_ids of its first mapped label wins over a
+// per-board one from google.calendar.board_color_ids, which wins over
+// google.calendar.default_color_id. An empty result leaves the calendar's own
+// colour in place.
+func eventColorID(cfg *config.Google, card models.Card) string {
+	for _, label := range card.Labels {
+		if colorID, ok := cfg.Calendar.LabelColorIDs[strings.ToLower(label)]; ok {
+			return colorID
+		}
+	}
+	if colorID, ok := cfg.Calendar.BoardColorIDs[strings.ToLower(card.BoardID)]; ok {
+		return colorID
+	}
+	return cfg.Calendar.DefaultColorID
+}
+
+// ResolveCalendarID maps a calendar reference from config to a calendar ID.
+// References may be aliases defined under [google.calendars] or raw calendar
+// IDs; an empty reference means the default calendar.
+func (c *CalendarClient) ResolveCalendarID(ref string) string {
+	cfg := c.settings()
+	if ref == "" {
+		return cfg.Calendar.CalendarID
+	}
+	if id, ok := cfg.Calendars[strings.ToLower(ref)]; ok {
+		return id
+	}
+	return ref
+}
+
+// ConfiguredCalendarIDs returns the default calendar and every aliased one.
+func (c *CalendarClient) ConfiguredCalendarIDs() []string {
+	cfg := c.settings()
+	ids := []string{cfg.Calendar.CalendarID}
+	for _, id := range cfg.Calendars {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// CalendarFor returns the calendar the card's event lives in
+func (c *CalendarClient) CalendarFor(card models.Card) string {
+	return calendarFor(c.settings(), card)
+}
+
+func calendarFor(cfg *config.Google, card models.Card) string {
+	if card.CalendarID != "" {
+		return card.CalendarID
+	}
+	return cfg.Calendar.CalendarID
+}
+
+// eventTransparency maps google.calendar.transparency to the API value.
+// "free"/"transparent" keeps events from blocking availability; anything else
+// marks them busy.
+func eventTransparency(cfg *config.Google) string {
+	switch strings.ToLower(cfg.Calendar.Transparency) {
+	case "free", "transparent":
+		return "transparent"
+	case "busy", "opaque":
+		return "opaque"
+	default:
+		return ""
+	}
 }
 
-func (c *CalendarClient) CreateEvent(card models.Card) (*calendar.Event, error) {
+// EventCardID returns the Trello card ID recorded on an event synced by this
+// service, or "" if the event wasn't created by us.
+func EventCardID(event *calendar.Event) string {
+	if event.ExtendedProperties == nil || event.ExtendedProperties.Private[PropSource] != PropSourceValue {
+		return ""
+	}
+	return event.ExtendedProperties.Private[PropCardID]
+}
+
+// EventURL returns the link that opens an event in Google Calendar.
+func EventURL(eventID, calendarID string) string {
+	eid := base64.RawStdEncoding.EncodeToString([]byte(eventID + " " + calendarID))
+	return "https://www.google.com/calendar/event?eid=" + url.QueryEscape(eid)
+}
+
+func (c *CalendarClient) CreateEvent(ctx context.Context, card models.Card) (*calendar.Event, error) {
 	if card.DueDate == nil {
 		return nil, fmt.Errorf("card does not have a due date, cannot create event")
 	}
 
-	calendarID := viper.GetString("google.calendar.calendar_id")
+	// One snapshot for the whole call, so a reload can't mix two configs
+	cfg := c.settings()
+	calendarID := calendarFor(cfg, card)
 	if calendarID == "" {
 		return nil, fmt.Errorf("google calendar ID is not configured")
 	}
 
-	event := &calendar.Event{
-		Summary:     card.Name,
-		Description: fmt.Sprintf("Trello Card: %s", card.URL),
-		Start: &calendar.EventDateTime{
-			Date: card.DueDate.Format("2006-01-02"),
-		},
-		End: &calendar.EventDateTime{
-			Date: card.DueDate.AddDate(0, 0, 1).Format("2006-01-02"), // all-day event ends the next day
-		},
-	}
+	event := &calendar.Event{}
+	applyCard(cfg, event, card)
 
 	var createdEvent *calendar.Event
 	err := retry.Do(
 		func() error {
+			callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+			defer cancel()
+
 			var err error
-			createdEvent, err = c.service.Events.Insert(calendarID, event).Do()
+			createdEvent, err = c.service.Events.Insert(calendarID, event).Context(callCtx).Do()
+			c.usage.Record("events.insert", err)
 			if err != nil {
 				if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
 					return err
@@ -79,49 +275,50 @@ func (c *CalendarClient) CreateEvent(card models.Card) (*calendar.Event, error)
 			}
 			return nil
 		},
+		retry.Context(ctx),
 		retry.Attempts(3),
 		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
-			zap.L().Warn("Retrying Google Calendar CreateEvent", zap.Uint("attempt", n+1), zap.Error(err))
+			logging.FromContext(ctx).Warn("Retrying Google Calendar CreateEvent", zap.Uint("attempt", n+1), zap.Error(err))
 		}),
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("unable to create event in Google Calendar: %w", err)
+		return nil, fmt.Errorf("unable to create event in Google Calendar: %w", googleError(err))
 	}
 
 	return createdEvent, nil
 }
 
-func (c *CalendarClient) UpdateEvent(card models.Card, eventID string) (*calendar.Event, error) {
+// UpdateEvent patches the card-derived fields of an existing event. If the
+// event no longer exists (deleted from the calendar by hand) a fresh one is
+// created instead; callers should store the returned event's ID.
+func (c *CalendarClient) UpdateEvent(ctx context.Context, card models.Card, eventID string) (*calendar.Event, error) {
 	if card.DueDate == nil {
 		return nil, fmt.Errorf("card does not have a due date, cannot update event")
 	}
 
-	calendarID := viper.GetString("google.calendar.calendar_id")
+	cfg := c.settings()
+	calendarID := calendarFor(cfg, card)
 	if calendarID == "" {
 		return nil, fmt.Errorf("google calendar ID is not configured")
 	}
 
-	event, err := c.service.Events.Get(calendarID, eventID).Do()
-	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve event from Google Calendar: %w", err)
-	}
-
-	event.Summary = card.Name
-	event.Description = fmt.Sprintf("Trello Card: %s", card.URL)
-	event.Start = &calendar.EventDateTime{
-		Date: card.DueDate.Format("2006-01-02"),
-	}
-	event.End = &calendar.EventDateTime{
-		Date: card.DueDate.AddDate(0, 0, 1).Format("2006-01-02"), // all-day event ends the next day
-	}
+	patch := &calendar.Event{}
+	applyCard(cfg, patch, card)
+	// Send cleared fields explicitly; Patch otherwise leaves them untouched
+	patch.ForceSendFields = []string{"Description", "ColorId", "Visibility", "Transparency"}
 
 	var updatedEvent *calendar.Event
-	err = retry.Do(
+	err := retry.Do(
 		func() error {
+			callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+			defer cancel()
+
 			var err error
-			updatedEvent, err = c.service.Events.Update(calendarID, event.Id, event).Do()
+			updatedEvent, err = c.service.Events.Patch(calendarID, eventID, patch).Context(callCtx).Do()
+			c.usage.Record("events.patch", err)
 			if err != nil {
 				if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
 					return err // Retry on 5xx errors
@@ -130,29 +327,53 @@ func (c *CalendarClient) UpdateEvent(card models.Card, eventID string) (*calenda
 			}
 			return nil
 		},
+		retry.Context(ctx),
 		retry.Attempts(3),
 		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
-			zap.L().Warn("Retrying Google Calendar UpdateEvent", zap.Uint("attempt", n+1), zap.Error(err))
+			logging.FromContext(ctx).Warn("Retrying Google Calendar UpdateEvent", zap.Uint("attempt", n+1), zap.Error(err))
 		}),
 	)
 
+	if isGone(err) || (err == nil && updatedEvent.Status == "cancelled") {
+		logging.FromContext(ctx).Info("Event no longer exists in Google Calendar; creating a new one", zap.String("eventID", eventID), zap.String("cardID", card.ID))
+		return c.CreateEvent(ctx, card)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to update event in Google Calendar: %w", err)
+		return nil, fmt.Errorf("unable to update event in Google Calendar: %w", googleError(err))
 	}
 
 	return updatedEvent, nil
 }
 
-func (c *CalendarClient) DeleteEvent(eventID string) error {
-	calendarID := viper.GetString("google.calendar.calendar_id")
+// isGone reports whether err is a Google API 404 or 410 response
+func isGone(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	return gerr.Code == http.StatusNotFound || gerr.Code == http.StatusGone
+}
+
+// DeleteEvent removes eventID from calendarID, or from the default calendar if
+// calendarID is empty.
+func (c *CalendarClient) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	cfg := c.settings()
+	if calendarID == "" {
+		calendarID = cfg.Calendar.CalendarID
+	}
 	if calendarID == "" {
 		return fmt.Errorf("google calendar ID is not configured")
 	}
 
 	err := retry.Do(
 		func() error {
-			err := c.service.Events.Delete(calendarID, eventID).Do()
+			callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+			defer cancel()
+
+			err := c.service.Events.Delete(calendarID, eventID).Context(callCtx).Do()
+			c.usage.Record("events.delete", err)
 			if err != nil {
 				if gerr, ok := err.(*googleapi.Error); ok {
 					if gerr.Code == 404 {
@@ -163,25 +384,67 @@ func (c *CalendarClient) DeleteEvent(eventID string) error {
 					}
 					return retry.Unrecoverable(err) // Don't retry on other errors
 				}
-				return nil
+				return err // Retry network failures and an open circuit
 			}
 			return nil
 		},
+		retry.Context(ctx),
 		retry.Attempts(3),
 		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
-			zap.L().Warn("Retrying Google Calendar DeleteEvent", zap.Uint("attempt", n+1), zap.Error(err))
+			logging.FromContext(ctx).Warn("Retrying Google Calendar DeleteEvent", zap.Uint("attempt", n+1), zap.Error(err))
 		}),
 	)
 
 	if err != nil {
 		// It's possible the event was already deleted, so we can choose to ignore "Not Found" errors
-		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
-			zap.L().Info("Event not found in Google Calendar. Already deleted.", zap.String("eventID", eventID))
+		if isGone(err) {
+			logging.FromContext(ctx).Info("Event not found in Google Calendar. Already deleted.", zap.String("eventID", eventID))
 			return nil
 		}
-		return fmt.Errorf("unable to delete event from Google Calendar: %w", err)
+		return fmt.Errorf("unable to delete event from Google Calendar: %w", googleError(err))
 	}
 
 	return nil
 }
+
+// MoveEvent moves an event to another calendar, keeping its ID.
+func (c *CalendarClient) MoveEvent(ctx context.Context, eventID, fromCalendarID, toCalendarID string) (*calendar.Event, error) {
+	cfg := c.settings()
+	if fromCalendarID == "" {
+		fromCalendarID = cfg.Calendar.CalendarID
+	}
+
+	var movedEvent *calendar.Event
+	err := retry.Do(
+		func() error {
+			callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+			defer cancel()
+
+			var err error
+			movedEvent, err = c.service.Events.Move(fromCalendarID, eventID, toCalendarID).Context(callCtx).Do()
+			c.usage.Record("events.move", err)
+			if err != nil {
+				if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
+					return err
+				}
+				return retry.Unrecoverable(err)
+			}
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(3),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			logging.FromContext(ctx).Warn("Retrying Google Calendar MoveEvent", zap.Uint("attempt", n+1), zap.Error(err))
+		}),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to move event between calendars: %w", googleError(err))
+	}
+
+	return movedEvent, nil
+}
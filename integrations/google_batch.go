@@ -0,0 +1,123 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+	"google.golang.org/api/calendar/v3"
+)
+
+const defaultBatchConcurrency = 8
+
+type EventOpType string
+
+const (
+	EventOpCreate EventOpType = "create"
+	EventOpUpdate EventOpType = "update"
+	EventOpDelete EventOpType = "delete"
+)
+
+// EventOp is a single calendar mutation queued as part of a bulk sync. Card
+// carries the target calendar; for updates, FromCalendarID is where the event
+// currently lives if it needs to move first.
+type EventOp struct {
+	Type           EventOpType
+	Card           models.Card
+	EventID        string // required for update and delete
+	FromCalendarID string
+}
+
+type EventOpResult struct {
+	Op    EventOp
+	Event *calendar.Event // nil for deletes
+	Err   error
+}
+
+type BatchSummary struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+	Failed  int `json:"failed"`
+}
+
+// ApplyBatch runs ops concurrently in bounded chunks and returns one result per
+// op, in the same order as ops. Individual failures do not stop the batch.
+func (c *CalendarClient) ApplyBatch(ctx context.Context, ops []EventOp) ([]EventOpResult, BatchSummary) {
+	return ApplyEventOps(ctx, c, c.settings().Calendar.BatchConcurrency, ops)
+}
+
+// ApplyEventOps is ApplyBatch for any CalendarAPI, running up to concurrency
+// ops at once; the default if it isn't positive.
+func ApplyEventOps(ctx context.Context, c CalendarAPI, concurrency int, ops []EventOp) ([]EventOpResult, BatchSummary) {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	results := make([]EventOpResult, len(ops))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, op := range ops {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = applyEventOp(ctx, c, op)
+		}()
+	}
+	wg.Wait()
+
+	var summary BatchSummary
+	for _, res := range results {
+		if res.Err != nil {
+			summary.Failed++
+			continue
+		}
+		switch res.Op.Type {
+		case EventOpCreate:
+			summary.Created++
+		case EventOpUpdate:
+			summary.Updated++
+		case EventOpDelete:
+			summary.Deleted++
+		}
+	}
+
+	logging.FromContext(ctx).Info("Applied batch of calendar operations",
+		zap.Int("total", len(ops)),
+		zap.Int("created", summary.Created),
+		zap.Int("updated", summary.Updated),
+		zap.Int("deleted", summary.Deleted),
+		zap.Int("failed", summary.Failed),
+	)
+
+	return results, summary
+}
+
+func applyEventOp(ctx context.Context, c CalendarAPI, op EventOp) EventOpResult {
+	res := EventOpResult{Op: op}
+	switch op.Type {
+	case EventOpCreate:
+		res.Event, res.Err = c.CreateEvent(ctx, op.Card)
+	case EventOpUpdate:
+		if to := c.CalendarFor(op.Card); op.FromCalendarID != "" && op.FromCalendarID != to {
+			// An event deleted meanwhile is recreated by the update
+			if _, res.Err = c.MoveEvent(ctx, op.EventID, op.FromCalendarID, to); res.Err != nil && !errors.Is(res.Err, ErrNotFound) {
+				return res
+			}
+		}
+		res.Event, res.Err = c.UpdateEvent(ctx, op.Card, op.EventID)
+	case EventOpDelete:
+		res.Err = c.DeleteEvent(ctx, op.Card.CalendarID, op.EventID)
+	default:
+		res.Err = fmt.Errorf("unknown event operation %q", op.Type)
+	}
+	return res
+}
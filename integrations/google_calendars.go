@@ -0,0 +1,175 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"go.uber.org/zap"
+	"google.golang.org/api/calendar/v3"
+)
+
+const DefaultCalendarName = "Trello Sync"
+
+// IsCalendarID reports whether s looks like a calendar ID rather than a
+// human-readable calendar name. Calendar IDs are either "primary" or
+// email-style addresses.
+func IsCalendarID(s string) bool {
+	return s == "primary" || strings.Contains(s, "@")
+}
+
+// CalendarExists reports whether the service account can see calendarID.
+func (c *CalendarClient) CalendarExists(ctx context.Context, calendarID string) bool {
+	callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+	defer cancel()
+
+	_, err := c.service.CalendarList.Get(calendarID).Context(callCtx).Do()
+	c.usage.Record("calendarList.get", err)
+	return err == nil
+}
+
+// ListWritableCalendars returns the calendars the client's account can add
+// events to.
+func (c *CalendarClient) ListWritableCalendars(ctx context.Context) ([]*calendar.CalendarListEntry, error) {
+	var entries []*calendar.CalendarListEntry
+	pageToken := ""
+	for {
+		call := c.service.CalendarList.List().MinAccessRole("writer")
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+		list, err := call.Context(callCtx).Do()
+		cancel()
+		c.usage.Record("calendarList.list", err)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list calendars: %w", googleError(err))
+		}
+
+		entries = append(entries, list.Items...)
+		if list.NextPageToken == "" {
+			return entries, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// EnsureCalendar returns the ID of the calendar called name, creating it if the
+// service account has no calendar by that name. Newly created calendars are
+// shared with the addresses in google.calendar.share_with.
+func (c *CalendarClient) EnsureCalendar(ctx context.Context, name string) (string, error) {
+	cfg := c.settings()
+	pageToken := ""
+	for {
+		call := c.service.CalendarList.List()
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+		list, err := call.Context(callCtx).Do()
+		cancel()
+		c.usage.Record("calendarList.list", err)
+		if err != nil {
+			return "", fmt.Errorf("unable to list calendars: %w", googleError(err))
+		}
+
+		for _, entry := range list.Items {
+			if entry.Summary == name {
+				logging.FromContext(ctx).Info("Found existing calendar", zap.String("name", name), zap.String("calendarID", entry.Id))
+				return entry.Id, nil
+			}
+		}
+
+		if list.NextPageToken == "" {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+
+	callCtx, cancel := googleCallContext(ctx, cfg.RequestTimeout)
+	defer cancel()
+	created, err := c.service.Calendars.Insert(&calendar.Calendar{
+		Summary:     name,
+		Description: "Due dates synchronised from Trello",
+	}).Context(callCtx).Do()
+	c.usage.Record("calendars.insert", err)
+	if err != nil {
+		return "", fmt.Errorf("unable to create calendar %q: %w", name, googleError(err))
+	}
+	logging.FromContext(ctx).Info("Created calendar", zap.String("name", name), zap.String("calendarID", created.Id))
+
+	for _, email := range cfg.Calendar.ShareWith {
+		rule := &calendar.AclRule{
+			Role:  "writer",
+			Scope: &calendar.AclRuleScope{Type: "user", Value: email},
+		}
+		_, err := c.service.Acl.Insert(created.Id, rule).Context(callCtx).Do()
+		c.usage.Record("acl.insert", err)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Failed to share calendar", zap.String("calendarID", created.Id), zap.String("email", email), zap.Error(err))
+		} else {
+			logging.FromContext(ctx).Info("Shared calendar", zap.String("calendarID", created.Id), zap.String("email", email))
+		}
+	}
+
+	return created.Id, nil
+}
+
+// ListManagedEvents returns every live event on calendarID that was created
+// by this service, identified by its private extended properties.
+func (c *CalendarClient) ListManagedEvents(ctx context.Context, calendarID string) ([]*calendar.Event, error) {
+	var events []*calendar.Event
+	pageToken := ""
+	for {
+		call := c.service.Events.List(calendarID).
+			PrivateExtendedProperty(PropSource + "=" + PropSourceValue).
+			MaxResults(250)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+		resp, err := call.Context(callCtx).Do()
+		cancel()
+		c.usage.Record("events.list", err)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list managed events: %w", googleError(err))
+		}
+
+		events = append(events, resp.Items...)
+		if resp.NextPageToken == "" {
+			return events, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// CheckWritable verifies the service account can add events to calendarID by
+// creating a private, free all-day event and deleting it again.
+func (c *CalendarClient) CheckWritable(ctx context.Context, calendarID string) error {
+	today := time.Now().Format("2006-01-02")
+	event := &calendar.Event{
+		Summary:      "trello-gcal-sync write check",
+		Start:        &calendar.EventDateTime{Date: today},
+		End:          &calendar.EventDateTime{Date: time.Now().AddDate(0, 0, 1).Format("2006-01-02")},
+		Visibility:   "private",
+		Transparency: "transparent",
+	}
+
+	callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+	defer cancel()
+	created, err := c.service.Events.Insert(calendarID, event).Context(callCtx).Do()
+	c.usage.Record("events.insert", err)
+	if err != nil {
+		return fmt.Errorf("unable to create an event: %w", googleError(err))
+	}
+
+	err = c.service.Events.Delete(calendarID, created.Id).Context(callCtx).Do()
+	c.usage.Record("events.delete", err)
+	if err != nil {
+		return fmt.Errorf("created a test event but could not delete it (event %s): %w", created.Id, googleError(err))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// NewGoogleOAuth returns the OAuth client tenants authorise access to their
+// calendars through, sending them back to redirectURL once they have.
+func NewGoogleOAuth(cfg config.OAuth, redirectURL string) (*oauth2.Config, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("google.oauth.client_id and client_secret must be set for tenants to connect Google accounts")
+	}
+	return &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Endpoint:     google.Endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{calendar.CalendarScope},
+	}, nil
+}
+
+// NewTenantCalendarClient builds a client from the google settings that acts
+// as the Google account which granted refreshToken, making no more calls than
+// limit allows, if it isn't nil.
+func NewTenantCalendarClient(cfg *config.Google, oauth *oauth2.Config, refreshToken string, limit *CallLimit) (*CalendarClient, error) {
+	client := authorisedClient(context.Background(), cfg, func(ctx context.Context) oauth2.TokenSource {
+		return oauth.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken})
+	})
+	if limit != nil {
+		client = withCallLimit(client, limit)
+	}
+	return NewCalendarClient(cfg, option.WithHTTPClient(client))
+}
+
+// PrimaryCalendarID returns the ID of the primary calendar of the account the
+// client acts as, which is the account's email address.
+func (c *CalendarClient) PrimaryCalendarID(ctx context.Context) (string, error) {
+	callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+	defer cancel()
+
+	entry, err := c.service.CalendarList.Get("primary").Context(callCtx).Do()
+	c.usage.Record("calendarList.get", err)
+	if err != nil {
+		return "", fmt.Errorf("unable to look up primary calendar: %w", googleError(err))
+	}
+	return entry.Id, nil
+}
@@ -0,0 +1,191 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/avast/retry-go"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/tasks/v1"
+)
+
+const defaultTaskListID = "@default"
+
+// TasksClient syncs cards to Google Tasks as an alternative to calendar events.
+type TasksClient struct {
+	service *tasks.Service
+	usage   *APIUsage
+	cfg     atomic.Pointer[config.Google]
+}
+
+// TasksAPI is the part of Google Tasks the service syncs with. TasksClient
+// implements it against the Google API.
+type TasksAPI interface {
+	// Configure replaces the google settings calls work from
+	Configure(cfg *config.Google)
+	TaskListFor(card models.Card) string
+
+	CreateTask(ctx context.Context, card models.Card) (*tasks.Task, error)
+	// UpdateTask recreates tasks that no longer exist
+	UpdateTask(ctx context.Context, card models.Card, taskID string) (*tasks.Task, error)
+	DeleteTask(ctx context.Context, taskListID, taskID string) error
+
+	Usage() UsageSnapshot
+}
+
+var _ TasksAPI = (*TasksClient)(nil)
+
+// Configure replaces the google settings the client works from.
+func (c *TasksClient) Configure(cfg *config.Google) {
+	c.cfg.Store(cfg)
+	c.usage.Configure(cfg.Quota)
+}
+
+func (c *TasksClient) settings() *config.Google {
+	return c.cfg.Load()
+}
+
+// NewTasksClient builds a client from the google settings, authenticated as
+// the service account unless opts are given, as for NewCalendarClient.
+func NewTasksClient(cfg *config.Google, opts ...option.ClientOption) (*TasksClient, error) {
+	ctx := context.Background()
+
+	if len(opts) == 0 {
+		client, err := serviceAccountClient(ctx, cfg, tasks.TasksScope)
+		if err != nil {
+			return nil, err
+		}
+		opts = []option.ClientOption{option.WithHTTPClient(client)}
+	}
+
+	srv, err := tasks.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Tasks client: %w", err)
+	}
+
+	c := &TasksClient{service: srv, usage: NewAPIUsage("google_tasks", cfg.Quota)}
+	c.cfg.Store(cfg)
+	return c, nil
+}
+
+// TaskListFor returns the task list the card's task lives in
+func (c *TasksClient) TaskListFor(card models.Card) string {
+	if card.TaskListID != "" {
+		return card.TaskListID
+	}
+	if id := c.settings().Tasks.TasklistID; id != "" {
+		return id
+	}
+	return defaultTaskListID
+}
+
+func buildTask(card models.Card) *tasks.Task {
+	notes := card.URL
+	if card.Description != "" {
+		notes = strings.TrimSpace(card.Description) + "\n\n" + card.URL
+	}
+	return &tasks.Task{
+		Title: card.Name,
+		Notes: notes,
+		// Tasks only keeps the date portion of the due timestamp
+		Due: card.DueDate.Format("2006-01-02") + "T00:00:00.000Z",
+	}
+}
+
+func (c *TasksClient) CreateTask(ctx context.Context, card models.Card) (*tasks.Task, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot create task")
+	}
+
+	var createdTask *tasks.Task
+	err := c.do(ctx, "tasks.insert", func(callCtx context.Context) error {
+		var err error
+		createdTask, err = c.service.Tasks.Insert(c.TaskListFor(card), buildTask(card)).Context(callCtx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create task in Google Tasks: %w", googleError(err))
+	}
+
+	return createdTask, nil
+}
+
+// UpdateTask patches an existing task, recreating it if it has been deleted.
+func (c *TasksClient) UpdateTask(ctx context.Context, card models.Card, taskID string) (*tasks.Task, error) {
+	if card.DueDate == nil {
+		return nil, fmt.Errorf("card does not have a due date, cannot update task")
+	}
+
+	var updatedTask *tasks.Task
+	err := c.do(ctx, "tasks.patch", func(callCtx context.Context) error {
+		var err error
+		updatedTask, err = c.service.Tasks.Patch(c.TaskListFor(card), taskID, buildTask(card)).Context(callCtx).Do()
+		return err
+	})
+	if isGone(err) || (err == nil && updatedTask.Deleted) {
+		logging.FromContext(ctx).Info("Task no longer exists in Google Tasks; creating a new one", zap.String("taskID", taskID), zap.String("cardID", card.ID))
+		return c.CreateTask(ctx, card)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to update task in Google Tasks: %w", googleError(err))
+	}
+
+	return updatedTask, nil
+}
+
+func (c *TasksClient) DeleteTask(ctx context.Context, taskListID, taskID string) error {
+	if taskListID == "" {
+		taskListID = c.TaskListFor(models.Card{})
+	}
+
+	err := c.do(ctx, "tasks.delete", func(callCtx context.Context) error {
+		return c.service.Tasks.Delete(taskListID, taskID).Context(callCtx).Do()
+	})
+	if err != nil && !isGone(err) {
+		return fmt.Errorf("unable to delete task from Google Tasks: %w", googleError(err))
+	}
+
+	return nil
+}
+
+// do runs call with the same retry policy as the calendar client: server
+// errors are retried, everything else fails immediately. Each attempt gets
+// its own timeout.
+func (c *TasksClient) do(ctx context.Context, method string, call func(context.Context) error) error {
+	timeout := c.settings().RequestTimeout
+	return retry.Do(
+		func() error {
+			callCtx, cancel := googleCallContext(ctx, timeout)
+			defer cancel()
+
+			err := call(callCtx)
+			c.usage.Record(method, err)
+			if err != nil {
+				if gerr, ok := err.(*googleapi.Error); ok && gerr.Code >= 500 {
+					return err
+				}
+				return retry.Unrecoverable(err)
+			}
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(3),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			logging.FromContext(ctx).Warn("Retrying Google Tasks call", zap.String("method", method), zap.Uint("attempt", n+1), zap.Error(err))
+		}),
+	)
+}
+
+// Usage returns today's Tasks API call counts.
+func (c *TasksClient) Usage() UsageSnapshot {
+	return c.usage.Snapshot()
+}
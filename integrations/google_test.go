@@ -0,0 +1,71 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"google.golang.org/api/option"
+)
+
+// roundTripFunc answers requests with a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// statusTransport answers every request with status
+func statusTransport(status int) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.WriteHeader(status)
+		fmt.Fprintf(rec, `{"error":{"code":%d,"message":"stand-in"}}`, status)
+		resp := rec.Result()
+		resp.Request = req
+		return resp, nil
+	})
+}
+
+func TestDeleteEventErrors(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		wantErr   bool
+		transient bool
+	}{
+		{name: "deleted", transport: statusTransport(http.StatusNoContent)},
+		{name: "already gone", transport: statusTransport(http.StatusNotFound)},
+		{name: "server error", transport: statusTransport(http.StatusServiceUnavailable), wantErr: true, transient: true},
+		{name: "forbidden", transport: statusTransport(http.StatusForbidden), wantErr: true},
+		{name: "network failure", transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, refused
+		}), wantErr: true, transient: true},
+		{name: "circuit open", transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, ErrCircuitOpen
+		}), wantErr: true, transient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Google{}
+			cfg.Calendar.CalendarID = "primary"
+			client, err := NewCalendarClient(cfg, option.WithHTTPClient(&http.Client{Transport: tt.transport}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.DeleteEvent(t.Context(), "", "event1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeleteEvent() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrTransient); got != tt.transient {
+				t.Errorf("errors.Is(%v, ErrTransient) = %v, want %v", err, got, tt.transient)
+			}
+		})
+	}
+}
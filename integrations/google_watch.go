@@ -0,0 +1,100 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrSyncTokenExpired is returned by ListChangedEvents when Google has
+// invalidated the sync token and a full resync is required.
+var ErrSyncTokenExpired = errors.New("calendar sync token expired")
+
+// WatchEvents opens a push notification channel for changes to events on
+// calendarID. Google delivers notifications to address.
+func (c *CalendarClient) WatchEvents(ctx context.Context, calendarID, channelID, address, token string, ttl time.Duration) (*calendar.Channel, error) {
+	if calendarID == "" {
+		return nil, fmt.Errorf("google calendar ID is not configured")
+	}
+
+	channel := &calendar.Channel{
+		Id:      channelID,
+		Type:    "web_hook",
+		Address: address,
+		Token:   token,
+		Params: map[string]string{
+			"ttl": fmt.Sprintf("%d", int64(ttl.Seconds())),
+		},
+	}
+
+	callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+	defer cancel()
+
+	created, err := c.service.Events.Watch(calendarID, channel).Context(callCtx).Do()
+	c.usage.Record("events.watch", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open watch channel on Google Calendar: %w", googleError(err))
+	}
+
+	return created, nil
+}
+
+// StopChannel closes a previously opened watch channel.
+func (c *CalendarClient) StopChannel(ctx context.Context, channelID, resourceID string) error {
+	callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+	defer cancel()
+
+	err := c.service.Channels.Stop(&calendar.Channel{Id: channelID, ResourceId: resourceID}).Context(callCtx).Do()
+	c.usage.Record("channels.stop", err)
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+			return nil // Already expired or stopped
+		}
+		return fmt.Errorf("unable to stop watch channel: %w", googleError(err))
+	}
+	return nil
+}
+
+// ListChangedEvents returns every event on calendarID changed since
+// syncToken, including deleted ones, along with the token to use for the next
+// call. An empty syncToken performs a full listing to establish the initial
+// token.
+func (c *CalendarClient) ListChangedEvents(ctx context.Context, calendarID, syncToken string) ([]*calendar.Event, string, error) {
+	if calendarID == "" {
+		return nil, "", fmt.Errorf("google calendar ID is not configured")
+	}
+
+	var events []*calendar.Event
+	pageToken := ""
+	for {
+		call := c.service.Events.List(calendarID).ShowDeleted(true).SingleEvents(true).MaxResults(250)
+		if syncToken != "" {
+			call = call.SyncToken(syncToken)
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		callCtx, cancel := googleCallContext(ctx, c.settings().RequestTimeout)
+		resp, err := call.Context(callCtx).Do()
+		cancel()
+		c.usage.Record("events.list", err)
+		if err != nil {
+			if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusGone {
+				return nil, "", ErrSyncTokenExpired
+			}
+			return nil, "", fmt.Errorf("unable to list changed events: %w", googleError(err))
+		}
+
+		events = append(events, resp.Items...)
+		if resp.NextPageToken == "" {
+			return events, resp.NextSyncToken, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
@@ -0,0 +1,126 @@
+// Package googletest stands in for the Google Calendar and Tasks APIs in
+// process, for exercising the sync without calling Google.
+package googletest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// collections are the path segments naming a list of resources, which GETs
+// list and POSTs add to
+var collections = map[string]bool{
+	"calendars":    true,
+	"calendarList": true,
+	"events":       true,
+	"instances":    true,
+	"lists":        true,
+	"tasks":        true,
+	"import":       true,
+	"quickAdd":     true,
+}
+
+// Transport answers Calendar and Tasks API requests without keeping any
+// state: inserts and updates echo the resource back with an ID, lists are
+// empty, and deletes succeed. Batch requests are refused. Each request takes
+// Latency, to stand in for the round trip to Google.
+type Transport struct {
+	Latency time.Duration
+
+	requests atomic.Int64
+	nextID   atomic.Int64
+}
+
+// Client returns an HTTP client whose requests go to t.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// Requests returns how many requests t has answered.
+func (t *Transport) Requests() int64 {
+	return t.requests.Load()
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	if t.Latency > 0 {
+		timer := time.NewTimer(t.Latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	t.requests.Add(1)
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	last := segments[len(segments)-1]
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/batch"):
+		return respond(req, http.StatusNotImplemented, map[string]any{
+			"error": map[string]any{"code": http.StatusNotImplemented, "message": "batch requests are not supported by googletest"},
+		})
+	case req.Method == http.MethodDelete:
+		return respond(req, http.StatusNoContent, nil)
+	case req.Method == http.MethodGet && collections[last]:
+		return respond(req, http.StatusOK, map[string]any{"items": []any{}})
+	case req.Method == http.MethodGet:
+		return respond(req, http.StatusOK, map[string]any{"id": last})
+	}
+
+	resource := make(map[string]any)
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &resource); err != nil {
+				return respond(req, http.StatusBadRequest, map[string]any{
+					"error": map[string]any{"code": http.StatusBadRequest, "message": err.Error()},
+				})
+			}
+		}
+	}
+	switch {
+	case last == "move" && len(segments) > 1:
+		resource["id"] = segments[len(segments)-2]
+	case collections[last]:
+		if _, ok := resource["id"]; !ok {
+			resource["id"] = fmt.Sprintf("stub%d", t.nextID.Add(1))
+		}
+	default:
+		resource["id"] = last
+	}
+	return respond(req, http.StatusOK, resource)
+}
+
+func respond(req *http.Request, status int, body any) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
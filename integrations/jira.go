@@ -0,0 +1,110 @@
+package integrations
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+)
+
+const jiraPageSize = 100
+
+// jiraFields are the issue fields requested from searches
+const jiraFields = "summary,duedate,project,status"
+
+// JiraClient reads issues from a Jira Cloud or Data Center site.
+type JiraClient struct {
+	BaseURL string
+	client  *http.Client
+	header  http.Header
+	timeout time.Duration
+	cloud   bool // Cloud sites search with /rest/api/3/search/jql
+}
+
+// NewJiraClient checks the jira settings and builds a client from them.
+func NewJiraClient(cfg config.Jira) (*JiraClient, error) {
+	base, err := url.Parse(cfg.URL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.New("jira.url must be the http or https URL of the Jira site")
+	}
+	if cfg.APIToken == "" {
+		return nil, errors.New("jira.api_token is not set")
+	}
+
+	// Cloud API tokens go with the account's email; Data Center personal
+	// access tokens stand alone
+	header := http.Header{"Authorization": {"Bearer " + cfg.APIToken}}
+	if cfg.Email != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(cfg.Email+":"+cfg.APIToken)))
+	}
+	return &JiraClient{
+		BaseURL: strings.TrimSuffix(base.String(), "/"),
+		client:  &http.Client{Transport: requestid.Transport(tracing.Transport(http.DefaultTransport, "jira"))},
+		header:  header,
+		timeout: cfg.RequestTimeout,
+		cloud:   strings.HasSuffix(base.Hostname(), ".atlassian.net"),
+	}, nil
+}
+
+func (c *JiraClient) get(ctx context.Context, path string, params url.Values, out any) error {
+	return restCall{
+		API:     "Jira",
+		Method:  http.MethodGet,
+		URL:     c.BaseURL + path + "?" + params.Encode(),
+		Header:  c.header,
+		Out:     out,
+		Timeout: c.timeout,
+	}.do(ctx, c.client)
+}
+
+// SearchIssues returns every issue matching jql.
+func (c *JiraClient) SearchIssues(ctx context.Context, jql string) ([]models.JiraIssue, error) {
+	params := url.Values{
+		"jql":        {jql},
+		"fields":     {jiraFields},
+		"maxResults": {strconv.Itoa(jiraPageSize)},
+	}
+
+	var all []models.JiraIssue
+	for {
+		var page struct {
+			Issues        []models.JiraIssue `json:"issues"`
+			Total         int                `json:"total"`
+			NextPageToken string             `json:"nextPageToken"`
+		}
+		if c.cloud {
+			if err := c.get(ctx, "/rest/api/3/search/jql", params, &page); err != nil {
+				return nil, err
+			}
+			all = append(all, page.Issues...)
+			if page.NextPageToken == "" {
+				return all, nil
+			}
+			params.Set("nextPageToken", page.NextPageToken)
+			continue
+		}
+
+		params.Set("startAt", strconv.Itoa(len(all)))
+		if err := c.get(ctx, "/rest/api/2/search", params, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Issues...)
+		if len(page.Issues) == 0 || len(all) >= page.Total {
+			return all, nil
+		}
+	}
+}
+
+// IssueURL returns the link that opens the issue with key in Jira.
+func (c *JiraClient) IssueURL(key string) string {
+	return c.BaseURL + "/browse/" + url.PathEscape(key)
+}
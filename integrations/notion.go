@@ -0,0 +1,147 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+)
+
+// TargetNotion is the sync target name of the Notion database.
+const TargetNotion = "notion"
+
+const (
+	notionBaseURL = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+	// notionTextLimit is the most characters Notion takes in one text object
+	notionTextLimit = 2000
+)
+
+func init() {
+	RegisterTarget(TargetNotion, func(cfg *config.Config) (SyncTarget, error) {
+		return NewNotionClient(cfg.Targets.Notion)
+	})
+}
+
+// NotionClient syncs each card to a page in a Notion database. The page ID
+// is the event ID; pages are archived rather than deleted when their card
+// stops being synced.
+type NotionClient struct {
+	cfg     config.Notion
+	client  *http.Client
+	baseURL string
+}
+
+// NewNotionClient checks the notion settings and builds a client from them.
+func NewNotionClient(cfg config.Notion) (*NotionClient, error) {
+	switch {
+	case cfg.Token == "":
+		return nil, errors.New("targets.notion.token is not set")
+	case cfg.DatabaseID == "":
+		return nil, errors.New("targets.notion.database_id is not set")
+	case cfg.Properties.Title == "" || cfg.Properties.Date == "":
+		return nil, errors.New("targets.notion.properties needs title and date property names")
+	}
+	client := &http.Client{Transport: requestid.Transport(tracing.Transport(http.DefaultTransport, "notion"))}
+	return &NotionClient{cfg: cfg, client: client, baseURL: notionBaseURL}, nil
+}
+
+func notionText(s string) []map[string]any {
+	if runes := []rune(s); len(runes) > notionTextLimit {
+		s = string(runes[:notionTextLimit-1]) + "…"
+	}
+	return []map[string]any{{"text": map[string]string{"content": s}}}
+}
+
+// pageProperties maps card onto the configured database properties
+func (c *NotionClient) pageProperties(card models.Card) map[string]any {
+	names := c.cfg.Properties
+	properties := map[string]any{
+		names.Title: map[string]any{"title": notionText(card.Name)},
+		names.Date:  map[string]any{"date": map[string]string{"start": card.DueDate.Format("2006-01-02")}},
+	}
+	if names.URL != "" {
+		var link any // Notion clears URL properties given null
+		if card.URL != "" {
+			link = card.URL
+		}
+		properties[names.URL] = map[string]any{"url": link}
+	}
+	if names.Description != "" {
+		properties[names.Description] = map[string]any{"rich_text": notionText(card.Description)}
+	}
+	if names.CardID != "" {
+		properties[names.CardID] = map[string]any{"rich_text": notionText(card.ID)}
+	}
+	return properties
+}
+
+func (c *NotionClient) call(method, path string, body, out any) restCall {
+	return restCall{
+		API:    "Notion",
+		Method: method,
+		URL:    c.baseURL + path,
+		Header: http.Header{
+			"Authorization":  {"Bearer " + c.cfg.Token},
+			"Notion-Version": {notionVersion},
+		},
+		Body:    body,
+		Out:     out,
+		Timeout: c.cfg.RequestTimeout,
+	}
+}
+
+type notionPage struct {
+	ID string `json:"id"`
+}
+
+func (c *NotionClient) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot create page")
+	}
+
+	body := map[string]any{
+		"parent":     map[string]string{"database_id": c.cfg.DatabaseID},
+		"properties": c.pageProperties(card),
+	}
+	var page notionPage
+	if err := c.call(http.MethodPost, "/pages", body, &page).do(ctx, c.client); err != nil {
+		return "", fmt.Errorf("unable to create page in Notion: %w", err)
+	}
+	return page.ID, nil
+}
+
+// UpdateEvent restores the page if it was archived from Notion, and creates
+// a fresh one if it is gone altogether.
+func (c *NotionClient) UpdateEvent(ctx context.Context, card models.Card, pageID string) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot update page")
+	}
+
+	body := map[string]any{"properties": c.pageProperties(card), "archived": false}
+	err := c.call(http.MethodPatch, "/pages/"+url.PathEscape(pageID), body, nil).do(ctx, c.client)
+	if errors.Is(err, ErrNotFound) {
+		return c.CreateEvent(ctx, card)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to update page in Notion: %w", err)
+	}
+	return pageID, nil
+}
+
+func (c *NotionClient) DeleteEvent(ctx context.Context, card models.Card, pageID string) error {
+	body := map[string]any{"archived": true}
+	err := c.call(http.MethodPatch, "/pages/"+url.PathEscape(pageID), body, nil).do(ctx, c.client)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to archive page in Notion: %w", err)
+	}
+	return nil
+}
+
+var _ SyncTarget = (*NotionClient)(nil)
@@ -0,0 +1,184 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// TargetOutlook is the sync target name of the Microsoft Graph calendar.
+const TargetOutlook = "outlook"
+
+const graphBaseURL = "https://graph.microsoft.com/v1.0"
+
+// graphCardIDProperty tags each event with its card, like the private
+// extended properties on Google events
+const graphCardIDProperty = "String {5d1a7c1e-3f0b-4c8e-9a57-2b60f4d1e8a3} Name " + PropCardID
+
+func init() {
+	RegisterTarget(TargetOutlook, func(cfg *config.Config) (SyncTarget, error) {
+		return NewOutlookClient(cfg.Targets.Outlook)
+	})
+}
+
+// OutlookClient syncs cards to a Microsoft 365 or Outlook.com calendar as
+// all-day events through Microsoft Graph.
+type OutlookClient struct {
+	cfg     config.Outlook
+	client  *http.Client
+	baseURL string
+	user    string // Graph path of the user whose calendar is synced
+}
+
+// NewOutlookClient checks the outlook settings and builds a client from them.
+// Tokens are only requested once the first event is synced.
+func NewOutlookClient(cfg config.Outlook) (*OutlookClient, error) {
+	if cfg.ClientID == "" {
+		return nil, errors.New("targets.outlook.client_id is not set")
+	}
+	tenant := cfg.TenantID
+	if tenant == "" {
+		tenant = "common"
+	}
+
+	ctx := context.Background()
+	c := &OutlookClient{cfg: cfg, baseURL: graphBaseURL}
+	switch {
+	case cfg.RefreshToken != "":
+		oauthCfg := &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     microsoft.AzureADEndpoint(tenant),
+			Scopes:       []string{"offline_access", "https://graph.microsoft.com/Calendars.ReadWrite"},
+		}
+		c.client = oauth2.NewClient(ctx, oauthCfg.TokenSource(ctx, &oauth2.Token{RefreshToken: cfg.RefreshToken}))
+		c.user = "/me"
+	case cfg.ClientSecret == "":
+		return nil, errors.New("targets.outlook needs a refresh_token, or a client_secret and user")
+	case cfg.User == "":
+		return nil, errors.New("targets.outlook.user must name the user whose calendar is synced with client credentials")
+	case tenant == "common" || tenant == "organizations" || tenant == "consumers":
+		return nil, errors.New("targets.outlook.tenant_id must be the directory's tenant ID for client credentials")
+	default:
+		oauthCfg := &clientcredentials.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			TokenURL:     microsoft.AzureADEndpoint(tenant).TokenURL,
+			Scopes:       []string{"https://graph.microsoft.com/.default"},
+		}
+		c.client = oauthCfg.Client(ctx)
+		c.user = "/users/" + url.PathEscape(cfg.User)
+	}
+	c.client.Transport = requestid.Transport(tracing.Transport(c.client.Transport, "microsoft"))
+	return c, nil
+}
+
+type graphEvent struct {
+	ID                            string          `json:"id,omitempty"`
+	Subject                       string          `json:"subject"`
+	Body                          graphBody       `json:"body"`
+	Start                         graphDateTime   `json:"start"`
+	End                           graphDateTime   `json:"end"`
+	IsAllDay                      bool            `json:"isAllDay"`
+	SingleValueExtendedProperties []graphProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// graphEventFor builds the all-day event for card, matching the Google one
+func graphEventFor(card models.Card) graphEvent {
+	content := card.Description
+	if card.URL != "" {
+		if content != "" {
+			content += "\n\n"
+		}
+		content += "Open in Trello: " + card.URL
+	}
+	return graphEvent{
+		Subject:  card.Name,
+		Body:     graphBody{ContentType: "text", Content: content},
+		Start:    graphDateTime{DateTime: card.DueDate.Format("2006-01-02") + "T00:00:00", TimeZone: "UTC"},
+		End:      graphDateTime{DateTime: card.DueDate.AddDate(0, 0, 1).Format("2006-01-02") + "T00:00:00", TimeZone: "UTC"},
+		IsAllDay: true,
+		SingleValueExtendedProperties: []graphProperty{
+			{ID: graphCardIDProperty, Value: card.ID},
+		},
+	}
+}
+
+func (c *OutlookClient) call(method, path string, body, out any) restCall {
+	return restCall{
+		API:     "Microsoft Graph",
+		Method:  method,
+		URL:     c.baseURL + c.user + path,
+		Body:    body,
+		Out:     out,
+		Timeout: c.cfg.RequestTimeout,
+	}
+}
+
+func (c *OutlookClient) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot create event")
+	}
+
+	path := "/calendar/events"
+	if c.cfg.CalendarID != "" {
+		path = "/calendars/" + url.PathEscape(c.cfg.CalendarID) + "/events"
+	}
+	var created graphEvent
+	if err := c.call(http.MethodPost, path, graphEventFor(card), &created).do(ctx, c.client); err != nil {
+		return "", fmt.Errorf("unable to create event in Outlook: %w", err)
+	}
+	return created.ID, nil
+}
+
+// UpdateEvent creates a fresh event if eventID was deleted from the calendar.
+func (c *OutlookClient) UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error) {
+	if card.DueDate == nil {
+		return "", fmt.Errorf("card does not have a due date, cannot update event")
+	}
+
+	var updated graphEvent
+	err := c.call(http.MethodPatch, "/events/"+url.PathEscape(eventID), graphEventFor(card), &updated).do(ctx, c.client)
+	if errors.Is(err, ErrNotFound) {
+		return c.CreateEvent(ctx, card)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to update event in Outlook: %w", err)
+	}
+	return updated.ID, nil
+}
+
+func (c *OutlookClient) DeleteEvent(ctx context.Context, card models.Card, eventID string) error {
+	err := c.call(http.MethodDelete, "/events/"+url.PathEscape(eventID), nil, nil).do(ctx, c.client)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to delete event from Outlook: %w", err)
+	}
+	return nil
+}
+
+var _ SyncTarget = (*OutlookClient)(nil)
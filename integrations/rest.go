@@ -0,0 +1,132 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"go.uber.org/zap"
+)
+
+const defaultTargetTimeout = 30 * time.Second
+
+// StatusError is returned when a sync target's API responds with a
+// non-success status. It unwraps to the status's error class, such as
+// ErrNotFound.
+type StatusError struct {
+	API        string
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration // Set for 429 responses that say how long to wait
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s API returned status: %s, body: %s", e.API, e.Status, e.Body)
+}
+
+func (e *StatusError) Unwrap() error {
+	return statusClass(e.StatusCode, e.RetryAfter)
+}
+
+// restCall is one request to a sync target's REST API. Body, if set, is sent
+// as JSON, or as is if it is a []byte, and a successful JSON response is
+// decoded into Out, if set.
+type restCall struct {
+	API     string // Names the API in errors and logs
+	Method  string
+	URL     string
+	Header  http.Header
+	Body    any
+	Out     any
+	Timeout time.Duration // Per attempt; defaultTargetTimeout if 0
+}
+
+// do makes the call with client, which carries the API's authentication.
+// Network errors, server errors and rate limiting are retried.
+func (call restCall) do(ctx context.Context, client *http.Client) error {
+	var body []byte
+	switch b := call.Body.(type) {
+	case nil:
+	case []byte:
+		body = b
+	default:
+		var err error
+		if body, err = json.Marshal(b); err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", call.API, err)
+		}
+	}
+	timeout := call.Timeout
+	if timeout <= 0 {
+		timeout = defaultTargetTimeout
+	}
+
+	err := retry.Do(
+		func() error {
+			callCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(callCtx, call.Method, call.URL, bytes.NewReader(body))
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			if body != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req.Header.Set("Accept", "application/json")
+			for key, values := range call.Header {
+				req.Header[key] = values
+			}
+
+			resp, err := client.Do(req)
+			if errors.Is(err, ErrCircuitOpen) {
+				return retry.Unrecoverable(err)
+			}
+			if err != nil {
+				return err // Retry on network errors
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 300 {
+				bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+				statusErr := &StatusError{
+					API:        call.API,
+					StatusCode: resp.StatusCode,
+					Status:     resp.Status,
+					Body:       string(bodyBytes),
+					RetryAfter: parseRetryAfter(resp.Header),
+				}
+				if retryableStatus(resp.StatusCode) {
+					return statusErr
+				}
+				return retry.Unrecoverable(statusErr)
+			}
+
+			if call.Out == nil || resp.StatusCode == http.StatusNoContent {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(call.Out); err != nil {
+				return retry.Unrecoverable(fmt.Errorf("failed to decode %s response: %w", call.API, err))
+			}
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(3),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			logging.FromContext(ctx).Warn("Retrying sync target request", zap.String("api", call.API), zap.String("method", call.Method), zap.Uint("attempt", n+1), zap.Error(err))
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", call.API, call.Method, networkError(err))
+	}
+	return nil
+}
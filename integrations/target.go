@@ -0,0 +1,123 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// SyncTarget is somewhere cards with due dates are mirrored to. Each card
+// becomes one event, identified by the ID CreateEvent returns.
+type SyncTarget interface {
+	CreateEvent(ctx context.Context, card models.Card) (string, error)
+	// UpdateEvent returns the event's ID, which differs from eventID if the
+	// event had to be recreated
+	UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error)
+	// DeleteEvent treats events that no longer exist as deleted
+	DeleteEvent(ctx context.Context, card models.Card, eventID string) error
+}
+
+// TargetFactory builds a sync target from its settings in the loaded config.
+type TargetFactory func(cfg *config.Config) (SyncTarget, error)
+
+var (
+	targetsMu sync.Mutex
+	targets   = make(map[string]TargetFactory)
+)
+
+// RegisterTarget makes a sync target available to sync rules under name.
+// Targets register themselves from an init function.
+func RegisterTarget(name string, factory TargetFactory) {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	if _, ok := targets[name]; ok {
+		panic("integrations: sync target " + name + " registered twice")
+	}
+	targets[name] = factory
+}
+
+// NewTarget builds the registered sync target called name from cfg.
+func NewTarget(name string, cfg *config.Config) (SyncTarget, error) {
+	targetsMu.Lock()
+	factory, ok := targets[name]
+	targetsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sync target %q", name)
+	}
+	return factory(cfg)
+}
+
+// RegisteredTargets lists the names of the registered sync targets.
+func RegisteredTargets() []string {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CalendarTarget syncs cards to Google Calendar through a CalendarAPI.
+type CalendarTarget struct {
+	Client CalendarAPI
+}
+
+func (t CalendarTarget) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	event, err := t.Client.CreateEvent(ctx, card)
+	if err != nil {
+		return "", err
+	}
+	return event.Id, nil
+}
+
+func (t CalendarTarget) UpdateEvent(ctx context.Context, card models.Card, eventID string) (string, error) {
+	event, err := t.Client.UpdateEvent(ctx, card, eventID)
+	if err != nil {
+		return "", err
+	}
+	return event.Id, nil
+}
+
+func (t CalendarTarget) DeleteEvent(ctx context.Context, card models.Card, eventID string) error {
+	err := t.Client.DeleteEvent(ctx, card.CalendarID, eventID)
+	if isGone(err) {
+		return nil
+	}
+	return err
+}
+
+// TasksTarget syncs cards to Google Tasks through a TasksAPI.
+type TasksTarget struct {
+	Client TasksAPI
+}
+
+func (t TasksTarget) CreateEvent(ctx context.Context, card models.Card) (string, error) {
+	task, err := t.Client.CreateTask(ctx, card)
+	if err != nil {
+		return "", err
+	}
+	return task.Id, nil
+}
+
+func (t TasksTarget) UpdateEvent(ctx context.Context, card models.Card, taskID string) (string, error) {
+	task, err := t.Client.UpdateTask(ctx, card, taskID)
+	if err != nil {
+		return "", err
+	}
+	return task.Id, nil
+}
+
+func (t TasksTarget) DeleteEvent(ctx context.Context, card models.Card, taskID string) error {
+	return t.Client.DeleteTask(ctx, card.TaskListID, taskID)
+}
+
+var (
+	_ SyncTarget = CalendarTarget{}
+	_ SyncTarget = TasksTarget{}
+)
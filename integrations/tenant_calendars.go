@@ -0,0 +1,168 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"google.golang.org/api/calendar/v3"
+)
+
+// TenantCalendars is a CalendarAPI reaching each tenant's calendar through
+// the client acting as the tenant's Google account, and every other calendar
+// through the wrapped CalendarAPI, the service account's. Tenants can be
+// added while it's in use.
+type TenantCalendars struct {
+	CalendarAPI
+
+	cfg       atomic.Pointer[config.Google]
+	tenantsMu sync.RWMutex
+	tenants   map[string]CalendarAPI // By the calendar ID they own
+
+	mu       sync.Mutex
+	channels map[string]CalendarAPI // Watch channels opened on tenants' calendars, by ID
+}
+
+// NewTenantCalendars wraps c, reaching each calendar ID tenants maps to
+// through the client it maps to.
+func NewTenantCalendars(c CalendarAPI, tenants map[string]CalendarAPI) *TenantCalendars {
+	if tenants == nil {
+		tenants = make(map[string]CalendarAPI)
+	}
+	return &TenantCalendars{CalendarAPI: c, tenants: tenants, channels: make(map[string]CalendarAPI)}
+}
+
+var _ CalendarAPI = (*TenantCalendars)(nil)
+
+// client returns the client calendarID is reached through
+func (t *TenantCalendars) client(calendarID string) CalendarAPI {
+	t.tenantsMu.RLock()
+	defer t.tenantsMu.RUnlock()
+	if client, ok := t.tenants[calendarID]; ok {
+		return client
+	}
+	return t.CalendarAPI
+}
+
+// AddTenant reaches calendarID through client from now on, configuring it
+// with the settings last given to Configure.
+func (t *TenantCalendars) AddTenant(calendarID string, client CalendarAPI) {
+	t.tenantsMu.Lock()
+	defer t.tenantsMu.Unlock()
+	if cfg := t.cfg.Load(); cfg != nil {
+		client.Configure(TenantSettings(cfg, calendarID))
+	}
+	t.tenants[calendarID] = client
+}
+
+// Configure hands the google settings on, with each tenant's client seeing
+// its own calendar as the default.
+func (t *TenantCalendars) Configure(cfg *config.Google) {
+	t.tenantsMu.Lock()
+	defer t.tenantsMu.Unlock()
+	t.cfg.Store(cfg)
+	t.CalendarAPI.Configure(cfg)
+	for calendarID, client := range t.tenants {
+		client.Configure(TenantSettings(cfg, calendarID))
+	}
+}
+
+// TenantSettings returns a copy of the google settings for the client of the
+// tenant owning calendarID, which is its default calendar. The service
+// account's calendar aliases and sharing don't apply to it.
+func TenantSettings(cfg *config.Google, calendarID string) *config.Google {
+	settings := *cfg
+	settings.Calendar.CalendarID = calendarID
+	settings.Calendar.ShareWith = nil
+	settings.Calendars = nil
+	return &settings
+}
+
+// ConfiguredCalendarIDs adds the tenants' calendars to the configured ones.
+func (t *TenantCalendars) ConfiguredCalendarIDs() []string {
+	ids := t.CalendarAPI.ConfiguredCalendarIDs()
+	t.tenantsMu.RLock()
+	defer t.tenantsMu.RUnlock()
+	for calendarID := range t.tenants {
+		if !slices.Contains(ids, calendarID) {
+			ids = append(ids, calendarID)
+		}
+	}
+	return ids
+}
+
+func (t *TenantCalendars) CreateEvent(ctx context.Context, card models.Card) (*calendar.Event, error) {
+	return t.client(t.CalendarFor(card)).CreateEvent(ctx, card)
+}
+
+func (t *TenantCalendars) UpdateEvent(ctx context.Context, card models.Card, eventID string) (*calendar.Event, error) {
+	return t.client(t.CalendarFor(card)).UpdateEvent(ctx, card, eventID)
+}
+
+func (t *TenantCalendars) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return t.client(calendarID).DeleteEvent(ctx, calendarID, eventID)
+}
+
+// MoveEvent moves events between calendars of the same account; Google can't
+// move them from one account to another.
+func (t *TenantCalendars) MoveEvent(ctx context.Context, eventID, fromCalendarID, toCalendarID string) (*calendar.Event, error) {
+	client := t.client(fromCalendarID)
+	if client != t.client(toCalendarID) {
+		return nil, fmt.Errorf("can't move event %s from calendar %s to %s, which belongs to another Google account", eventID, fromCalendarID, toCalendarID)
+	}
+	return client.MoveEvent(ctx, eventID, fromCalendarID, toCalendarID)
+}
+
+// ApplyBatch leaves the batch to the service account's client until there are
+// tenants, whose operations each go through their own client.
+func (t *TenantCalendars) ApplyBatch(ctx context.Context, ops []EventOp) ([]EventOpResult, BatchSummary) {
+	t.tenantsMu.RLock()
+	none := len(t.tenants) == 0
+	t.tenantsMu.RUnlock()
+	if none {
+		return t.CalendarAPI.ApplyBatch(ctx, ops)
+	}
+	return ApplyEventOps(ctx, t, t.cfg.Load().Calendar.BatchConcurrency, ops)
+}
+
+func (t *TenantCalendars) CalendarExists(ctx context.Context, calendarID string) bool {
+	return t.client(calendarID).CalendarExists(ctx, calendarID)
+}
+
+func (t *TenantCalendars) ListManagedEvents(ctx context.Context, calendarID string) ([]*calendar.Event, error) {
+	return t.client(calendarID).ListManagedEvents(ctx, calendarID)
+}
+
+func (t *TenantCalendars) WatchEvents(ctx context.Context, calendarID, channelID, address, token string, ttl time.Duration) (*calendar.Channel, error) {
+	client := t.client(calendarID)
+	channel, err := client.WatchEvents(ctx, calendarID, channelID, address, token, ttl)
+	if err == nil && client != t.CalendarAPI {
+		t.mu.Lock()
+		t.channels[channel.Id] = client
+		t.mu.Unlock()
+	}
+	return channel, err
+}
+
+// StopChannel stops the channel through the client that opened it. Channels
+// opened before a restart are stopped through the service account's, which
+// fails for a tenant's; those expire on their own.
+func (t *TenantCalendars) StopChannel(ctx context.Context, channelID, resourceID string) error {
+	t.mu.Lock()
+	client, ok := t.channels[channelID]
+	delete(t.channels, channelID)
+	t.mu.Unlock()
+	if !ok {
+		client = t.CalendarAPI
+	}
+	return client.StopChannel(ctx, channelID, resourceID)
+}
+
+func (t *TenantCalendars) ListChangedEvents(ctx context.Context, calendarID, syncToken string) ([]*calendar.Event, string, error) {
+	return t.client(calendarID).ListChangedEvents(ctx, calendarID, syncToken)
+}
@@ -0,0 +1,99 @@
+package integrations
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+)
+
+// One connection pool per dependency, shared by every client that calls it
+var (
+	googleTransport = newSharedTransport(config.HTTPTransport{})
+	trelloTransport = newSharedTransport(config.HTTPTransport{})
+)
+
+// ConfigureTransports applies the google.http and trello.http settings to
+// the shared transports. Requests already under way finish on the old ones.
+func ConfigureTransports(google, trello config.HTTPTransport) error {
+	googleNext, err := NewTransport(google)
+	if err != nil {
+		return fmt.Errorf("google.http: %w", err)
+	}
+	trelloNext, err := NewTransport(trello)
+	if err != nil {
+		return fmt.Errorf("trello.http: %w", err)
+	}
+	googleTransport.swap(googleNext)
+	trelloTransport.swap(trelloNext)
+	return nil
+}
+
+// NewTransport returns an HTTP transport tuned by cfg, starting from Go's
+// default transport.
+func NewTransport(cfg config.HTTPTransport) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// The same dialer defaults as http.DefaultTransport
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if cfg.DialTimeout > 0 {
+		dialer.Timeout = cfg.DialTimeout
+	}
+	if cfg.KeepAlive != 0 {
+		dialer.KeepAlive = cfg.KeepAlive // Negative disables keep-alive probes
+	}
+	transport.DialContext = dialer.DialContext
+	if cfg.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	switch cfg.TLSMinVersion {
+	case "", "1.2":
+	case "1.3":
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported tls_min_version %q; expected \"1.2\" or \"1.3\"", cfg.TLSMinVersion)
+	}
+	return transport, nil
+}
+
+// sharedTransport forwards requests to a transport that can be replaced on
+// reload without rebuilding the clients using it
+type sharedTransport struct {
+	current atomic.Pointer[http.Transport]
+}
+
+func newSharedTransport(cfg config.HTTPTransport) *sharedTransport {
+	t := &sharedTransport{}
+	transport, _ := NewTransport(cfg)
+	t.current.Store(transport)
+	return t
+}
+
+func (t *sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().RoundTrip(req)
+}
+
+func (t *sharedTransport) swap(next *http.Transport) {
+	if previous := t.current.Swap(next); previous != nil {
+		previous.CloseIdleConnections()
+	}
+}
@@ -2,64 +2,95 @@ package integrations
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/avast/retry-go"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
 	"go.uber.org/zap"
 )
 
+const trelloAPIBase = "https://api.trello.com/1"
+
+const defaultTrelloTimeout = 15 * time.Second
+
+// trelloCallContext bounds a single Trello request by trello.request_timeout
+func trelloCallContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTrelloTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// DefaultWebhookDescription is used when trello.webhook_description is unset
+const DefaultWebhookDescription = "Webhook for Trello-GCal Sync"
+
 type TrelloClient struct {
 	Client      *http.Client
+	BaseURL     string
 	APIKey      string
 	APIToken    string
 	CallbackURL string
+	Description string        // Description given to registered webhooks
+	Timeout     time.Duration // Per-request limit; 0 means the default
 }
 
 func NewTrelloClient(key, token, callbackURL string) *TrelloClient {
 	return &TrelloClient{
-		Client:      &http.Client{},
+		Client:      withBreaker(&http.Client{Transport: requestid.Transport(tracing.Transport(trelloTransport, "trello"))}, trelloBreaker),
+		BaseURL:     trelloAPIBase,
 		APIKey:      key,
 		APIToken:    token,
 		CallbackURL: callbackURL,
+		Description: DefaultWebhookDescription,
 	}
 }
 
-func (tc *TrelloClient) RegisterWebhook(boardId string) (string, error) {
-	apiURL := "https://api.trello.com/1/webhooks/"
+func (tc *TrelloClient) RegisterWebhook(ctx context.Context, boardId string) (string, error) {
+	apiURL := tc.BaseURL + "/webhooks/"
 
 	formData := url.Values{}
 	formData.Set("key", tc.APIKey)
 	formData.Set("token", tc.APIToken)
 	formData.Set("callbackURL", tc.CallbackURL)
 	formData.Set("idModel", boardId)
-	formData.Set("description", "Webhook for Trello-GCal Sync")
+	formData.Set("description", tc.Description)
 
 	var webhookID string
 	err := retry.Do(
 		func() error {
-			req, err := http.NewRequest("POST", apiURL, bytes.NewBufferString(formData.Encode()))
+			callCtx, cancel := trelloCallContext(ctx, tc.Timeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(callCtx, "POST", apiURL, bytes.NewBufferString(formData.Encode()))
 			if err != nil {
 				return retry.Unrecoverable(fmt.Errorf("failed to create post request: %v", err))
 			}
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 			resp, err := tc.Client.Do(req)
+			if errors.Is(err, ErrCircuitOpen) {
+				return retry.Unrecoverable(err)
+			}
 			if err != nil {
 				return err
 			}
 			defer resp.Body.Close()
 
 			if resp.StatusCode != http.StatusOK {
-				if resp.StatusCode >= 500 {
-					bodyBytes, _ := io.ReadAll(resp.Body)
-					return fmt.Errorf("trello API returned 5xx status: %s, body: %s", resp.Status, string(bodyBytes))
+				statusErr := newTrelloStatusError(resp)
+				if retryableStatus(resp.StatusCode) {
+					return statusErr
 				}
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				return retry.Unrecoverable(fmt.Errorf("trello API returned non-retryable status: %s, body: %s", resp.Status, string(bodyBytes)))
+				return retry.Unrecoverable(statusErr)
 			}
 
 			var webhook struct {
@@ -73,64 +104,97 @@ func (tc *TrelloClient) RegisterWebhook(boardId string) (string, error) {
 			webhookID = webhook.ID
 			return nil
 		},
+		retry.Context(ctx),
 		retry.Attempts(3),
 		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
-			zap.L().Warn("Retrying Trello RegisterWebhook", zap.Uint("attempt", n+1), zap.Error(err))
+			logging.FromContext(ctx).Warn("Retrying Trello RegisterWebhook", zap.Uint("attempt", n+1), zap.Error(err))
 		}),
 	)
 
 	if err != nil {
-		return "", fmt.Errorf("unable to register webhook with Trello: %w", err)
+		return "", fmt.Errorf("unable to register webhook with Trello: %w", networkError(err))
 	}
 
-	zap.L().Info("Successfully registered webhook", zap.String("webhookID", webhookID), zap.String("boardID", boardId))
+	logging.FromContext(ctx).Info("Successfully registered webhook", zap.String("webhookID", webhookID), zap.String("boardID", boardId))
 
 	return webhookID, nil
 }
 
-func (tc *TrelloClient) DeleteWebhook(webhookID string) error {
-	apiURL := fmt.Sprintf("https://api.trello.com/1/webhooks/%s", webhookID)
+// ErrWebhookNotOwned is returned when asked to delete a webhook another
+// integration registered on the same token.
+var ErrWebhookNotOwned = errors.New("webhook was not registered by this service")
+
+// OwnsWebhook reports whether webhook was registered by client, judged by its
+// callback URL and description.
+func OwnsWebhook(client TrelloAPI, webhook models.TrelloWebhook) bool {
+	return webhook.CallbackURL == client.WebhookCallbackURL() && webhook.Description == client.WebhookDescription()
+}
+
+// DeleteWebhook deletes a webhook after checking that this service registered
+// it, so other integrations sharing the token are never touched. Webhooks
+// that no longer exist are ignored.
+func (tc *TrelloClient) DeleteWebhook(ctx context.Context, webhookID string) error {
+	webhook, err := tc.GetWebhook(ctx, webhookID)
+	if IsTrelloNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to verify webhook before deleting: %w", err)
+	}
+	if !OwnsWebhook(tc, *webhook) {
+		return fmt.Errorf("refusing to delete webhook %s for %s: %w", webhookID, webhook.CallbackURL, ErrWebhookNotOwned)
+	}
+
+	apiURL := fmt.Sprintf("%s/webhooks/%s", tc.BaseURL, webhookID)
 
 	formData := url.Values{}
 	formData.Set("key", tc.APIKey)
 	formData.Set("token", tc.APIToken)
 
-	err := retry.Do(
+	err = retry.Do(
 		func() error {
-			req, err := http.NewRequest("DELETE", apiURL+"?"+formData.Encode(), nil)
+			callCtx, cancel := trelloCallContext(ctx, tc.Timeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(callCtx, "DELETE", apiURL+"?"+formData.Encode(), nil)
 			if err != nil {
 				return retry.Unrecoverable(fmt.Errorf("failed to create delete request: %v", err))
 			}
 
 			resp, err := tc.Client.Do(req)
+			if errors.Is(err, ErrCircuitOpen) {
+				return retry.Unrecoverable(err)
+			}
 			if err != nil {
 				return err // Retry on network errors
 			}
 			defer resp.Body.Close()
 
 			if resp.StatusCode != http.StatusOK {
-				if resp.StatusCode >= 500 {
-					bodyBytes, _ := io.ReadAll(resp.Body)
-					return fmt.Errorf("trello API returned 5xx status: %s, body: %s", resp.Status, string(bodyBytes))
+				statusErr := newTrelloStatusError(resp)
+				if retryableStatus(resp.StatusCode) {
+					return statusErr
 				}
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				return retry.Unrecoverable(fmt.Errorf("trello API returned non-retryable status: %s, body: %s", resp.Status, string(bodyBytes)))
+				return retry.Unrecoverable(statusErr)
 			}
 			return nil
 		},
+		retry.Context(ctx),
 		retry.Attempts(3),
 		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
 		retry.OnRetry(func(n uint, err error) {
-			zap.L().Warn("Retrying Trello DeleteWebhook", zap.Uint("attempt", n+1), zap.Error(err))
+			logging.FromContext(ctx).Warn("Retrying Trello DeleteWebhook", zap.Uint("attempt", n+1), zap.Error(err))
 		}),
 	)
 
 	if err != nil {
-		return fmt.Errorf("unable to delete webhook with Trello: %w", err)
+		return fmt.Errorf("unable to delete webhook with Trello: %w", networkError(err))
 	}
 
-	zap.L().Info("Successfully deleted webhook", zap.String("webhookID", webhookID))
+	logging.FromContext(ctx).Info("Successfully deleted webhook", zap.String("webhookID", webhookID))
 
 	return nil
 }
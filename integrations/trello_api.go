@@ -0,0 +1,45 @@
+package integrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// TrelloAPI is the subset of the Trello REST API the service uses.
+// TrelloClient implements it against api.trello.com; trellotest.Fake
+// implements it in memory for exercising handlers and startup logic.
+type TrelloAPI interface {
+	RegisterWebhook(ctx context.Context, boardID string) (string, error)
+	DeleteWebhook(ctx context.Context, webhookID string) error
+	GetWebhook(ctx context.Context, webhookID string) (*models.TrelloWebhook, error)
+	ActivateWebhook(ctx context.Context, webhookID string) error
+	ListWebhooks(ctx context.Context) ([]models.TrelloWebhook, error)
+
+	// WebhookCallbackURL and WebhookDescription identify the webhooks this
+	// client registers
+	WebhookCallbackURL() string
+	WebhookDescription() string
+
+	GetMe(ctx context.Context) (*models.TrelloMember, error)
+	GetBoard(ctx context.Context, boardID string) (*models.TrelloBoard, error)
+	GetCard(ctx context.Context, cardID string) (*models.TrelloCard, error)
+	SetCardDue(ctx context.Context, cardID string, due time.Time) error
+	ListCards(ctx context.Context, boardID, filter string) ([]models.TrelloCard, error)
+	ListLists(ctx context.Context, boardID string) ([]models.TrelloList, error)
+	ListLabels(ctx context.Context, boardID string) ([]models.TrelloLabel, error)
+	ListMembers(ctx context.Context, boardID string) ([]models.TrelloMember, error)
+	ListOrganizationBoards(ctx context.Context, orgID string) ([]models.TrelloBoard, error)
+	ListBoardActions(ctx context.Context, boardID, since, filter string) ([]models.TrelloAction, error)
+}
+
+var _ TrelloAPI = (*TrelloClient)(nil)
+
+func (tc *TrelloClient) WebhookCallbackURL() string {
+	return tc.CallbackURL
+}
+
+func (tc *TrelloClient) WebhookDescription() string {
+	return tc.Description
+}
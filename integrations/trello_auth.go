@@ -0,0 +1,42 @@
+package integrations
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+const trelloAuthorizeURL = "https://trello.com/1/authorize"
+
+// TrelloAppName is shown to the user on Trello's authorize page.
+const TrelloAppName = "Trello GCal Sync"
+
+// AuthorizeURL builds the page where a user grants the service a
+// non-expiring read/write token for apiKey. Without a returnURL Trello shows
+// the token for the user to copy; with one, Trello redirects there with the
+// token in the URL fragment.
+func AuthorizeURL(apiKey, returnURL string) string {
+	params := url.Values{}
+	params.Set("key", apiKey)
+	params.Set("name", TrelloAppName)
+	params.Set("expiration", "never")
+	params.Set("scope", "read,write")
+	params.Set("response_type", "token")
+	if returnURL != "" {
+		params.Set("return_url", returnURL)
+		params.Set("callback_method", "fragment")
+	}
+	return trelloAuthorizeURL + "?" + params.Encode()
+}
+
+// GetMe returns the member the client's token belongs to, which doubles as a
+// check that the token is valid.
+func (tc *TrelloClient) GetMe(ctx context.Context) (*models.TrelloMember, error) {
+	var member models.TrelloMember
+	params := url.Values{"fields": {"id,username,fullName"}}
+	if err := tc.get(ctx, "/members/me", params, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
@@ -0,0 +1,354 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// TrelloStatusError is returned when the Trello API responds with a
+// non-success status. It unwraps to the status's error class, such as
+// ErrNotFound.
+type TrelloStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration // Set for 429 responses that say how long to wait
+}
+
+func (e *TrelloStatusError) Error() string {
+	return fmt.Sprintf("trello API returned status: %s, body: %s", e.Status, e.Body)
+}
+
+func (e *TrelloStatusError) Unwrap() error {
+	return statusClass(e.StatusCode, e.RetryAfter)
+}
+
+// newTrelloStatusError reads the body of a failed response into an error
+func newTrelloStatusError(resp *http.Response) *TrelloStatusError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &TrelloStatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       logging.Redact(string(bodyBytes)), // Some echo the request's key and token
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+}
+
+// retryableStatus reports whether a request that got code is worth retrying
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// IsTrelloNotFound reports whether err is a Trello 404 response
+func IsTrelloNotFound(err error) bool {
+	var serr *TrelloStatusError
+	return errors.As(err, &serr) && serr.StatusCode == http.StatusNotFound
+}
+
+// trelloPageSize is the most cards Trello returns per request
+const trelloPageSize = 1000
+
+const cardFields = "id,name,desc,due,start,dueComplete,closed,shortLink,shortUrl,url,idBoard,idList,idLabels,idMembers,labels,dateLastActivity"
+
+// get issues an authenticated GET against the Trello API and decodes the JSON
+// response into out. Server errors and rate limiting are retried.
+func (tc *TrelloClient) get(ctx context.Context, path string, params url.Values, out any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("key", tc.APIKey)
+	params.Set("token", tc.APIToken)
+	apiURL := tc.BaseURL + path + "?" + params.Encode()
+
+	err := retry.Do(
+		func() error {
+			callCtx, cancel := trelloCallContext(ctx, tc.Timeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(callCtx, "GET", apiURL, nil)
+			if err != nil {
+				return retry.Unrecoverable(fmt.Errorf("failed to create get request: %v", err))
+			}
+
+			resp, err := tc.Client.Do(req)
+			if errors.Is(err, ErrCircuitOpen) {
+				return retry.Unrecoverable(err)
+			}
+			if err != nil {
+				return err // Retry on network errors
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				statusErr := newTrelloStatusError(resp)
+				if retryableStatus(resp.StatusCode) {
+					return statusErr
+				}
+				return retry.Unrecoverable(statusErr)
+			}
+
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return retry.Unrecoverable(fmt.Errorf("failed to decode Trello response: %v", err))
+			}
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(3),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			logging.FromContext(ctx).Warn("Retrying Trello GET", zap.String("path", path), zap.Uint("attempt", n+1), zap.Error(err))
+		}),
+	)
+
+	if err != nil {
+		return fmt.Errorf("trello GET %s failed: %w", path, networkError(err))
+	}
+	return nil
+}
+
+// GetBoard fetches a board by ID or shortLink.
+func (tc *TrelloClient) GetBoard(ctx context.Context, boardID string) (*models.TrelloBoard, error) {
+	var board models.TrelloBoard
+	params := url.Values{"fields": {"id,name,closed,shortLink,url,idOrganization"}}
+	if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID), params, &board); err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
+
+// GetCard fetches the authoritative state of a card, including its labels.
+func (tc *TrelloClient) GetCard(ctx context.Context, cardID string) (*models.TrelloCard, error) {
+	var card models.TrelloCard
+	params := url.Values{"fields": {cardFields}}
+	if err := tc.get(ctx, "/cards/"+url.PathEscape(cardID), params, &card); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// ListCards returns every card on a board matching filter ("open", "closed",
+// "all", or "visible"), following Trello's before-ID pagination.
+func (tc *TrelloClient) ListCards(ctx context.Context, boardID, filter string) ([]models.TrelloCard, error) {
+	if filter == "" {
+		filter = "open"
+	}
+
+	var cards []models.TrelloCard
+	before := ""
+	for {
+		params := url.Values{
+			"fields": {cardFields},
+			"limit":  {strconv.Itoa(trelloPageSize)},
+		}
+		if before != "" {
+			params.Set("before", before)
+		}
+
+		var page []models.TrelloCard
+		if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID)+"/cards/"+url.PathEscape(filter), params, &page); err != nil {
+			return nil, err
+		}
+		cards = append(cards, page...)
+
+		if len(page) < trelloPageSize {
+			return cards, nil
+		}
+
+		// Trello IDs begin with a creation timestamp, so the lowest ID is the oldest card
+		before = page[0].ID
+		for _, card := range page {
+			if card.ID < before {
+				before = card.ID
+			}
+		}
+	}
+}
+
+// ListLists returns the open lists on a board.
+func (tc *TrelloClient) ListLists(ctx context.Context, boardID string) ([]models.TrelloList, error) {
+	var lists []models.TrelloList
+	params := url.Values{"fields": {"id,name,closed,idBoard,pos"}}
+	if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID)+"/lists/open", params, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// ListLabels returns every label defined on a board.
+func (tc *TrelloClient) ListLabels(ctx context.Context, boardID string) ([]models.TrelloLabel, error) {
+	var labels []models.TrelloLabel
+	params := url.Values{
+		"fields": {"id,name,color,idBoard"},
+		"limit":  {strconv.Itoa(trelloPageSize)},
+	}
+	if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID)+"/labels", params, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// ListMembers returns the members of a board.
+func (tc *TrelloClient) ListMembers(ctx context.Context, boardID string) ([]models.TrelloMember, error) {
+	var members []models.TrelloMember
+	params := url.Values{"fields": {"id,username,fullName"}}
+	if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID)+"/members", params, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetWebhook fetches a webhook registration, including whether Trello has
+// deactivated it after repeated callback failures.
+func (tc *TrelloClient) GetWebhook(ctx context.Context, webhookID string) (*models.TrelloWebhook, error) {
+	var webhook models.TrelloWebhook
+	if err := tc.get(ctx, "/webhooks/"+url.PathEscape(webhookID), nil, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ActivateWebhook re-enables a webhook Trello has deactivated.
+func (tc *TrelloClient) ActivateWebhook(ctx context.Context, webhookID string) error {
+	params := url.Values{}
+	params.Set("key", tc.APIKey)
+	params.Set("token", tc.APIToken)
+	params.Set("active", "true")
+	apiURL := tc.BaseURL + "/webhooks/" + url.PathEscape(webhookID) + "?" + params.Encode()
+
+	callCtx, cancel := trelloCallContext(ctx, tc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(callCtx, "PUT", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create put request: %v", err)
+	}
+
+	resp, err := tc.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to activate webhook with Trello: %w", networkError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newTrelloStatusError(resp)
+	}
+	return nil
+}
+
+// SetCardDue moves the card's due date to due.
+func (tc *TrelloClient) SetCardDue(ctx context.Context, cardID string, due time.Time) error {
+	params := url.Values{}
+	params.Set("key", tc.APIKey)
+	params.Set("token", tc.APIToken)
+	params.Set("due", due.UTC().Format(time.RFC3339))
+	apiURL := tc.BaseURL + "/cards/" + url.PathEscape(cardID) + "?" + params.Encode()
+
+	callCtx, cancel := trelloCallContext(ctx, tc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(callCtx, "PUT", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create put request: %v", err)
+	}
+
+	resp, err := tc.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to update card with Trello: %w", networkError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newTrelloStatusError(resp)
+	}
+	return nil
+}
+
+// ListWebhooks returns every webhook registered with the client's token.
+func (tc *TrelloClient) ListWebhooks(ctx context.Context) ([]models.TrelloWebhook, error) {
+	var webhooks []models.TrelloWebhook
+	if err := tc.get(ctx, "/tokens/"+url.PathEscape(tc.APIToken)+"/webhooks", nil, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// ListOrganizationBoards returns the open boards in a Trello workspace.
+func (tc *TrelloClient) ListOrganizationBoards(ctx context.Context, orgID string) ([]models.TrelloBoard, error) {
+	var boards []models.TrelloBoard
+	params := url.Values{
+		"filter": {"open"},
+		"fields": {"id,name,closed,shortLink,url,idOrganization"},
+	}
+	if err := tc.get(ctx, "/organizations/"+url.PathEscape(orgID)+"/boards", params, &boards); err != nil {
+		return nil, err
+	}
+	return boards, nil
+}
+
+// ListMemberBoards returns the open boards of the member the client's token
+// belongs to.
+func (tc *TrelloClient) ListMemberBoards(ctx context.Context) ([]models.TrelloBoard, error) {
+	var boards []models.TrelloBoard
+	params := url.Values{
+		"filter": {"open"},
+		"fields": {"id,name,closed,shortLink,url,idOrganization"},
+	}
+	if err := tc.get(ctx, "/members/me/boards", params, &boards); err != nil {
+		return nil, err
+	}
+	return boards, nil
+}
+
+// ListBoardActions returns the board's actions of the given types that are
+// newer than the action with ID since, oldest first. An empty since returns
+// only the most recent action, which callers use to initialise a cursor.
+func (tc *TrelloClient) ListBoardActions(ctx context.Context, boardID, since, filter string) ([]models.TrelloAction, error) {
+	limit := trelloPageSize
+	if since == "" {
+		limit = 1
+	}
+
+	var actions []models.TrelloAction
+	before := ""
+	for {
+		params := url.Values{"limit": {strconv.Itoa(limit)}}
+		if filter != "" {
+			params.Set("filter", filter)
+		}
+		if since != "" {
+			params.Set("since", since)
+		}
+		if before != "" {
+			params.Set("before", before)
+		}
+
+		// Trello returns actions newest first
+		var page []models.TrelloAction
+		if err := tc.get(ctx, "/boards/"+url.PathEscape(boardID)+"/actions", params, &page); err != nil {
+			return nil, err
+		}
+		actions = append(actions, page...)
+
+		if since == "" || len(page) < limit {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+
+	slices.Reverse(actions)
+	return actions, nil
+}
@@ -0,0 +1,333 @@
+// Package trellotest provides an in-memory implementation of
+// integrations.TrelloAPI for exercising code without calling api.trello.com.
+package trellotest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// Fake is a TrelloAPI backed by maps the caller seeds directly. Boards and
+// cards can be looked up by ID or shortLink, missing resources return a 404
+// TrelloStatusError like the real API, and every call is recorded in Calls.
+type Fake struct {
+	mu sync.Mutex
+
+	CallbackURL string
+	Description string
+	Me          models.TrelloMember
+
+	Boards   map[string]models.TrelloBoard // keyed by board ID
+	Cards    map[string]models.TrelloCard  // keyed by card ID
+	Lists    map[string][]models.TrelloList
+	Labels   map[string][]models.TrelloLabel
+	Members  map[string][]models.TrelloMember
+	Actions  map[string][]models.TrelloAction // keyed by board ID, oldest first
+	Webhooks map[string]models.TrelloWebhook  // keyed by webhook ID
+
+	// Errors makes the named method fail with the given error
+	Errors map[string]error
+
+	// Calls records each method called, with its first argument
+	Calls []string
+
+	nextID int
+}
+
+var _ integrations.TrelloAPI = (*Fake)(nil)
+
+func NewFake(callbackURL string) *Fake {
+	return &Fake{
+		CallbackURL: callbackURL,
+		Description: integrations.DefaultWebhookDescription,
+		Boards:      make(map[string]models.TrelloBoard),
+		Cards:       make(map[string]models.TrelloCard),
+		Lists:       make(map[string][]models.TrelloList),
+		Labels:      make(map[string][]models.TrelloLabel),
+		Members:     make(map[string][]models.TrelloMember),
+		Actions:     make(map[string][]models.TrelloAction),
+		Webhooks:    make(map[string]models.TrelloWebhook),
+		Errors:      make(map[string]error),
+	}
+}
+
+// NotFound returns the error the real client produces for a 404.
+func NotFound(what string) error {
+	return &integrations.TrelloStatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: what + " not found"}
+}
+
+// record logs the call and returns any injected error, or the context's
+// error once it is cancelled; callers hold f.mu
+func (f *Fake) record(ctx context.Context, method, arg string) error {
+	f.Calls = append(f.Calls, method+" "+arg)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Errors[method]
+}
+
+func (f *Fake) newID() string {
+	f.nextID++
+	return fmt.Sprintf("%024x", f.nextID)
+}
+
+func (f *Fake) board(ref string) (models.TrelloBoard, bool) {
+	if board, ok := f.Boards[ref]; ok {
+		return board, true
+	}
+	for _, board := range f.Boards {
+		if board.ShortLink == ref {
+			return board, true
+		}
+	}
+	return models.TrelloBoard{}, false
+}
+
+func (f *Fake) RegisterWebhook(ctx context.Context, boardID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "RegisterWebhook", boardID); err != nil {
+		return "", err
+	}
+	if _, ok := f.board(boardID); !ok {
+		return "", &integrations.TrelloStatusError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request", Body: "invalid value for idModel"}
+	}
+
+	webhook := models.TrelloWebhook{ID: f.newID(), IDModel: boardID, CallbackURL: f.CallbackURL, Description: f.Description, Active: true}
+	f.Webhooks[webhook.ID] = webhook
+	return webhook.ID, nil
+}
+
+func (f *Fake) DeleteWebhook(ctx context.Context, webhookID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "DeleteWebhook", webhookID); err != nil {
+		return err
+	}
+	webhook, ok := f.Webhooks[webhookID]
+	if !ok {
+		return nil
+	}
+	if !integrations.OwnsWebhook(f, webhook) {
+		return integrations.ErrWebhookNotOwned
+	}
+	delete(f.Webhooks, webhookID)
+	return nil
+}
+
+func (f *Fake) GetWebhook(ctx context.Context, webhookID string) (*models.TrelloWebhook, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "GetWebhook", webhookID); err != nil {
+		return nil, err
+	}
+	webhook, ok := f.Webhooks[webhookID]
+	if !ok {
+		return nil, NotFound("webhook")
+	}
+	return &webhook, nil
+}
+
+func (f *Fake) ActivateWebhook(ctx context.Context, webhookID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ActivateWebhook", webhookID); err != nil {
+		return err
+	}
+	webhook, ok := f.Webhooks[webhookID]
+	if !ok {
+		return NotFound("webhook")
+	}
+	webhook.Active = true
+	webhook.ConsecutiveFailures = 0
+	f.Webhooks[webhookID] = webhook
+	return nil
+}
+
+func (f *Fake) ListWebhooks(ctx context.Context) ([]models.TrelloWebhook, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListWebhooks", ""); err != nil {
+		return nil, err
+	}
+	webhooks := make([]models.TrelloWebhook, 0, len(f.Webhooks))
+	for _, webhook := range f.Webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	slices.SortFunc(webhooks, func(a, b models.TrelloWebhook) int { return strings.Compare(a.ID, b.ID) })
+	return webhooks, nil
+}
+
+func (f *Fake) WebhookCallbackURL() string {
+	return f.CallbackURL
+}
+
+func (f *Fake) WebhookDescription() string {
+	return f.Description
+}
+
+func (f *Fake) GetMe(ctx context.Context) (*models.TrelloMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "GetMe", ""); err != nil {
+		return nil, err
+	}
+	me := f.Me
+	return &me, nil
+}
+
+func (f *Fake) GetBoard(ctx context.Context, boardID string) (*models.TrelloBoard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "GetBoard", boardID); err != nil {
+		return nil, err
+	}
+	board, ok := f.board(boardID)
+	if !ok {
+		return nil, NotFound("board")
+	}
+	return &board, nil
+}
+
+func (f *Fake) GetCard(ctx context.Context, cardID string) (*models.TrelloCard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "GetCard", cardID); err != nil {
+		return nil, err
+	}
+	if card, ok := f.Cards[cardID]; ok {
+		return &card, nil
+	}
+	for _, card := range f.Cards {
+		if card.ShortLink == cardID {
+			return &card, nil
+		}
+	}
+	return nil, NotFound("card")
+}
+
+func (f *Fake) SetCardDue(ctx context.Context, cardID string, due time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "SetCardDue", cardID); err != nil {
+		return err
+	}
+	card, ok := f.Cards[cardID]
+	if !ok {
+		return NotFound("card")
+	}
+	card.Due = due.UTC().Format(time.RFC3339)
+	f.Cards[cardID] = card
+	return nil
+}
+
+func (f *Fake) ListCards(ctx context.Context, boardID, filter string) ([]models.TrelloCard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListCards", boardID); err != nil {
+		return nil, err
+	}
+
+	var cards []models.TrelloCard
+	for _, card := range f.Cards {
+		if card.IDBoard != boardID {
+			continue
+		}
+		switch filter {
+		case "", "open", "visible":
+			if card.Closed {
+				continue
+			}
+		case "closed":
+			if !card.Closed {
+				continue
+			}
+		}
+		cards = append(cards, card)
+	}
+	slices.SortFunc(cards, func(a, b models.TrelloCard) int { return strings.Compare(a.ID, b.ID) })
+	return cards, nil
+}
+
+func (f *Fake) ListLists(ctx context.Context, boardID string) ([]models.TrelloList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListLists", boardID); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.Lists[boardID]), nil
+}
+
+func (f *Fake) ListLabels(ctx context.Context, boardID string) ([]models.TrelloLabel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListLabels", boardID); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.Labels[boardID]), nil
+}
+
+func (f *Fake) ListMembers(ctx context.Context, boardID string) ([]models.TrelloMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListMembers", boardID); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.Members[boardID]), nil
+}
+
+func (f *Fake) ListOrganizationBoards(ctx context.Context, orgID string) ([]models.TrelloBoard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListOrganizationBoards", orgID); err != nil {
+		return nil, err
+	}
+
+	var boards []models.TrelloBoard
+	for _, board := range f.Boards {
+		if board.IDOrganization == orgID && !board.Closed {
+			boards = append(boards, board)
+		}
+	}
+	slices.SortFunc(boards, func(a, b models.TrelloBoard) int { return strings.Compare(a.ID, b.ID) })
+	return boards, nil
+}
+
+// ListBoardActions treats since as an action ID; an empty since returns only
+// the most recent action, matching TrelloClient.
+func (f *Fake) ListBoardActions(ctx context.Context, boardID, since, filter string) ([]models.TrelloAction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(ctx, "ListBoardActions", boardID); err != nil {
+		return nil, err
+	}
+
+	var actions []models.TrelloAction
+	for _, action := range f.Actions[boardID] {
+		if filter != "" && !slices.Contains(strings.Split(filter, ","), action.Type) {
+			continue
+		}
+		actions = append(actions, action)
+	}
+
+	if since == "" {
+		if len(actions) == 0 {
+			return nil, nil
+		}
+		return actions[len(actions)-1:], nil
+	}
+	for i, action := range actions {
+		if action.ID == since {
+			return slices.Clone(actions[i+1:]), nil
+		}
+	}
+	return actions, nil
+}
@@ -0,0 +1,202 @@
+package trellotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+)
+
+// The kinds of webhook payload Payload fabricates
+const (
+	FixtureDue       = "due"        // updateCard setting or changing the due date
+	FixtureRemoveDue = "remove-due" // updateCard clearing the due date
+	FixtureArchive   = "archive"    // updateCard archiving the card
+	FixtureRename    = "rename"     // updateCard renaming the card
+	FixtureMove      = "move"       // updateCard moving the card to another list
+	FixtureCreate    = "create"     // createCard
+	FixtureMoveAll   = "move-all"   // moveAllCardsInList, Trello's bulk move
+)
+
+// FixtureKinds lists the kinds of payload Payload fabricates.
+var FixtureKinds = []string{FixtureDue, FixtureRemoveDue, FixtureArchive, FixtureRename, FixtureMove, FixtureCreate, FixtureMoveAll}
+
+// FixtureOptions are the boards, lists, card and dates a fabricated payload
+// is about. IDs left empty are made up, and names get placeholders.
+type FixtureOptions struct {
+	BoardID   string
+	BoardName string
+	CardID    string
+	CardName  string
+	// OldName is what a renamed card was called
+	OldName  string
+	ListID   string
+	ListName string
+	// The list moved cards end up in, on TargetBoardID for a bulk move to
+	// another board
+	TargetListID   string
+	TargetListName string
+	TargetBoardID  string
+	MemberID       string
+	// Due is the new due date, or for remove-due the one removed, and OldDue
+	// the due date it replaced, if any
+	Due    time.Time
+	OldDue time.Time
+	// At is when the action happened, now by default
+	At time.Time
+}
+
+// NewID makes up a Trello object ID created at t: the hex creation time
+// followed by random digits, as Trello's are.
+func NewID(t time.Time) string {
+	return fmt.Sprintf("%08x%016x", t.Unix(), rand.Uint64())
+}
+
+// withDefaults fills in the options left empty
+func (o FixtureOptions) withDefaults() FixtureOptions {
+	if o.At.IsZero() {
+		o.At = time.Now().UTC()
+	}
+	// Objects are older than the action about them
+	created := o.At.Add(-24 * time.Hour)
+	fill := func(s *string, value func() string) {
+		if *s == "" {
+			*s = value()
+		}
+	}
+	newID := func() string { return NewID(created) }
+	fill(&o.BoardID, newID)
+	fill(&o.BoardName, func() string { return "Fixture Board" })
+	fill(&o.CardID, newID)
+	fill(&o.CardName, func() string { return "Fixture card" })
+	fill(&o.OldName, func() string { return o.CardName + " (old name)" })
+	fill(&o.ListID, newID)
+	fill(&o.ListName, func() string { return "To Do" })
+	fill(&o.TargetListID, newID)
+	fill(&o.TargetListName, func() string { return "Done" })
+	fill(&o.MemberID, newID)
+	if o.Due.IsZero() {
+		o.Due = o.At.AddDate(0, 0, 7).Truncate(time.Hour)
+	}
+	return o
+}
+
+// shortLink makes up the 8 character short link of the object with id, the
+// same one each time.
+func shortLink(id string) string {
+	const alphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	r := rand.New(rand.NewPCG(hash.Sum64(), 0))
+	var b strings.Builder
+	for range 8 {
+		b.WriteByte(alphabet[r.IntN(len(alphabet))])
+	}
+	return b.String()
+}
+
+// trelloTime formats t as Trello's JSON does, or null for the zero time.
+func trelloTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// Payload fabricates a webhook delivery of the given kind, shaped like
+// Trello's: the action, the board the webhook is registered on as its
+// model, and the webhook.
+func Payload(kind string, opts FixtureOptions) ([]byte, error) {
+	o := opts.withDefaults()
+	board := map[string]any{"id": o.BoardID, "name": o.BoardName, "shortLink": shortLink(o.BoardID)}
+	list := map[string]any{"id": o.ListID, "name": o.ListName}
+	targetList := map[string]any{"id": o.TargetListID, "name": o.TargetListName}
+	card := map[string]any{
+		"id":        o.CardID,
+		"name":      o.CardName,
+		"idShort":   int(rand.N(500)) + 1,
+		"shortLink": shortLink(o.CardID),
+	}
+	data := map[string]any{"card": card, "board": board, "list": list}
+	old := map[string]any{}
+
+	actionType, translationKey := "updateCard", ""
+	switch kind {
+	case FixtureDue:
+		card["due"] = trelloTime(o.Due)
+		old["due"] = trelloTime(o.OldDue)
+		translationKey = "action_changed_a_due_date"
+		if o.OldDue.IsZero() {
+			translationKey = "action_added_a_due_date"
+		}
+	case FixtureRemoveDue:
+		card["due"] = nil
+		old["due"] = trelloTime(o.Due)
+		translationKey = "action_removed_a_due_date"
+	case FixtureArchive:
+		card["closed"] = true
+		old["closed"] = false
+		translationKey = "action_archived_card"
+	case FixtureRename:
+		old["name"] = o.OldName
+		translationKey = "action_renamed_card"
+	case FixtureMove:
+		card["idList"] = o.TargetListID
+		old["idList"] = o.ListID
+		delete(data, "list")
+		data["listBefore"], data["listAfter"] = list, targetList
+		translationKey = "action_move_card_from_list_to_list"
+	case FixtureCreate:
+		actionType, translationKey = "createCard", "action_create_card"
+	case FixtureMoveAll:
+		actionType, translationKey = "moveAllCardsInList", "action_moved_all_cards_in_list"
+		delete(data, "card")
+		data["listAfter"] = targetList
+		if o.TargetBoardID != "" && o.TargetBoardID != o.BoardID {
+			data["boardTarget"] = map[string]any{"id": o.TargetBoardID}
+		}
+	default:
+		return nil, fmt.Errorf("unknown fixture kind %q: must be one of %s", kind, strings.Join(FixtureKinds, ", "))
+	}
+	if len(old) > 0 {
+		data["old"] = old
+	}
+
+	member := map[string]any{
+		"id":       o.MemberID,
+		"username": "fixture",
+		"fullName": "Fixture Member",
+		"initials": "FM",
+	}
+	payload := map[string]any{
+		"model": map[string]any{
+			"id":        o.BoardID,
+			"name":      o.BoardName,
+			"closed":    false,
+			"shortLink": board["shortLink"],
+			"url":       "https://trello.com/b/" + board["shortLink"].(string),
+		},
+		"action": map[string]any{
+			"id":              NewID(o.At),
+			"idMemberCreator": o.MemberID,
+			"type":            actionType,
+			"date":            trelloTime(o.At),
+			"data":            data,
+			"appCreator":      nil,
+			"limits":          map[string]any{},
+			"display":         map[string]any{"translationKey": translationKey},
+			"memberCreator":   member,
+		},
+		"webhook": map[string]any{
+			"id":          NewID(o.At.Add(-48 * time.Hour)),
+			"description": integrations.DefaultWebhookDescription,
+			"idModel":     o.BoardID,
+			"active":      true,
+		},
+	}
+	return json.MarshalIndent(payload, "", "  ")
+}
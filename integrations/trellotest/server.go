@@ -0,0 +1,124 @@
+package trellotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+)
+
+// Edit runs fn with the fake locked, for changing its maps while it is in
+// use. fn must not call the fake's methods.
+func (f *Fake) Edit(fn func(f *Fake)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+// Handler serves the fake as the Trello REST API under /1, for exercising
+// integrations.TrelloClient itself: point its BaseURL at the server's /1.
+// Like Trello, webhooks are only registered once a HEAD request to their
+// callback URL succeeds.
+func (f *Fake) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /1/members/me", serve(func(r *http.Request) (any, error) {
+		return f.GetMe(r.Context())
+	}))
+	mux.HandleFunc("GET /1/boards/{id}", serve(func(r *http.Request) (any, error) {
+		return f.GetBoard(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /1/boards/{id}/cards/{filter}", serve(func(r *http.Request) (any, error) {
+		return f.ListCards(r.Context(), r.PathValue("id"), r.PathValue("filter"))
+	}))
+	mux.HandleFunc("GET /1/boards/{id}/lists/open", serve(func(r *http.Request) (any, error) {
+		return f.ListLists(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /1/boards/{id}/labels", serve(func(r *http.Request) (any, error) {
+		return f.ListLabels(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /1/boards/{id}/members", serve(func(r *http.Request) (any, error) {
+		return f.ListMembers(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /1/boards/{id}/actions", serve(func(r *http.Request) (any, error) {
+		actions, err := f.ListBoardActions(r.Context(), r.PathValue("id"), r.FormValue("since"), r.FormValue("filter"))
+		// Trello lists actions newest first
+		slices.Reverse(actions)
+		return actions, err
+	}))
+	mux.HandleFunc("GET /1/organizations/{id}/boards", serve(func(r *http.Request) (any, error) {
+		return f.ListOrganizationBoards(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /1/cards/{id}", serve(func(r *http.Request) (any, error) {
+		return f.GetCard(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("PUT /1/cards/{id}", serve(func(r *http.Request) (any, error) {
+		due, err := time.Parse(time.RFC3339, r.FormValue("due"))
+		if err != nil {
+			return nil, badRequest("invalid value for due")
+		}
+		return struct{}{}, f.SetCardDue(r.Context(), r.PathValue("id"), due)
+	}))
+	mux.HandleFunc("GET /1/tokens/{token}/webhooks", serve(func(r *http.Request) (any, error) {
+		return f.ListWebhooks(r.Context())
+	}))
+	mux.HandleFunc("POST /1/webhooks/", serve(func(r *http.Request) (any, error) {
+		if err := validateCallback(r.Context(), r.FormValue("callbackURL")); err != nil {
+			return nil, badRequest(err.Error())
+		}
+		id, err := f.RegisterWebhook(r.Context(), r.FormValue("idModel"))
+		return map[string]string{"id": id}, err
+	}))
+	mux.HandleFunc("GET /1/webhooks/{id}", serve(func(r *http.Request) (any, error) {
+		return f.GetWebhook(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("PUT /1/webhooks/{id}", serve(func(r *http.Request) (any, error) {
+		return struct{}{}, f.ActivateWebhook(r.Context(), r.PathValue("id"))
+	}))
+	mux.HandleFunc("DELETE /1/webhooks/{id}", serve(func(r *http.Request) (any, error) {
+		return struct{}{}, f.DeleteWebhook(r.Context(), r.PathValue("id"))
+	}))
+	return mux
+}
+
+// serve writes what fn returns as JSON, or its error as the status the real
+// API would give it
+func serve(fn func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var statusErr *integrations.TrelloStatusError
+			if errors.As(err, &statusErr) {
+				status = statusErr.StatusCode
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func badRequest(body string) error {
+	return &integrations.TrelloStatusError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request", Body: body}
+}
+
+func validateCallback(ctx context.Context, callbackURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, callbackURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("callback URL did not return 200 for a HEAD request")
+	}
+	return nil
+}
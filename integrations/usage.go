@@ -0,0 +1,168 @@
+package integrations
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	_ "time/tzdata" // Quota days are reckoned in Pacific time, which slim images lack
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+)
+
+const defaultQuotaWarnRatio = 0.8
+
+// quotaLocation is where Google resets daily API quotas
+var quotaLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}()
+
+type UsageSnapshot struct {
+	Day    string           `json:"day"`
+	Total  int64            `json:"total"`
+	Budget int64            `json:"budget,omitempty"`
+	Calls  map[string]int64 `json:"calls"`
+	Errors map[string]int64 `json:"errors"`
+}
+
+// APIUsage counts calls made to an external API per quota day, broken down by
+// method and error class, and warns when the count approaches the daily budget
+// in quota. Counts live in memory and
+// restart from zero with the process.
+type APIUsage struct {
+	mu        sync.Mutex
+	name      string
+	quota     config.Quota
+	day       string
+	total     int64
+	calls     map[string]int64
+	errors    map[string]int64
+	warned    bool
+	exhausted bool
+}
+
+func NewAPIUsage(name string, quota config.Quota) *APIUsage {
+	return &APIUsage{name: name, quota: quota, calls: map[string]int64{}, errors: map[string]int64{}}
+}
+
+// Configure replaces the daily budget and warning ratio.
+func (u *APIUsage) Configure(quota config.Quota) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.quota = quota
+}
+
+// Record counts one call to method and its outcome.
+func (u *APIUsage) Record(method string, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rollover()
+	u.total++
+	u.calls[method]++
+	if err != nil {
+		u.errors[classifyError(err)]++
+	}
+
+	budget := u.quota.DailyBudget
+	if budget <= 0 {
+		return
+	}
+
+	ratio := u.quota.WarnRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = defaultQuotaWarnRatio
+	}
+
+	switch {
+	case u.total >= budget && !u.exhausted:
+		u.exhausted = true
+		zap.L().Error("Daily API budget exhausted", zap.String("api", u.name), zap.Int64("calls", u.total), zap.Int64("budget", budget))
+	case float64(u.total) >= ratio*float64(budget) && !u.warned:
+		u.warned = true
+		zap.L().Warn("Approaching daily API budget", zap.String("api", u.name), zap.Int64("calls", u.total), zap.Int64("budget", budget))
+	}
+}
+
+func (u *APIUsage) Snapshot() UsageSnapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rollover()
+	snapshot := UsageSnapshot{
+		Day:    u.day,
+		Total:  u.total,
+		Budget: u.quota.DailyBudget,
+		Calls:  make(map[string]int64, len(u.calls)),
+		Errors: make(map[string]int64, len(u.errors)),
+	}
+	for k, v := range u.calls {
+		snapshot.Calls[k] = v
+	}
+	for k, v := range u.errors {
+		snapshot.Errors[k] = v
+	}
+	return snapshot
+}
+
+// rollover resets the counters when a new quota day starts. Callers hold mu.
+func (u *APIUsage) rollover() {
+	today := time.Now().In(quotaLocation).Format("2006-01-02")
+	if today == u.day {
+		return
+	}
+	u.day = today
+	u.total = 0
+	u.calls = map[string]int64{}
+	u.errors = map[string]int64{}
+	u.warned = false
+	u.exhausted = false
+}
+
+func classifyError(err error) string {
+	if errors.Is(err, ErrCircuitOpen) {
+		return "circuit_open"
+	}
+	var limitErr *CallLimitError
+	if errors.As(err, &limitErr) {
+		return "tenant_limit"
+	}
+
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return "network"
+	}
+
+	switch {
+	case gerr.Code == http.StatusTooManyRequests:
+		return "rate_limited"
+	case gerr.Code == http.StatusForbidden && isRateLimitReason(gerr):
+		return "rate_limited"
+	case gerr.Code >= 500:
+		return "server_error"
+	default:
+		return fmt.Sprintf("http_%d", gerr.Code)
+	}
+}
+
+func isRateLimitReason(gerr *googleapi.Error) bool {
+	for _, item := range gerr.Errors {
+		switch item.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded", "dailyLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// Usage returns today's Calendar API call counts.
+func (c *CalendarClient) Usage() UsageSnapshot {
+	return c.usage.Snapshot()
+}
@@ -0,0 +1,63 @@
+// Package cardlock serialises work on individual cards, so concurrent updates
+// to the same card run one after another while different cards proceed in
+// parallel.
+package cardlock
+
+import (
+	"context"
+	"sync"
+)
+
+type lock struct {
+	held    chan struct{} // Holds a value while the lock is taken
+	waiters int           // Holders plus goroutines waiting for the lock
+}
+
+// Locker hands out one mutex per card ID. Mutexes are created on demand and
+// dropped once nobody holds or waits for them.
+type Locker struct {
+	mu    sync.Mutex
+	locks map[string]*lock
+}
+
+func New() *Locker {
+	return &Locker{locks: make(map[string]*lock)}
+}
+
+// Lock waits until cardID is free or ctx is done. On success it returns a
+// function that releases the lock.
+func (l *Locker) Lock(ctx context.Context, cardID string) (func(), error) {
+	l.mu.Lock()
+	entry, ok := l.locks[cardID]
+	if !ok {
+		entry = &lock{held: make(chan struct{}, 1)}
+		l.locks[cardID] = entry
+	}
+	entry.waiters++
+	l.mu.Unlock()
+
+	select {
+	case entry.held <- struct{}{}:
+	case <-ctx.Done():
+		l.done(cardID, entry)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-entry.held
+			l.done(cardID, entry)
+		})
+	}, nil
+}
+
+// done drops a holder or waiter, forgetting the lock when it was the last
+func (l *Locker) done(cardID string, entry *lock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.waiters--
+	if entry.waiters == 0 {
+		delete(l.locks, cardID)
+	}
+}
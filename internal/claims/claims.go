@@ -0,0 +1,102 @@
+package claims
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	OwnerWebhook       = "webhook"
+	OwnerReconciler    = "reconciler"
+	OwnerCalendarWatch = "calendar-watch"
+
+	pollInterval = 50 * time.Millisecond
+)
+
+type claim struct {
+	owner   string
+	holders int
+	expires time.Time
+}
+
+// Registry tracks which subsystem is currently working on a card. Claims held
+// by the same owner stack, so concurrent webhook workers don't block each
+// other, but a card claimed by one owner can't be claimed by another until it
+// is released or its claim expires.
+type Registry struct {
+	mu     sync.Mutex
+	claims map[string]*claim
+}
+
+func NewRegistry() *Registry {
+	return &Registry{claims: make(map[string]*claim)}
+}
+
+// TryClaim claims cardID for owner, returning false if another owner holds an
+// unexpired claim on it.
+func (r *Registry) TryClaim(cardID, owner string, ttl time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	existing, ok := r.claims[cardID]
+	if ok && now.Before(existing.expires) {
+		if existing.owner != owner {
+			return false
+		}
+		existing.holders++
+		existing.expires = now.Add(ttl)
+		return true
+	}
+
+	r.claims[cardID] = &claim{owner: owner, holders: 1, expires: now.Add(ttl)}
+	return true
+}
+
+// Claim waits until cardID can be claimed for owner or ctx is done.
+func (r *Registry) Claim(ctx context.Context, cardID, owner string, ttl time.Duration) bool {
+	if r.TryClaim(cardID, owner, ttl) {
+		return true
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if r.TryClaim(cardID, owner, ttl) {
+				return true
+			}
+		}
+	}
+}
+
+// Release drops one claim on cardID held by owner.
+func (r *Registry) Release(cardID, owner string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.claims[cardID]
+	if !ok || existing.owner != owner {
+		return
+	}
+	existing.holders--
+	if existing.holders <= 0 {
+		delete(r.claims, cardID)
+	}
+}
+
+// Holder returns the owner currently holding an unexpired claim on cardID.
+func (r *Registry) Holder(cardID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.claims[cardID]
+	if !ok || time.Now().After(existing.expires) {
+		return "", false
+	}
+	return existing.owner, true
+}
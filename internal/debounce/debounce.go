@@ -0,0 +1,76 @@
+// Package debounce coalesces bursts of updates to the same card, such as
+// those fired while a due date is dragged around, so only the last of them
+// is synced.
+package debounce
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	latest string    // ID of the latest update noted
+	at     time.Time // When it was noted
+}
+
+// Debouncer tracks the latest update noted for each card until it has been
+// synced. Updates it never noted, such as jobs resumed after a restart or
+// taken from a broker another instance fed, are not held back.
+type Debouncer struct {
+	mu    sync.Mutex
+	cards map[string]*entry
+}
+
+func New() *Debouncer {
+	return &Debouncer{cards: make(map[string]*entry)}
+}
+
+// Note records that update updateID to cardID arrived, superseding any
+// noted before it.
+func (d *Debouncer) Note(cardID, updateID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cards[cardID] = &entry{latest: updateID, at: time.Now()}
+}
+
+// Wait blocks until no update to cardID has been noted for window, or ctx is
+// done. It reports whether updateID is still the latest; if not, a later
+// update will sync the card's final state and this one can be skipped.
+func (d *Debouncer) Wait(ctx context.Context, cardID, updateID string, window time.Duration) (bool, error) {
+	for {
+		d.mu.Lock()
+		e, ok := d.cards[cardID]
+		if !ok {
+			d.mu.Unlock()
+			return true, nil
+		}
+		if e.latest != updateID {
+			d.mu.Unlock()
+			return false, nil
+		}
+		remaining := time.Until(e.at.Add(window))
+		d.mu.Unlock()
+		if remaining <= 0 {
+			return true, nil
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Done forgets cardID once its latest update, updateID, has been synced or
+// won't be, such as when it couldn't be queued.
+func (d *Debouncer) Done(cardID, updateID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.cards[cardID]; ok && e.latest == updateID {
+		delete(d.cards, cardID)
+	}
+}
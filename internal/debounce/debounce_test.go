@@ -0,0 +1,107 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const window = 50 * time.Millisecond
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name string
+		// run notes updates and waits on one, returning Wait's results
+		run        func(d *Debouncer) (bool, error)
+		wantLatest bool
+		wantErr    error
+	}{
+		{
+			name: "update never noted isn't held back",
+			run: func(d *Debouncer) (bool, error) {
+				return d.Wait(context.Background(), "c1", "u1", window)
+			},
+			wantLatest: true,
+		},
+		{
+			name: "latest update waits out the window",
+			run: func(d *Debouncer) (bool, error) {
+				d.Note("c1", "u1")
+				start := time.Now()
+				latest, err := d.Wait(context.Background(), "c1", "u1", window)
+				if elapsed := time.Since(start); elapsed < window {
+					return false, errors.New("returned before the window passed")
+				}
+				return latest, err
+			},
+			wantLatest: true,
+		},
+		{
+			name: "superseded before waiting",
+			run: func(d *Debouncer) (bool, error) {
+				d.Note("c1", "u1")
+				d.Note("c1", "u2")
+				return d.Wait(context.Background(), "c1", "u1", window)
+			},
+			wantLatest: false,
+		},
+		{
+			name: "superseded while waiting",
+			run: func(d *Debouncer) (bool, error) {
+				d.Note("c1", "u1")
+				time.AfterFunc(window/2, func() { d.Note("c1", "u2") })
+				return d.Wait(context.Background(), "c1", "u1", window)
+			},
+			wantLatest: false,
+		},
+		{
+			name: "other cards don't interfere",
+			run: func(d *Debouncer) (bool, error) {
+				d.Note("c1", "u1")
+				d.Note("c2", "u2")
+				return d.Wait(context.Background(), "c1", "u1", window)
+			},
+			wantLatest: true,
+		},
+		{
+			name: "cancelled",
+			run: func(d *Debouncer) (bool, error) {
+				d.Note("c1", "u1")
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return d.Wait(ctx, "c1", "u1", time.Hour)
+			},
+			wantLatest: false,
+			wantErr:    context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latest, err := tt.run(New())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+			if latest != tt.wantLatest {
+				t.Errorf("Wait() latest = %v, want %v", latest, tt.wantLatest)
+			}
+		})
+	}
+}
+
+func TestDoneOnlyForgetsTheLatestUpdate(t *testing.T) {
+	d := New()
+	d.Note("c1", "u1")
+	d.Note("c1", "u2")
+
+	// The superseded update finishing leaves the later one waiting
+	d.Done("c1", "u1")
+	if latest, _ := d.Wait(context.Background(), "c1", "u1", window); latest {
+		t.Error("superseded update reported latest after its Done")
+	}
+
+	d.Done("c1", "u2")
+	if latest, _ := d.Wait(context.Background(), "c1", "u1", window); !latest {
+		t.Error("update held back after the card was forgotten")
+	}
+}
@@ -0,0 +1,190 @@
+// Package digest builds and emails the summary of upcoming due dates sent on
+// the schedule in the digest settings.
+package digest
+
+import (
+	"cmp"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// Schedules
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// Next returns the first time after t a digest is due.
+func Next(cfg config.Digest, t time.Time) (time.Time, error) {
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid digest.timezone: %w", err)
+		}
+	}
+	at, err := time.Parse("15:04", cfg.At)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid digest.at %q: must be like 08:00", cfg.At)
+	}
+
+	t = t.In(location)
+	next := time.Date(t.Year(), t.Month(), t.Day(), at.Hour(), at.Minute(), 0, 0, location)
+	switch strings.ToLower(cfg.Schedule) {
+	case Daily:
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+	case Weekly:
+		weekday, ok := weekdays[strings.ToLower(cfg.Weekday)]
+		if !ok {
+			return time.Time{}, fmt.Errorf("invalid digest.weekday %q", cfg.Weekday)
+		}
+		next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+	default:
+		return time.Time{}, fmt.Errorf("invalid digest.schedule %q: must be %q or %q", cfg.Schedule, Daily, Weekly)
+	}
+	return next, nil
+}
+
+// Item is a card in the digest and, if it has one, the link to its calendar
+// event.
+type Item struct {
+	Card      models.Card
+	EventLink string
+}
+
+// Build returns the subject and plain-text body of a digest of items, which
+// are due within days of now. boardNames maps board IDs to names; boards
+// missing from it are shown by ID.
+func Build(items []Item, boardNames map[string]string, now time.Time, days int) (string, string) {
+	subject := fmt.Sprintf("Trello: %d card(s) due in the next %d days", len(items), days)
+
+	var body strings.Builder
+	if len(items) == 0 {
+		fmt.Fprintf(&body, "No synced cards are due in the next %d days.\n", days)
+		return subject, body.String()
+	}
+
+	byBoard := make(map[string][]Item)
+	for _, item := range items {
+		byBoard[item.Card.BoardID] = append(byBoard[item.Card.BoardID], item)
+	}
+	boardName := func(id string) string {
+		if name := boardNames[id]; name != "" {
+			return name
+		}
+		return "Board " + id
+	}
+	boards := make([]string, 0, len(byBoard))
+	for id := range byBoard {
+		boards = append(boards, id)
+	}
+	slices.SortFunc(boards, func(a, b string) int {
+		return cmp.Compare(boardName(a), boardName(b))
+	})
+
+	fmt.Fprintf(&body, "Cards due between %s and %s:\n",
+		now.Format("Mon 2 Jan"), now.AddDate(0, 0, days).Format("Mon 2 Jan"))
+	for _, id := range boards {
+		fmt.Fprintf(&body, "\n%s\n%s\n", boardName(id), strings.Repeat("=", len([]rune(boardName(id)))))
+		for _, item := range byBoard[id] {
+			fmt.Fprintf(&body, "\n- %s\n  Due %s\n  Card: %s\n",
+				item.Card.Name, item.Card.DueDate.In(now.Location()).Format("Mon 2 Jan 15:04"), item.Card.URL)
+			if item.EventLink != "" {
+				fmt.Fprintf(&body, "  Event: %s\n", item.EventLink)
+			}
+		}
+	}
+	return subject, body.String()
+}
+
+// Send emails the digest to cfg.To through cfg.SMTP.
+func Send(cfg config.Digest, subject, body string) error {
+	if cfg.SMTP.Host == "" {
+		return fmt.Errorf("digest.smtp.host is not set")
+	}
+	from := cfg.From
+	if from == "" {
+		from = cfg.SMTP.Username
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if cfg.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Host)
+	}
+	address := net.JoinHostPort(cfg.SMTP.Host, cfg.SMTP.Port)
+	if cfg.SMTP.Port != "465" {
+		if err := smtp.SendMail(address, auth, from, cfg.To, []byte(msg.String())); err != nil {
+			return fmt.Errorf("sending digest: %w", err)
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{ServerName: cfg.SMTP.Host})
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", address, err)
+	}
+	client, err := smtp.NewClient(conn, cfg.SMTP.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", address, err)
+	}
+	defer client.Close()
+	if err := sendOn(client, auth, from, cfg.To, msg.String()); err != nil {
+		return fmt.Errorf("sending digest: %w", err)
+	}
+	return nil
+}
+
+func sendOn(client *smtp.Client, auth smtp.Auth, from string, to []string, msg string) error {
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
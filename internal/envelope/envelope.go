@@ -0,0 +1,115 @@
+// Package envelope encrypts values with AES-GCM data keys that are in turn
+// encrypted ("wrapped") by a key-encryption key kept outside the database,
+// in the environment or a KMS.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of data keys and local key-encryption keys: AES-256
+const KeySize = 32
+
+// Wrapper encrypts and decrypts data keys with a key-encryption key.
+type Wrapper interface {
+	// ID names the key-encryption key, so a stored data key can be matched
+	// with the wrapper that opens it
+	ID() string
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// NewDataKey returns a random data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with key, binding it to context so the result
+// can't be moved to stand in for a different value. The nonce is prepended.
+func Seal(key, plaintext, context []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, context), nil
+}
+
+// Open decrypts what Seal returned for the same key and context.
+func Open(key, sealed, context []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, context)
+	if err != nil {
+		return nil, errors.New("decryption failed; the ciphertext was altered or the key is wrong")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// local wraps data keys with a key-encryption key given in the config
+type local struct {
+	key []byte
+	id  string
+}
+
+// Local returns a Wrapper for a key given as base64, such as the output of
+// `openssl rand -base64 32`.
+func Local(encoded string) (Wrapper, error) {
+	encoded = strings.TrimSpace(encoded)
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	}
+	if err != nil {
+		return nil, errors.New("encryption key isn't valid base64")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key is %d bytes; it must be %d", len(key), KeySize)
+	}
+
+	// Data keys record a fingerprint of the key that wrapped them, never the key
+	sum := sha256.Sum256(key)
+	return &local{key: key, id: "local:" + hex.EncodeToString(sum[:8])}, nil
+}
+
+func (l *local) ID() string {
+	return l.id
+}
+
+func (l *local) Wrap(_ context.Context, dataKey []byte) ([]byte, error) {
+	return Seal(l.key, dataKey, []byte(l.id))
+}
+
+func (l *local) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	return Open(l.key, wrapped, []byte(l.id))
+}
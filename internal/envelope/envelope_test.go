@@ -0,0 +1,118 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("trello-api-token")
+	context := []byte("credential:trello.api_token")
+	sealed, err := Seal(key, plaintext, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) []byte {
+		altered := bytes.Clone(sealed)
+		altered[i] ^= 1
+		return altered
+	}
+	tests := []struct {
+		name    string
+		key     []byte
+		sealed  []byte
+		context []byte
+		wantErr bool
+	}{
+		{name: "same key and context", key: key, sealed: sealed, context: context},
+		{name: "wrong key", key: otherKey, sealed: sealed, context: context, wantErr: true},
+		{name: "other context", key: key, sealed: sealed, context: []byte("credential:google.refresh_token"), wantErr: true},
+		{name: "altered nonce", key: key, sealed: flip(0), context: context, wantErr: true},
+		{name: "altered ciphertext", key: key, sealed: flip(len(sealed) / 2), context: context, wantErr: true},
+		{name: "altered tag", key: key, sealed: flip(len(sealed) - 1), context: context, wantErr: true},
+		{name: "truncated", key: key, sealed: sealed[:len(sealed)-1], context: context, wantErr: true},
+		{name: "shorter than a nonce", key: key, sealed: sealed[:4], context: context, wantErr: true},
+		{name: "invalid key size", key: key[:5], sealed: sealed, context: context, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.key, tt.sealed, tt.context)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Open() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Open() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := Seal(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Seal(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+}
+
+func TestLocalWrapper(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	wrapper, err := Local(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := wrapper.Wrap(t.Context(), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := wrapper.Unwrap(t.Context(), wrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("Unwrap() = %x, %v; want the data key", unwrapped, err)
+	}
+
+	other, err := Local(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, KeySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID() == wrapper.ID() {
+		t.Error("different keys have the same ID")
+	}
+	if _, err := other.Unwrap(t.Context(), wrapped); err == nil {
+		t.Error("a data key was unwrapped with the wrong key-encryption key")
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		if _, err := Local(encoded); err == nil {
+			t.Errorf("Local(%q) accepted an invalid key", encoded)
+		}
+	}
+}
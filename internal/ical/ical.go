@@ -0,0 +1,94 @@
+// Package ical renders a minimal RFC 5545 calendar of all-day events.
+package ical
+
+import (
+	"strings"
+	"time"
+)
+
+const maxLineOctets = 75
+
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Date        time.Time // All-day event on this date
+	Modified    time.Time
+}
+
+type Calendar struct {
+	Name   string
+	Method string // "PUBLISH" for feeds; CalDAV objects must have none
+	Events []Event
+}
+
+// Render serialises the calendar with CRLF line endings and folded lines.
+func (c Calendar) Render() string {
+	var b strings.Builder
+	w := func(line string) {
+		b.WriteString(fold(line))
+		b.WriteString("\r\n")
+	}
+
+	w("BEGIN:VCALENDAR")
+	w("VERSION:2.0")
+	w("PRODID:-//trello-gcal-sync//EN")
+	w("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		w("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		w("X-WR-CALNAME:" + escape(c.Name))
+	}
+
+	for _, event := range c.Events {
+		w("BEGIN:VEVENT")
+		w("UID:" + escape(event.UID))
+		w("DTSTAMP:" + event.Modified.UTC().Format("20060102T150405Z"))
+		w("LAST-MODIFIED:" + event.Modified.UTC().Format("20060102T150405Z"))
+		w("DTSTART;VALUE=DATE:" + event.Date.Format("20060102"))
+		w("DTEND;VALUE=DATE:" + event.Date.AddDate(0, 0, 1).Format("20060102"))
+		w("SUMMARY:" + escape(event.Summary))
+		if event.Description != "" {
+			w("DESCRIPTION:" + escape(event.Description))
+		}
+		if event.URL != "" {
+			w("URL:" + event.URL)
+		}
+		w("TRANSP:TRANSPARENT")
+		w("END:VEVENT")
+	}
+
+	w("END:VCALENDAR")
+	return b.String()
+}
+
+// escape applies TEXT value escaping from RFC 5545 section 3.3.11
+func escape(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	return replacer.Replace(s)
+}
+
+// fold splits lines longer than 75 octets, continuing them with a space,
+// without breaking multi-byte UTF-8 sequences.
+func fold(line string) string {
+	if len(line) <= maxLineOctets {
+		return line
+	}
+
+	var b strings.Builder
+	limit := maxLineOctets
+	count := 0
+	for _, r := range line {
+		size := len(string(r))
+		if count+size > limit {
+			b.WriteString("\r\n ")
+			count = 0
+			limit = maxLineOctets - 1 // Account for the leading space
+		}
+		b.WriteRune(r)
+		count += size
+	}
+	return b.String()
+}
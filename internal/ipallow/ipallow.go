@@ -0,0 +1,187 @@
+// Package ipallow restricts requests to the address ranges a service
+// publishes for its outgoing traffic, such as those Trello sends webhooks
+// from, refreshing them as the published list changes.
+package ipallow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// List holds the allowed ranges: those last fetched from a published list,
+// plus fixed extra ones that are always allowed. Until a fetch succeeds only
+// the extra ranges are allowed.
+type List struct {
+	url     string
+	product string
+	client  *http.Client
+	extra   []netip.Prefix
+	proxies []netip.Prefix
+	fetched atomic.Pointer[[]netip.Prefix]
+}
+
+// New returns a List of the egress ranges of product published at url,
+// along with the extra CIDRs. Requests from the trustedProxies CIDRs are
+// judged by the address they forwarded for instead.
+func New(url, product string, extra, trustedProxies []string) (*List, error) {
+	extraPrefixes, err := parsePrefixes(extra)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &List{
+		url:     url,
+		product: product,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		extra:   extraPrefixes,
+		proxies: proxies,
+	}, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		// A lone address stands for itself
+		if addr, err := netip.ParseAddr(cidr); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ranges is the published list's format
+type ranges struct {
+	Items []struct {
+		CIDR      string   `json:"cidr"`
+		Product   []string `json:"product"`
+		Direction []string `json:"direction"`
+	} `json:"items"`
+}
+
+// Refresh fetches the published ranges, keeping the previous ones if that
+// fails.
+func (l *List) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching IP ranges: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching IP ranges: %s", resp.Status)
+	}
+
+	var published ranges
+	if err := json.NewDecoder(resp.Body).Decode(&published); err != nil {
+		return fmt.Errorf("decoding IP ranges: %w", err)
+	}
+	var prefixes []netip.Prefix
+	for _, item := range published.Items {
+		// Items without products or directions apply to all of them
+		if len(item.Product) > 0 && !slices.ContainsFunc(item.Product, func(p string) bool { return strings.EqualFold(p, l.product) }) {
+			continue
+		}
+		if len(item.Direction) > 0 && !slices.Contains(item.Direction, "egress") {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item.CIDR)
+		if err != nil {
+			zap.L().Warn("Skipping invalid published IP range", zap.String("cidr", item.CIDR), zap.Error(err))
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	if len(prefixes) == 0 {
+		return fmt.Errorf("no IP ranges for %s published at %s", l.product, l.url)
+	}
+
+	l.fetched.Store(&prefixes)
+	zap.L().Debug("Refreshed published IP ranges", zap.String("product", l.product), zap.Int("ranges", len(prefixes)))
+	return nil
+}
+
+// retryInterval is how soon a refresh is retried while no ranges have been
+// fetched yet
+const retryInterval = time.Minute
+
+// Run refreshes the ranges every interval until ctx is cancelled.
+func (l *List) Run(ctx context.Context, interval time.Duration) {
+	for {
+		wait := interval
+		if l.fetched.Load() == nil {
+			wait = min(interval, retryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+			if err := l.Refresh(ctx); err != nil {
+				zap.L().Warn("Failed to refresh published IP ranges; keeping the previous ones", zap.String("product", l.product), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Allows reports whether the request came from an allowed address, and the
+// address it was judged by.
+func (l *List) Allows(r *http.Request) (bool, netip.Addr) {
+	addr := l.clientAddr(r)
+	if !addr.IsValid() {
+		return false, addr
+	}
+	if slices.ContainsFunc(l.extra, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+		return true, addr
+	}
+	fetched := l.fetched.Load()
+	return fetched != nil && slices.ContainsFunc(*fetched, func(p netip.Prefix) bool { return p.Contains(addr) }), addr
+}
+
+// clientAddr is the request's remote address or, when that is a trusted
+// proxy, the last address in X-Forwarded-For not added by one
+func (l *List) clientAddr(r *http.Request) netip.Addr {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr := remote.Addr().Unmap()
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0 && l.trusted(addr); i-- {
+		hop, err := netip.ParseAddr(forwarded[i])
+		if err != nil {
+			return netip.Addr{}
+		}
+		addr = hop.Unmap()
+	}
+	return addr
+}
+
+func (l *List) trusted(addr netip.Addr) bool {
+	return slices.ContainsFunc(l.proxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
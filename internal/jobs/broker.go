@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Queue backends
+const (
+	BackendDatabase = "database"
+	BackendRedis    = "redis"
+	BackendNATS     = "nats"
+)
+
+// Broker carries jobs through a queue outside the process, so any instance
+// can accept a job and any other can run it.
+type Broker interface {
+	// Publish adds job to the queue. Once it returns nil the broker holds the
+	// job until a worker acknowledges it.
+	Publish(ctx context.Context, job Job) error
+	// Receive waits for the next job, returning an error once ctx is done.
+	Receive(ctx context.Context) (Delivery, error)
+	Close() error
+}
+
+// Delivery is a job handed to one worker. A delivery that is neither acked
+// nor retried, because its worker stopped, is handed to another worker once
+// the broker's redelivery timeout passes.
+type Delivery interface {
+	Job() Job
+	// Ack removes the job from the queue.
+	Ack() error
+	// Retry queues the job again with its retry count set to retries, to be
+	// handed out no sooner than delay from now, and removes this delivery.
+	Retry(retries int, delay time.Duration) error
+}
+
+// OpenBroker connects to the broker cfg configures, with consumer naming this
+// instance's workers. It returns nil for the database backend, which needs no
+// broker.
+func OpenBroker(ctx context.Context, cfg config.Queue, consumer string) (Broker, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendDatabase:
+		return nil, nil
+	case BackendRedis:
+		return NewRedisBroker(ctx, cfg, consumer)
+	case BackendNATS:
+		return NewNATSBroker(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("invalid sync.queue.backend %q: must be %q, %q or %q", cfg.Backend, BackendDatabase, BackendRedis, BackendNATS)
+	}
+}
+
+// message is a job as brokers carry it
+type message struct {
+	Account   string                      `json:"account"`
+	RequestID string                      `json:"requestID,omitempty"`
+	Payload   models.TrelloWebhookPayload `json:"payload"`
+	// Trace holds the W3C trace context of the span that received the job
+	Trace   propagation.MapCarrier `json:"trace,omitempty"`
+	Retries int                    `json:"retries,omitempty"`
+	// NotBefore is when a retried job is due, in Unix milliseconds, for
+	// brokers that can't hold messages back themselves
+	NotBefore int64 `json:"notBefore,omitempty"`
+}
+
+func encodeJob(job Job, notBefore time.Time) ([]byte, error) {
+	msg := message{
+		Account:   job.Account,
+		RequestID: job.RequestID,
+		Payload:   job.Payload,
+		Retries:   job.retries,
+	}
+	if job.Trace.IsValid() {
+		msg.Trace = propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), job.Trace), msg.Trace)
+	}
+	if !notBefore.IsZero() {
+		msg.NotBefore = notBefore.UnixMilli()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encoding job: %w", err)
+	}
+	return data, nil
+}
+
+func decodeJob(data []byte) (Job, time.Time, error) {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Job{}, time.Time{}, fmt.Errorf("decoding job: %w", err)
+	}
+	job := Job{
+		Account:   msg.Account,
+		RequestID: msg.RequestID,
+		Payload:   msg.Payload,
+		retries:   msg.Retries,
+	}
+	if msg.Trace != nil {
+		job.Trace = trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), msg.Trace))
+	}
+	var notBefore time.Time
+	if msg.NotBefore != 0 {
+		notBefore = time.UnixMilli(msg.NotBefore)
+	}
+	return job, notBefore, nil
+}
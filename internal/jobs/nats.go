@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// NATSBroker queues jobs on a JetStream work-queue stream read through a
+// durable pull consumer, which removes each job once it is acknowledged.
+type NATSBroker struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	subject  string
+	consumer jetstream.Consumer
+}
+
+// NewNATSBroker connects to the NATS server at cfg.URL and creates the stream
+// and consumer if they don't exist yet.
+func NewNATSBroker(ctx context.Context, cfg config.Queue) (*NATSBroker, error) {
+	conn, err := nats.Connect(cfg.URL, nats.Name("trello-gcal-sync"))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to JetStream: %w", err)
+	}
+
+	subject := cfg.Stream + ".jobs"
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      cfg.Stream,
+		Subjects:  []string{subject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating JetStream stream: %w", err)
+	}
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:    cfg.Group,
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    cfg.RedeliverAfter,
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating JetStream consumer: %w", err)
+	}
+	return &NATSBroker{conn: conn, js: js, subject: subject, consumer: consumer}, nil
+}
+
+// Publish adds job to the stream.
+func (b *NATSBroker) Publish(ctx context.Context, job Job) error {
+	return b.publish(ctx, job, time.Time{})
+}
+
+func (b *NATSBroker) publish(ctx context.Context, job Job, notBefore time.Time) error {
+	data, err := encodeJob(job, notBefore)
+	if err != nil {
+		return err
+	}
+	if _, err := b.js.Publish(ctx, b.subject, data); err != nil {
+		return fmt.Errorf("publishing job to JetStream: %w", err)
+	}
+	return nil
+}
+
+// Receive returns the next job that is due. A retried job received early is
+// handed back to be redelivered when it is due.
+func (b *NATSBroker) Receive(ctx context.Context) (Delivery, error) {
+	for {
+		msg, err := b.consumer.Next(jetstream.FetchContext(ctx))
+		if errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading jobs from JetStream: %w", err)
+		}
+
+		job, notBefore, err := decodeJob(msg.Data())
+		if err != nil {
+			zap.L().Error("Dropping unreadable job from JetStream", zap.Error(err))
+			if err := msg.Term(); err != nil {
+				zap.L().Warn("Failed to delete unreadable job from JetStream", zap.Error(err))
+			}
+			continue
+		}
+		if wait := time.Until(notBefore); wait > 0 {
+			if err := msg.NakWithDelay(wait); err != nil {
+				zap.L().Warn("Failed to hold back retried job in JetStream", zap.Error(err))
+			}
+			continue
+		}
+		return &natsDelivery{broker: b, msg: msg, job: job}, nil
+	}
+}
+
+// Close disconnects from NATS, first sending anything still buffered.
+func (b *NATSBroker) Close() error {
+	return b.conn.Drain()
+}
+
+type natsDelivery struct {
+	broker *NATSBroker
+	msg    jetstream.Msg
+	job    Job
+}
+
+func (d *natsDelivery) Job() Job {
+	return d.job
+}
+
+func (d *natsDelivery) Ack() error {
+	if err := d.msg.Ack(); err != nil {
+		return fmt.Errorf("acknowledging job in JetStream: %w", err)
+	}
+	return nil
+}
+
+// Retry publishes a copy carrying the new retry count before acknowledging
+// the delivery, since a redelivered message can't be changed. The copy is
+// held back by NakWithDelay when received early.
+func (d *natsDelivery) Retry(retries int, delay time.Duration) error {
+	job := d.job
+	job.retries = retries
+	if err := d.broker.publish(context.Background(), job, time.Now().Add(delay)); err != nil {
+		return err
+	}
+	return d.Ack()
+}
@@ -0,0 +1,559 @@
+// Package jobs runs card syncs on a bounded pool of workers so webhook
+// requests can be acknowledged before the sync finishes. Jobs are stored in
+// the database until they are processed, so none are lost if the service
+// stops in between, or carried by an external Broker so any instance can run
+// a job another accepted.
+package jobs
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrQueueFull is returned by Enqueue when every buffered slot is taken.
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrQueueClosed is returned by Enqueue once the queue is shutting down.
+var ErrQueueClosed = errors.New("job queue is closed")
+
+// RetryError asks the queue to run a job again after a delay rather than
+// dropping it, for failures that are expected to clear up on their own.
+type RetryError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("retrying in %s: %v", e.After, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Retry wraps err so the queue retries the job after the given delay.
+func Retry(err error, after time.Duration) error {
+	return &RetryError{After: after, Err: err}
+}
+
+// OutageError asks the queue to hold a job until the API it needs is back,
+// for outages that can outlast any retry delay.
+type OutageError struct {
+	Err error
+}
+
+func (e *OutageError) Error() string {
+	return fmt.Sprintf("waiting for outage to end: %v", e.Err)
+}
+
+func (e *OutageError) Unwrap() error {
+	return e.Err
+}
+
+// Outage wraps err so the queue buffers the job and replays it, in order,
+// once calls succeed again.
+func Outage(err error) error {
+	return &OutageError{Err: err}
+}
+
+// Job is one Trello action to sync, and the account it was delivered to.
+type Job struct {
+	Account string
+	Payload models.TrelloWebhookPayload
+	// RequestID is the ID of the request that delivered the job
+	RequestID string
+	// Trace is the span that received the job, so its sync joins the same
+	// trace. It isn't stored; resumed jobs start traces of their own.
+	Trace trace.SpanContext
+
+	id      uint // PendingJob row, deleted once the job has run
+	retries int
+}
+
+// logger tags log lines with the job's account, request and action, matching
+// the lines logged while it was processed
+func (j Job) logger() *zap.Logger {
+	return zap.L().With(
+		zap.String("requestID", j.RequestID),
+		zap.String("account", j.Account),
+		zap.String("actionID", j.Payload.Action.ID),
+	)
+}
+
+// maxRetries bounds how often a job asking to be retried is put back, so one
+// that never succeeds doesn't circulate forever
+const maxRetries = 10
+
+// replayInterval is how often the oldest buffered job is tried again to see
+// whether an outage is over
+const replayInterval = 30 * time.Second
+
+// Queue buffers jobs for a fixed number of workers.
+type Queue struct {
+	db      *gorm.DB
+	owner   string
+	jobs    chan Job
+	process func(context.Context, Job) error
+	workers sync.WaitGroup
+
+	// broker, if set, carries jobs instead of db and jobs, and receiving is
+	// cancelled by Close to stop workers taking more from it
+	broker        Broker
+	receiving     context.Context
+	stopReceiving context.CancelFunc
+
+	// ctx is passed to every job and cancelled when Drain gives up, so
+	// in-flight calls to Trello and Google abort instead of holding up exit
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	// closing is closed by Close, stopping replays and resumes; jobs is only
+	// closed once resuming shows no Resume is still sending to it
+	closing  chan struct{}
+	resuming sync.WaitGroup
+
+	// buffered holds jobs waiting out an outage, oldest first, and
+	// bufferedCards counts them per card so later updates to those cards
+	// wait behind them instead of overtaking. They have their own lock, so
+	// workers checking them never wait on mu.
+	bufferMu      sync.Mutex
+	buffered      []Job
+	bufferedCards map[string]int
+}
+
+// NewQueue starts workers goroutines that call process for each job, with
+// room for size jobs to wait. Jobs are stored as owner's, so replicas sharing
+// the database each resume only their own.
+func NewQueue(db *gorm.DB, owner string, workers, size int, process func(context.Context, Job) error) *Queue {
+	q := newQueue(size, process)
+	q.db = db
+	q.owner = owner
+	for range workers {
+		q.workers.Add(1)
+		go q.work()
+	}
+	q.workers.Add(1)
+	go q.replay()
+	return q
+}
+
+// NewBrokerQueue returns a queue whose jobs are published to broker, with
+// workers goroutines taking jobs from it unless consume is false, for
+// instances that only accept webhooks. The broker holds jobs until they have
+// run, so there is nothing to resume, and jobs that hit an outage are retried
+// after a delay rather than buffered in order.
+func NewBrokerQueue(broker Broker, workers int, consume bool, process func(context.Context, Job) error) *Queue {
+	q := newQueue(0, process)
+	q.broker = broker
+	q.receiving, q.stopReceiving = context.WithCancel(context.Background())
+	if consume {
+		for range workers {
+			q.workers.Add(1)
+			go q.receive()
+		}
+	}
+	return q
+}
+
+func newQueue(size int, process func(context.Context, Job) error) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		jobs:    make(chan Job, size),
+		process: process,
+		ctx:     ctx,
+		cancel:  cancel,
+
+		bufferedCards: make(map[string]int),
+		closing:       make(chan struct{}),
+	}
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for job := range q.jobs {
+		if q.ctx.Err() != nil {
+			return // Drain gave up; leave the rest unprocessed
+		}
+		if q.waitBehindBuffered(job) {
+			continue
+		}
+		q.finish(job, q.process(trace.ContextWithSpanContext(q.ctx, job.Trace), job))
+	}
+}
+
+// finish retries, buffers or forgets the job according to how it went
+func (q *Queue) finish(job Job, err error) {
+	cardID := job.Payload.Action.Data.Card.ID
+	if err != nil && q.ctx.Err() != nil {
+		// Cut off by shutdown; keep the job so the next run retries it
+		job.logger().Warn("Card update interrupted by shutdown", zap.String("cardID", cardID), zap.Error(err))
+		return
+	}
+
+	var outageErr *OutageError
+	if errors.As(err, &outageErr) {
+		job.logger().Warn("Card update buffered until the outage ends", zap.String("cardID", cardID), zap.Error(outageErr.Err))
+		q.buffer(job)
+		return
+	}
+
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) && job.retries < maxRetries {
+		job.retries++
+		job.logger().Warn("Card update postponed", zap.String("cardID", cardID), zap.Duration("retryIn", retryErr.After), zap.Error(retryErr.Err))
+		q.retryLater(job, retryErr.After)
+		return
+	}
+
+	logResult(job, err)
+	q.forget(job)
+}
+
+func logResult(job Job, err error) {
+	cardID := job.Payload.Action.Data.Card.ID
+	if err != nil {
+		job.logger().Error("Error processing card update", zap.String("cardID", cardID), zap.Error(err))
+	} else {
+		job.logger().Info("Successfully processed card", zap.String("cardID", cardID))
+	}
+}
+
+// receive runs jobs taken from the broker until the queue is closed.
+func (q *Queue) receive() {
+	defer q.workers.Done()
+	for {
+		delivery, err := q.broker.Receive(q.receiving)
+		if q.receiving.Err() != nil {
+			return
+		}
+		if err != nil {
+			zap.L().Warn("Failed to receive job from queue; retrying", zap.Error(err))
+			select {
+			case <-q.receiving.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		job := delivery.Job()
+		q.settle(delivery, q.process(trace.ContextWithSpanContext(q.ctx, job.Trace), job))
+	}
+}
+
+// settle is finish for a job taken from the broker: it acks the delivery or
+// hands the job back to be retried
+func (q *Queue) settle(delivery Delivery, err error) {
+	job := delivery.Job()
+	cardID := job.Payload.Action.Data.Card.ID
+	if err != nil && q.ctx.Err() != nil {
+		// Left unacknowledged, so the broker redelivers it
+		job.logger().Warn("Card update interrupted by shutdown", zap.String("cardID", cardID), zap.Error(err))
+		return
+	}
+
+	retries, delay, retry := job.retries, time.Duration(0), false
+	var outageErr *OutageError
+	var retryErr *RetryError
+	switch {
+	case errors.As(err, &outageErr):
+		// Outages don't count towards maxRetries, however long they last
+		job.logger().Warn("Card update postponed until the outage ends", zap.String("cardID", cardID), zap.Duration("retryIn", replayInterval), zap.Error(outageErr.Err))
+		delay, retry = replayInterval, true
+	case errors.As(err, &retryErr) && job.retries < maxRetries:
+		job.logger().Warn("Card update postponed", zap.String("cardID", cardID), zap.Duration("retryIn", retryErr.After), zap.Error(retryErr.Err))
+		retries, delay, retry = job.retries+1, retryErr.After, true
+	}
+	if retry {
+		if err := delivery.Retry(retries, delay); err != nil {
+			job.logger().Error("Failed to requeue card update; it will be redelivered", zap.String("cardID", cardID), zap.Error(err))
+		}
+		return
+	}
+
+	logResult(job, err)
+	if err := delivery.Ack(); err != nil {
+		job.logger().Error("Failed to acknowledge processed job; it will run again", zap.String("cardID", cardID), zap.Error(err))
+	}
+}
+
+// buffer adds the job to the buffered jobs, keeping them in the order they
+// were received
+func (q *Queue) buffer(job Job) {
+	q.bufferMu.Lock()
+	defer q.bufferMu.Unlock()
+	i, _ := slices.BinarySearchFunc(q.buffered, job.id, func(buffered Job, id uint) int {
+		return cmp.Compare(buffered.id, id)
+	})
+	q.buffered = slices.Insert(q.buffered, i, job)
+	q.bufferedCards[job.Payload.Action.Data.Card.ID]++
+}
+
+// waitBehindBuffered buffers the job if an earlier update to its card is
+// buffered, so the two apply in order
+func (q *Queue) waitBehindBuffered(job Job) bool {
+	q.bufferMu.Lock()
+	waiting := q.bufferedCards[job.Payload.Action.Data.Card.ID] > 0
+	q.bufferMu.Unlock()
+	if waiting {
+		job.logger().Debug("Card update buffered behind earlier ones", zap.String("cardID", job.Payload.Action.Data.Card.ID))
+		q.buffer(job)
+	}
+	return waiting
+}
+
+// replay periodically tries the oldest buffered job and, once one gets
+// through, works through the rest.
+func (q *Queue) replay() {
+	defer q.workers.Done()
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.closing:
+			return
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			q.replayBuffered()
+		}
+	}
+}
+
+// replayBuffered runs buffered jobs one at a time, oldest first, so each card
+// ends up as its last update left it. It stops at the first job that still
+// hits the outage; that job stays first in line.
+func (q *Queue) replayBuffered() {
+	replayed := 0
+	for {
+		q.mu.Lock()
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			break
+		}
+
+		q.bufferMu.Lock()
+		if len(q.buffered) == 0 {
+			q.bufferMu.Unlock()
+			break
+		}
+		job := q.buffered[0]
+		q.bufferMu.Unlock()
+
+		err := q.process(trace.ContextWithSpanContext(q.ctx, job.Trace), job)
+		var outageErr *OutageError
+		if errors.As(err, &outageErr) || (err != nil && q.ctx.Err() != nil) {
+			break
+		}
+		if replayed == 0 {
+			zap.L().Info("Outage over; replaying buffered card updates", zap.Int("buffered", q.Buffered()))
+		}
+		replayed++
+
+		q.bufferMu.Lock()
+		q.buffered = q.buffered[1:]
+		cardID := job.Payload.Action.Data.Card.ID
+		if q.bufferedCards[cardID]--; q.bufferedCards[cardID] == 0 {
+			delete(q.bufferedCards, cardID)
+		}
+		q.bufferMu.Unlock()
+		q.finish(job, err)
+	}
+	if replayed > 0 {
+		zap.L().Info("Replayed buffered card updates", zap.Int("replayed", replayed), zap.Int("stillBuffered", q.Buffered()))
+	}
+}
+
+// retryLater puts the job back on the queue after delay. Its stored copy is
+// kept, so if the queue is closed by then it runs on the next start instead.
+func (q *Queue) retryLater(job Job, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.closed {
+			return
+		}
+
+		select {
+		case q.jobs <- job:
+		default:
+			q.retryLater(job, delay)
+		}
+	})
+}
+
+// forget deletes the job's stored copy once it no longer needs to be retried
+func (q *Queue) forget(job Job) {
+	if err := database.DeletePendingJob(q.db, job.id); err != nil {
+		job.logger().Error("Failed to delete processed job; it will run again on restart", zap.Uint("jobID", job.id), zap.Error(err))
+	}
+}
+
+// Enqueue stores a job and adds it to the queue without blocking. Once it
+// returns nil the job runs even if the service restarts first.
+func (q *Queue) Enqueue(job Job) error {
+	if q.broker != nil {
+		return q.publish(job)
+	}
+
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		return fmt.Errorf("encoding job: %w", err)
+	}
+	pending := models.PendingJob{Account: job.Account, Payload: string(payload), RequestID: job.RequestID, Owner: q.owner}
+	if err := database.CreatePendingJob(q.db, &pending); err != nil {
+		return fmt.Errorf("storing job: %w", err)
+	}
+	job.id = pending.ID
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		q.forget(job)
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		// The caller is told to retry, so don't also run it from storage
+		q.forget(job)
+		return ErrQueueFull
+	}
+}
+
+// publishTimeout bounds how long Enqueue waits for the broker, so webhook
+// deliveries are turned away rather than held while it is unreachable
+const publishTimeout = 5 * time.Second
+
+func (q *Queue) publish(job Job) error {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return ErrQueueClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return q.broker.Publish(ctx, job)
+}
+
+// Resume queues the jobs a previous run accepted but didn't finish, waiting
+// for room if there are more stored jobs than the queue holds. Jobs enqueued
+// meanwhile are turned away with ErrQueueFull rather than waiting behind it,
+// and closing the queue stops it.
+func (q *Queue) Resume() (int, error) {
+	if q.broker != nil {
+		return 0, nil
+	}
+
+	pending, err := database.ListPendingJobs(q.db, q.owner)
+	if err != nil {
+		return 0, fmt.Errorf("loading pending jobs: %w", err)
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return 0, ErrQueueClosed
+	}
+	q.resuming.Add(1)
+	q.mu.Unlock()
+	defer q.resuming.Done()
+
+	for _, stored := range pending {
+		job := Job{Account: stored.Account, RequestID: stored.RequestID, id: stored.ID}
+		if err := json.Unmarshal([]byte(stored.Payload), &job.Payload); err != nil {
+			zap.L().Error("Dropping unreadable stored job", zap.Uint("jobID", stored.ID), zap.Error(err))
+			q.forget(job)
+			continue
+		}
+
+		select {
+		case q.jobs <- job:
+		case <-q.closing:
+			return 0, ErrQueueClosed
+		}
+	}
+	return len(pending), nil
+}
+
+// Len returns the number of jobs waiting for a worker. Jobs waiting in a
+// broker aren't counted.
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Buffered returns the number of jobs held until an outage ends.
+func (q *Queue) Buffered() int {
+	q.bufferMu.Lock()
+	defer q.bufferMu.Unlock()
+	return len(q.buffered)
+}
+
+// Close stops accepting jobs. Workers finish what is already queued; buffered
+// jobs stay stored and are replayed on the next start.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.closing)
+	if q.broker != nil {
+		q.stopReceiving()
+	}
+	q.mu.Unlock()
+
+	// Nothing else sends once closed is set, so jobs can be closed as soon as
+	// a running Resume has seen closing
+	q.resuming.Wait()
+	close(q.jobs)
+}
+
+// Drain closes the queue and waits for queued and in-flight jobs to finish,
+// so syncs aren't cut off halfway. It gives up when ctx is done, cancelling
+// the jobs still running and returning the number that were still waiting.
+func (q *Queue) Drain(ctx context.Context) (int, error) {
+	q.Close()
+	if q.broker != nil {
+		defer func() {
+			if err := q.broker.Close(); err != nil {
+				zap.L().Warn("Failed to disconnect from queue broker", zap.Error(err))
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0, nil
+	case <-ctx.Done():
+		remaining := q.Len()
+		q.cancel()
+		return remaining, ctx.Err()
+	}
+}
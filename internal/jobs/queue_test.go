@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"gorm.io/gorm"
+)
+
+// TestResumeMoreJobsThanRoom resumes more stored jobs than the queue and its
+// workers hold at once, as a restart after a long outage does. Resume must
+// wait for the workers to make room rather than block them.
+func TestResumeMoreJobsThanRoom(t *testing.T) {
+	const stored = 10
+	db := storeJobs(t, stored)
+
+	var processed atomic.Int32
+	q := NewQueue(db, "o", 2, 2, func(context.Context, Job) error {
+		processed.Add(1)
+		return nil
+	})
+
+	resumed := make(chan error, 1)
+	go func() {
+		_, err := q.Resume()
+		resumed <- err
+	}()
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Fatalf("resuming: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resume didn't return; workers are blocked behind it")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if _, err := q.Drain(ctx); err != nil {
+		t.Fatalf("draining: %v", err)
+	}
+	if got := processed.Load(); got != stored {
+		t.Errorf("processed %d jobs, want %d", got, stored)
+	}
+	pending, err := database.ListPendingJobs(db, "o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d jobs still stored after draining", len(pending))
+	}
+}
+
+// TestResumeDoesNotBlockTheQueue resumes while the workers are stuck, so
+// Resume waits for room. Enqueue and Close must not wait behind it.
+func TestResumeDoesNotBlockTheQueue(t *testing.T) {
+	db := storeJobs(t, 5)
+	release := make(chan struct{})
+	q := NewQueue(db, "o", 1, 1, func(context.Context, Job) error {
+		<-release
+		return nil
+	})
+
+	resumed := make(chan error, 1)
+	go func() {
+		_, err := q.Resume()
+		resumed <- err
+	}()
+	// Let Resume fill the queue and start waiting for room
+	for q.Len() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	enqueued := make(chan error, 1)
+	go func() { enqueued <- q.Enqueue(Job{}) }()
+	select {
+	case err := <-enqueued:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("Enqueue() error = %v, want %v", err, ErrQueueFull)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked behind Resume")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind Resume")
+	}
+	select {
+	case err := <-resumed:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("Resume() error = %v, want %v", err, ErrQueueClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Resume kept waiting after the queue closed")
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if _, err := q.Drain(ctx); err != nil {
+		t.Fatalf("draining: %v", err)
+	}
+}
+
+// storeJobs stores n pending jobs for owner "o", as a previous run left them.
+func storeJobs(t *testing.T, n int) *gorm.DB {
+	t.Helper()
+	db := database.Init(filepath.Join(t.TempDir(), "jobs.db"))
+	for i := range n {
+		var payload models.TrelloWebhookPayload
+		payload.Action.Data.Card.ID = fmt.Sprintf("card%d", i)
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.CreatePendingJob(db, &models.PendingJob{Owner: "o", Payload: string(encoded)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,215 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// redisBlock bounds each wait for a new entry, so waiting workers keep
+// reclaiming entries abandoned by stopped ones and stop soon after Close
+const redisBlock = 2 * time.Second
+
+// redisPromoteInterval is how often retried jobs that are due are moved from
+// the delayed set back onto the stream
+const redisPromoteInterval = time.Second
+
+// promoteDue moves due members of the delayed set (KEYS[2]) onto the stream
+// (KEYS[1]) in one step, so a job is never in both or neither
+var promoteDue = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, data in ipairs(due) do
+	redis.call('XADD', KEYS[1], '*', 'job', data)
+	redis.call('ZREM', KEYS[2], data)
+end
+return #due
+`)
+
+// RedisBroker queues jobs on a Redis stream read by a consumer group. Retried
+// jobs wait in a sorted set next to the stream until they are due.
+type RedisBroker struct {
+	client         *redis.Client
+	stream         string
+	delayed        string
+	group          string
+	consumer       string
+	redeliverAfter time.Duration
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewRedisBroker connects to the Redis server at cfg.URL and creates the
+// stream and consumer group if they don't exist yet. consumer names this
+// instance within the group.
+func NewRedisBroker(ctx context.Context, cfg config.Queue, consumer string) (*RedisBroker, error) {
+	options, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync.queue.url: %w", err)
+	}
+	client := redis.NewClient(options)
+
+	err = client.XGroupCreateMkStream(ctx, cfg.Stream, cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		client.Close()
+		return nil, fmt.Errorf("creating Redis consumer group: %w", err)
+	}
+
+	promoteCtx, stop := context.WithCancel(context.Background())
+	b := &RedisBroker{
+		client:         client,
+		stream:         cfg.Stream,
+		delayed:        cfg.Stream + ":delayed",
+		group:          cfg.Group,
+		consumer:       consumer,
+		redeliverAfter: cfg.RedeliverAfter,
+		stop:           stop,
+		done:           make(chan struct{}),
+	}
+	go b.promote(promoteCtx)
+	return b, nil
+}
+
+// Publish adds job to the stream.
+func (b *RedisBroker) Publish(ctx context.Context, job Job) error {
+	data, err := encodeJob(job, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := b.client.XAdd(ctx, &redis.XAddArgs{Stream: b.stream, Values: map[string]any{"job": data}}).Err(); err != nil {
+		return fmt.Errorf("publishing job to Redis: %w", err)
+	}
+	return nil
+}
+
+// Receive returns the next entry for this consumer, taking over entries
+// another consumer has held unacknowledged for longer than redeliverAfter
+// before reading new ones.
+func (b *RedisBroker) Receive(ctx context.Context) (Delivery, error) {
+	for {
+		entries, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   b.stream,
+			Group:    b.group,
+			Consumer: b.consumer,
+			MinIdle:  b.redeliverAfter,
+			Start:    "0-0",
+			Count:    1,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("reclaiming jobs from Redis: %w", err)
+		}
+
+		if len(entries) == 0 {
+			streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    b.group,
+				Consumer: b.consumer,
+				Streams:  []string{b.stream, ">"},
+				Count:    1,
+				Block:    redisBlock,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading jobs from Redis: %w", err)
+			}
+			entries = streams[0].Messages
+		}
+
+		for _, entry := range entries {
+			data, _ := entry.Values["job"].(string)
+			job, _, err := decodeJob([]byte(data))
+			if err != nil {
+				zap.L().Error("Dropping unreadable job from Redis", zap.String("entryID", entry.ID), zap.Error(err))
+				if err := b.remove(ctx, entry.ID); err != nil {
+					zap.L().Warn("Failed to delete unreadable job from Redis", zap.String("entryID", entry.ID), zap.Error(err))
+				}
+				continue
+			}
+			return &redisDelivery{broker: b, id: entry.ID, job: job}, nil
+		}
+	}
+}
+
+// remove acknowledges and deletes an entry, so the stream doesn't keep
+// growing
+func (b *RedisBroker) remove(ctx context.Context, id string) error {
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, b.stream, b.group, id)
+		pipe.XDel(ctx, b.stream, id)
+		return nil
+	})
+	return err
+}
+
+// promote moves retried jobs back onto the stream once they are due, until
+// ctx is done.
+func (b *RedisBroker) promote(ctx context.Context) {
+	defer close(b.done)
+	ticker := time.NewTicker(redisPromoteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		if err := promoteDue.Run(ctx, b.client, []string{b.stream, b.delayed}, now).Err(); err != nil && ctx.Err() == nil {
+			zap.L().Warn("Failed to requeue retried jobs in Redis", zap.Error(err))
+		}
+	}
+}
+
+// Close disconnects from Redis.
+func (b *RedisBroker) Close() error {
+	b.stop()
+	<-b.done
+	return b.client.Close()
+}
+
+type redisDelivery struct {
+	broker *RedisBroker
+	id     string
+	job    Job
+}
+
+func (d *redisDelivery) Job() Job {
+	return d.job
+}
+
+func (d *redisDelivery) Ack() error {
+	if err := d.broker.remove(context.Background(), d.id); err != nil {
+		return fmt.Errorf("acknowledging job in Redis: %w", err)
+	}
+	return nil
+}
+
+func (d *redisDelivery) Retry(retries int, delay time.Duration) error {
+	job := d.job
+	job.retries = retries
+	data, err := encodeJob(job, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	b := d.broker
+	_, err = b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, b.delayed, redis.Z{Score: float64(time.Now().Add(delay).UnixMilli()), Member: string(data)})
+		pipe.XAck(ctx, b.stream, b.group, d.id)
+		pipe.XDel(ctx, b.stream, d.id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("requeueing job in Redis: %w", err)
+	}
+	return nil
+}
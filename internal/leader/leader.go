@@ -0,0 +1,126 @@
+// Package leader elects one of several replicas sharing a database to do the
+// work only one of them may do, using a lease row that the leader keeps
+// renewing.
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const leaseName = "leader"
+
+const defaultLeaseTTL = 15 * time.Second
+
+// Elector campaigns for the leader lease on behalf of one instance.
+type Elector struct {
+	db  *gorm.DB
+	id  string
+	ttl time.Duration
+
+	done chan struct{} // Closed when Run returns
+}
+
+// New returns an Elector for the instance id. The lease lapses ttl after the
+// leader last renewed it, so a replica that dies is replaced within ttl.
+func New(db *gorm.DB, id string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	return &Elector{db: db, id: id, ttl: ttl, done: make(chan struct{})}
+}
+
+// ID returns the instance the Elector campaigns for.
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// Leader returns the instance currently holding the lease, or "" if none is.
+func (e *Elector) Leader() (string, error) {
+	return database.LeaseHolder(e.db, leaseName)
+}
+
+// Run campaigns until ctx is done. Each time the lease is won, lead is called
+// with a context that is cancelled when the lease is lost; lead starts the
+// leader's work and returns, and if it fails the instance steps down for a
+// while so another replica can take over. When ctx is done the lease is kept
+// until Resign, so the leader can clean up first.
+func (e *Elector) Run(ctx context.Context, lead func(context.Context) error) {
+	defer close(e.done)
+	for {
+		wait := e.ttl / 3
+		acquired, err := database.AcquireLease(e.db, leaseName, e.id, e.ttl)
+		if err != nil {
+			zap.L().Warn("Failed to campaign for leadership", zap.Error(err))
+		} else if acquired && !e.leadWhileHeld(ctx, lead) {
+			wait = e.ttl
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// leadWhileHeld runs lead and renews the lease until it is lost or ctx is
+// done. It returns false if lead failed.
+func (e *Elector) leadWhileHeld(ctx context.Context, lead func(context.Context) error) bool {
+	heldUntil := time.Now().Add(e.ttl)
+	zap.L().Info("Elected leader", zap.String("instance", e.id))
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := lead(leadCtx); err != nil {
+		zap.L().Error("Failed to take over as leader; stepping down", zap.Error(err))
+		cancel()
+		if err := database.ReleaseLease(e.db, leaseName, e.id); err != nil {
+			zap.L().Warn("Failed to release leader lease", zap.Error(err))
+		}
+		return false
+	}
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+
+		renewedAt := time.Now()
+		renewed, err := database.AcquireLease(e.db, leaseName, e.id, e.ttl)
+		switch {
+		case err == nil && renewed:
+			heldUntil = renewedAt.Add(e.ttl)
+		case err == nil:
+			zap.L().Warn("Another instance took over as leader", zap.String("instance", e.id))
+			return true
+		case time.Now().After(heldUntil):
+			zap.L().Error("Could not renew leader lease before it expired; stepping down", zap.Error(err))
+			return true
+		default:
+			zap.L().Warn("Failed to renew leader lease; retrying", zap.Error(err))
+		}
+	}
+}
+
+// Resign waits for Run to return, then releases the lease if this instance
+// holds it, so another replica can take over without waiting for it to
+// expire.
+func (e *Elector) Resign(ctx context.Context) {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return
+	}
+	if err := database.ReleaseLease(e.db, leaseName, e.id); err != nil {
+		zap.L().Warn("Failed to release leader lease", zap.Error(err))
+	}
+}
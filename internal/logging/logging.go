@@ -0,0 +1,132 @@
+// Package logging builds the service's zap logger from the log settings and
+// carries loggers tagged for a request or job in contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// level is shared by every logger New builds, so changing it at runtime
+// applies to all of them
+var level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+
+// configured is log.level, which ToggleDebug returns to
+var configured atomic.Int32
+
+// New returns a logger for cfg. An empty level logs everything from debug up,
+// and an unknown one falls back to info.
+func New(cfg config.Log) (*zap.Logger, error) {
+	SetConfiguredLevel(cfg.Level)
+
+	var encoder zapcore.Encoder
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatConsole:
+		encoderConfig := zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if cfg.File != "" {
+			// Escape codes only make sense on a terminal
+			encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	case FormatJSON:
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.TimeKey = "time"
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("invalid log.format %q: must be %q or %q", cfg.Format, FormatConsole, FormatJSON)
+	}
+
+	output := zapcore.Lock(os.Stdout)
+	if cfg.File != "" {
+		output = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxAge:     cfg.MaxAgeDays,
+			MaxBackups: cfg.MaxBackups,
+			Compress:   cfg.Compress,
+			LocalTime:  true,
+		})
+	}
+
+	// Credentials echoed in errors and responses must not end up in logs
+	core := redactingCore{zapcore.NewCore(encoder, output, level)}
+	return zap.New(core,
+		zap.Development(),
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.WarnLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+	), nil
+}
+
+// SetConfiguredLevel applies log.level, as given in the config.
+func SetConfiguredLevel(name string) {
+	name = strings.ToLower(name)
+	if name == "" {
+		name = "debug"
+	}
+	parsed, err := zapcore.ParseLevel(name)
+	if err != nil {
+		parsed = zapcore.InfoLevel
+	}
+	configured.Store(int32(parsed))
+	level.SetLevel(parsed)
+}
+
+// Level returns the current minimum level.
+func Level() zapcore.Level {
+	return level.Level()
+}
+
+// SetLevel changes the minimum level until the next SetLevel, ToggleDebug or
+// config change, without a restart.
+func SetLevel(l zapcore.Level) {
+	level.SetLevel(l)
+}
+
+// ToggleDebug switches to debug logging, or back to the configured level if
+// debug is already on, and returns the new level.
+func ToggleDebug() zapcore.Level {
+	next := zapcore.DebugLevel
+	if level.Level() == zapcore.DebugLevel {
+		next = zapcore.Level(configured.Load())
+	}
+	level.SetLevel(next)
+	return next
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying logger, for FromContext.
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// With returns ctx carrying the logger from ctx with fields added, so every
+// line logged for the work ctx belongs to can be picked out.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// FromContext returns the logger ctx carries, or the global logger.
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L()
+}
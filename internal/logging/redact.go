@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces secrets in log lines and error messages.
+const Redacted = "[REDACTED]"
+
+var (
+	// Credentials in query strings and form bodies, as Trello takes them
+	secretParam = regexp.MustCompile(`(?i)\b((?:\w+_)?(?:api_?key|key|token|secret|password|signature))=[^&\s"'<>]+`)
+	// Credentials in Authorization headers, echoed in some error bodies
+	bearer      = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	oauthParam  = regexp.MustCompile(`(?i)\b(oauth_(?:consumer_key|token|signature))="[^"]*"`)
+	privateKey  = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+	jsonSecrets = regexp.MustCompile(`(?i)("(?:private_key|client_secret|refresh_token|access_token|api_token|token|password)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// minSecretLen keeps short values, which would match ordinary words, from
+// being registered as secrets
+const minSecretLen = 8
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecrets registers values, such as the configured API tokens, to be
+// redacted wherever they appear. Values shorter than a few characters are
+// ignored.
+func AddSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, value := range values {
+		if len(value) >= minSecretLen && !slices.Contains(secrets, value) {
+			secrets = append(secrets, value)
+		}
+	}
+	// Longest first, so a secret containing another is replaced whole
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+}
+
+// Redact returns s with registered secrets and anything shaped like a
+// credential replaced.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	secretsMu.RUnlock()
+
+	s = privateKey.ReplaceAllString(s, Redacted)
+	s = jsonSecrets.ReplaceAllString(s, `$1"`+Redacted+`"`)
+	s = secretParam.ReplaceAllString(s, "$1="+Redacted)
+	s = oauthParam.ReplaceAllString(s, `$1="`+Redacted+`"`)
+	s = bearer.ReplaceAllString(s, "$1 "+Redacted)
+	return s
+}
+
+// redactingCore redacts messages and string and error fields before they
+// reach the wrapped core
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = Redact(field.String)
+		case zapcore.ByteStringType:
+			field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(string(field.Interface.([]byte)))}
+		case zapcore.ErrorType:
+			// Errors wrap request URLs and response bodies, so they are
+			// logged as their redacted message
+			if err, ok := field.Interface.(error); ok && err != nil {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(err.Error())}
+			}
+		case zapcore.StringerType:
+			if stringer, ok := field.Interface.(fmt.Stringer); ok {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(safeString(stringer))}
+			}
+		}
+		redacted[i] = field
+	}
+	return redacted
+}
+
+// safeString is stringer's String, or "<nil>" for nil pointers that panic in
+// it, as zap prints them
+func safeString(stringer fmt.Stringer) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = "<nil>"
+		}
+	}()
+	return stringer.String()
+}
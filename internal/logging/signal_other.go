@@ -0,0 +1,8 @@
+//go:build !unix
+
+package logging
+
+import "context"
+
+// ToggleDebugOnSignal does nothing on platforms without SIGUSR1.
+func ToggleDebugOnSignal(ctx context.Context) {}
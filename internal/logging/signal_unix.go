@@ -0,0 +1,29 @@
+//go:build unix
+
+package logging
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ToggleDebugOnSignal calls ToggleDebug on every SIGUSR1 until ctx is done.
+func ToggleDebugOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			current := ToggleDebug()
+			zap.L().Log(max(zapcore.InfoLevel, current), "Log level changed by SIGUSR1", zap.Stringer("level", current))
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// APIKey is a scoped key for the admin API, issued with `api-keys create`.
+// Only a hash of the key is stored.
+type APIKey struct {
+	ID         string `gorm:"primaryKey"` // Public part of the key, shown in listings
+	Name       string
+	Hash       string
+	Scopes     string // Comma-separated
+	Tenant     string `gorm:"index;not null;default:''"` // Tenant the key is confined to; empty for the operator's keys
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
@@ -0,0 +1,40 @@
+package models
+
+// Types returned by the Asana API and sent by Asana webhooks. Only the
+// fields the service uses are decoded.
+
+type AsanaTask struct {
+	GID          string `json:"gid"`
+	Name         string `json:"name"`
+	Notes        string `json:"notes"`
+	DueOn        string `json:"due_on"` // "2006-01-02", or empty
+	DueAt        string `json:"due_at"` // RFC 3339, for tasks due at a time
+	Completed    bool   `json:"completed"`
+	PermalinkURL string `json:"permalink_url"`
+	Memberships  []struct {
+		Project struct {
+			GID string `json:"gid"`
+		} `json:"project"`
+		Section struct {
+			Name string `json:"name"`
+		} `json:"section"`
+	} `json:"memberships"`
+}
+
+type AsanaWebhook struct {
+	GID      string `json:"gid"`
+	Active   bool   `json:"active"`
+	Target   string `json:"target"`
+	Resource struct {
+		GID string `json:"gid"`
+	} `json:"resource"`
+}
+
+// AsanaEvent is one change in the body of an Asana webhook delivery.
+type AsanaEvent struct {
+	Action   string `json:"action"` // Such as "changed" or "deleted"
+	Resource struct {
+		GID          string `json:"gid"`
+		ResourceType string `json:"resource_type"`
+	} `json:"resource"`
+}
@@ -2,14 +2,36 @@ package models
 
 import "time"
 
+// Sources of cards other than Trello
+const (
+	SourceJira   = "jira"
+	SourceGitHub = "github"
+	SourceAsana  = "asana"
+)
+
 type Card struct {
-	ID        string `gorm:"primaryKey"`
-	Name      string
-	DueDate   *time.Time
-	URL       string
-	BoardID   string
-	Archived  bool `gorm:"default:false"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	EventID   string // Google Calendar Event ID
+	ID          string `gorm:"primaryKey"`
+	Source      string `gorm:"index;not null;default:''"` // Empty for Trello cards
+	Tenant      string `gorm:"index;not null;default:''"` // Tenant owning the card's board; empty for the operator's cards
+	Name        string
+	Description string
+	DueDate     *time.Time
+	URL         string
+	BoardID     string
+	ListID      string
+	Labels      []string `gorm:"serializer:json"` // Names and IDs of the card's Trello labels, as last fetched
+	Archived    bool     `gorm:"default:false"`
+	// LastActionAt is the date of the latest Trello action applied to the
+	// card, so redeliveries of older ones can be told apart
+	LastActionAt *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	// The card's Google Calendar event and Google Tasks task. They are stored
+	// as TargetEvents, loaded with database.LoadCardEvents and saved with
+	// database.SaveCard.
+	EventID    string `gorm:"-"`
+	CalendarID string `gorm:"-"` // Calendar holding EventID; empty means the default calendar
+	TaskID     string `gorm:"-"`
+	TaskListID string `gorm:"-"`
 }
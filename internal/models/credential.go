@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Credential is a secret the service obtained itself, such as a Trello token
+// from the authorize flow, kept out of config.toml.
+type Credential struct {
+	Name      string `gorm:"primaryKey"`
+	Secret    string
+	UpdatedAt time.Time
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Types sent by GitHub issue and milestone webhooks. Only the fields the
+// service uses are decoded.
+
+type GitHubIssue struct {
+	ID        int64            `json:"id"`
+	Number    int              `json:"number"`
+	Title     string           `json:"title"`
+	Body      string           `json:"body"`
+	HTMLURL   string           `json:"html_url"`
+	State     string           `json:"state"` // "open" or "closed"
+	Labels    []GitHubLabel    `json:"labels"`
+	Milestone *GitHubMilestone `json:"milestone"`
+}
+
+type GitHubLabel struct {
+	Name string `json:"name"`
+}
+
+type GitHubMilestone struct {
+	ID          int64      `json:"id"`
+	Number      int        `json:"number"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	HTMLURL     string     `json:"html_url"`
+	State       string     `json:"state"`
+	DueOn       *time.Time `json:"due_on"`
+}
+
+type GitHubRepository struct {
+	FullName string `json:"full_name"` // "owner/name"
+}
+
+// GitHubWebhookPayload is the body of an "issues" or "milestone" webhook;
+// Issue is set for the former and Milestone for the latter.
+type GitHubWebhookPayload struct {
+	Action     string           `json:"action"`
+	Issue      *GitHubIssue     `json:"issue"`
+	Milestone  *GitHubMilestone `json:"milestone"`
+	Repository GitHubRepository `json:"repository"`
+	// Changes holds the previous values of the fields an "edited" action
+	// changed; the others are nil
+	Changes struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+		DueOn *struct {
+			From *time.Time `json:"from"`
+		} `json:"due_on"`
+	} `json:"changes"`
+}
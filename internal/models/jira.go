@@ -0,0 +1,28 @@
+package models
+
+// Types returned by the Jira REST API and sent by Jira webhooks. Only the
+// fields the service uses are decoded.
+
+type JiraIssue struct {
+	ID     string          `json:"id"`
+	Key    string          `json:"key"`
+	Fields JiraIssueFields `json:"fields"`
+}
+
+type JiraIssueFields struct {
+	Summary string `json:"summary"`
+	DueDate string `json:"duedate"` // "2006-01-02", or empty
+	Project struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"project"`
+	Status struct {
+		Name string `json:"name"`
+	} `json:"status"`
+}
+
+// JiraWebhookPayload is the body of a Jira issue webhook.
+type JiraWebhookPayload struct {
+	WebhookEvent string    `json:"webhookEvent"` // Such as "jira:issue_updated"
+	Issue        JiraIssue `json:"issue"`
+}
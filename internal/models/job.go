@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PendingJob is a webhook delivery that has been acknowledged but not yet
+// synced, kept so it survives a crash or redeploy.
+type PendingJob struct {
+	ID        uint `gorm:"primaryKey"`
+	Account   string
+	Payload   string // TrelloWebhookPayload as JSON
+	RequestID string
+	Owner     string `gorm:"index;not null;default:''"` // Instance that accepted the job, with leader election
+	CreatedAt time.Time
+}
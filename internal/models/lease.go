@@ -0,0 +1,9 @@
+package models
+
+// Lease is held by one instance at a time until it expires, such as the
+// leader lease replicas compete for.
+type Lease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	ExpiresAt int64 // Unix milliseconds
+}
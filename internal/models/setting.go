@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Setting is a key/value pair the service persists for itself, such as IDs of
+// resources it created on first run.
+type Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
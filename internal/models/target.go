@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Targets whose links are mirrored onto Card's event fields. They are named as
+// sync rules route cards to them.
+const (
+	TargetCalendar = "calendar"
+	TargetTasks    = "tasks"
+)
+
+// TargetEvent links a card to the event created for it on one sync target,
+// so a card can be synced to several at once. Container is the calendar or
+// task list holding the event, for targets that have several.
+type TargetEvent struct {
+	CardID    string `gorm:"primaryKey"`
+	Target    string `gorm:"primaryKey;index:idx_target_events_event"`
+	EventID   string `gorm:"index:idx_target_events_event"`
+	Container string
+	UpdatedAt time.Time
+}
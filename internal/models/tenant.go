@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Tenant is someone sharing the service with their own Trello token and
+// Google account, registered with the tenant command rather than in
+// config.toml. Their tokens are kept as Credentials.
+type Tenant struct {
+	Name string `gorm:"primaryKey"`
+	// BoardIDs are the IDs of the Trello boards synced to CalendarID, a
+	// calendar in the tenant's Google account
+	BoardIDs   []string `gorm:"serializer:json"`
+	CalendarID string
+	// Who authorised each side, for telling tenants apart; empty until then
+	TrelloMember  string
+	GoogleAccount string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,63 @@
+package models
+
+// Types returned by the Trello REST API. Only the fields the service uses are
+// decoded.
+
+type TrelloBoard struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Closed         bool   `json:"closed"`
+	ShortLink      string `json:"shortLink"`
+	URL            string `json:"url"`
+	IDOrganization string `json:"idOrganization"`
+}
+
+type TrelloList struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Closed  bool    `json:"closed"`
+	IDBoard string  `json:"idBoard"`
+	Pos     float64 `json:"pos"`
+}
+
+type TrelloLabel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+	IDBoard string `json:"idBoard"`
+}
+
+type TrelloMember struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	FullName string `json:"fullName"`
+}
+
+type TrelloCard struct {
+	ID               string        `json:"id"`
+	Name             string        `json:"name"`
+	Desc             string        `json:"desc"`
+	Due              string        `json:"due"`
+	Start            string        `json:"start"`
+	DueComplete      bool          `json:"dueComplete"`
+	Closed           bool          `json:"closed"`
+	ShortLink        string        `json:"shortLink"`
+	ShortURL         string        `json:"shortUrl"`
+	URL              string        `json:"url"`
+	IDBoard          string        `json:"idBoard"`
+	IDList           string        `json:"idList"`
+	IDLabels         []string      `json:"idLabels"`
+	IDMembers        []string      `json:"idMembers"`
+	Labels           []TrelloLabel `json:"labels"`
+	DateLastActivity string        `json:"dateLastActivity"`
+}
+
+type TrelloWebhook struct {
+	ID                       string `json:"id"`
+	Description              string `json:"description"`
+	IDModel                  string `json:"idModel"`
+	CallbackURL              string `json:"callbackURL"`
+	Active                   bool   `json:"active"`
+	ConsecutiveFailures      int    `json:"consecutiveFailures"`
+	FirstConsecutiveFailDate string `json:"firstConsecutiveFailDate"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// WatchChannel is a Google Calendar push notification channel opened by this
+// service, along with the incremental sync token for the watched calendar.
+type WatchChannel struct {
+	ID         string `gorm:"primaryKey"` // Channel ID we generated
+	ResourceID string // Opaque ID Google assigns to the watched resource
+	CalendarID string
+	Token      string // Echoed back in X-Goog-Channel-Token to authenticate notifications
+	SyncToken  string
+	Expiration time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
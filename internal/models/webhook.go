@@ -3,22 +3,50 @@ package models
 type TrelloCardData struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
+	Desc      string `json:"desc"`
 	Due       string `json:"due"`
 	ShortLink string `json:"shortLink"`
 	Closed    bool   `json:"closed"`
+	IDList    string `json:"idList"`
+
+	// Only populated when the card is fetched from the REST API
+	Start     string        `json:"start"`
+	Labels    []TrelloLabel `json:"labels"`
+	IDMembers []string      `json:"idMembers"`
 }
 
-type TrelloBoardData struct {
+type TrelloListData struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
+type TrelloBoardData struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Closed bool   `json:"closed"`
+}
+
+// TrelloAction is a single change on a board, delivered by a webhook or
+// fetched from the board's action history.
+type TrelloAction struct {
+	ID   string `json:"id"`
+	Date string `json:"date"`
+	Data struct {
+		Card  TrelloCardData  `json:"card"`
+		Board TrelloBoardData `json:"board"`
+		List  TrelloListData  `json:"list"`
+		// Present instead of List when the card was moved between lists
+		ListAfter TrelloListData `json:"listAfter"`
+		// The board a list's cards were moved to, when it isn't Board
+		BoardTarget TrelloBoardData `json:"boardTarget"`
+		// The changed fields' previous values
+		Old struct {
+			Closed *bool `json:"closed"`
+		} `json:"old"`
+	} `json:"data"`
+	Type string `json:"type"` // e.g., "updateCard"
+}
+
 type TrelloWebhookPayload struct {
-	Action struct {
-		Data struct {
-			Card  TrelloCardData  `json:"card"`
-			Board TrelloBoardData `json:"board"`
-		} `json:"data"`
-		Type string `json:"type"` // e.g., "updateCard"
-	} `json:"action"`
+	Action TrelloAction `json:"action"`
 }
@@ -0,0 +1,183 @@
+// Package notify posts sync activity and failures to Slack and Telegram, so
+// the team sees schedule changes and problems without watching the logs.
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/telegram"
+)
+
+// Kinds of notification, as named in slack.channels.events and
+// telegram.events
+const (
+	EventCreated    = "event_created"
+	SyncFailed      = "sync_failed"
+	WebhookDisabled = "webhook_disabled"
+)
+
+// defaultTelegramEvents are the kinds sent to Telegram when telegram.events
+// is empty: only the alerts
+var defaultTelegramEvents = []string{SyncFailed, WebhookDisabled}
+
+const defaultFailureThreshold = 3
+
+// Notification is one message, about BoardID if it concerns a board.
+type Notification struct {
+	Kind    string
+	BoardID string
+	Parts   []Part
+}
+
+// Part is a run of a notification's text, linking to URL if it is set.
+type Part struct {
+	Text string
+	URL  string
+}
+
+// Notifier sends notifications to the Slack channels and Telegram chat that
+// want them. A nil *Notifier drops everything.
+type Notifier struct {
+	slack    atomic.Pointer[config.Slack]
+	telegram atomic.Pointer[config.Telegram]
+	client   *http.Client // Posts to Slack
+
+	mu       sync.Mutex
+	bot      *telegram.Client // Rebuilt when the bot token changes
+	botToken string
+	failures map[string]int // Card ID -> syncs failed in a row
+}
+
+// New returns a notifier sending as cfg configures.
+func New(cfg *config.Config) *Notifier {
+	n := &Notifier{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		failures: make(map[string]int),
+	}
+	n.Configure(cfg)
+	return n
+}
+
+// Configure replaces the slack and telegram settings the notifier works from.
+func (n *Notifier) Configure(cfg *config.Config) {
+	n.slack.Store(&cfg.Slack)
+	n.telegram.Store(&cfg.Telegram)
+}
+
+// Notify sends notification, in the background, everywhere its kind and board
+// are wanted.
+func (n *Notifier) Notify(notification Notification) {
+	if n == nil {
+		return
+	}
+	for _, channel := range n.slack.Load().Channels {
+		if wants(channel.Events, channel.Boards, notification) {
+			go n.postSlack(channel, notification)
+		}
+	}
+
+	tg := n.telegram.Load()
+	events := tg.Events
+	if len(events) == 0 {
+		events = defaultTelegramEvents
+	}
+	if tg.BotToken != "" && tg.ChatID != "" && wants(events, tg.Boards, notification) {
+		go n.sendTelegram(n.Bot(), tg.ChatID, notification)
+	}
+}
+
+func wants(events, boards []string, n Notification) bool {
+	if len(events) > 0 && !slices.Contains(events, n.Kind) {
+		return false
+	}
+	return len(boards) == 0 || n.BoardID == "" || slices.Contains(boards, n.BoardID)
+}
+
+// Bot returns the Telegram client for telegram.bot_token, or nil if there is
+// no token.
+func (n *Notifier) Bot() *telegram.Client {
+	token := n.telegram.Load().BotToken
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if token != n.botToken {
+		n.bot, n.botToken = nil, token
+		if token != "" {
+			n.bot = telegram.New(token)
+		}
+	}
+	return n.bot
+}
+
+// SyncResult records how a sync of the card went, notifying once the card
+// has failed slack.failure_threshold times in a row and again when it next
+// succeeds.
+func (n *Notifier) SyncResult(card models.TrelloCardData, boardID string, err error) {
+	if n == nil {
+		return
+	}
+	threshold := n.slack.Load().FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	n.mu.Lock()
+	previous := n.failures[card.ID]
+	if err == nil {
+		delete(n.failures, card.ID)
+	} else {
+		n.failures[card.ID] = previous + 1
+	}
+	n.mu.Unlock()
+
+	switch {
+	case err != nil && previous+1 == threshold:
+		n.Notify(Notification{Kind: SyncFailed, BoardID: boardID, Parts: []Part{
+			{Text: "⚠️ Syncing "}, trelloCard(card),
+			{Text: fmt.Sprintf(" has failed %d times in a row: %s", threshold, logging.Redact(err.Error()))},
+		}})
+	case err == nil && previous >= threshold:
+		n.Notify(Notification{Kind: SyncFailed, BoardID: boardID, Parts: []Part{
+			{Text: "✅ "}, trelloCard(card),
+			{Text: fmt.Sprintf(" is syncing again after %d failures", previous)},
+		}})
+	}
+}
+
+// EventCreatedNotification announces that card, which was given a due date,
+// now has an event on target.
+func EventCreatedNotification(card models.Card, target string) Notification {
+	return Notification{Kind: EventCreated, BoardID: card.BoardID, Parts: []Part{
+		{Text: "📅 "}, {Text: card.Name, URL: card.URL},
+		{Text: fmt.Sprintf(" is due %s; added to %s", card.DueDate.Format("Mon 2 Jan 2006 15:04 MST"), target)},
+	}}
+}
+
+// WebhookDisabledNotification announces that the Trello webhook for boardID
+// was found disabled or missing, and whether it could be recovered.
+func WebhookDisabledNotification(boardID string, recovered bool, err error) Notification {
+	text := fmt.Sprintf("🚨 The Trello webhook for board %s was disabled; it has been restored, but updates made meanwhile may have been missed", boardID)
+	if !recovered {
+		text = fmt.Sprintf("🚨 The Trello webhook for board %s was disabled and could not be restored, so its updates are being missed: %s", boardID, logging.Redact(err.Error()))
+	}
+	return Notification{Kind: WebhookDisabled, BoardID: boardID, Parts: []Part{{Text: text}}}
+}
+
+// trelloCard links to the card, or names it if it has no short link
+func trelloCard(card models.TrelloCardData) Part {
+	name := card.Name
+	if name == "" {
+		name = "card " + card.ID
+	}
+	if card.ShortLink == "" {
+		return Part{Text: name}
+	}
+	return Part{Text: name, URL: "https://trello.com/c/" + card.ShortLink}
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+)
+
+func (n *Notifier) postSlack(channel config.SlackChannel, notification Notification) {
+	name := channel.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	body, _ := json.Marshal(map[string]string{"text": slackText(notification.Parts)})
+
+	resp, err := n.client.Post(channel.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		zap.L().Error("Failed to post Slack notification", zap.String("channel", name), zap.String("kind", notification.Kind), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		zap.L().Error("Slack rejected notification", zap.String("channel", name), zap.String("kind", notification.Kind), zap.String("status", resp.Status))
+	}
+}
+
+// slackText renders parts as Slack mrkdwn
+func slackText(parts []Part) string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+	var text strings.Builder
+	for _, part := range parts {
+		if part.URL == "" {
+			text.WriteString(escape(part.Text))
+		} else {
+			fmt.Fprintf(&text, "<%s|%s>", part.URL, escape(part.Text))
+		}
+	}
+	return text.String()
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/internal/telegram"
+	"go.uber.org/zap"
+)
+
+func (n *Notifier) sendTelegram(bot *telegram.Client, chatID string, notification Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := bot.SendMessage(ctx, chatID, TelegramText(notification.Parts)); err != nil {
+		zap.L().Error("Failed to send Telegram notification", zap.String("kind", notification.Kind), zap.Error(err))
+	}
+}
+
+// TelegramText renders parts as Telegram HTML.
+func TelegramText(parts []Part) string {
+	var text strings.Builder
+	for _, part := range parts {
+		if part.URL == "" {
+			text.WriteString(html.EscapeString(part.Text))
+		} else {
+			fmt.Fprintf(&text, `<a href="%s">%s</a>`, html.EscapeString(part.URL), html.EscapeString(part.Text))
+		}
+	}
+	return text.String()
+}
@@ -0,0 +1,200 @@
+// Package outbound sends a signed JSON POST to the configured webhooks
+// whenever a synced event changes, so automations such as Home Assistant or
+// n8n can react to schedule changes.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// Operations, as named in webhooks.operations
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// TargetCalendar is the Target of events on Google Calendar; events on other
+// sync targets carry the target's name.
+const TargetCalendar = "google_calendar"
+
+// Headers sent with each delivery
+const (
+	HeaderEvent     = "X-Trello-Gcal-Sync-Event"
+	HeaderDelivery  = "X-Trello-Gcal-Sync-Delivery"
+	HeaderSignature = "X-Trello-Gcal-Sync-Signature"
+)
+
+// retryDelays are the waits before each retry of a delivery that failed with
+// a network error or a 5xx response
+var retryDelays = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second}
+
+// Event is the body of a delivery.
+type Event struct {
+	Operation  string     `json:"operation"`
+	CardID     string     `json:"cardId"`
+	CardName   string     `json:"cardName,omitempty"`
+	CardURL    string     `json:"cardUrl,omitempty"`
+	BoardID    string     `json:"boardId,omitempty"`
+	DueDate    *time.Time `json:"dueDate,omitempty"`
+	Target     string     `json:"target"`
+	CalendarID string     `json:"calendarId,omitempty"` // Only for Google Calendar
+	EventID    string     `json:"eventId"`
+	Timestamp  time.Time  `json:"timestamp"`
+}
+
+// CardEvent returns the event for operation on card's event eventID on
+// target.
+func CardEvent(operation string, card models.Card, target, eventID string) Event {
+	event := Event{
+		Operation: operation,
+		CardID:    card.ID,
+		CardName:  card.Name,
+		CardURL:   card.URL,
+		BoardID:   card.BoardID,
+		DueDate:   card.DueDate,
+		Target:    target,
+		EventID:   eventID,
+		Timestamp: time.Now().UTC(),
+	}
+	if target == TargetCalendar {
+		event.CalendarID = card.CalendarID
+	}
+	if operation == Deleted {
+		event.DueDate = nil
+	}
+	return event
+}
+
+// Validate checks that every webhook has an HTTP(S) URL and names only known
+// operations.
+func Validate(webhooks []config.Webhook) error {
+	for i, webhook := range webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d]: url must be an http or https URL", i)
+		}
+		for _, operation := range webhook.Operations {
+			if !slices.Contains([]string{Created, Updated, Deleted}, operation) {
+				return fmt.Errorf("webhooks[%d]: unknown operation %q", i, operation)
+			}
+		}
+	}
+	return nil
+}
+
+// Dispatcher delivers events to the webhooks that want them. A nil
+// *Dispatcher drops everything.
+type Dispatcher struct {
+	webhooks atomic.Pointer[[]config.Webhook]
+	client   *http.Client
+}
+
+// New returns a dispatcher delivering to the webhooks in cfg.
+func New(cfg *config.Config) *Dispatcher {
+	d := &Dispatcher{client: &http.Client{Timeout: 10 * time.Second}}
+	d.Configure(cfg)
+	return d
+}
+
+// Configure replaces the webhooks the dispatcher delivers to.
+func (d *Dispatcher) Configure(cfg *config.Config) {
+	d.webhooks.Store(&cfg.Webhooks)
+}
+
+// Send delivers event, in the background, to every webhook that wants it.
+func (d *Dispatcher) Send(event Event) {
+	if d == nil {
+		return
+	}
+	var body []byte
+	for _, webhook := range *d.webhooks.Load() {
+		if len(webhook.Operations) > 0 && !slices.Contains(webhook.Operations, event.Operation) {
+			continue
+		}
+		if len(webhook.Boards) > 0 && event.BoardID != "" && !slices.Contains(webhook.Boards, event.BoardID) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				zap.L().Error("Failed to encode outbound webhook event", zap.Error(err))
+				return
+			}
+		}
+		go d.deliver(webhook, event.Operation, body)
+	}
+}
+
+func (d *Dispatcher) deliver(webhook config.Webhook, operation string, body []byte) {
+	name := webhook.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	delivery := rand.Text()
+
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(webhook, operation, delivery, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == len(retryDelays) {
+			zap.L().Error("Failed to deliver outbound webhook", zap.String("webhook", name), zap.String("operation", operation), zap.String("delivery", delivery), zap.Int("attempts", attempt+1), zap.Error(err))
+			return
+		}
+		zap.L().Warn("Outbound webhook delivery failed; retrying", zap.String("webhook", name), zap.String("delivery", delivery), zap.Duration("in", retryDelays[attempt]), zap.Error(err))
+		time.Sleep(retryDelays[attempt])
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth
+// retrying.
+func (d *Dispatcher) post(webhook config.Webhook, operation, delivery string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "trello-gcal-sync")
+	req.Header.Set(HeaderEvent, "event."+operation)
+	req.Header.Set(HeaderDelivery, delivery)
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by
+// the hex HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,167 @@
+// Package recording keeps the raw webhook deliveries the service receives on
+// disk, so a sync that went wrong can be replayed later exactly as it came
+// in.
+package recording
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// redactedHeaders carry credentials rather than anything a replay needs.
+// Asana's handshake secret is dropped too, since replaying the handshake
+// would replace the stored one.
+var redactedHeaders = []string{"Authorization", "Cookie", "X-Hook-Secret"}
+
+// Recording is one webhook delivery as it was received.
+type Recording struct {
+	ReceivedAt time.Time   `json:"received_at"`
+	RequestID  string      `json:"request_id,omitempty"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	Header     http.Header `json:"header"`
+	// Body holds bodies that are JSON as they are, so recordings stay
+	// readable; others are kept as text in BodyText
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+
+	File string `json:"-"` // Where the recording was loaded from
+}
+
+// New records a delivery of body to req.
+func New(req *http.Request, requestID string, body []byte) Recording {
+	header := req.Header.Clone()
+	for _, name := range redactedHeaders {
+		delete(header, http.CanonicalHeaderKey(name))
+	}
+	rec := Recording{
+		ReceivedAt: time.Now().UTC(),
+		RequestID:  requestID,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.RawQuery,
+		Header:     header,
+	}
+	if json.Valid(body) {
+		rec.Body = slices.Clone(body)
+	} else {
+		rec.BodyText = string(body)
+	}
+	return rec
+}
+
+// Payload returns the body as it was received.
+func (r Recording) Payload() []byte {
+	if r.Body != nil {
+		return r.Body
+	}
+	return []byte(r.BodyText)
+}
+
+// Recorder writes recordings to a directory, one file each, named so they
+// sort in the order they were received.
+type Recorder struct {
+	dir string
+}
+
+// NewRecorder records into dir, creating it if need be. Deliveries can carry
+// private card details, so the directory is readable by the owner only.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating webhook recording directory: %w", err)
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Save writes rec to a new file in the recorder's directory.
+func (r *Recorder) Save(rec Recording) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := rec.ReceivedAt.Format("20060102T150405.000000Z") + "-" + hex.EncodeToString(suffix) + ".json"
+	return os.WriteFile(filepath.Join(r.dir, name), data, 0o600)
+}
+
+// Load reads the recordings at paths, each a recording file or a directory of
+// them, in the order they were received.
+func Load(paths ...string) ([]Recording, error) {
+	var recs []Recording
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			rec, err := read(file)
+			if err != nil {
+				return nil, err
+			}
+			recs = append(recs, rec)
+		}
+	}
+	slices.SortStableFunc(recs, func(a, b Recording) int { return a.ReceivedAt.Compare(b.ReceivedAt) })
+	return recs, nil
+}
+
+// ErrNotRecording is returned for a JSON file that isn't a recording.
+var ErrNotRecording = errors.New("not a webhook recording")
+
+func read(file string) (Recording, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Recording{}, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Recording{}, fmt.Errorf("reading recording %s: %w", file, err)
+	}
+	if rec.Path == "" || rec.Method == "" {
+		return Recording{}, fmt.Errorf("reading %s: %w", file, ErrNotRecording)
+	}
+	rec.File = file
+	return rec, nil
+}
+
+// Filter picks recordings to replay. Zero fields match every recording.
+type Filter struct {
+	Since, Until time.Time
+	PathPrefix   string
+	Contains     string // Found in the body, such as a card ID
+}
+
+// Select returns the recordings that match f.
+func (f Filter) Select(recs []Recording) []Recording {
+	var selected []Recording
+	for _, rec := range recs {
+		if !f.Since.IsZero() && rec.ReceivedAt.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && !rec.ReceivedAt.Before(f.Until) {
+			continue
+		}
+		if !strings.HasPrefix(rec.Path, f.PathPrefix) || !strings.Contains(string(rec.Payload()), f.Contains) {
+			continue
+		}
+		selected = append(selected, rec)
+	}
+	return selected
+}
@@ -0,0 +1,154 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	ActionInclude = "include"
+	ActionExclude = "exclude"
+
+	TargetCalendar = "calendar"
+	TargetTasks    = "tasks"
+)
+
+// Rule matches cards by board, list and/or label. Trello boards and lists are
+// given by ID, not name; cards from other sources have the board and list
+// their source gives them, as described in config. Labels are given by name
+// or ID, matched regardless of case, and a card matches if it has any of
+// them. Empty match fields match anything.
+// Rules are evaluated in order and the first match decides the outcome.
+// Target selects whether included cards become calendar events (the default),
+// Google Tasks, or events on another registered sync target, and Calendar
+// routes events to a calendar alias or ID; empty means the default calendar.
+// Also lists further targets, including "calendar" and "tasks", the cards
+// are synced to as well.
+type Rule struct {
+	Name     string   `mapstructure:"name" json:"name"`
+	Boards   []string `mapstructure:"boards" json:"boards"`
+	Lists    []string `mapstructure:"lists" json:"lists"`
+	Labels   []string `mapstructure:"labels" json:"labels,omitempty"`
+	Action   string   `mapstructure:"action" json:"action"`
+	Target   string   `mapstructure:"target" json:"target"`
+	Calendar string   `mapstructure:"calendar" json:"calendar"`
+	Also     []string `mapstructure:"also" json:"also,omitempty"`
+}
+
+// Card is the subset of card state rules can match against
+type Card struct {
+	BoardID string
+	ListID  string
+	Labels  []string // Names and IDs of the card's labels
+}
+
+type Decision struct {
+	Sync     bool     `json:"sync"`
+	Rule     string   `json:"rule,omitempty"` // Name of the matching rule, if any
+	Target   string   `json:"target"`
+	Calendar string   `json:"calendar,omitempty"` // Calendar reference from the matching rule
+	Also     []string `json:"also,omitempty"`     // Further targets synced alongside Target
+}
+
+// Targets lists every target the card goes to: Target, then Also.
+func (d Decision) Targets() []string {
+	return append([]string{d.Target}, d.Also...)
+}
+
+// Includes reports whether the card is synced to target.
+func (d Decision) Includes(target string) bool {
+	return d.Sync && (d.Target == target || slices.Contains(d.Also, target))
+}
+
+type Set struct {
+	rules []Rule
+}
+
+// Load reads sync.rules from v.
+func Load(v *viper.Viper) (*Set, error) {
+	var rules []Rule
+	if err := v.UnmarshalKey("sync.rules", &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse sync.rules: %w", err)
+	}
+	return New(rules)
+}
+
+// LoadTOML parses a config snippet containing [[sync.rules]] tables.
+func LoadTOML(snippet string) (*Set, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(snippet)); err != nil {
+		return nil, fmt.Errorf("unable to parse rules config: %w", err)
+	}
+	return Load(v)
+}
+
+func New(rules []Rule) (*Set, error) {
+	for i, rule := range rules {
+		switch rule.Action {
+		case ActionInclude, ActionExclude:
+		case "":
+			rules[i].Action = ActionInclude
+		default:
+			return nil, fmt.Errorf("rule %d (%s): unknown action %q", i, rule.Name, rule.Action)
+		}
+
+		// Other targets are checked against the registered ones by the caller
+		if rule.Target == "" {
+			rules[i].Target = TargetCalendar
+		}
+		rules[i].Target = strings.ToLower(rules[i].Target)
+
+		var also []string
+		for _, target := range rule.Also {
+			target = strings.ToLower(target)
+			if target != rules[i].Target && !slices.Contains(also, target) {
+				also = append(also, target)
+			}
+		}
+		rules[i].Also = also
+	}
+	return &Set{rules: rules}, nil
+}
+
+// Targets lists the sync targets the rules route cards to.
+func (s *Set) Targets() []string {
+	targets := []string{TargetCalendar}
+	for _, rule := range s.rules {
+		for _, target := range append([]string{rule.Target}, rule.Also...) {
+			if !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// Evaluate decides whether card should have a calendar event. Cards matching
+// no rule are synced.
+func (s *Set) Evaluate(card Card) Decision {
+	for _, rule := range s.rules {
+		if rule.matches(card) {
+			return Decision{Sync: rule.Action == ActionInclude, Rule: rule.Name, Target: rule.Target, Calendar: rule.Calendar, Also: rule.Also}
+		}
+	}
+	return Decision{Sync: true, Target: TargetCalendar}
+}
+
+func (r Rule) matches(card Card) bool {
+	if len(r.Boards) > 0 && !slices.Contains(r.Boards, card.BoardID) {
+		return false
+	}
+	if len(r.Lists) > 0 && !slices.Contains(r.Lists, card.ListID) {
+		return false
+	}
+	if len(r.Labels) > 0 && !slices.ContainsFunc(r.Labels, func(label string) bool {
+		return slices.ContainsFunc(card.Labels, func(has string) bool { return strings.EqualFold(has, label) })
+	}) {
+		return false
+	}
+	return true
+}
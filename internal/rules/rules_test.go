@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	const (
+		board      = "5f00000000000000000b0a4d"
+		otherBoard = "5f00000000000000000b0a4e"
+		backlog    = "5f00000000000000000115a1"
+		doing      = "5f00000000000000000115a2"
+		urgentID   = "5f00000000000000001abe11"
+		chores     = "5f00000000000000000115a3"
+	)
+	set, err := New([]Rule{
+		{Name: "backlog", Lists: []string{backlog}, Action: ActionExclude},
+		{Name: "urgent", Boards: []string{board}, Labels: []string{"urgent", "5f00000000000000001abe12"}, Calendar: "team"},
+		{Name: "chores", Lists: []string{chores}, Target: "Tasks", Also: []string{"calendar", "tasks", "Calendar"}},
+		{Name: "other board", Boards: []string{otherBoard}, Action: ActionExclude},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		card Card
+		want Decision
+	}{
+		{
+			name: "first matching rule wins",
+			card: Card{BoardID: board, ListID: backlog, Labels: []string{"Urgent", urgentID}},
+			want: Decision{Sync: false, Rule: "backlog", Target: TargetCalendar},
+		},
+		{
+			name: "labels match by name regardless of case",
+			card: Card{BoardID: board, ListID: doing, Labels: []string{"URGENT", urgentID}},
+			want: Decision{Sync: true, Rule: "urgent", Target: TargetCalendar, Calendar: "team"},
+		},
+		{
+			name: "labels match by ID",
+			card: Card{BoardID: board, ListID: doing, Labels: []string{"5f00000000000000001abe12"}},
+			want: Decision{Sync: true, Rule: "urgent", Target: TargetCalendar, Calendar: "team"},
+		},
+		{
+			name: "every match field must match",
+			card: Card{BoardID: otherBoard, ListID: doing, Labels: []string{"urgent"}},
+			want: Decision{Sync: false, Rule: "other board", Target: TargetCalendar},
+		},
+		{
+			name: "lists match by ID, not name",
+			card: Card{BoardID: board, ListID: "Backlog"},
+			want: Decision{Sync: true, Target: TargetCalendar},
+		},
+		{
+			name: "target and also are normalised",
+			card: Card{BoardID: board, ListID: chores},
+			want: Decision{Sync: true, Rule: "chores", Target: TargetTasks, Also: []string{TargetCalendar}},
+		},
+		{
+			name: "no rule matches",
+			card: Card{BoardID: "5f00000000000000000b0a4f", ListID: doing},
+			want: Decision{Sync: true, Target: TargetCalendar},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := set.Evaluate(tt.card)
+			if got.Sync != tt.want.Sync || got.Rule != tt.want.Rule || got.Target != tt.want.Target ||
+				got.Calendar != tt.want.Calendar || !slices.Equal(got.Also, tt.want.Also) {
+				t.Errorf("Evaluate(%+v) = %+v, want %+v", tt.card, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecisionIncludes(t *testing.T) {
+	tests := []struct {
+		decision Decision
+		target   string
+		want     bool
+	}{
+		{Decision{Sync: true, Target: TargetCalendar}, TargetCalendar, true},
+		{Decision{Sync: true, Target: TargetTasks, Also: []string{TargetCalendar}}, TargetCalendar, true},
+		{Decision{Sync: true, Target: TargetTasks}, TargetCalendar, false},
+		{Decision{Sync: false, Target: TargetCalendar}, TargetCalendar, false},
+	}
+	for _, tt := range tests {
+		if got := tt.decision.Includes(tt.target); got != tt.want {
+			t.Errorf("%+v.Includes(%q) = %v, want %v", tt.decision, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestNewRejectsUnknownAction(t *testing.T) {
+	if _, err := New([]Rule{{Name: "typo", Action: "exlude"}}); err == nil {
+		t.Error("New accepted a rule with an unknown action")
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	set, err := LoadTOML(`
+[[sync.rules]]
+name = "backlog"
+lists = ["5f00000000000000000115a1"]
+action = "exclude"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := set.Evaluate(Card{ListID: "5f00000000000000000115a1"}); got.Sync || got.Rule != "backlog" {
+		t.Errorf("card on the excluded list got %+v, want it excluded by backlog", got)
+	}
+}
@@ -0,0 +1,156 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+)
+
+// aws reads secrets from AWS Secrets Manager by name or ARN, signing
+// requests with the credentials from the environment.
+type aws struct {
+	region       string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newAWS(cfg config.AWSSecrets) (*aws, error) {
+	a := &aws{
+		region:       cfg.Region,
+		accessKeyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       http.DefaultClient,
+	}
+	if a.region == "" {
+		a.region = os.Getenv("AWS_REGION")
+	}
+	if a.region == "" {
+		return nil, fmt.Errorf("the aws secrets provider needs secrets.aws.region or AWS_REGION")
+	}
+	if a.accessKeyID == "" || a.secretKey == "" {
+		return nil, fmt.Errorf("the aws secrets provider needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return a, nil
+}
+
+func (a *aws) Fetch(ctx context.Context, secretID string) (string, error) {
+	var secret struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}
+	if err := a.call(ctx, "secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": secretID}, &secret); err != nil {
+		return "", fmt.Errorf("reading %s from Secrets Manager: %w", secretID, err)
+	}
+	if secret.SecretString != "" || secret.SecretBinary == "" {
+		return secret.SecretString, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(secret.SecretBinary)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s from Secrets Manager: %w", secretID, err)
+	}
+	return string(data), nil
+}
+
+// call invokes target, an operation of one of the AWS services speaking the
+// JSON 1.1 protocol, and decodes its response into out
+func (a *aws) call(ctx context.Context, service, target string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := "https://" + service + "." + a.region + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	a.sign(req, body, service, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req, a request to
+// service whose body is body
+func (a *aws) sign(req *http.Request, body []byte, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+
+	// Every header set above is signed, along with the host, in
+	// alphabetical order
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if a.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"", // No query
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+	scope := date + "/" + a.region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", a.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// gcp reads secrets from Google Cloud Secret Manager with the application
+// default credentials. References are secret names such as
+// "projects/my-project/secrets/trello-token", optionally naming a version;
+// the latest is read otherwise.
+type gcp struct {
+	service *secretmanager.Service
+}
+
+func newGCP(ctx context.Context) (*gcp, error) {
+	service, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Secret Manager: %w", err)
+	}
+	return &gcp{service: service}, nil
+}
+
+func (g *gcp) Fetch(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	version, err := g.service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("reading %s from Secret Manager: %w", name, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s from Secret Manager: %w", name, err)
+	}
+	return string(data), nil
+}
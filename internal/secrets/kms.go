@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/envelope"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// NewKMS returns a wrapper for data keys that uses keyRef in a KMS: an AWS
+// KMS key ARN, ID or alias, signed for with the [secrets.aws] settings, or
+// a Google Cloud KMS key name, used with the application default
+// credentials.
+func NewKMS(ctx context.Context, cfg config.Secrets, keyRef string) (envelope.Wrapper, error) {
+	if strings.HasPrefix(keyRef, "projects/") {
+		service, err := cloudkms.NewService(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to Cloud KMS: %w", err)
+		}
+		return &gcpKMS{service: service, key: keyRef}, nil
+	}
+
+	awsCfg := cfg.AWS
+	// Key ARNs name their region, arn:aws:kms:<region>:<account>:key/<id>
+	if parts := strings.Split(keyRef, ":"); len(parts) >= 6 && parts[0] == "arn" && parts[2] == "kms" {
+		awsCfg.Region = parts[3]
+	}
+	client, err := newAWS(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("using AWS KMS: %w", err)
+	}
+	return &awsKMS{aws: client, key: keyRef}, nil
+}
+
+// awsKMS wraps data keys with a symmetric AWS KMS key
+type awsKMS struct {
+	*aws
+	key string
+}
+
+func (k *awsKMS) ID() string {
+	return "aws:" + k.key
+}
+
+func (k *awsKMS) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var out struct {
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	in := map[string]any{"KeyId": k.key, "Plaintext": dataKey}
+	if err := k.call(ctx, "kms", "TrentService.Encrypt", in, &out); err != nil {
+		return nil, fmt.Errorf("encrypting data key with AWS KMS: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	in := map[string]any{"KeyId": k.key, "CiphertextBlob": wrapped}
+	if err := k.call(ctx, "kms", "TrentService.Decrypt", in, &out); err != nil {
+		return nil, fmt.Errorf("decrypting data key with AWS KMS: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+// gcpKMS wraps data keys with a Google Cloud KMS symmetric key
+type gcpKMS struct {
+	service *cloudkms.Service
+	key     string
+}
+
+func (k *gcpKMS) ID() string {
+	return "gcp:" + k.key
+}
+
+func (k *gcpKMS) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	keys := k.service.Projects.Locations.KeyRings.CryptoKeys
+	resp, err := keys.Encrypt(k.key, &cloudkms.EncryptRequest{Plaintext: base64.StdEncoding.EncodeToString(dataKey)}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("encrypting data key with Cloud KMS: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+func (k *gcpKMS) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	keys := k.service.Projects.Locations.KeyRings.CryptoKeys
+	resp, err := keys.Decrypt(k.key, &cloudkms.DecryptRequest{Ciphertext: base64.StdEncoding.EncodeToString(wrapped)}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data key with Cloud KMS: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,145 @@
+// Package secrets fetches credentials from an external secret manager, so
+// config.toml on disk can name them instead of holding them.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"go.uber.org/zap"
+)
+
+// Provider fetches the value of a secret by the reference the secret
+// manager knows it by.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// New returns the provider cfg names.
+func New(ctx context.Context, cfg config.Secrets) (Provider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "vault":
+		return newVault(cfg.Vault)
+	case "aws":
+		return newAWS(cfg.AWS)
+	case "gcp":
+		return newGCP(ctx)
+	case "":
+		return nil, fmt.Errorf("settings ending in _secret need secrets.provider")
+	default:
+		return nil, fmt.Errorf("unknown secrets.provider %q; expected \"vault\", \"aws\" or \"gcp\"", cfg.Provider)
+	}
+}
+
+// setting is a setting to be fetched from the secret manager
+type setting struct {
+	key string // Name of the _secret setting, for errors
+	ref string
+	set func(value string) error
+}
+
+// Resolve fetches every setting of cfg given as a reference into a secret
+// manager and fills in the setting it stands for. It does nothing when no
+// such setting is given.
+func Resolve(ctx context.Context, cfg *config.Config) error {
+	settings := references(cfg)
+	if len(settings) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Secrets.Timeout)
+	defer cancel()
+	provider, err := New(ctx, cfg.Secrets)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		value, err := fetch(ctx, provider, s.ref)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", s.key, err)
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("invalid secret for %s: %w", s.key, err)
+		}
+	}
+	zap.L().Info("Loaded secrets from secret manager", zap.String("provider", cfg.Secrets.Provider), zap.Int("count", len(settings)))
+	return nil
+}
+
+// references lists the settings of cfg to be fetched
+func references(cfg *config.Config) []setting {
+	var settings []setting
+	if ref := cfg.Google.ServiceAccountSecret; ref != "" {
+		settings = append(settings, setting{"google.service_account_secret", ref, func(value string) error {
+			var key map[string]any
+			if err := json.Unmarshal([]byte(value), &key); err != nil {
+				return fmt.Errorf("expected a service account key as JSON: %w", err)
+			}
+			cfg.Google.ServiceAccount = key
+			cfg.Google.ServiceAccountFile = "" // Would take precedence
+			return nil
+		}})
+	}
+	if ref := cfg.Database.Encryption.KeySecret; ref != "" {
+		settings = append(settings, setting{"database.encryption.key_secret", ref, func(value string) error {
+			cfg.Database.Encryption.Key = strings.TrimSpace(value)
+			return nil
+		}})
+	}
+	if ref := cfg.Google.OAuth.ClientSecretSecret; ref != "" {
+		settings = append(settings, setting{"google.oauth.client_secret_secret", ref, func(value string) error {
+			cfg.Google.OAuth.ClientSecret = strings.TrimSpace(value)
+			return nil
+		}})
+	}
+	if ref := cfg.Trello.APITokenSecret; ref != "" {
+		settings = append(settings, setting{"trello.api_token_secret", ref, func(value string) error {
+			cfg.Trello.APIToken = value
+			return nil
+		}})
+	}
+	for i := range cfg.Trello.Accounts {
+		account := &cfg.Trello.Accounts[i]
+		if account.APITokenSecret != "" {
+			settings = append(settings, setting{fmt.Sprintf("api_token_secret of trello account %q", account.Name), account.APITokenSecret, func(value string) error {
+				account.APIToken = value
+				return nil
+			}})
+		}
+	}
+	return settings
+}
+
+// fetch returns the secret ref names. A ref ending in "#field" takes that
+// field of a secret holding a JSON object.
+func fetch(ctx context.Context, provider Provider, ref string) (string, error) {
+	name, field, hasField := strings.Cut(ref, "#")
+	value, err := provider.Fetch(ctx, name)
+	if err != nil || !hasField {
+		return value, err
+	}
+	return jsonField(value, field)
+}
+
+// jsonField returns field of the JSON object in value, as a string if it is
+// one and as JSON otherwise
+func jsonField(value, field string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object, so has no field %q", field)
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q; it has %s", field, strings.Join(slices.Sorted(maps.Keys(fields)), ", "))
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
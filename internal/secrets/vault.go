@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+)
+
+// vault reads KV secrets, version 1 or 2, over Vault's HTTP API. A secret's
+// value is its data as a JSON object, so references normally pick one field
+// with "#field".
+type vault struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVault(cfg config.VaultSecrets) (*vault, error) {
+	v := &vault{address: cfg.Address, token: cfg.Token, namespace: cfg.Namespace, client: http.DefaultClient}
+	if v.address == "" {
+		v.address = os.Getenv("VAULT_ADDR")
+	}
+	if v.token == "" {
+		v.token = os.Getenv("VAULT_TOKEN")
+	}
+	if v.namespace == "" {
+		v.namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if v.address == "" || v.token == "" {
+		return nil, fmt.Errorf("the vault secrets provider needs secrets.vault.address and secrets.vault.token, or VAULT_ADDR and VAULT_TOKEN")
+	}
+	v.address = strings.TrimSuffix(v.address, "/")
+	return v, nil
+}
+
+func (v *vault) Fetch(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reading %s from Vault: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("reading %s from Vault: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decoding %s from Vault: %w", path, err)
+	}
+	// KV version 2 nests the secret's data, next to its metadata
+	data, nested := secret.Data["data"]
+	if _, ok := secret.Data["metadata"]; !ok || !nested {
+		data, err = json.Marshal(secret.Data)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,121 @@
+// Package systemd speaks the parts of systemd's service protocol the service
+// uses: readiness, stopping and watchdog notifications, and socket
+// activation. Everything is a no-op when the process isn't run by systemd.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// States sent with Notify
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// Notify sends state to systemd. It reports false without error when
+// systemd isn't listening for notifications.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract namespace
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("connecting to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("notifying systemd: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a watchdog ping, or 0
+// if the unit has no WatchdogSec.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings systemd at half the watchdog interval until ctx is done,
+// so a hung process is restarted. It returns at once if the watchdog is off.
+func RunWatchdog(ctx context.Context) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := Notify(Watchdog); err != nil {
+				zap.L().Warn("Failed to ping systemd watchdog", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Listeners returns the sockets passed by systemd socket activation, in the
+// order of the socket unit's Listen directives, or nil if none were passed.
+func Listeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Child processes must not inherit the sockets or think they were
+	// activated themselves
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := range count {
+		fd := listenFDsStart + i
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener holds its own close-on-exec duplicate
+		if err != nil {
+			return nil, fmt.Errorf("socket %s passed by systemd is not a listening socket: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,105 @@
+// Package telegram is a minimal client for the Telegram Bot API: sending
+// messages and long polling for the messages sent to the bot.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const apiURL = "https://api.telegram.org/bot"
+
+// pollTimeout is how long a GetUpdates call waits for a message
+const pollTimeout = 50 * time.Second
+
+// Client calls the Bot API as the bot with token.
+type Client struct {
+	token  string
+	client *http.Client
+}
+
+func New(token string) *Client {
+	return &Client{token: token, client: &http.Client{Timeout: pollTimeout + 10*time.Second}}
+}
+
+// Update is a message sent to the bot.
+type Update struct {
+	ID      int64    `json:"update_id"`
+	Message *Message `json:"message"`
+}
+
+type Message struct {
+	Text string `json:"text"`
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+}
+
+// SendMessage sends text, formatted as Telegram's HTML subset, to chatID,
+// which is a numeric chat ID or a channel's @username.
+func (c *Client) SendMessage(ctx context.Context, chatID, text string) error {
+	return c.call(ctx, "sendMessage", map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+// GetUpdates waits for messages with update IDs from offset on, returning
+// none if nothing arrives in time. Passing the ID after the last one seen
+// acknowledges it.
+func (c *Client) GetUpdates(ctx context.Context, offset int64) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(pollTimeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+func (c *Client) call(ctx context.Context, method string, params map[string]any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The URL holds the token, so don't let it into logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("calling Telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var decoded struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("calling Telegram %s: %s", method, resp.Status)
+	}
+	if !decoded.OK {
+		return fmt.Errorf("telegram %s failed: %s: %s", method, resp.Status, decoded.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(decoded.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Package titles cleans up card names before they become event titles, so
+// formatting and very long names don't make calendar entries unreadable.
+package titles
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+)
+
+var (
+	markdownLink = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	// Emphasis markers only count outside words and around text that doesn't
+	// start or end with a space, so snake_case names and lone asterisks are
+	// left alone
+	markdownEmphasis = regexp.MustCompile(`(^|[^\pL\pN])(\*\*|__|~~|\*|_|` + "`" + `)([^\s*_~` + "`" + `](?:[^*_~` + "`" + `]*[^\s*_~` + "`" + `])?)(\*\*|__|~~|\*|_|` + "`" + `)($|[^\pL\pN])`)
+	// A shortcode needs a letter, so times like 10:30:00 aren't taken for one
+	emojiShortcode = regexp.MustCompile(`:[a-z0-9_+-]*[a-z][a-z0-9_+-]*:`)
+)
+
+// Ellipsis ends titles cut short to the maximum length.
+const Ellipsis = "…"
+
+// Clean applies the cleanup cfg turns on to title.
+func Clean(title string, cfg config.Titles) string {
+	if cfg.StripMarkdown {
+		title = stripMarkdown(title)
+	}
+	if cfg.StripEmojiShortcodes {
+		title = emojiShortcode.ReplaceAllString(title, "")
+	}
+	if cfg.CollapseWhitespace || cfg.StripEmojiShortcodes {
+		// Removed shortcodes leave doubled spaces behind
+		title = strings.Join(strings.Fields(title), " ")
+	}
+	if cfg.MaxLength > 0 {
+		title = truncate(title, cfg.MaxLength)
+	}
+	return title
+}
+
+func stripMarkdown(title string) string {
+	title = markdownLink.ReplaceAllString(title, "$1")
+	// Nested emphasis such as ***x*** takes more than one pass
+	for range 3 {
+		stripped := markdownEmphasis.ReplaceAllStringFunc(title, func(match string) string {
+			parts := markdownEmphasis.FindStringSubmatch(match)
+			if parts[2] != parts[4] {
+				return match
+			}
+			return parts[1] + parts[3] + parts[5]
+		})
+		if stripped == title {
+			break
+		}
+		title = stripped
+	}
+	return title
+}
+
+// truncate cuts title to at most max characters, ending it with Ellipsis if
+// anything was cut
+func truncate(title string, max int) string {
+	if utf8.RuneCountInString(title) <= max {
+		return title
+	}
+	runes := []rune(title)
+	keep := max - utf8.RuneCountInString(Ellipsis)
+	if keep <= 0 {
+		return string(runes[:max])
+	}
+	return strings.TrimRight(string(runes[:keep]), " ") + Ellipsis
+}
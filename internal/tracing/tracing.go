@@ -0,0 +1,211 @@
+// Package tracing sets up OpenTelemetry and instruments the service's HTTP
+// server, outgoing API calls and database queries. Until Setup is called with
+// an endpoint, spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const defaultServiceName = "trello-gcal-sync"
+
+// Tracer creates the service's spans
+var Tracer = otel.Tracer("github.com/chxlky/trello-gcal-sync")
+
+// Setup starts exporting spans as configured. The returned function flushes
+// and stops the exporter; it does nothing when tracing is off.
+func Setup(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing.endpoint: %w", err)
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+		resource.WithAttributes(attribute.String("service.name", name)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe service for tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// End records err on span, if there is one, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware starts a span for each request, continuing a trace the caller
+// propagated. Routes rather than full URLs are recorded, since some URLs
+// carry tokens.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := Tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.request.id", requestid.FromContext(ctx)),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// Transport wraps next so every request to system, such as "trello", gets a
+// client span. The query string is left out and path segments following
+// "tokens" are masked, since Trello takes credentials in both.
+func Transport(next http.RoundTripper, system string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next, system: system}
+}
+
+type transport struct {
+	next   http.RoundTripper
+	system string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := redactPath(req.URL.Path)
+	ctx, span := Tracer.Start(req.Context(), t.system+" "+req.Method+" "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", path),
+		),
+	)
+	defer span.End()
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+func redactPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "tokens" {
+			segments[i] = "{token}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// gormSpanKey holds the span a gorm callback started, for the matching end
+// callback
+const gormSpanKey = "tracing:span"
+
+// InstrumentDB adds a span for each statement run on db with a context that
+// is already being traced, such as a sync's. Untraced background queries
+// don't start traces of their own.
+func InstrumentDB(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startDBSpan("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endDBSpan),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startDBSpan("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endDBSpan),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startDBSpan("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endDBSpan),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startDBSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endDBSpan),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", startDBSpan("row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", endDBSpan),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", startDBSpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", endDBSpan),
+	)
+}
+
+func startDBSpan(op string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		ctx, span := Tracer.Start(ctx, "db "+op, trace.WithSpanKind(trace.SpanKindClient))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endDBSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		attribute.String("db.system", "sqlite"),
+		attribute.String("db.sql.table", tx.Statement.Table),
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.RowsAffected),
+	)
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil // An answer, not a failure
+	}
+	End(span, err)
+}
@@ -0,0 +1,170 @@
+// Package tunnel runs a cloudflared or ngrok tunnel to a local port, giving
+// the service a public URL Trello can deliver webhooks to during local
+// development.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The tunnel programs Start knows how to run
+const (
+	Cloudflared = "cloudflared"
+	Ngrok       = "ngrok"
+)
+
+// startTimeout bounds the wait for the tunnel's public URL
+const startTimeout = 30 * time.Second
+
+// stopTimeout is how long Close waits for the tunnel to exit after
+// interrupting it, before killing it
+const stopTimeout = 5 * time.Second
+
+// provider describes how to run one tunnel program and read its output
+type provider struct {
+	args func(localURL string) []string
+	// url finds the public URL in a line of output
+	url *regexp.Regexp
+	// ready matches the line saying the tunnel is taking traffic; nil when
+	// the URL is only printed once it is
+	ready *regexp.Regexp
+}
+
+var providers = map[string]provider{
+	Cloudflared: {
+		args: func(localURL string) []string {
+			return []string{"tunnel", "--no-autoupdate", "--url", localURL}
+		},
+		url:   regexp.MustCompile(`https://[-a-z0-9]+\.trycloudflare\.com`),
+		ready: regexp.MustCompile(`Registered tunnel connection`),
+	},
+	Ngrok: {
+		args: func(localURL string) []string {
+			return []string{"http", localURL, "--log", "stdout", "--log-format", "json"}
+		},
+		url: regexp.MustCompile(`"url":"(https://[^"]+)"`),
+	},
+}
+
+// Tunnel is a running tunnel program.
+type Tunnel struct {
+	// URL is the public HTTPS URL traffic to the local port arrives from
+	URL string
+
+	cmd    *exec.Cmd
+	exited chan struct{} // Closed once the program has exited
+	err    error         // Why it exited, set before exited is closed
+	once   sync.Once
+}
+
+// Find returns the tunnel program to run: name if it is set, otherwise
+// whichever of cloudflared and ngrok is installed, preferring cloudflared,
+// which needs no account.
+func Find(name string) (string, error) {
+	if name != "" {
+		if _, ok := providers[name]; !ok {
+			return "", fmt.Errorf("unknown tunnel %q: must be %s or %s", name, Cloudflared, Ngrok)
+		}
+		if _, err := exec.LookPath(name); err != nil {
+			return "", fmt.Errorf("%s is not installed: %w", name, err)
+		}
+		return name, nil
+	}
+	for _, name := range []string{Cloudflared, Ngrok} {
+		if _, err := exec.LookPath(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("neither %s nor %s is installed; install one to get a public URL", Cloudflared, Ngrok)
+}
+
+// Start runs the named tunnel program, with extra arguments appended, to
+// forward a public URL to localURL, and returns once the URL is known and
+// the tunnel is taking traffic. Close the tunnel when done with it.
+func Start(ctx context.Context, name, localURL string, extraArgs ...string) (*Tunnel, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tunnel %q: must be %s or %s", name, Cloudflared, Ngrok)
+	}
+
+	cmd := exec.Command(name, append(p.args(localURL), extraArgs...)...)
+	output, write := io.Pipe()
+	cmd.Stdout, cmd.Stderr = write, write
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+	t := &Tunnel{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		t.err = cmd.Wait()
+		write.Close()
+		close(t.exited)
+	}()
+
+	found := make(chan string, 1)
+	go func() {
+		log := zap.L().With(zap.String("tunnel", name))
+		var url string
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			line := scanner.Text()
+			log.Debug("Tunnel output", zap.String("line", line))
+			if url == "" {
+				if m := p.url.FindStringSubmatch(line); m != nil {
+					url = m[len(m)-1]
+				}
+			}
+			if url != "" && (p.ready == nil || p.ready.MatchString(line)) {
+				select {
+				case found <- url:
+				default:
+				}
+			}
+		}
+		// Keep draining, so the program never blocks writing its logs
+		io.Copy(io.Discard, output)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	select {
+	case t.URL = <-found:
+		return t, nil
+	case <-t.exited:
+		return nil, fmt.Errorf("%s exited before the tunnel was up: %v", name, t.err)
+	case <-ctx.Done():
+		t.Close()
+		return nil, fmt.Errorf("waiting for the %s tunnel to come up: %w", name, ctx.Err())
+	}
+}
+
+// Close stops the tunnel program, killing it if it doesn't exit promptly
+// once interrupted.
+func (t *Tunnel) Close() {
+	t.once.Do(func() {
+		if err := t.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			t.cmd.Process.Kill()
+		}
+		select {
+		case <-t.exited:
+		case <-time.After(stopTimeout):
+			t.cmd.Process.Kill()
+			<-t.exited
+		}
+	})
+}
+
+// Done is closed once the tunnel program has exited.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.exited
+}
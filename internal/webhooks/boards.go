@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// BoardRef extracts what the Trello API accepts as a board identifier from a
+// configured value, which may be a 24-character board ID, an 8-character
+// shortLink, or a board URL such as https://trello.com/b/<shortLink>/<name>.
+func BoardRef(value string) string {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		return value
+	}
+
+	if u, err := url.Parse(value); err == nil {
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		for i := 0; i+1 < len(segments); i++ {
+			if segments[i] == "b" {
+				return segments[i+1]
+			}
+		}
+	}
+	return value
+}
+
+// ValidateBoards resolves each configured board reference to its canonical
+// board and checks that it exists, is open, and is readable with the
+// configured token. Boards that pass are returned; problems with the rest are
+// joined into the returned error.
+func ValidateBoards(ctx context.Context, client integrations.TrelloAPI, boardRefs []string) ([]models.TrelloBoard, error) {
+	var boards []models.TrelloBoard
+	var problems []error
+	for _, ref := range boardRefs {
+		boardID := BoardRef(ref)
+		board, err := client.GetBoard(ctx, boardID)
+		if err != nil {
+			problems = append(problems, describeBoardError(boardID, err))
+			continue
+		}
+		if board.Closed {
+			problems = append(problems, fmt.Errorf("board %s (%q) is closed", boardID, board.Name))
+			continue
+		}
+
+		if board.ID != ref {
+			zap.L().Info("Resolved Trello board reference", zap.String("ref", ref), zap.String("boardID", board.ID))
+		}
+		zap.L().Info("Validated Trello board", zap.String("boardID", board.ID), zap.String("name", board.Name))
+		boards = append(boards, *board)
+	}
+	return boards, errors.Join(problems...)
+}
+
+func describeBoardError(boardID string, err error) error {
+	// Trello answers 400 rather than 404 for IDs that are malformed
+	var serr *integrations.TrelloStatusError
+	invalidID := errors.As(err, &serr) && serr.StatusCode == http.StatusBadRequest
+
+	switch {
+	case errors.Is(err, integrations.ErrNotFound) || invalidID:
+		return fmt.Errorf("board %s does not exist; check trello.board_ids", boardID)
+	case errors.Is(err, integrations.ErrUnauthorized):
+		return fmt.Errorf("board %s is not accessible with the configured Trello token", boardID)
+	}
+	return fmt.Errorf("board %s could not be fetched: %w", boardID, err)
+}
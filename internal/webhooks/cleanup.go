@@ -0,0 +1,34 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+)
+
+// FindStale returns the webhooks this service owns on the client's token that
+// aren't needed for any of boardIDs, including extra duplicates for a board.
+// Webhooks registered by other integrations are never included.
+func FindStale(ctx context.Context, client integrations.TrelloAPI, boardIDs []string) ([]models.TrelloWebhook, error) {
+	registered, err := client.ListWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing webhooks: %w", err)
+	}
+
+	var stale []models.TrelloWebhook
+	seen := make(map[string]bool)
+	for _, webhook := range registered {
+		if !integrations.OwnsWebhook(client, webhook) {
+			continue
+		}
+		if slices.Contains(boardIDs, webhook.IDModel) && !seen[webhook.IDModel] {
+			seen[webhook.IDModel] = true
+			continue
+		}
+		stale = append(stale, webhook)
+	}
+	return stale, nil
+}
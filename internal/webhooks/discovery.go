@@ -0,0 +1,48 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DiscoverBoards registers webhooks for every open board in the workspace
+// that isn't already tracked.
+func (m *Manager) DiscoverBoards(ctx context.Context, orgID string) error {
+	boards, err := m.client.ListOrganizationBoards(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("listing boards in workspace %s: %w", orgID, err)
+	}
+
+	tracked := m.Webhooks()
+	for _, board := range boards {
+		if _, ok := tracked[board.ID]; ok {
+			continue
+		}
+		zap.L().Info("Discovered Trello board", zap.String("boardID", board.ID), zap.String("name", board.Name))
+		if err := m.Register(ctx, board.ID); err != nil {
+			zap.L().Error("Failed to register webhook for discovered board", zap.String("boardID", board.ID), zap.String("name", board.Name), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// WatchOrganization re-runs DiscoverBoards each interval until ctx is
+// cancelled so boards created after startup are picked up.
+func (m *Manager) WatchOrganization(ctx context.Context, orgID string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.DiscoverBoards(ctx, orgID); err != nil {
+				zap.L().Warn("Board discovery failed", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Package webhooks keeps track of the Trello webhooks registered for each
+// board and makes sure they stay active.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"go.uber.org/zap"
+)
+
+// Alert describes a webhook that was found disabled or missing.
+type Alert struct {
+	BoardID             string
+	WebhookID           string
+	ConsecutiveFailures int
+	Recovered           bool // Whether the webhook was re-enabled or re-registered
+	Err                 error
+}
+
+// Manager owns the board ID to webhook ID mapping.
+type Manager struct {
+	client integrations.TrelloAPI
+
+	// OnAlert is called whenever a webhook is found disabled or missing,
+	// after the manager has attempted to recover it.
+	OnAlert func(Alert)
+	// OnChange is called with the IDs of the boards that have a webhook
+	// whenever a board gains or loses one.
+	OnChange func(boardIDs []string)
+
+	mu       sync.Mutex
+	webhooks map[string]string               // board ID -> webhook ID
+	existing map[string]models.TrelloWebhook // board ID -> registration left by a previous run
+}
+
+func NewManager(client integrations.TrelloAPI) *Manager {
+	return &Manager{
+		client:   client,
+		webhooks: make(map[string]string),
+	}
+}
+
+// LoadExisting fetches the webhooks already registered for this token so that
+// Register can reuse them instead of creating duplicates. Only webhooks this
+// service owns are considered; extra duplicates for the same board are
+// deleted.
+func (m *Manager) LoadExisting(ctx context.Context) error {
+	registered, err := m.client.ListWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("listing existing webhooks: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.existing = make(map[string]models.TrelloWebhook)
+	for _, webhook := range registered {
+		if !integrations.OwnsWebhook(m.client, webhook) {
+			continue
+		}
+		if _, ok := m.existing[webhook.IDModel]; ok {
+			zap.L().Info("Deleting duplicate Trello webhook", zap.String("boardID", webhook.IDModel), zap.String("webhookID", webhook.ID))
+			if err := m.client.DeleteWebhook(ctx, webhook.ID); err != nil {
+				zap.L().Warn("Failed to delete duplicate Trello webhook", zap.String("webhookID", webhook.ID), zap.Error(err))
+			}
+			continue
+		}
+		m.existing[webhook.IDModel] = webhook
+	}
+	return nil
+}
+
+// Register starts tracking a webhook for the board, reusing one found by
+// LoadExisting when available and registering a new one otherwise.
+func (m *Manager) Register(ctx context.Context, boardID string) error {
+	m.mu.Lock()
+	existing, ok := m.existing[boardID]
+	delete(m.existing, boardID)
+	m.mu.Unlock()
+
+	if ok {
+		if !existing.Active {
+			if err := m.client.ActivateWebhook(ctx, existing.ID); err != nil {
+				return fmt.Errorf("re-enabling existing webhook: %w", err)
+			}
+		}
+		zap.L().Info("Reusing existing Trello webhook", zap.String("boardID", boardID), zap.String("webhookID", existing.ID))
+
+		m.track(boardID, existing.ID)
+		return nil
+	}
+
+	webhookID, err := m.client.RegisterWebhook(ctx, boardID)
+	if err != nil {
+		return err
+	}
+
+	m.track(boardID, webhookID)
+	return nil
+}
+
+func (m *Manager) track(boardID, webhookID string) {
+	m.mu.Lock()
+	_, known := m.webhooks[boardID]
+	m.webhooks[boardID] = webhookID
+	m.mu.Unlock()
+	if !known {
+		m.changed()
+	}
+}
+
+// changed reports the tracked boards to OnChange
+func (m *Manager) changed() {
+	if m.OnChange != nil {
+		m.OnChange(slices.Sorted(maps.Keys(m.Webhooks())))
+	}
+}
+
+// Deregister deletes the board's webhook from Trello and stops tracking it.
+func (m *Manager) Deregister(ctx context.Context, boardID string) error {
+	m.mu.Lock()
+	webhookID, ok := m.webhooks[boardID]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if err := m.client.DeleteWebhook(ctx, webhookID); err != nil && !integrations.IsTrelloNotFound(err) {
+		return err
+	}
+
+	m.mu.Lock()
+	delete(m.webhooks, boardID)
+	m.mu.Unlock()
+	m.changed()
+	return nil
+}
+
+// Webhooks returns a copy of the board ID to webhook ID mapping.
+func (m *Manager) Webhooks() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := make(map[string]string, len(m.webhooks))
+	for boardID, webhookID := range m.webhooks {
+		webhooks[boardID] = webhookID
+	}
+	return webhooks
+}
+
+// DeleteAll deletes every tracked webhook from Trello.
+func (m *Manager) DeleteAll(ctx context.Context) {
+	defer m.changed()
+	for boardID, webhookID := range m.Webhooks() {
+		if err := m.client.DeleteWebhook(ctx, webhookID); err != nil {
+			zap.L().Error("Error deleting webhook for board", zap.String("boardID", boardID), zap.Error(err))
+			continue
+		}
+		zap.L().Info("Successfully deleted webhook for board", zap.String("boardID", boardID))
+
+		m.mu.Lock()
+		delete(m.webhooks, boardID)
+		m.mu.Unlock()
+	}
+}
+
+// Monitor checks every tracked webhook each interval until ctx is cancelled.
+// Trello deactivates webhooks after repeated callback failures, so without
+// this the service would silently stop receiving events.
+func (m *Manager) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll verifies every tracked webhook once.
+func (m *Manager) CheckAll(ctx context.Context) {
+	for boardID, webhookID := range m.Webhooks() {
+		m.check(ctx, boardID, webhookID)
+	}
+}
+
+func (m *Manager) check(ctx context.Context, boardID, webhookID string) {
+	webhook, err := m.client.GetWebhook(ctx, webhookID)
+	if err != nil && !integrations.IsTrelloNotFound(err) {
+		zap.L().Warn("Failed to check Trello webhook", zap.String("boardID", boardID), zap.String("webhookID", webhookID), zap.Error(err))
+		return
+	}
+
+	if err == nil && webhook.Active {
+		zap.L().Debug("Trello webhook is active", zap.String("boardID", boardID), zap.String("webhookID", webhookID))
+		return
+	}
+
+	alert := Alert{BoardID: boardID, WebhookID: webhookID}
+	if err != nil {
+		// The webhook was deleted, so register a replacement
+		zap.L().Warn("Trello webhook no longer exists; registering a new one", zap.String("boardID", boardID), zap.String("webhookID", webhookID))
+		if err := m.Register(ctx, boardID); err != nil {
+			alert.Err = fmt.Errorf("re-registering webhook: %w", err)
+		}
+	} else {
+		alert.ConsecutiveFailures = webhook.ConsecutiveFailures
+		zap.L().Warn("Trello webhook was deactivated; re-enabling it",
+			zap.String("boardID", boardID),
+			zap.String("webhookID", webhookID),
+			zap.Int("consecutiveFailures", webhook.ConsecutiveFailures),
+			zap.String("failingSince", webhook.FirstConsecutiveFailDate),
+		)
+		if err := m.client.ActivateWebhook(ctx, webhookID); err != nil {
+			alert.Err = fmt.Errorf("re-enabling webhook: %w", err)
+		}
+	}
+	alert.Recovered = alert.Err == nil
+
+	if alert.Recovered {
+		zap.L().Info("Recovered Trello webhook", zap.String("boardID", boardID))
+	} else {
+		zap.L().Error("Failed to recover Trello webhook; events for this board are being missed", zap.String("boardID", boardID), zap.Error(alert.Err))
+	}
+
+	if m.OnAlert != nil {
+		m.OnAlert(alert)
+	}
+}
+
+// PostAlerts returns an OnAlert hook that POSTs each alert as JSON to url,
+// for wiring up to chat or paging integrations.
+func PostAlerts(url string) func(Alert) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(alert Alert) {
+		payload := map[string]any{
+			"event":                "trello_webhook_disabled",
+			"board_id":             alert.BoardID,
+			"webhook_id":           alert.WebhookID,
+			"consecutive_failures": alert.ConsecutiveFailures,
+			"recovered":            alert.Recovered,
+		}
+		if alert.Err != nil {
+			payload["error"] = logging.Redact(alert.Err.Error())
+		}
+		body, _ := json.Marshal(payload)
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			zap.L().Error("Failed to send webhook alert", zap.Error(err))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			zap.L().Error("Webhook alert endpoint rejected alert", zap.String("status", resp.Status))
+		}
+	}
+}
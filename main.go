@@ -2,128 +2,161 @@ package main
 
 import (
 	"context"
-	"errors"
-	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
-	"time"
 
-	"github.com/chxlky/trello-gcal-sync/api"
+	"github.com/chxlky/trello-gcal-sync/app"
+	"github.com/chxlky/trello-gcal-sync/config"
 	"github.com/chxlky/trello-gcal-sync/database"
-	"github.com/chxlky/trello-gcal-sync/integrations"
-	ginzap "github.com/gin-contrib/zap"
-	"github.com/gin-gonic/gin"
+	"github.com/chxlky/trello-gcal-sync/internal/logging"
+	"github.com/chxlky/trello-gcal-sync/internal/secrets"
+	"github.com/chxlky/trello-gcal-sync/internal/systemd"
+	"github.com/chxlky/trello-gcal-sync/internal/tracing"
+	"github.com/chxlky/trello-gcal-sync/internal/tunnel"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 func main() {
-	levelStr := strings.ToLower(os.Getenv("LOG_LEVEL"))
-	if levelStr == "" {
-		levelStr = "debug"
-	}
-	level, err := zapcore.ParseLevel(levelStr)
-	if err != nil {
-		level = zapcore.InfoLevel
+	args := os.Args[1:]
+	dev := false
+flags:
+	for ; len(args) > 0; args = args[1:] {
+		switch args[0] {
+		case "--dry-run":
+			// sync.dry_run for the service or command, over the config file
+			// and its reloads
+			viper.Set("sync.dry_run", true)
+		case "--dev":
+			dev = true
+		default:
+			break flags
+		}
 	}
 
-	encoderConfig := zap.NewDevelopmentEncoderConfig()
-	encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-
-	config := zap.Config{
-		Level:            zap.NewAtomicLevelAt(level),
-		Development:      true,
-		Encoding:         "console",
-		EncoderConfig:    encoderConfig,
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stderr"},
+	if len(args) > 0 && args[0] == "init-config" {
+		os.Exit(initConfig(args[1:]))
 	}
 
-	logger, _ := config.Build()
-	defer logger.Sync()
+	// Log to the console until the log settings are loaded
+	logger, _ := logging.New(config.Log{Level: os.Getenv("LOG_LEVEL")})
 	zap.ReplaceGlobals(logger)
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
-		zap.L().Fatal("Error reading config file", zap.Error(err))
+	cfg, err := config.Load(viper.GetViper())
+	if err == nil {
+		err = secrets.Resolve(context.Background(), cfg)
 	}
+	if err != nil {
+		zap.L().Fatal("Error loading configuration", zap.Error(err))
+	}
+	logging.AddSecrets(cfg.SecretValues()...)
+	if logger, err = logging.New(cfg.Log); err != nil {
+		zap.L().Fatal("Error setting up logging", zap.Error(err))
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
 
-	dbPath := viper.GetString("database.path")
+	dbPath := cfg.Database.Path
 	if dbPath == "" {
 		dbPath = "cards.db"
 	}
 	db := database.Init(dbPath)
 	sqlDB, _ := db.DB()
+	if err := app.SetupEncryption(context.Background(), db, cfg); err != nil {
+		zap.L().Fatal("Failed to set up credential encryption", zap.Error(err))
+	}
 
-	port := viper.GetString("server.port")
-	if port == "" {
-		port = "8080"
+	if len(args) > 0 {
+		if dev {
+			zap.L().Fatal("--dev only applies to running the service, not to commands")
+		}
+		code := runCommand(args, cfg, db)
+		sqlDB.Close()
+		os.Exit(code)
 	}
 
-	calClient, err := integrations.NewCalendarClient()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		zap.L().Fatal("Failed to initialise Google Calendar client", zap.Error(err))
+		zap.L().Fatal("Failed to set up tracing", zap.Error(err))
 	}
-	zap.L().Info("Successfully authenticated with Google Calendar API.")
-
-	router := gin.Default()
-	router.Use(ginzap.Ginzap(logger, time.RFC3339, true))
-	router.Use(ginzap.RecoveryWithZap(logger, true))
 
-	apiHandler := &api.Handler{
-		DB:        db,
-		CalClient: calClient,
-		Workers:   make(chan struct{}, 10), // Limit to 10 concurrent workers
+	opts := app.Options{Config: cfg, DB: db}
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		zap.L().Fatal("Failed to use socket from systemd", zap.Error(err))
 	}
-	apiGroup := router.Group("/api")
-	{
-		apiGroup.POST("/trello-webhook", apiHandler.TrelloWebhookHandler)
-		apiGroup.HEAD("/trello-webhook", apiHandler.TrelloWebhookHandler)
-		apiGroup.GET("/health", apiHandler.HealthCheckHandler)
+	if len(listeners) > 0 {
+		if len(listeners) > 1 {
+			zap.L().Warn("systemd passed several sockets; serving only the first", zap.Int("count", len(listeners)))
+		}
+		opts.Listener = listeners[0]
+		zap.L().Info("Using socket passed by systemd", zap.String("address", opts.Listener.Addr().String()))
 	}
 
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+	// The tunnel would outlive a fatal exit, so it is closed first
+	var devTunnel *tunnel.Tunnel
+	fatal := func(msg string, err error) {
+		if devTunnel != nil {
+			devTunnel.Close()
+		}
+		zap.L().Fatal(msg, zap.Error(err))
 	}
-
-	zap.L().Info("Starting server", zap.String("port", port))
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			zap.L().Fatal("Server error", zap.Error(err))
+	if dev {
+		if devTunnel, err = startDevTunnel(context.Background(), cfg, opts.Listener); err != nil {
+			fatal("Failed to start tunnel for --dev", err)
 		}
-	}()
-
-	// Give the server a moment to start
-	time.Sleep(250 * time.Millisecond)
-
-	trelloClient := integrations.NewTrelloClient(
-		viper.GetString("trello.api_key"),
-		viper.GetString("trello.api_token"),
-		viper.GetString("trello.callback_url"),
-	)
+		useTunnel(cfg, devTunnel)
+	}
 
-	var boardIDs []string
-	if err := viper.UnmarshalKey("trello.board_ids", &boardIDs); err != nil || len(boardIDs) == 0 {
-		zap.L().Fatal("trello.board_ids is not configured properly", zap.Error(err))
+	service, err := app.New(opts)
+	if err != nil {
+		fatal("Failed to set up sync service", err)
+	}
+	if err := service.Start(context.Background()); err != nil {
+		fatal("Failed to start sync service", err)
 	}
 
-	zap.L().Info("Registering Trello webhook for boards", zap.Strings("boardIDs", boardIDs))
+	// Start returns once webhooks are registered, which is when the service
+	// is actually ready to receive updates
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		zap.L().Warn("Failed to notify systemd of readiness", zap.Error(err))
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go systemd.RunWatchdog(backgroundCtx)
+	go logging.ToggleDebugOnSignal(backgroundCtx)
+	if devTunnel != nil {
+		go func() {
+			select {
+			case <-devTunnel.Done():
+				zap.L().Error("Tunnel exited; Trello webhooks can no longer reach the service until it is restarted")
+			case <-backgroundCtx.Done():
+			}
+		}()
+	}
 
-	webhookIDs := make(map[string]string)
-	for _, boardId := range boardIDs {
-		webhookID, err := trelloClient.RegisterWebhook(boardId)
-		if err != nil {
-			zap.L().Fatal("Failed to register webhook on startup for board", zap.String("boardID", boardId), zap.Error(err))
-		}
-		webhookIDs[boardId] = webhookID
+	// Edits to config.toml are applied without a restart
+	if viper.ConfigFileUsed() != "" {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			zap.L().Info("Config file changed; reloading", zap.String("file", e.Name))
+			cfg, err := config.Decode(viper.GetViper())
+			if err == nil {
+				err = secrets.Resolve(context.Background(), cfg)
+			}
+			if err == nil {
+				logging.AddSecrets(cfg.SecretValues()...)
+				if devTunnel != nil {
+					useTunnel(cfg, devTunnel)
+				}
+				err = service.Reload(cfg)
+			}
+			if err != nil {
+				zap.L().Error("Failed to reload configuration", zap.Error(err))
+			}
+		})
+		viper.WatchConfig()
 	}
 
 	sigCh := make(chan os.Signal, 2)
@@ -134,25 +167,18 @@ func main() {
 
 	cleanup := func(reason string) {
 		zap.L().Info("Shutdown initiated", zap.String("reason", reason))
-
-		close(apiHandler.Workers) // Close the channel to stop accepting new work
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		zap.L().Info("Shutting down HTTP server...")
-		if err := srv.Shutdown(ctx); err != nil {
-			zap.L().Error("Error shutting down server", zap.Error(err))
-		} else {
-			zap.L().Info("HTTP server shut down gracefully.")
+		if _, err := systemd.Notify(systemd.Stopping); err != nil {
+			zap.L().Warn("Failed to notify systemd of shutdown", zap.Error(err))
+		}
+		service.Stop()
+		stopBackground()
+		if devTunnel != nil {
+			devTunnel.Close()
 		}
 
-		for boardID, webhookID := range webhookIDs {
-			if err := trelloClient.DeleteWebhook(webhookID); err != nil {
-				zap.L().Error("Error deleting webhook for board", zap.String("boardID", boardID), zap.Error(err))
-			} else {
-				zap.L().Info("Successfully deleted webhook for board", zap.String("boardID", boardID))
-			}
+		// Flush the spans of the last syncs
+		if err := shutdownTracing(context.Background()); err != nil {
+			zap.L().Warn("Failed to flush traces", zap.Error(err))
 		}
 
 		if sqlDB != nil {
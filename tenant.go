@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chxlky/trello-gcal-sync/app"
+	"github.com/chxlky/trello-gcal-sync/config"
+	"github.com/chxlky/trello-gcal-sync/database"
+	"github.com/chxlky/trello-gcal-sync/integrations"
+	"github.com/chxlky/trello-gcal-sync/internal/models"
+	"github.com/chxlky/trello-gcal-sync/internal/webhooks"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// googleAuthTimeout bounds the wait for the user to approve Google access
+const googleAuthTimeout = 10 * time.Minute
+
+// tenants registers, lists, connects and removes the people sharing the
+// service with their own Trello and Google accounts.
+func tenants(db *gorm.DB, cfg *config.Config, command string, args []string) error {
+	switch command {
+	case "list":
+		if len(args) > 0 {
+			return fmt.Errorf("unexpected argument %q", args[0])
+		}
+		tenants, err := database.ListTenants(db)
+		if err != nil {
+			return err
+		}
+		if len(tenants) == 0 {
+			fmt.Println("No tenants have been added.")
+			return nil
+		}
+		for _, tenant := range tenants {
+			status := "ready"
+			problem, err := app.TenantProblem(db, tenant)
+			if err != nil {
+				return err
+			}
+			if problem != "" {
+				status = problem
+			}
+			fmt.Printf("%s  trello=%s  google=%s  calendar=%s  boards=%d  status=%s\n", tenant.Name, orNone(tenant.TrelloMember), orNone(tenant.GoogleAccount), orNone(tenant.CalendarID), len(tenant.BoardIDs), status)
+		}
+		return nil
+
+	case "add":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: tenant add <name>")
+		}
+		name := args[0]
+		if err := app.CheckTenantName(name); err != nil {
+			return err
+		}
+		if existing, err := database.GetTenant(db, name); err != nil {
+			return err
+		} else if existing != nil {
+			return fmt.Errorf("tenant %q already exists", name)
+		}
+		if err := database.SaveTenant(db, &models.Tenant{Name: name}); err != nil {
+			return fmt.Errorf("storing tenant: %w", err)
+		}
+		fmt.Printf("Added tenant %s. Next, connect its accounts:\n\n  %[2]s tenant auth %[1]s trello\n  %[2]s tenant auth %[1]s google\n  %[2]s tenant boards %[1]s <board>...\n", name, os.Args[0])
+		return nil
+
+	case "invite":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: tenant invite <name>")
+		}
+		link, key, err := app.InviteTenant(db, cfg, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Send tenant %s this link to connect Trello and Google, choose boards and a calendar, and start syncing:\n\n  %s\n\n", args[0], link)
+		if strings.HasPrefix(link, "/") {
+			fmt.Println("Set server.public_url, or put the service's address in front of the link, first.")
+		}
+		fmt.Printf("It works until revoked with `%s api-keys revoke %s`.\n", os.Args[0], key.ID)
+		return nil
+
+	case "auth":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: tenant auth <name> trello|google")
+		}
+		tenant, err := findTenant(db, args[0])
+		if err != nil {
+			return err
+		}
+		switch args[1] {
+		case "trello":
+			return tenantTrelloAuth(db, cfg, tenant)
+		case "google":
+			return tenantGoogleAuth(db, cfg, tenant)
+		}
+		return fmt.Errorf("unknown account %q; expected trello or google", args[1])
+
+	case "boards":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: tenant boards <name> <board>...")
+		}
+		tenant, err := findTenant(db, args[0])
+		if err != nil {
+			return err
+		}
+		account, err := app.FindTrelloAccount(db, cfg, app.TenantAccountName(tenant.Name))
+		if err != nil {
+			return err
+		}
+		if account.APIToken == "" {
+			return fmt.Errorf("connect the tenant's Trello account first with `%s tenant auth %s trello`", os.Args[0], tenant.Name)
+		}
+		boards, err := webhooks.ValidateBoards(context.Background(), account.Client, args[1:])
+		if err != nil {
+			return err
+		}
+		tenant.BoardIDs = nil
+		for _, board := range boards {
+			tenant.BoardIDs = append(tenant.BoardIDs, board.ID)
+		}
+		if err := app.CheckTenantBoards(db, cfg, tenant.Name, tenant.BoardIDs); err != nil {
+			return err
+		}
+		for _, board := range boards {
+			fmt.Printf("%s  %s\n", board.ID, board.Name)
+		}
+		if err := database.SaveTenant(db, tenant); err != nil {
+			return fmt.Errorf("storing tenant: %w", err)
+		}
+		fmt.Printf("Tenant %s now syncs %d board(s).\n", tenant.Name, len(boards))
+		return nil
+
+	case "calendar":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: tenant calendar <name> <calendar ID>")
+		}
+		tenant, err := findTenant(db, args[0])
+		if err != nil {
+			return err
+		}
+		client, err := tenantCalendarClient(db, cfg, tenant.Name)
+		if err != nil {
+			return err
+		}
+		if !client.CalendarExists(context.Background(), args[1]) {
+			return fmt.Errorf("%s can't reach calendar %s", tenant.GoogleAccount, args[1])
+		}
+		tenant.CalendarID = args[1]
+		if err := database.SaveTenant(db, tenant); err != nil {
+			return fmt.Errorf("storing tenant: %w", err)
+		}
+		fmt.Printf("Tenant %s's events now go to %s. Events already synced stay where they are.\n", tenant.Name, tenant.CalendarID)
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: tenant remove <name>")
+		}
+		if err := database.DeleteTenant(db, args[0], app.TenantCredentials(args[0])...); err != nil {
+			return err
+		}
+		fmt.Printf("Removed tenant %s, its stored tokens, its API keys and invites. Its boards stop syncing when the service restarts.\n", args[0])
+		return nil
+	}
+	return fmt.Errorf("unknown tenant command %q; expected list, add, invite, auth, boards, calendar or remove", command)
+}
+
+func findTenant(db *gorm.DB, name string) (*models.Tenant, error) {
+	tenant, err := database.GetTenant(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, fmt.Errorf("no tenant named %q; add it with `%s tenant add %s`", name, os.Args[0], name)
+	}
+	return tenant, nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// tenantTrelloAuth stores the Trello token the tenant approves, with the
+// instance's API key.
+func tenantTrelloAuth(db *gorm.DB, cfg *config.Config, tenant *models.Tenant) error {
+	account, err := app.FindTrelloAccount(db, cfg, app.TenantAccountName(tenant.Name))
+	if err != nil {
+		return err
+	}
+	token, member, err := authorizeTrello(account)
+	if err != nil {
+		return err
+	}
+	if err := database.PutCredential(db, app.CredentialName(account.Name), token); err != nil {
+		return fmt.Errorf("storing token: %w", err)
+	}
+	tenant.TrelloMember = member.Username
+	if err := database.SaveTenant(db, tenant); err != nil {
+		return fmt.Errorf("storing tenant: %w", err)
+	}
+	fmt.Printf("Authorised as %s (@%s). The token for tenant %s has been stored.\n", member.FullName, member.Username, tenant.Name)
+	return nil
+}
+
+// tenantGoogleAuth has the tenant grant access to their Google calendars and
+// stores the refresh token. Unless the tenant has chosen a calendar, events go
+// to one named like the service account's, created if the account has none.
+func tenantGoogleAuth(db *gorm.DB, cfg *config.Config, tenant *models.Tenant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), googleAuthTimeout)
+	defer cancel()
+
+	refreshToken, err := authorizeGoogle(ctx, cfg.Google.OAuth)
+	if err != nil {
+		return err
+	}
+	oauth, err := integrations.NewGoogleOAuth(cfg.Google.OAuth, "")
+	if err != nil {
+		return err
+	}
+	if err := app.ConnectTenantGoogle(ctx, db, cfg, tenant, oauth, refreshToken); err != nil {
+		return err
+	}
+	fmt.Printf("Authorised as %s. Tenant %s's events go to calendar %s.\n", tenant.GoogleAccount, tenant.Name, tenant.CalendarID)
+	return nil
+}
+
+// tenantCalendarClient acts as the Google account the tenant connected
+func tenantCalendarClient(db *gorm.DB, cfg *config.Config, tenant string) (*integrations.CalendarClient, error) {
+	token, err := database.GetCredential(db, app.GoogleCredentialName(tenant))
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("connect the tenant's Google account first with `%s tenant auth %s google`", os.Args[0], tenant)
+	}
+	return app.TenantCalendarClient(db, cfg, tenant)
+}
+
+// authorizeGoogle has the user approve access to their calendars and returns
+// the refresh token Google grants. Google redirects the browser to a local
+// address this listens on; when the browser runs on another machine and
+// can't reach it, the user pastes the address it ended up on instead.
+func authorizeGoogle(ctx context.Context, settings config.OAuth) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("listening for Google's redirect: %w", err)
+	}
+	oauth, err := integrations.NewGoogleOAuth(settings, "http://"+listener.Addr().String()+"/")
+	if err != nil {
+		listener.Close()
+		return "", err
+	}
+
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		listener.Close()
+		return "", err
+	}
+	state := hex.EncodeToString(stateBytes)
+	verifier := oauth2.GenerateVerifier()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 2)
+	// redirected reads the outcome from the query of Google's redirect,
+	// reporting whether it was one
+	redirected := func(query url.Values) bool {
+		switch {
+		case query.Get("state") != state:
+			return false
+		case query.Get("error") != "":
+			results <- result{err: fmt.Errorf("Google access was not granted: %s", query.Get("error"))}
+		default:
+			results <- result{code: query.Get("code")}
+		}
+		return true
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !redirected(r.URL.Query()) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "Done; you can close this tab and return to the terminal.")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Println("Open this URL in your browser and approve access:")
+	fmt.Println()
+	fmt.Println("  " + oauth.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier)))
+	fmt.Println()
+	fmt.Println("Waiting for Google to send your browser back here. If the browser is on")
+	fmt.Print("another machine and can't load the page it is sent to, paste its address: ")
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			u, err := url.Parse(strings.TrimSpace(scanner.Text()))
+			if err == nil && redirected(u.Query()) {
+				return
+			}
+			fmt.Print("That isn't the address Google sent the browser to; paste it again: ")
+		}
+	}()
+
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return "", errors.New("timed out waiting for Google access to be approved")
+	}
+	fmt.Println()
+	if res.err != nil {
+		return "", res.err
+	}
+
+	token, err := oauth.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return "", fmt.Errorf("exchanging Google's authorization code: %w", err)
+	}
+	if token.RefreshToken == "" {
+		return "", errors.New("Google granted no refresh token; remove the service's access at https://myaccount.google.com/permissions and try again")
+	}
+	return token.RefreshToken, nil
+}